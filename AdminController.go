@@ -0,0 +1,429 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type AdminController struct {
+	Controller
+}
+
+// Removes duplicate statistics rows, see DedupeStatistics
+func (c *AdminController) dedupeStatistics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	groups, removed, err := DedupeStatistics(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]int64{
+		"groups": int64(groups),
+		"removed": removed,
+	})
+}
+
+// Returns the usage writer's counters, dropped and lost uses weren't counted
+func (c *AdminController) usage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Usage.Stats())
+}
+
+// Returns the latest wiki canary results and whether item updates are paused
+func (c *AdminController) canary(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(CanaryStatus())
+}
+
+// Accepts the diverged canary pages' new output and resumes item updates
+func (c *AdminController) acceptCanary(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	accepted, err := AcceptCanary(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]string{
+		"accepted": accepted,
+	})
+}
+
+// Lists a server's alias candidates with ?status=pending|approved|rejected,
+// pending by default. ?server picks the tenant, the default tenant otherwise
+func (c *AdminController) aliasCandidates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	server, ok := ServerQueryParam(r)
+	if !ok {
+		http.Error(w, "Invalid server", 400)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = ALIAS_PENDING
+	}
+	if status != ALIAS_PENDING && status != ALIAS_APPROVED && status != ALIAS_REJECTED {
+		http.Error(w, "status must be pending, approved or rejected", 400)
+		return
+	}
+	limit, ok := IntQueryParam(r, "limit", DEFAULT_PER_PAGE, 1, MAX_PER_PAGE)
+	if !ok {
+		http.Error(w, "limit must be a number between 1 and " + strconv.Itoa(MAX_PER_PAGE), 400)
+		return
+	}
+
+	candidates, err := FetchAliasCandidates(r.Context(), server, status, limit)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(candidates)
+}
+
+// Learns alias candidates now rather than waiting for the next run
+func (c *AdminController) learnAliases(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	learnt, err := LearnAliases(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]int{
+		"candidates": learnt,
+	})
+}
+
+// Approves or rejects one of a server's pending alias candidates
+func (c *AdminController) reviewAliasCandidate(w http.ResponseWriter, r *http.Request) {
+	server, ok := ServerQueryParam(r)
+	if !ok {
+		http.Error(w, "Invalid server", 400)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid candidate id", 400)
+		return
+	}
+	status := ALIAS_REJECTED
+	if mux.Vars(r)["decision"] == "approve" {
+		status = ALIAS_APPROVED
+	}
+
+	reviewed, err := ReviewAliasCandidate(r.Context(), server, id, status)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	if !reviewed {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Lists the aliases added by hand for a server, pass item to only list that
+// item's. ?server picks the tenant, the default tenant otherwise
+func (c *AdminController) itemAliases(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	server, ok := ServerQueryParam(r)
+	if !ok {
+		http.Error(w, "Invalid server", 400)
+		return
+	}
+
+	aliases, err := FetchItemAliases(r.Context(), server, strings.TrimSpace(r.URL.Query().Get("item")))
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(aliases)
+}
+
+// Adds an alias for a server, the body looks like {"alias": "fungi", "item":
+// "Fungus Covered Scale Tunic"}. Storing an alias which exists points it at the
+// new item
+func (c *AdminController) storeItemAlias(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	server, ok := ServerQueryParam(r)
+	if !ok {
+		http.Error(w, "Invalid server", 400)
+		return
+	}
+
+	var body struct {
+		Alias string `json:"alias"`
+		Item  string `json:"item"`
+	}
+	if r.Body == nil {
+		http.Error(w, "Please send a request body", 400)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if NormaliseAlias(body.Alias) == "" || strings.TrimSpace(body.Item) == "" {
+		http.Error(w, "Please send an alias and an item", 400)
+		return
+	}
+	if Corpus.IsName(body.Alias) {
+		http.Error(w, "An item already goes by that name", 400)
+		return
+	}
+
+	alias, exists, err := StoreItemAlias(r.Context(), server, body.Alias, body.Item)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	if !exists {
+		http.Error(w, "No such item, scrape it before adding aliases for it", 404)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(alias)
+}
+
+// Removes one of a server's aliases
+func (c *AdminController) deleteItemAlias(w http.ResponseWriter, r *http.Request) {
+	server, ok := ServerQueryParam(r)
+	if !ok {
+		http.Error(w, "Invalid server", 400)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid alias id", 400)
+		return
+	}
+
+	deleted, err := DeleteItemAlias(r.Context(), server, id)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	if !deleted {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Lists every API key a tenant has had, the keys themselves are never returned
+func (c *AdminController) apiKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tenant, ok := NormaliseServer(mux.Vars(r)["tenant"])
+	if !ok {
+		http.Error(w, "Invalid tenant", 400)
+		return
+	}
+
+	keys, err := FetchApiKeys(r.Context(), tenant)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(keys)
+}
+
+// Creates an API key for a tenant, the body looks like {"name": "..."}. The
+// key is only ever in this response
+func (c *AdminController) storeApiKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tenant, ok := NormaliseServer(mux.Vars(r)["tenant"])
+	if !ok {
+		http.Error(w, "Invalid tenant", 400)
+		return
+	}
+
+	var body struct {
+		Name string `json:"name"`
+	}
+	if r.Body == nil {
+		http.Error(w, "Please send a request body", 400)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	apiKey, key, err := CreateApiKey(r.Context(), tenant, strings.TrimSpace(body.Name))
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"key": key,
+		"apiKey": apiKey,
+	})
+}
+
+// Revokes an API key
+func (c *AdminController) revokeApiKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid key id", 400)
+		return
+	}
+
+	revoked, err := RevokeApiKey(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	if !revoked {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Lists a tenant's webhooks, their secrets are never returned
+func (c *AdminController) webhooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tenant, ok := NormaliseServer(mux.Vars(r)["tenant"])
+	if !ok {
+		http.Error(w, "Invalid tenant", 400)
+		return
+	}
+
+	webhooks, err := FetchWebhooks(r.Context(), &tenant)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(webhooks)
+}
+
+// Registers a webhook for a tenant, the body looks like {"url": "...",
+// "event": "price_below", "item": "...", "max_price": 100, "format": "discord"}.
+// The secret payloads are signed with is only ever in this response
+func (c *AdminController) storeWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tenant, ok := NormaliseServer(mux.Vars(r)["tenant"])
+	if !ok {
+		http.Error(w, "Invalid tenant", 400)
+		return
+	}
+
+	var body struct {
+		Url      string   `json:"url"`
+		Event    string   `json:"event"`
+		Item     string   `json:"item"`
+		MaxPrice *float64 `json:"max_price"`
+		Format   string   `json:"format"`
+	}
+	if r.Body == nil {
+		http.Error(w, "Please send a request body", 400)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	webhook := Webhook{
+		server: tenant,
+		url: strings.TrimSpace(body.Url),
+		event: body.Event,
+		itemName: strings.Replace(strings.TrimSpace(body.Item), "_", " ", -1),
+		format: body.Format,
+	}
+	if webhook.format == "" {
+		webhook.format = WEBHOOK_FORMAT_JSON
+	}
+	if body.MaxPrice != nil {
+		webhook.maxPrice = sql.NullFloat64{ Float64: *body.MaxPrice, Valid: true }
+	}
+	if problem := webhook.Validate(); problem != "" {
+		http.Error(w, problem, 400)
+		return
+	}
+
+	webhook, err := CreateWebhook(r.Context(), webhook)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"secret": webhook.secret,
+		"webhook": webhook,
+	})
+}
+
+// Deletes a webhook along with any deliveries still queued for it
+func (c *AdminController) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook id", 400)
+		return
+	}
+
+	deleted, err := DeleteWebhook(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	if !deleted {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Lists a webhook's latest deliveries so an admin can see why it isn't firing
+func (c *AdminController) webhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook id", 400)
+		return
+	}
+
+	deliveries, err := FetchWebhookDeliveries(r.Context(), id, MAX_PER_PAGE)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(deliveries)
+}
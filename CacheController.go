@@ -0,0 +1,17 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type CacheController struct {
+	Controller
+}
+
+// Returns hit and miss counts for the local and Redis item caches
+func (c *CacheController) stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ItemCacheStats())
+}
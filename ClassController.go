@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type ClassController struct {
+	Controller
+}
+
+// Returns the best items in each slot for a class, ranked by the requested
+// stat, i.e. /classes/WAR/equipment?stat=AC&per_slot=3. Pass clicky_weight to
+// also rank by clickable effects, i.e. clicky_weight=5 makes an instant clicky
+// worth 10 points of the stat
+func (c *ClassController) equipment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	class, ok := NormaliseClass(mux.Vars(r)["class"])
+	if !ok {
+		http.Error(w, "Unknown class", 400)
+		return
+	}
+
+	stat := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("stat")))
+	if stat == "" {
+		stat = "AC"
+	}
+
+	perSlot, ok := IntQueryParam(r, "per_slot", 5, 1, 25)
+	if !ok {
+		http.Error(w, "per_slot must be a number between 1 and 25", 400)
+		return
+	}
+
+	clickyWeight, ok := IntQueryParam(r, "clicky_weight", 0, 0, MAX_CLICKY_WEIGHT)
+	if !ok {
+		http.Error(w, "clicky_weight must be a number between 0 and " + strconv.Itoa(MAX_CLICKY_WEIGHT), 400)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(FetchEquipmentPlan(class, stat, perSlot, clickyWeight))
+}
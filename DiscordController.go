@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type DiscordController struct {
+	Controller
+}
+
+// Discord gives up on an interaction if we don't respond within 3 seconds
+const DISCORD_INTERACTION_TIMEOUT = 2500 * time.Millisecond
+
+// Interaction and response types from Discord's interactions API
+const (
+	DISCORD_INTERACTION_PING    = 1
+	DISCORD_INTERACTION_COMMAND = 2
+
+	DISCORD_RESPONSE_PONG    = 1
+	DISCORD_RESPONSE_MESSAGE = 4
+
+	DISCORD_MESSAGE_EPHEMERAL = 64
+)
+
+// Returns the item as a Discord embed, scraping it from the wiki if we haven't
+// seen it before
+func (c *DiscordController) item(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	embed, err := c.embed(r.Context(), mux.Vars(r)["item_name"])
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	if embed == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(embed)
+}
+
+// Handles slash commands when this service is set as the bot's interactions
+// endpoint. Discord signs every request and will stop sending them if we
+// accept one with a bad signature, so this is disabled unless
+// discord_public_key is configured
+func (c *DiscordController) interactions(w http.ResponseWriter, r *http.Request) {
+	if Settings.DiscordPublicKey == "" {
+		http.Error(w, "Discord interactions aren't configured", http.StatusNotFound)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Couldn't read the request body", 400)
+		return
+	}
+	if !verifyDiscordSignature(r.Header.Get("X-Signature-Ed25519"), r.Header.Get("X-Signature-Timestamp"), body) {
+		http.Error(w, "Invalid request signature", http.StatusUnauthorized)
+		return
+	}
+
+	var interaction struct {
+		Type int `json:"type"`
+		Data struct {
+			Options []struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			} `json:"options"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &interaction); err != nil {
+		http.Error(w, "Invalid interaction", 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if interaction.Type == DISCORD_INTERACTION_PING {
+		json.NewEncoder(w).Encode(map[string]int{ "type": DISCORD_RESPONSE_PONG })
+		return
+	}
+	if interaction.Type != DISCORD_INTERACTION_COMMAND || len(interaction.Data.Options) == 0 {
+		http.Error(w, "Unsupported interaction", 400)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), DISCORD_INTERACTION_TIMEOUT)
+	defer cancel()
+
+	itemName := interaction.Data.Options[0].Value
+	embed, err := c.embed(ctx, itemName)
+
+	// Failures are only shown to whoever ran the command
+	data := map[string]interface{}{}
+	if err != nil {
+		data["content"] = "Couldn't look up " + itemName + " right now, try again shortly"
+		data["flags"] = DISCORD_MESSAGE_EPHEMERAL
+	} else if embed == nil {
+		data["content"] = "Couldn't find an item called " + itemName
+		data["flags"] = DISCORD_MESSAGE_EPHEMERAL
+	} else {
+		data["embeds"] = []DiscordEmbed{ *embed }
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type": DISCORD_RESPONSE_MESSAGE,
+		"data": data,
+	})
+}
+
+// Fetches the item and builds its embed, returns nil if the item doesn't exist
+func (c *DiscordController) embed(ctx context.Context, itemName string) (*DiscordEmbed, error) {
+	itemName = strings.TrimSpace(strings.Replace(itemName, "_", " ", -1))
+	item := Item {
+		name: itemName,
+		displayName: TitleCase(itemName, true),
+	}
+
+	if err := item.FetchData(ctx); err != nil {
+		if err == ErrPageNotFound || err == ErrNotAnItemPage || err == ErrDisambiguation {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !item.Resolved() {
+		return nil, nil
+	}
+	Usage.Track(item.id, USAGE_VIEW)
+
+	embed := NewDiscordEmbed(item, FetchPriceSummary(TenantFrom(ctx), item.id, OBSERVED_PRICE_MIN_CONFIDENCE))
+	return &embed, nil
+}
+
+// Discord signs the timestamp followed by the raw body with the application's
+// Ed25519 key
+func verifyDiscordSignature(signature string, timestamp string, body []byte) bool {
+	publicKey, err := hex.DecodeString(LiveSetting("discord_public_key", Settings.DiscordPublicKey))
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		Log.Error("discord_public_key isn't a valid Ed25519 public key")
+		return false
+	}
+	decoded, err := hex.DecodeString(signature)
+	if err != nil || timestamp == "" {
+		return false
+	}
+	return ed25519.Verify(publicKey, append([]byte(timestamp), body...), decoded)
+}
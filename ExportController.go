@@ -0,0 +1,253 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type ExportController struct {
+	Controller
+}
+
+// Number of items loaded from SQL at a time while exporting
+const EXPORT_BATCH_SIZE = 500
+
+// Streams every item we have in a format other tools can import, ?format=
+// picks the format and defaults to lucy
+func (c *ExportController) items(w http.ResponseWriter, r *http.Request) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "lucy"
+	}
+
+	switch format {
+	case "lucy":
+		c.lucy(w, r)
+	default:
+		http.Error(w, "format must be lucy", 400)
+	}
+}
+
+// Streams every item as newline delimited JSON, one GET /items object per
+// line, gzipped. ?since= limits the dump to items scraped since a date or
+// RFC 3339 time so a copy can be kept up to date without fetching it all again,
+// deleted items aren't in an incremental dump
+func (c *ExportController) itemsJson(w http.ResponseWriter, r *http.Request) {
+	since, valid := sinceQueryParam(r)
+	if !valid {
+		http.Error(w, "since must be a date (2006-01-02) or an RFC 3339 time", 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Encoding", "gzip")
+	w.WriteHeader(http.StatusOK)
+
+	writer := gzip.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+	flusher, _ := w.(http.Flusher)
+
+	var afterId int64
+	for {
+		if r.Context().Err() != nil {
+			return
+		}
+
+		items := ExportItems(afterId, since, EXPORT_BATCH_SIZE)
+		for _, item := range items {
+			if err := encoder.Encode(item); err != nil {
+				LoggerFrom(r.Context()).Warn("Failed to write exported item", "err", err)
+				return
+			}
+			afterId = item.id
+		}
+		if len(items) < EXPORT_BATCH_SIZE {
+			break
+		}
+
+		// Clients see each batch as it's written rather than all at the end
+		writer.Flush()
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		LoggerFrom(r.Context()).Warn("Failed to finish export", "err", err)
+	}
+}
+
+// Streams every item as a CSV with a column per stat, ?stats= picks the stat
+// columns i.e. stats=AC,HP,MANA and defaults to the same ones as the report
+func (c *ExportController) itemsCsv(w http.ResponseWriter, r *http.Request) {
+	stats := reportStatsQueryParam(r)
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"items.csv\"")
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	writer.Write(ItemReportHeader(stats))
+
+	var afterId int64
+	for {
+		if r.Context().Err() != nil {
+			return
+		}
+
+		items := ExportItems(afterId, time.Time{}, EXPORT_BATCH_SIZE)
+		for _, item := range items {
+			if err := writer.Write(NewItemReportRow(item, stats).Strings()); err != nil {
+				LoggerFrom(r.Context()).Warn("Failed to write exported item", "err", err)
+				return
+			}
+			afterId = item.id
+		}
+		if len(items) < EXPORT_BATCH_SIZE {
+			break
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		LoggerFrom(r.Context()).Warn("Failed to finish export", "err", err)
+	}
+}
+
+// Reads the comma separated stat codes in ?stats=, in the order given with
+// duplicates dropped. Returns DefaultReportStats if there aren't any
+func reportStatsQueryParam(r *http.Request) []string {
+	var stats []string
+	seen := make(map[string]bool)
+	for _, code := range strings.Split(r.URL.Query().Get("stats"), ",") {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code != "" && !seen[code] {
+			seen[code] = true
+			stats = append(stats, code)
+		}
+	}
+	if len(stats) == 0 {
+		return DefaultReportStats()
+	}
+	return stats
+}
+
+// Reads the optional since query parameter, a bare date means the start of
+// that day. Returns the zero time if it wasn't sent
+func sinceQueryParam(r *http.Request) (time.Time, bool) {
+	raw := r.URL.Query().Get("since")
+	if raw == "" {
+		return time.Time{}, true
+	}
+	if day, err := time.Parse("2006-01-02", raw); err == nil {
+		return day, true
+	}
+	at, err := time.Parse(time.RFC3339, raw)
+	return at, err == nil
+}
+
+// Lists the nightly snapshots of the catalog, newest first
+func (c *ExportController) snapshots(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(FetchSnapshots())
+}
+
+// Writes the items as a Lucy dump, a header row of column names followed by
+// one pipe delimited row per item
+func (c *ExportController) lucy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"items.txt\"")
+	w.WriteHeader(http.StatusOK)
+
+	w.Write([]byte(strings.Join(lucyColumns, "|") + "\n"))
+	for page := 1; ; page++ {
+		if r.Context().Err() != nil {
+			return
+		}
+
+		items, _ := ListItems(page, EXPORT_BATCH_SIZE)
+		for _, item := range items {
+			w.Write([]byte(strings.Join(NewLucyItem(item).Row(), "|") + "\n"))
+		}
+		if len(items) < EXPORT_BATCH_SIZE {
+			return
+		}
+	}
+}
+
+// Streams a spreadsheet of the items matching the same filters as /items/query,
+// as either items.xlsx or items.csv, with a column per stat
+func (c *ExportController) itemsReport(w http.ResponseWriter, r *http.Request) {
+	filter, problem := itemFilterFromRequest(r)
+	if problem != "" {
+		http.Error(w, problem, 400)
+		return
+	}
+
+	stats := reportStatsQueryParam(r)
+	format := mux.Vars(r)["format"]
+	w.Header().Set("Content-Disposition", "attachment; filename=\"items." + format + "\"")
+
+	var writeRow func(row ItemReportRow) error
+	var finish func() error
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		writer := csv.NewWriter(w)
+		writer.Write(ItemReportHeader(stats))
+		writeRow = func(row ItemReportRow) error {
+			return writer.Write(row.Strings())
+		}
+		finish = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.WriteHeader(http.StatusOK)
+
+		writer, err := NewXlsxWriter(w, "Items")
+		if err != nil {
+			LoggerFrom(r.Context()).Error("Failed to start spreadsheet", "err", err)
+			return
+		}
+		var header []interface{}
+		for _, column := range ItemReportHeader(stats) {
+			header = append(header, column)
+		}
+		writer.WriteRow(header)
+		writeRow = func(row ItemReportRow) error {
+			return writer.WriteRow(row.Cells())
+		}
+		finish = writer.Close
+	}
+
+	for page := 1; ; page++ {
+		if r.Context().Err() != nil {
+			return
+		}
+
+		items := QueryItems(filter, page, MAX_PER_PAGE)
+		for _, item := range items {
+			if err := writeRow(NewItemReportRow(item, stats)); err != nil {
+				LoggerFrom(r.Context()).Warn("Failed to write report row", "err", err)
+				return
+			}
+		}
+		if len(items) < MAX_PER_PAGE {
+			break
+		}
+	}
+
+	if err := finish(); err != nil {
+		LoggerFrom(r.Context()).Warn("Failed to finish report", "err", err)
+	}
+}
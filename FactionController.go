@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type FactionController struct {
+	Controller
+}
+
+// Returns the faction's members along with the merchants, quests and items it
+// affects, if we have never seen the faction before its wiki page is scraped first
+func (c *FactionController) show(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	uri, factionName := c.factionName(r)
+
+	faction, exists, err := FetchFaction(r.Context(), factionName)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	if !exists {
+		body, err := FetchWikiPage(r.Context(), uri)
+		if err != nil {
+			http.Error(w, err.Error(), StatusForError(err))
+			return
+		}
+		var ok bool
+		if faction, ok = ParseFaction(factionName, body); !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := faction.Save(r.Context()); err != nil {
+			http.Error(w, err.Error(), StatusForError(err))
+			return
+		}
+	}
+	if err := faction.fetchLinks(r.Context()); err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(faction)
+}
+
+// Returns the wiki uri and the display name of the faction in the route
+func (c *FactionController) factionName(r *http.Request) (string, string) {
+	uri := TitleCase(strings.Replace(mux.Vars(r)["faction_name"], "_", " ", -1), true)
+	return uri, strings.Replace(uri, "_", " ", -1)
+}
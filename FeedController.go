@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+type FeedController struct {
+	Controller
+}
+
+// Sent when nothing else has been for this long, so proxies don't close an
+// idle connection
+const FEED_HEARTBEAT_INTERVAL = 30 * time.Second
+
+// Subscribers who can't take a message within this long are disconnected
+const FEED_WRITE_TIMEOUT = 10 * time.Second
+
+// Streams feed events to the client over a WebSocket as JSON messages, i.e.
+// {"type": "item", "data": {...}, "at": "..."}. types=item,auction limits the
+// stream to those types. Anything the client sends is ignored
+func (c *FeedController) stream(w http.ResponseWriter, r *http.Request) {
+	var kinds []string
+	if raw := strings.TrimSpace(r.URL.Query().Get("types")); raw != "" {
+		for _, kind := range strings.Split(raw, ",") {
+			kind = strings.TrimSpace(kind)
+			if kind != FEED_EVENT_ITEM && kind != FEED_EVENT_AUCTION {
+				http.Error(w, "types must be a list of item and auction", 400)
+				return
+			}
+			kinds = append(kinds, kind)
+		}
+	}
+
+	subscriber, ok := SubscribeFeed(TenantFrom(r.Context()), kinds)
+	if !ok {
+		tooBusy(w)
+		return
+	}
+	defer UnsubscribeFeed(subscriber)
+
+	// The origin isn't checked as the feed only carries what the API serves
+	server := websocket.Server{ Handler: func(conn *websocket.Conn) {
+		logger := LoggerFrom(r.Context())
+		logger.Info("Feed subscriber connected")
+
+		// Reading is how we find out the client has gone
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			var message string
+			for websocket.Message.Receive(conn, &message) == nil {
+			}
+		}()
+
+		heartbeat := time.NewTicker(FEED_HEARTBEAT_INTERVAL)
+		defer heartbeat.Stop()
+		for {
+			var err error
+			select {
+			case event, open := <-subscriber.events:
+				if !open {
+					logger.Info("Feed subscriber disconnected as it fell behind")
+					return
+				}
+				conn.SetWriteDeadline(time.Now().Add(FEED_WRITE_TIMEOUT))
+				err = websocket.JSON.Send(conn, event)
+			case <-heartbeat.C:
+				conn.SetWriteDeadline(time.Now().Add(FEED_WRITE_TIMEOUT))
+				err = websocket.JSON.Send(conn, map[string]string{ "type": "heartbeat" })
+			case <-closed:
+				logger.Info("Feed subscriber disconnected")
+				return
+			case <-AppContext.Done():
+				return
+			}
+			if err != nil {
+				logger.Info("Feed subscriber disconnected", "err", err)
+				return
+			}
+		}
+	}}
+	server.ServeHTTP(w, r)
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"net/http"
+)
+
+type GraphqlController struct {
+	Controller
+}
+
+// The gqlgen server, built from the schema when the router is created
+var graphqlHandler http.Handler
+
+// Runs a GraphQL query sent either as a POST body or in the query string
+func (c *GraphqlController) query(w http.ResponseWriter, r *http.Request) {
+	graphqlHandler.ServeHTTP(w, r)
+}
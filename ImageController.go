@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type ImageController struct {
+	Controller
+}
+
+// Images only change when the wiki's does, and the ETag catches that, so
+// clients can hold on to them for a day before asking again
+const IMAGE_MAX_AGE_IN_SECS = 86400
+
+// Serves an item's icon from the image store, downloading it first if it
+// hasn't been yet. Read only mirrors redirect to the wiki for icons they
+// don't have
+func (c *ImageController) show(w http.ResponseWriter, r *http.Request) {
+	itemId, err := strconv.ParseInt(mux.Vars(r)["item_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid item id", 400)
+		return
+	}
+
+	image, exists, err := FetchItemImage(r.Context(), itemId)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	if !exists {
+		items := fetchItems("SELECT " + itemColumns + " FROM items WHERE items.id = ?", itemId)
+		if len(items) == 0 || !IsWikiPath(items[0].imageSrc) {
+			http.Error(w, "Couldn't find an image for item " + strconv.FormatInt(itemId, 10), 404)
+			return
+		}
+		if Settings.ReadOnly {
+			http.Redirect(w, r, Settings.WikiBaseUrl + items[0].imageSrc, http.StatusFound)
+			return
+		}
+		image, err = StoreItemImage(r.Context(), itemId, items[0].imageSrc)
+		if err != nil {
+			http.Error(w, err.Error(), StatusForError(err))
+			return
+		}
+	}
+
+	etag := "\"" + image.hash + "\""
+	w.Header().Set("Cache-Control", "public, max-age=" + strconv.Itoa(IMAGE_MAX_AGE_IN_SECS))
+	w.Header().Set("ETag", etag)
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	body, err := Images.Get(r.Context(), image.hash)
+	if err != nil {
+		LoggerFrom(r.Context()).Error("Failed to read item image", "item_id", itemId, "hash", image.hash, "err", err)
+		http.Error(w, "Failed to read the image", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", image.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("Last-Modified", image.fetchedAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type ImportController struct {
+	Controller
+}
+
+// Seeds the catalog from a dump made by GET /export/items.json, gzipped or not.
+// Items are upserted by name and version so the same dump can be sent again,
+// the response says how many were saved and which lines were skipped. This is
+// an admin route, so it needs admin_api_key
+func (c *ImportController) items(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		http.Error(w, "Please send a request body", 400)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		defer reader.Close()
+		body = reader
+	}
+
+	result, err := ImportItems(r.Context(), body)
+	if err != nil && r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
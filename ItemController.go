@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"net/http"
-	"fmt"
+	"database/sql"
 	"encoding/json"
 	"strings"
 	"strconv"
+	"sync"
+	"time"
 	"github.com/gorilla/mux"
 )
 
@@ -13,7 +16,8 @@ type ItemController struct {
 	Controller
 }
 
-// Stores auction data to the Amazon RDS storage once it has been parsed
+// Queues auction data to be parsed and stored to the Amazon RDS storage in the
+// background, the caller is given a job id which can be polled at /jobs/{id}
 func (c *ItemController) store(w http.ResponseWriter, r *http.Request) {
 	var items []string
 	if r.Body == nil {
@@ -26,48 +30,1014 @@ func (c *ItemController) store(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Println("items are: ", items)
+	LoggerFrom(r.Context()).Debug("Storing items", "count", len(items))
 	if len(items) == 0 {
 		http.Error(w, "No lines were present in the auctions array", 400)
 		return
 	}
 
-	c.parse(&items)
+	job := Jobs.Start(TenantFrom(r.Context()), items)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/" + job.id)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
 }
 
 func (c *ItemController) fetchOrStore(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	// Wiki and database failures are reported as such so callers know whether
+	// it is worth trying again
+	item, found, err := c.fetchItem(r.Context(), mux.Vars(r)["item_name"])
+	if err == ErrDisambiguation {
+		// The name is ambiguous, so let the caller pick which item they meant
+		w.WriteHeader(StatusForError(err))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": err.Error(),
+			"candidates": item.candidates,
+		})
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	if found {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(item)
+	} else {
+		// Usually a typo or a nickname we don't know, so point at what they
+		// might have meant
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "Item not found",
+			"suggestions": Corpus.Suggest(TenantFrom(r.Context()), mux.Vars(r)["item_name"], MAX_SUGGESTIONS),
+		})
+	}
+}
+
+// Corrects an item by hand, the body looks like {"statistics": [{"code": "AC",
+// "value": 20}, {"code": "HASTE", "remove": true}], "price": 150, "imageSrc":
+// "/images/..."} and only what's sent is changed. The item is then left alone
+// by the re-scraper. ?server picks the tenant the price is corrected for, the
+// default tenant otherwise
+func (c *ItemController) correct(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	server, ok := ServerQueryParam(r)
+	if !ok {
+		http.Error(w, "Invalid server", 400)
+		return
+	}
+
+	var body struct {
+		Statistics []StatisticCorrection `json:"statistics"`
+		Price      *float64              `json:"price"`
+		ImageSrc   *string               `json:"imageSrc"`
+	}
+	if r.Body == nil {
+		http.Error(w, "Please send a request body", 400)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	correction := ItemCorrection{ server: server, statistics: body.Statistics, price: body.Price, imageSrc: body.ImageSrc }
+	if problem := correction.Validate(); problem != "" {
+		http.Error(w, problem, 400)
+		return
+	}
+
 	itemName := TitleCase(mux.Vars(r)["item_name"], true)
+	item := Item {
+		name: strings.Replace(itemName, "_", " ", -1),
+		displayName: itemName,
+	}
+	if !item.FetchCachedData(r.Context()) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := item.Correct(r.Context(), correction); err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(item)
+}
+
+// Removes an item and everything stored against it, this is an admin route
+// despite its path so it needs admin_api_key
+func (c *ItemController) destroy(w http.ResponseWriter, r *http.Request) {
+	name := strings.Replace(TitleCase(strings.TrimSpace(mux.Vars(r)["item_name"]), true), "_", " ", -1)
+
+	item, exists, err := fetchItemRow(r.Context(), name)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := item.Delete(r.Context()); err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Fetches and parses the item's wiki page as a scrape would without saving
+// anything, returning what it parsed to along with everything the parser
+// logged. Pages which don't parse still respond 200 with the error set
+func (c *ItemController) preview(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name := strings.TrimSpace(mux.Vars(r)["item_name"])
+	if name == "" {
+		http.Error(w, "Please send an item name", 400)
+		return
+	}
+
+	preview, err := PreviewItem(r.Context(), name)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(preview)
+}
+
+// Scrapes the item from the wiki again and overwrites what we had, for fixing
+// badly parsed items without editing the database by hand. As an /admin/ route
+// Tenancy only lets it through with admin_api_key
+func (c *ItemController) refresh(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name := strings.TrimSpace(mux.Vars(r)["item_name"])
+	if name == "" {
+		http.Error(w, "Please send an item name", 400)
+		return
+	}
+
+	item := Item{ name: strings.Replace(TitleCase(name, true), "_", " ", -1) }
+	if err := item.Refresh(r.Context()); err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(item)
+}
+
+// Looks the item up in the caches and SQL, scraping it from the wiki if we
+// haven't seen it. Nicknames are looked up as the item they stand for.
+// Returns false if nothing meaningful was found about it
+func (c *ItemController) fetchItem(ctx context.Context, name string) (Item, bool, error) {
+	if canonical, exists := Corpus.Alias(TenantFrom(ctx), name); exists {
+		LoggerFrom(ctx).Debug("Resolved item alias", "alias", name, "item", canonical)
+		name = canonical
+	}
+	itemName := TitleCase(name, true)
 
 	item := Item {
 		name: strings.Replace(itemName, "_", " ", -1),
 		displayName: TitleCase(itemName, true),
 	}
 
-	item.FetchData()
+	if err := item.FetchData(ctx); err != nil {
+		LoggerFrom(ctx).Warn("Couldn't fetch item", "item", item.name, "err", err)
+		return item, false, err
+	}
+
+	if !item.Resolved() {
+		LoggerFrom(ctx).Info("Couldn't find item", "item", item.name)
+		return item, false, nil
+	}
+	LoggerFrom(ctx).Debug("Fetched item", "item", item.name, "id", item.id)
+	return item, true, nil
+}
+
+// Lists every item we have scraped one page at a time, pass server to flag the
+// items that server has rules for. labels=long labels each stat as i.e. Strength
+// rather than STR on this and the other item lookups
+func (c *ItemController) index(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	server, ok := ServerQueryParam(r)
+	if !ok {
+		http.Error(w, "Invalid server", 400)
+		return
+	}
+	labels, ok := LabelsQueryParam(r)
+	if !ok {
+		http.Error(w, "labels must be short or long", 400)
+		return
+	}
+
+	page, ok := IntQueryParam(r, "page", 1, 1, 1000000)
+	if !ok {
+		http.Error(w, "page must be a positive number", 400)
+		return
+	}
+	perPage, ok := IntQueryParam(r, "per_page", DEFAULT_PER_PAGE, 1, MAX_PER_PAGE)
+	if !ok {
+		http.Error(w, "per_page must be a number between 1 and " + strconv.Itoa(MAX_PER_PAGE), 400)
+		return
+	}
+
+	items, total := ListItems(page, perPage)
+	attachRules(items, server)
+	applyStatLabels(items, labels)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"page": page,
+		"perPage": perPage,
+		"total": total,
+		"items": items,
+	})
+}
 
-	if item.imageSrc != "" || len(item.effects) > 0 || len(item.statistics) > 0 {
-		fmt.Println("Item is now: ", item)
+// Returns the long label of every stat code, what labels=long responds with
+func (c *ItemController) statLabels(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(statLabels)
+}
+
+// Fuzzy searches item names, auction logs are full of abbreviated and misspelt
+// names so we rank by closeness rather than requiring an exact match
+func (c *ItemController) search(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(w, "Please send a search query", 400)
+		return
+	}
+	limit, ok := IntQueryParam(r, "limit", 10, 1, MAX_PER_PAGE)
+	if !ok {
+		http.Error(w, "limit must be a number between 1 and " + strconv.Itoa(MAX_PER_PAGE), 400)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SearchItems(TenantFrom(r.Context()), q, limit))
+}
+
+// Combines the icons of a set of items into one image so a grid of items needs
+// a single image request. Items are named in names=a,b,c or are the results of
+// the search q, i.e. /items/sprites.css?q=cloak&limit=50. The png is the sheet,
+// css and json give each icon's offset on it and link to the matching png
+func (c *ItemController) sprites(w http.ResponseWriter, r *http.Request) {
+	var items []Item
+	if raw := strings.TrimSpace(r.URL.Query().Get("names")); raw != "" {
+		var names []string
+		seen := make(map[string]bool)
+		for _, name := range strings.Split(raw, ",") {
+			name = strings.TrimSpace(strings.Replace(name, "_", " ", -1))
+			if name != "" && !seen[strings.ToLower(name)] {
+				seen[strings.ToLower(name)] = true
+				names = append(names, name)
+			}
+		}
+		if len(names) > MAX_PER_PAGE {
+			http.Error(w, "At most " + strconv.Itoa(MAX_PER_PAGE) + " names can be sent", 400)
+			return
+		}
+		items = FetchItemsByName(names)
+	} else if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		limit, ok := IntQueryParam(r, "limit", DEFAULT_PER_PAGE, 1, MAX_PER_PAGE)
+		if !ok {
+			http.Error(w, "limit must be a number between 1 and " + strconv.Itoa(MAX_PER_PAGE), 400)
+			return
+		}
+		for _, result := range SearchItems(TenantFrom(r.Context()), q, limit) {
+			items = append(items, result.item)
+		}
+	} else {
+		http.Error(w, "Please send names or a search query", 400)
+		return
+	}
+
+	sheet := BuildSpriteSheet(r.Context(), items)
+
+	// The css and json point at the png built from the same query
+	imageUrl := strings.TrimSuffix(r.URL.Path, "." + mux.Vars(r)["format"]) + ".png"
+	if r.URL.RawQuery != "" {
+		imageUrl += "?" + r.URL.RawQuery
+	}
+
+	switch mux.Vars(r)["format"] {
+	case "png":
+		body, err := sheet.PNG()
+		if err != nil {
+			LoggerFrom(r.Context()).Error("Failed to encode sprite sheet", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "public, max-age=" + strconv.Itoa(Settings.CacheTimeInSecs))
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(item)
+		w.Write(body)
+	case "css":
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=" + strconv.Itoa(Settings.CacheTimeInSecs))
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(sheet.CSS(imageUrl)))
+	default:
+		body, err := sheet.JSON(imageUrl)
+		if err != nil {
+			LoggerFrom(r.Context()).Error("Failed to encode sprite sheet offsets", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}
+}
+
+// Filters items by stat value, class, slot and race, i.e. /items/query?stat=AC&min=10&class=WAR.
+// wearer_size=small|medium|large returns armour that fits any race of that size
+func (c *ItemController) query(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	filter, problem := itemFilterFromRequest(r)
+	if problem != "" {
+		http.Error(w, problem, 400)
+		return
+	}
+
+	page, ok := IntQueryParam(r, "page", 1, 1, 1000000)
+	if !ok {
+		http.Error(w, "page must be a positive number", 400)
+		return
+	}
+	perPage, ok := IntQueryParam(r, "per_page", DEFAULT_PER_PAGE, 1, MAX_PER_PAGE)
+	if !ok {
+		http.Error(w, "per_page must be a number between 1 and " + strconv.Itoa(MAX_PER_PAGE), 400)
+		return
+	}
+
+	server, ok := ServerQueryParam(r)
+	if !ok {
+		http.Error(w, "Invalid server", 400)
+		return
+	}
+	labels, ok := LabelsQueryParam(r)
+	if !ok {
+		http.Error(w, "labels must be short or long", 400)
+		return
+	}
+
+	items := QueryItems(filter, page, perPage)
+	attachRules(items, server)
+	applyStatLabels(items, labels)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"page": page,
+		"perPage": perPage,
+		"items": items,
+	})
+}
+
+// Ranks items by a figure worked out from their stats, type must be weapon and
+// order is either ratio (the default) or delay. Accepts the class, race,
+// wearer_size, slot and weapon_skill filters of /items/query
+func (c *ItemController) top(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := r.URL.Query()
+	if kind := params.Get("type"); kind != "" && kind != TOP_ITEMS_TYPE_WEAPON {
+		http.Error(w, "type must be weapon", 400)
+		return
+	}
+	order := params.Get("order")
+	if order == "" {
+		order = TOP_ITEMS_ORDER_RATIO
+	}
+	if order != TOP_ITEMS_ORDER_RATIO && order != TOP_ITEMS_ORDER_DELAY {
+		http.Error(w, "order must be ratio or delay", 400)
+		return
+	}
+
+	filter, problem := itemFilterFromRequest(r)
+	if problem != "" {
+		http.Error(w, problem, 400)
+		return
+	}
+	if filter.stat != "" || filter.clickyWeight > 0 {
+		http.Error(w, "stat and clicky_weight can't be used to rank top items", 400)
+		return
+	}
+
+	page, ok := IntQueryParam(r, "page", 1, 1, 1000000)
+	if !ok {
+		http.Error(w, "page must be a positive number", 400)
+		return
+	}
+	perPage, ok := IntQueryParam(r, "per_page", DEFAULT_PER_PAGE, 1, MAX_PER_PAGE)
+	if !ok {
+		http.Error(w, "per_page must be a number between 1 and " + strconv.Itoa(MAX_PER_PAGE), 400)
+		return
+	}
+
+	server, ok := ServerQueryParam(r)
+	if !ok {
+		http.Error(w, "Invalid server", 400)
+		return
+	}
+	labels, ok := LabelsQueryParam(r)
+	if !ok {
+		http.Error(w, "labels must be short or long", 400)
+		return
+	}
+
+	items := QueryTopWeapons(filter, order, page, perPage)
+	attachRules(items, server)
+	applyStatLabels(items, labels)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"page": page,
+		"perPage": perPage,
+		"items": items,
+	})
+}
+
+// Reads the filters shared by /items/query and the item reports, returns a
+// description of the first invalid one if there is one
+func itemFilterFromRequest(r *http.Request) (ItemFilter, string) {
+	params := r.URL.Query()
+	filter := ItemFilter{
+		stat: strings.TrimSpace(params.Get("stat")),
+	}
+
+	for key, target := range map[string]*sql.NullFloat64{"min": &filter.min, "max": &filter.max} {
+		if raw := params.Get(key); raw != "" {
+			value, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return filter, key + " must be a number"
+			}
+			*target = sql.NullFloat64{Float64: value, Valid: true}
+		}
+	}
+	if class := strings.TrimSpace(params.Get("class")); class != "" {
+		abbreviation, ok := NormaliseClass(class)
+		if !ok {
+			return filter, "Unknown class"
+		}
+		filter.class = abbreviation
+	}
+	if slot := strings.TrimSpace(params.Get("slot")); slot != "" {
+		normalised, ok := NormaliseSlot(slot)
+		if !ok {
+			return filter, "Unknown slot"
+		}
+		filter.slot = normalised
+	}
+	// Races are filtered individually, or by the size of armour they wear
+	if race := params.Get("race"); race != "" {
+		abbreviation, ok := NormaliseRace(race)
+		if !ok {
+			return filter, "Unknown race"
+		}
+		filter.races = []string{abbreviation}
+	}
+	if size := params.Get("wearer_size"); size != "" {
+		races, ok := RacesOfSize(size)
+		if !ok {
+			return filter, "wearer_size must be one of small, medium or large"
+		}
+		if filter.races != nil {
+			return filter, "race and wearer_size can't be used together"
+		}
+		filter.races = races
+	}
+	if skill := params.Get("weapon_skill"); skill != "" {
+		canonical, ok := NormaliseWeaponSkill(skill)
+		if !ok {
+			return filter, "Unknown weapon_skill"
+		}
+		filter.weaponSkill = canonical
+	}
+
+	if filter.stat == "" && (filter.min.Valid || filter.max.Valid) {
+		return filter, "min and max can only be used with a stat"
+	}
+
+	var ok bool
+	filter.clickyWeight, ok = IntQueryParam(r, "clicky_weight", 0, 0, MAX_CLICKY_WEIGHT)
+	if !ok {
+		return filter, "clicky_weight must be a number between 0 and " + strconv.Itoa(MAX_CLICKY_WEIGHT)
+	}
+	return filter, ""
+}
+
+// Returns an item from SQL without ever scraping the wiki, so lookups are side effect free.
+// Pass server to include any rules that server has for the item, source=wikitext
+// to see the wikitext the item was parsed from instead, or as_of to see the stats
+// the item had on a date (2019-06-01) or at a time (RFC 3339)
+func (c *ItemController) show(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	server, ok := ServerQueryParam(r)
+	if !ok {
+		http.Error(w, "Invalid server", 400)
+		return
+	}
+	labels, ok := LabelsQueryParam(r)
+	if !ok {
+		http.Error(w, "labels must be short or long", 400)
+		return
+	}
+	source := r.URL.Query().Get("source")
+	if source != "" && source != "wikitext" {
+		http.Error(w, "source must be wikitext", 400)
+		return
+	}
+	asOf, ok := asOfQueryParam(r)
+	if !ok {
+		http.Error(w, "as_of must be a date (2006-01-02) or an RFC 3339 time", 400)
+		return
+	}
+
+	version, ok := IntQueryParam(r, "version", DEFAULT_ITEM_VERSION, 1, MAX_ITEM_VERSIONS)
+	if !ok {
+		http.Error(w, "version must be a number between 1 and " + strconv.Itoa(MAX_ITEM_VERSIONS), 400)
+		return
+	}
+
+	itemName := TitleCase(mux.Vars(r)["item_name"], true)
+
+	item := Item {
+		name: strings.Replace(itemName, "_", " ", -1),
+		displayName: itemName,
+		version: version,
+	}
+
+	if source == "wikitext" {
+		c.wikitext(w, r, item)
+		return
+	}
+
+	if item.FetchCachedData(r.Context()) {
+		if !asOf.IsZero() {
+			revision, exists, err := FetchItemRevisionAsOf(r.Context(), item.id, asOf)
+			if err != nil {
+				http.Error(w, err.Error(), StatusForError(err))
+				return
+			}
+			if !exists {
+				http.Error(w, "No revision of the item is that old", http.StatusNotFound)
+				return
+			}
+			item.statistics = revision.statistics
+			item.effects = revision.effects
+			w.Header().Set("Last-Modified", revision.createdAt.UTC().Format(http.TimeFormat))
+		}
+
+		Usage.Track(item.id, USAGE_VIEW)
+
+		items := []Item{item}
+		attachRules(items, server)
+		applyStatLabels(items, labels)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(items[0])
 	} else {
-		fmt.Println("Couldn't find item: ", item)
+		LoggerFrom(r.Context()).Debug("Item isn't cached", "item", item.name)
 		w.WriteHeader(http.StatusNotFound)
 	}
 }
 
-//
-func (c *ItemController) parse(rawItems *[]string) {
+// Looks up many items at once, the body is a JSON array of names. Like show this
+// only reads items we've already scraped, from the item caches or SQL, and the
+// lookups run in parallel. The response maps each name as it was sent to either
+// {"item": {...}} or {"error": "page_not_found", "status": 404}. Accepts the
+// same server and labels parameters as show
+func (c *ItemController) batchGet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	server, ok := ServerQueryParam(r)
+	if !ok {
+		http.Error(w, "Invalid server", 400)
+		return
+	}
+	labels, ok := LabelsQueryParam(r)
+	if !ok {
+		http.Error(w, "labels must be short or long", 400)
+		return
+	}
+
+	var names []string
+	if r.Body == nil {
+		http.Error(w, "Please send a request body", 400)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&names); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if len(names) == 0 {
+		http.Error(w, "No names were present in the array", 400)
+		return
+	}
+	if len(names) > MAX_PER_PAGE {
+		http.Error(w, "At most " + strconv.Itoa(MAX_PER_PAGE) + " names can be sent", 400)
+		return
+	}
+
+	items := make([]Item, len(names))
+	errs := make([]error, len(names))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < minInt(Settings.ParseWorkers, len(names)); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				items[idx], errs[idx] = c.cachedItem(r.Context(), names[idx])
+			}
+		}()
+	}
+	for idx := range names {
+		indexes <- idx
+	}
+	close(indexes)
+	wg.Wait()
+
+	var found []Item
+	for idx := range names {
+		if errs[idx] == nil {
+			found = append(found, items[idx])
+		}
+	}
+	attachRules(found, server)
+	applyStatLabels(found, labels)
 
-	for _, itemName := range *rawItems {
-		// Ensure string is properly formatted
-		itemName = strings.TrimSpace(itemName)
-		LogInDebugMode("Item is: " + itemName + ", length is: " + strconv.Itoa(len(itemName)))
-		item := Item {
-			name: itemName,
-			displayName: TitleCase(itemName, true),
+	results := make(map[string]interface{})
+	position := 0
+	for idx, name := range names {
+		if errs[idx] != nil {
+			results[name] = map[string]interface{}{
+				"error": ErrorLabel(errs[idx]),
+				"status": StatusForError(errs[idx]),
+			}
+			continue
+		}
+		results[name] = map[string]interface{}{
+			"item": found[position],
 		}
-		item.FetchData()
+		position++
+		Usage.Track(items[idx].id, USAGE_VIEW)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(results)
+}
+
+// Returns the item from the item caches or SQL, ErrPageNotFound if we haven't
+// scraped it
+func (c *ItemController) cachedItem(ctx context.Context, name string) (Item, error) {
+	itemName := TitleCase(strings.TrimSpace(name), true)
+	item := Item {
+		name: strings.Replace(itemName, "_", " ", -1),
+		displayName: itemName,
+	}
+	if item.name == "" {
+		return item, ErrPageNotFound
+	}
+
+	if cached, exists := FetchCachedItem(item.name); exists {
+		return cached, nil
+	}
+	if _, err := item.fetchDataFromSQL(ctx); err != nil {
+		return item, err
+	}
+	item.fetchEffectsFromSQL()
+	if item.id <= 0 || !item.Resolved() {
+		return item, ErrPageNotFound
+	}
+	CacheItem(item.name, item)
+	return item, nil
+}
+
+// Returns the item's stats as plain text lines that can be pasted into EQ chat,
+// max_length can shorten the lines for channels with a lower limit. Like show
+// this only reads items we've already scraped
+func (c *ItemController) plaintext(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=us-ascii")
+
+	maxLength, ok := IntQueryParam(r, "max_length", EQ_CHAT_LINE_LIMIT, EQ_CHAT_LINE_MIN, EQ_CHAT_LINE_LIMIT)
+	if !ok {
+		http.Error(w, "max_length must be a number between " + strconv.Itoa(EQ_CHAT_LINE_MIN) + " and " + strconv.Itoa(EQ_CHAT_LINE_LIMIT), 400)
+		return
+	}
+
+	itemName := TitleCase(mux.Vars(r)["item_name"], true)
+	item := Item {
+		name: strings.Replace(itemName, "_", " ", -1),
+		displayName: itemName,
+	}
+	if !item.FetchCachedData(r.Context()) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(NewChatSummary(item, maxLength).String() + "\n"))
+}
+
+// Returns the mobs the item drops from and the zones they're in, as listed on
+// the item's wiki page when we last scraped it
+func (c *ItemController) sources(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	itemName := TitleCase(mux.Vars(r)["item_name"], true)
+	item := Item {
+		name: strings.Replace(itemName, "_", " ", -1),
+		displayName: itemName,
+	}
+	if !item.FetchCachedData(r.Context()) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	sources, err := FetchItemSources(r.Context(), item.id)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(sources)
+}
+
+// Returns the item's latest revisions, newest first, each with what changed
+// since the one before. limit caps how many are returned
+func (c *ItemController) history(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	limit, ok := IntQueryParam(r, "limit", DEFAULT_PER_PAGE, 1, MAX_PER_PAGE)
+	if !ok {
+		http.Error(w, "limit must be a number between 1 and " + strconv.Itoa(MAX_PER_PAGE), 400)
+		return
+	}
+
+	itemName := TitleCase(mux.Vars(r)["item_name"], true)
+	item := Item {
+		name: strings.Replace(itemName, "_", " ", -1),
+		displayName: itemName,
+	}
+	if !item.FetchCachedData(r.Context()) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	revisions, err := FetchItemRevisions(r.Context(), item.id, limit)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// Reads the optional as_of query parameter, a bare date means the end of that
+// day so anything saved on it is included. Returns the zero time if it wasn't sent
+func asOfQueryParam(r *http.Request) (time.Time, bool) {
+	raw := r.URL.Query().Get("as_of")
+	if raw == "" {
+		return time.Time{}, true
+	}
+	if day, err := time.Parse("2006-01-02", raw); err == nil {
+		return day.Add(24 * time.Hour - time.Second), true
+	}
+	at, err := time.Parse(time.RFC3339, raw)
+	return at, err == nil
+}
+
+// Responds with the stored wikitext for the item, a debug view of the source
+// the item was parsed from
+func (c *ItemController) wikitext(w http.ResponseWriter, r *http.Request, item Item) {
+	if _, err := item.fetchDataFromSQL(r.Context()); err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	wikitext, exists := FetchItemWikitext(item.id)
+	if item.id <= 0 || !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(wikitext)
+}
+
+// Lines which look like chat spam are quarantined before we ever hit SQL or the
+// wiki, lines which pass that check but don't resolve to an item are quarantined
+// afterwards so that an admin can review them. Lines which failed because the
+// wiki or database were down are queued to be retried as they may resolve next time.
+// Lines copied from the EQ log also record who auctioned each item
+func (c *ItemController) parseLine(ctx context.Context, rawLine string) LineResult {
+	result := LineResult{ line: rawLine, status: LINE_STATUS_PARSED }
+	auctionLine := ParseAuctionLine(rawLine)
+	message := auctionLine.message
+
+	if reason := SuspiciousLineReason(message); reason != "" {
+		quarantined := QuarantinedLine{ line: rawLine, reason: reason }
+		quarantined.Save()
+		result.status = LINE_STATUS_QUARANTINED
+		result.reason = reason
+		return result
+	}
+
+	// Auction lines may mention several (possibly misspelt) items, if none of them
+	// are in our corpus we treat the whole line as the name of a new item
+	matches := Tokenize(TenantFrom(ctx), message)
+	if len(matches) == 0 {
+		item, err := c.ingest(ctx, message)
+		if IsTransientError(err) {
+			result.status = LINE_STATUS_FAILED
+			result.reason = err.Error()
+			QueueRetryLine(TenantFrom(ctx), rawLine, err.Error())
+		} else if item.id <= 0 && !item.Resolved() {
+			quarantined := QuarantinedLine{ line: rawLine, reason: QUARANTINE_REASON_UNRESOLVED }
+			quarantined.Save()
+			result.status = LINE_STATUS_QUARANTINED
+			result.reason = QUARANTINE_REASON_UNRESOLVED
+		} else {
+			result.items = append(result.items, ItemResult{ name: item.name, confidence: 1.0, resolved: true })
+			c.recordAuction(ctx, auctionLine, rawLine, item, sql.NullFloat64{})
+			c.publishAuction(ctx, auctionLine, rawLine, []FeedAuctionItem{ { item.id, item.name, nil, 1.0 } })
+		}
+		return result
+	}
+
+	var auctioned []FeedAuctionItem
+
+	for _, match := range matches {
+		LoggerFrom(ctx).Debug("Matched item", "text", match.text, "item", match.name, "confidence", match.confidence)
+		item, err := c.ingest(ctx, match.name)
+		if item.id > 0 && match.price.Valid {
+			pricePoint := PricePoint{
+				server: TenantFrom(ctx),
+				itemId: item.id,
+				price: match.price.Float64,
+				confidence: match.confidence,
+				line: rawLine,
+			}
+			pricePoint.Save()
+			NotifyPriceBelow(ctx, item, pricePoint)
+		}
+		itemResult := ItemResult{
+			name: match.name,
+			confidence: match.confidence,
+			resolved: item.id > 0 || item.Resolved(),
+		}
+		if IsTransientError(err) {
+			// The rest of the line has been recorded so only the item is retried
+			itemResult.err = err.Error()
+			QueueRetryLine(TenantFrom(ctx), match.name, err.Error())
+		}
+		result.items = append(result.items, itemResult)
+		Usage.Track(item.id, USAGE_MENTION)
+		c.recordAuction(ctx, auctionLine, rawLine, item, match.price)
+		if item.id > 0 {
+			auctioned = append(auctioned, FeedAuctionItem{ item.id, item.name, nullFloatPointer(match.price), match.confidence })
+		}
+	}
+	c.publishAuction(ctx, auctionLine, rawLine, auctioned)
+
+	return result
+}
+
+// Pushes the line to feed subscribers if it resolved to any items
+func (c *ItemController) publishAuction(ctx context.Context, auctionLine AuctionLine, rawLine string, items []FeedAuctionItem) {
+	if len(items) == 0 {
+		return
+	}
+	auction := FeedAuction{
+		Seller: auctionLine.seller,
+		Line: rawLine,
+		AuctionedAt: auctionLine.auctionedAt,
+		Items: items,
+	}
+	PublishFeedEvent(FEED_EVENT_AUCTION, TenantFrom(ctx), false, auction)
+}
+
+// Records that the seller auctioned the item, only lines from the EQ log know who the seller was
+func (c *ItemController) recordAuction(ctx context.Context, auctionLine AuctionLine, rawLine string, item Item, price sql.NullFloat64) {
+	if auctionLine.seller == "" {
+		return
+	}
+
+	auction := Auction{
+		server: TenantFrom(ctx),
+		seller: auctionLine.seller,
+		itemId: item.id,
+		itemName: item.name,
+		price: price,
+		line: rawLine,
+		auctionedAt: auctionLine.auctionedAt,
+	}
+	auction.Save()
+}
+
+// Fetches a single raw line as an item, returning the item so callers can
+// decide what to do with it along with any error from fetching it
+func (c *ItemController) ingest(ctx context.Context, rawLine string) (Item, error) {
+	// Ensure string is properly formatted
+	itemName := strings.TrimSpace(rawLine)
+	LoggerFrom(ctx).Debug("Ingesting item", "item", itemName)
+	item := Item {
+		name: itemName,
+		displayName: TitleCase(itemName, true),
+	}
+	err := item.FetchData(ctx)
+	return item, err
+}
+
+// Returns aggregate prices for an item along with vendor prices, the min_confidence
+// query parameter can be used to exclude fuzzy matches from the aggregate
+func (c *ItemController) prices(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	minConfidence := 0.0
+	if raw := r.URL.Query().Get("min_confidence"); raw != "" {
+		parsed, err := strconv.ParseFloat(raw, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			http.Error(w, "min_confidence must be a number between 0 and 1", 400)
+			return
+		}
+		minConfidence = parsed
+	}
+
+	item := c.lookupItem(r)
+	if item.id <= 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	summary := FetchPriceSummary(TenantFrom(r.Context()), item.id, minConfidence)
+	summary.vendors = FetchMerchantPrices(item.id, item.name)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(summary)
+}
+
+// Returns the hourly or daily OHLC roll-ups for an item, these are precomputed
+// by the RollupPrices job so this never touches the raw price points
+func (c *ItemController) trend(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = ROLLUP_PERIOD_DAY
+	} else if period != ROLLUP_PERIOD_HOUR && period != ROLLUP_PERIOD_DAY {
+		http.Error(w, "period must be either hour or day", 400)
+		return
+	}
+
+	limit, ok := IntQueryParam(r, "limit", 30, 1, 1000)
+	if !ok {
+		http.Error(w, "limit must be a number between 1 and 1000", 400)
+		return
+	}
+
+	item := c.lookupItem(r)
+	if item.id <= 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	rollups := FetchPriceRollups(TenantFrom(r.Context()), item.id, period, limit)
+	if rollups == nil {
+		rollups = []PriceRollup{}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rollups)
+}
+
+// Resolves the {item_name} route variable to an item id using SQL only
+func (c *ItemController) lookupItem(r *http.Request) Item {
+	itemName := strings.Replace(mux.Vars(r)["item_name"], "_", " ", -1)
+	item := Item {
+		name: itemName,
 	}
+	item.fetchDataFromSQL(r.Context())
+	return item
 }
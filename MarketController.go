@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type MarketController struct {
+	Controller
+}
+
+// Returns a per day index of the whole market, giving an overview of the economy.
+// Pass server to leave out the items that server excludes from the market
+func (c *MarketController) index(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	server, ok := ServerQueryParam(r)
+	if !ok {
+		http.Error(w, "Invalid server", 400)
+		return
+	}
+
+	days, ok := IntQueryParam(r, "days", 30, 1, 365)
+	if !ok {
+		http.Error(w, "days must be a number between 1 and 365", 400)
+		return
+	}
+
+	indexes := FetchMarketIndex(TenantFrom(r.Context()), days, server)
+	if indexes == nil {
+		indexes = []MarketIndex{}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(indexes)
+}
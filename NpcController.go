@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type NpcController struct {
+	Controller
+}
+
+// Returns the NPC and its known loot as we last scraped them
+func (c *NpcController) show(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_, npcName := c.npcName(r)
+
+	npc, exists, err := FetchNpc(r.Context(), npcName)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(npc)
+}
+
+// Scrapes the NPC's bestiary page and stores its level, zone, hp and known loot
+func (c *NpcController) store(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	uri, npcName := c.npcName(r)
+
+	body, err := FetchWikiPage(r.Context(), uri)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	npc, ok := ParseNpc(npcName, body)
+	if !ok {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	if err := npc.Save(r.Context()); err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(npc)
+}
+
+// Returns the spawn points of an NPC, if we have never seen the NPC before
+// its wiki page is scraped for /loc coordinates first
+func (c *NpcController) spawns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	uri, npcName := c.npcName(r)
+
+	spawns := FetchNpcSpawns(npcName)
+	if len(spawns) == 0 {
+		body, err := FetchWikiPage(r.Context(), uri)
+		if err != nil {
+			http.Error(w, err.Error(), StatusForError(err))
+			return
+		}
+		spawns = ParseNpcSpawns(npcName, body)
+		SaveNpcSpawns(npcName, spawns)
+	}
+
+	if len(spawns) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(spawns)
+}
+
+// Scrapes a vendor's page and stores the items they sell along with their prices
+func (c *NpcController) inventory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	uri, npcName := c.npcName(r)
+
+	body, err := FetchWikiPage(r.Context(), uri)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	inventory := ParseMerchantInventory(npcName, body)
+	if len(inventory) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	SaveMerchantInventory(npcName, inventory)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(inventory)
+}
+
+// Returns the wiki uri and the display name of the NPC in the route
+func (c *NpcController) npcName(r *http.Request) (string, string) {
+	uri := TitleCase(strings.Replace(mux.Vars(r)["npc_name"], "_", " ", -1), true)
+	return uri, strings.Replace(uri, "_", " ", -1)
+}
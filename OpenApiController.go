@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+)
+
+type OpenApiController struct {
+	Controller
+}
+
+// Loads swagger-ui from a CDN rather than vendoring it, the page is only for
+// people browsing the API
+const swaggerUiPage = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>service-wiki API</title>
+	<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+	<div id="swagger-ui"></div>
+	<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+	<script>
+		window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+	</script>
+</body>
+</html>
+`
+
+// Serves the OpenAPI 3 document describing every route
+func (c *OpenApiController) document(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openApiDocument)
+}
+
+// Serves Swagger UI for the document
+func (c *OpenApiController) ui(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(swaggerUiPage))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type QuarantineController struct {
+	Controller
+}
+
+// Lists every line currently sat in quarantine so that it can be reviewed
+func (c *QuarantineController) index(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	lines := FetchQuarantinedLines()
+	if lines == nil {
+		lines = []QuarantinedLine{}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(lines)
+}
+
+// Lists every line waiting to be retried after a transient failure
+func (c *QuarantineController) retries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(FetchRetryLines())
+}
+
+// Counts how every wiki scrape since we started ended, keyed by ErrorLabel
+func (c *QuarantineController) scrapes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ScrapeOutcomes())
+}
+
+// Lists the wiki pages we most recently couldn't parse, along with the pages
+// themselves. limit caps how many are returned
+func (c *QuarantineController) parseFailures(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	limit, ok := IntQueryParam(r, "limit", DEFAULT_PER_PAGE, 1, MAX_PER_PAGE)
+	if !ok {
+		http.Error(w, "limit must be a number between 1 and " + strconv.Itoa(MAX_PER_PAGE), 400)
+		return
+	}
+
+	failures, err := FetchParseFailures(r.Context(), limit)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(failures)
+}
+
+// Fetches and parses the page again, for once the parser or the page has been
+// fixed. If it parses the item is saved and the failure removed, otherwise the
+// failure is updated with the page as it is now
+func (c *QuarantineController) retryParseFailure(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid parse failure id", 400)
+		return
+	}
+
+	failure, exists, err := FetchParseFailure(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	item := Item{ name: failure.name, displayName: TitleCase(failure.name, true) }
+	err = item.fetchDataFromWiki(r.Context())
+	if err != nil && !IsParseFailure(err) && err != ErrPageNotFound {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	resolved := err == nil
+	if resolved || err == ErrPageNotFound {
+		// Pages which have since been deleted have nothing left to review
+		failure.Delete(r.Context())
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":       failure.id,
+		"name":     failure.name,
+		"resolved": resolved,
+		"reason":   ErrorLabel(err),
+	})
+}
+
+// Replays a quarantined line through the normal ingestion path, skipping the
+// spam check as an admin has already reviewed the line. If the line resolves
+// it is removed from quarantine
+func (c *QuarantineController) replay(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid quarantine id", 400)
+		return
+	}
+
+	var quarantined QuarantinedLine
+	if !quarantined.FetchById(id) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	item, err := IC.ingest(r.Context(), quarantined.line)
+	if IsTransientError(err) {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	resolved := item.id > 0 || item.Resolved()
+	if resolved {
+		quarantined.Delete()
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":       quarantined.id,
+		"line":     quarantined.line,
+		"resolved": resolved,
+	})
+}
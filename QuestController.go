@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type QuestController struct {
+	Controller
+}
+
+// Returns the quest's items and walkthrough, if we have never seen the quest
+// before its wiki page is scraped first
+func (c *QuestController) show(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_, questName := c.questName(r)
+
+	quest, exists, err := FetchQuest(r.Context(), questName)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	if !exists {
+		c.store(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(quest)
+}
+
+// Scrapes the quest's wiki page and stores its items and walkthrough
+func (c *QuestController) store(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	uri, questName := c.questName(r)
+
+	body, err := FetchWikiPage(r.Context(), uri)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	quest, ok := ParseQuest(questName, body)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := quest.Save(r.Context()); err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(quest)
+}
+
+// Returns every quest we've scraped which requires or rewards the item
+func (c *QuestController) itemQuests(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	item := IC.lookupItem(r)
+	if item.id <= 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	quests, err := FetchItemQuests(r.Context(), item.id, item.name)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(quests)
+}
+
+// Returns the wiki uri and the display name of the quest in the route
+func (c *QuestController) questName(r *http.Request) (string, string) {
+	uri := TitleCase(strings.Replace(mux.Vars(r)["quest_name"], "_", " ", -1), true)
+	return uri, strings.Replace(uri, "_", " ", -1)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type RecipeController struct {
+	Controller
+}
+
+// Returns the recipe's tradeskill, components and results, if we have never
+// seen the recipe before its wiki page is scraped first
+func (c *RecipeController) show(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_, recipeName := c.recipeName(r)
+
+	recipe, exists, err := FetchRecipe(r.Context(), recipeName)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	if !exists {
+		c.store(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(recipe)
+}
+
+// Scrapes the recipe's wiki page and stores its components and results
+func (c *RecipeController) store(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	uri, recipeName := c.recipeName(r)
+
+	body, err := FetchWikiPage(r.Context(), uri)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	recipe, ok := ParseRecipe(recipeName, body)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := recipe.Save(r.Context()); err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(recipe)
+}
+
+// Returns the recipes we've scraped which use the item and those which produce it
+func (c *RecipeController) itemRecipes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	item := IC.lookupItem(r)
+	if item.id <= 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	usedIn, producedBy, err := FetchItemRecipes(r.Context(), item.id, item.name)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"used_in": usedIn,
+		"produced_by": producedBy,
+	})
+}
+
+// Returns the wiki uri and the display name of the recipe in the route
+func (c *RecipeController) recipeName(r *http.Request) (string, string) {
+	uri := TitleCase(strings.Replace(mux.Vars(r)["recipe_name"], "_", " ", -1), true)
+	return uri, strings.Replace(uri, "_", " ", -1)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type SellerController struct {
+	Controller
+}
+
+// Returns everything a character has auctioned, newest first, one page at a time
+func (c *SellerController) show(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	seller := NormaliseSeller(mux.Vars(r)["name"])
+	if seller == "" {
+		http.Error(w, "Please send a seller name", 400)
+		return
+	}
+
+	page, ok := IntQueryParam(r, "page", 1, 1, 1000000)
+	if !ok {
+		http.Error(w, "page must be a positive number", 400)
+		return
+	}
+	perPage, ok := IntQueryParam(r, "per_page", DEFAULT_PER_PAGE, 1, MAX_PER_PAGE)
+	if !ok {
+		http.Error(w, "per_page must be a number between 1 and " + strconv.Itoa(MAX_PER_PAGE), 400)
+		return
+	}
+
+	auctions, total := FetchSellerAuctions(TenantFrom(r.Context()), seller, page, perPage)
+	if total == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"seller": seller,
+		"page": page,
+		"perPage": perPage,
+		"total": total,
+		"auctions": auctions,
+	})
+}
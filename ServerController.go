@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type ServerController struct {
+	Controller
+}
+
+// Lists every item rule for a server, i.e. /servers/green/rules
+func (c *ServerController) rules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	server, ok := NormaliseServer(mux.Vars(r)["server"])
+	if !ok {
+		http.Error(w, "Invalid server", 400)
+		return
+	}
+	if !TenantAllows(r, server) {
+		http.Error(w, "Please send an API key for this server", http.StatusForbidden)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(FetchItemRules(server))
+}
+
+// Adds a rule for an item on a server, the body looks like:
+// {"item": "Cloak of Flames", "rule": "duped", "reason": "...", "excludeFromMarket": true}
+// Sending the same item and rule again updates the existing rule
+func (c *ServerController) storeRule(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	server, ok := NormaliseServer(mux.Vars(r)["server"])
+	if !ok {
+		http.Error(w, "Invalid server", 400)
+		return
+	}
+	if !TenantAllows(r, server) {
+		http.Error(w, "Please send an API key for this server", http.StatusForbidden)
+		return
+	}
+
+	var body struct {
+		Item              string `json:"item"`
+		Rule              string `json:"rule"`
+		Reason            string `json:"reason"`
+		ExcludeFromMarket bool   `json:"excludeFromMarket"`
+	}
+	if r.Body == nil {
+		http.Error(w, "Please send a request body", 400)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	rule := strings.ToLower(strings.TrimSpace(body.Rule))
+	if !IsItemRule(rule) {
+		http.Error(w, "rule must be one of " + ITEM_RULE_BANNED + ", " + ITEM_RULE_DUPED + " or " + ITEM_RULE_LEGACY, 400)
+		return
+	}
+
+	item := Item{ name: strings.TrimSpace(body.Item) }
+	if _, err := item.fetchDataFromSQL(r.Context()); err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	if item.id <= 0 {
+		http.Error(w, "Unknown item", http.StatusNotFound)
+		return
+	}
+
+	itemRule := ItemRule{
+		server: server,
+		itemId: item.id,
+		itemName: item.name,
+		rule: rule,
+		reason: strings.TrimSpace(body.Reason),
+		excludeFromMarket: body.ExcludeFromMarket,
+	}
+	if err := itemRule.Save(); err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(itemRule)
+}
+
+// Removes a rule from a server
+func (c *ServerController) deleteRule(w http.ResponseWriter, r *http.Request) {
+	server, ok := NormaliseServer(mux.Vars(r)["server"])
+	if !ok {
+		http.Error(w, "Invalid server", 400)
+		return
+	}
+	if !TenantAllows(r, server) {
+		http.Error(w, "Please send an API key for this server", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid rule id", 400)
+		return
+	}
+
+	if !DeleteItemRule(server, id) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type SpellController struct {
+	Controller
+}
+
+// Returns a spell, scraping it from the wiki if we haven't stored it yet
+func (c *SpellController) store(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	spell := Spell {
+		name: TitleCase(strings.Replace(mux.Vars(r)["spell_name"], "_", " ", -1), false),
+	}
+
+	if spell.FetchData(r.Context()) {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(spell)
+	} else {
+		LoggerFrom(r.Context()).Info("Couldn't find spell", "spell", spell.name)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Returns the full progression of spells that the requested spell belongs to
+func (c *SpellController) line(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var spellLine SpellLine
+	if !spellLine.FetchBySpell(mux.Vars(r)["spell_name"]) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(spellLine)
+}
+
+// Returns the pets that a summoning spell can create
+func (c *SpellController) pets(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	spellName := strings.Replace(mux.Vars(r)["spell_name"], "_", " ", -1)
+	if !strings.HasPrefix(strings.ToLower(spellName), "spell:") {
+		spellName = "Spell: " + spellName
+	}
+
+	spell := Item {
+		name: spellName,
+	}
+	spell.fetchDataFromSQL(r.Context())
+	if spell.id <= 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(FetchPets(spell.id))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type ZoneController struct {
+	Controller
+}
+
+// Returns the zone's level range, connected zones and notable NPCs, if we have
+// never seen the zone before its wiki page is scraped first
+func (c *ZoneController) show(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	uri, zoneName := c.zoneName(r)
+
+	zone, exists, err := FetchZone(r.Context(), zoneName)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+	if !exists {
+		body, err := FetchWikiPage(r.Context(), uri)
+		if err != nil {
+			http.Error(w, err.Error(), StatusForError(err))
+			return
+		}
+		var ok bool
+		if zone, ok = ParseZone(zoneName, body); !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := zone.Save(r.Context()); err != nil {
+			http.Error(w, err.Error(), StatusForError(err))
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(zone)
+}
+
+// Returns every item we know drops in the zone and the NPC which drops it
+func (c *ZoneController) items(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_, zoneName := c.zoneName(r)
+
+	items, err := FetchZoneItems(r.Context(), zoneName)
+	if err != nil {
+		http.Error(w, err.Error(), StatusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(items)
+}
+
+// Returns the wiki uri and the display name of the zone in the route
+func (c *ZoneController) zoneName(r *http.Request) (string, string) {
+	uri := TitleCase(strings.Replace(mux.Vars(r)["zone_name"], "_", " ", -1), true)
+	return uri, strings.Replace(uri, "_", " ", -1)
+}
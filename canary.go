@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: CanaryResult
+ |--------------------------------------------------------------------------
+ |
+ | Represents one check of a reference page. The canary re-parses a fixed
+ | set of item pages we know parse correctly and compares the stats and
+ | effects with what they parsed to when they were accepted. A difference
+ | usually means the wiki's templates have changed, so until the output is
+ | accepted again updates to items we already have are refused rather than
+ | overwriting good data with whatever the new markup parses to. The first
+ | time a page is checked its output is accepted
+ |
+ | @member page (string): Wiki uri of the reference page
+ | @member status (string): One of the CANARY_* statuses
+ | @member reason (string): Why the page diverged or couldn't be checked
+ | @member data ([]byte): What the page parsed to
+ | @member checkedAt (time.Time): When the page was checked
+ |
+ */
+
+type CanaryResult struct {
+	page string
+	status string
+	reason string
+	data []byte
+	checkedAt time.Time
+}
+
+const (
+	CANARY_OK          = "ok"
+	CANARY_DIVERGED    = "diverged"
+	CANARY_UNAVAILABLE = "unavailable"
+)
+
+// Results of the latest run, divergences counts every diverged page since we
+// started so alerts can fire on it increasing
+var canary = struct {
+	sync.Mutex
+	results []CanaryResult
+	paused bool
+	divergences int64
+	lastRunAt time.Time
+}{}
+
+// Checks the reference pages every canary_interval_in_secs until we shut down
+func RunCanary() {
+	if len(canaryPages()) == 0 {
+		Log.Info("The wiki canary is disabled as no canary_pages are configured")
+		return
+	}
+
+	for {
+		CheckCanary(AppContext)
+		select {
+		case <-time.After(seconds(Settings.CanaryIntervalInSecs)):
+		case <-AppContext.Done():
+			return
+		}
+	}
+}
+
+func canaryPages() []string {
+	var pages []string
+	for _, page := range strings.Split(Settings.CanaryPages, ",") {
+		if page = strings.TrimSpace(page); page != "" {
+			pages = append(pages, page)
+		}
+	}
+	return pages
+}
+
+// Checks every reference page, pausing item updates if any diverged. Pages we
+// couldn't fetch don't change whether we're paused
+func CheckCanary(ctx context.Context) []CanaryResult {
+	var (
+		results []CanaryResult
+		diverged int
+		unavailable int
+	)
+	for _, page := range canaryPages() {
+		result := checkCanaryPage(ctx, page)
+		switch result.status {
+		case CANARY_DIVERGED:
+			Log.Error("Wiki canary diverged", "page", page, "reason", result.reason)
+			diverged++
+		case CANARY_UNAVAILABLE:
+			unavailable++
+		}
+		results = append(results, result)
+	}
+
+	canary.Lock()
+	canary.results = results
+	canary.lastRunAt = time.Now().UTC()
+	canary.divergences += int64(diverged)
+	if diverged > 0 {
+		canary.paused = true
+	} else if unavailable == 0 {
+		canary.paused = false
+	}
+	paused := canary.paused
+	canary.Unlock()
+
+	Log.Info("Checked wiki canary", "pages", len(results), "diverged", diverged, "unavailable", unavailable, "paused", paused)
+	return results
+}
+
+func checkCanaryPage(ctx context.Context, page string) CanaryResult {
+	result := CanaryResult{ page: page, status: CANARY_OK, checkedAt: time.Now().UTC() }
+
+	body, err := FetchWikiPage(ctx, page)
+	if err != nil {
+		result.status = CANARY_UNAVAILABLE
+		result.reason = ErrorLabel(err)
+		return result
+	}
+
+	item := Item{ name: strings.Replace(page, "_", " ", -1), displayName: page }
+	if err := item.parseItemPage(ctx, body); err != nil {
+		result.status = CANARY_DIVERGED
+		result.reason = ErrorLabel(err)
+		return result
+	}
+	if result.data, err = encodeItemRevision(item); err != nil {
+		Log.Error("Failed to encode canary output", "page", page, "err", err)
+		result.status = CANARY_UNAVAILABLE
+		result.reason = "encode_failed"
+		return result
+	}
+
+	expected, exists, err := fetchCanaryExpectation(ctx, page)
+	if err != nil {
+		result.status = CANARY_UNAVAILABLE
+		result.reason = ErrorLabel(err)
+		return result
+	}
+	if !exists {
+		if err := saveCanaryExpectation(ctx, page, result.data); err != nil {
+			result.status = CANARY_UNAVAILABLE
+			result.reason = ErrorLabel(err)
+		}
+		return result
+	}
+	if !bytes.Equal(expected, result.data) {
+		result.status = CANARY_DIVERGED
+		result.reason = "output_changed"
+	}
+	return result
+}
+
+func fetchCanaryExpectation(ctx context.Context, page string) ([]byte, bool, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT data FROM canary_expectations WHERE page = ?", page)
+	if err != nil {
+		return nil, false, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	var data []byte
+	exists := false
+	for rows.Next() {
+		if err := rows.Scan(&data); err != nil {
+			Log.Error("Scan failed", "err", err)
+			return nil, false, ErrDatabaseRead
+		}
+		exists = true
+	}
+	return data, exists, nil
+}
+
+func saveCanaryExpectation(ctx context.Context, page string, data []byte) error {
+	query := "INSERT INTO canary_expectations (page, data, updated_at) " +
+		"VALUES (?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE data = VALUES(data), updated_at = VALUES(updated_at)"
+	if _, err := DB.InsertContext(ctx, query, page, data, time.Now().UTC()); err != nil {
+		return ErrDatabaseWrite
+	}
+	return nil
+}
+
+// Accepts what the diverged pages parsed to in the latest run as their new
+// expected output and resumes item updates. Call this once the parser has been
+// fixed, or the change has been checked and is a genuine edit to the page.
+// Returns the pages which were accepted
+func AcceptCanary(ctx context.Context) ([]string, error) {
+	canary.Lock()
+	results := canary.results
+	canary.Unlock()
+
+	accepted := []string{}
+	for _, result := range results {
+		if result.status != CANARY_DIVERGED {
+			continue
+		}
+		if result.data == nil {
+			// The page didn't parse at all so there's nothing to accept
+			continue
+		}
+		if err := saveCanaryExpectation(ctx, result.page, result.data); err != nil {
+			return accepted, err
+		}
+		accepted = append(accepted, result.page)
+	}
+
+	canary.Lock()
+	canary.paused = false
+	canary.Unlock()
+	Log.Info("Accepted wiki canary output", "pages", accepted)
+	return accepted, nil
+}
+
+// Returns true while updates to items we already have are paused
+func CanaryPaused() bool {
+	canary.Lock()
+	defer canary.Unlock()
+	return canary.paused
+}
+
+func CanaryStatus() map[string]interface{} {
+	canary.Lock()
+	defer canary.Unlock()
+
+	results := canary.results
+	if results == nil {
+		results = []CanaryResult{}
+	}
+	var lastRunAt *time.Time
+	if !canary.lastRunAt.IsZero() {
+		lastRunAt = &canary.lastRunAt
+	}
+	return map[string]interface{}{
+		"paused": canary.paused,
+		"divergences": canary.divergences,
+		"last_run_at": lastRunAt,
+		"pages": results,
+	}
+}
+
+func (c CanaryResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Page      string    `json:"page"`
+		Status    string    `json:"status"`
+		Reason    string    `json:"reason,omitempty"`
+		CheckedAt time.Time `json:"checked_at"`
+	}{c.page, c.status, c.reason, c.checkedAt})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: FetchGroup
+ |--------------------------------------------------------------------------
+ |
+ | Coalesces concurrent fetches of the same item. If fifty requests for an
+ | uncached item arrive at once, the first runs the fetch and the others
+ | wait for and share its result, so the wiki is only scraped and the item
+ | only saved once. The fetch runs on its own goroutine, so whoever started
+ | it can give up waiting without cancelling it for everyone else
+ |
+ | @member calls (map[string]*fetchCall): Fetches in flight, keyed by itemCacheKey
+ | @member coalesced (int64): Number of callers which shared another's fetch
+ |
+ */
+
+type FetchGroup struct {
+	mutex sync.Mutex
+	calls map[string]*fetchCall
+	coalesced int64
+}
+
+type fetchCall struct {
+	done chan struct{}
+	item Item
+	err error
+}
+
+var ItemFetches = FetchGroup{ calls: make(map[string]*fetchCall) }
+
+// Starts fetch unless a fetch for the key is already in flight, then waits for
+// whichever fetch it is and returns its result. Returns ctx's error if ctx is
+// cancelled first, the fetch carries on for anyone else waiting on it
+func (g *FetchGroup) Do(ctx context.Context, key string, fetch func() (Item, error)) (Item, error) {
+	g.mutex.Lock()
+	call, exists := g.calls[key]
+	if exists {
+		g.mutex.Unlock()
+		atomic.AddInt64(&g.coalesced, 1)
+		Log.Debug("Waiting on in flight fetch", "key", key)
+	} else {
+		call = &fetchCall{ done: make(chan struct{}) }
+		g.calls[key] = call
+		g.mutex.Unlock()
+		go g.run(key, call, fetch)
+	}
+
+	select {
+	case <-call.done:
+		return call.item, call.err
+	case <-ctx.Done():
+		return Item{}, ctx.Err()
+	}
+}
+
+func (g *FetchGroup) run(key string, call *fetchCall, fetch func() (Item, error)) {
+	// Always release the waiters
+	defer func() {
+		g.mutex.Lock()
+		delete(g.calls, key)
+		g.mutex.Unlock()
+		close(call.done)
+	}()
+
+	call.item, call.err = fetch()
+}
+
+func (g *FetchGroup) Coalesced() int64 {
+	return atomic.LoadInt64(&g.coalesced)
+}
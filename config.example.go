@@ -1,26 +0,0 @@
-package main
-
-/*
-// RENAME THIS FILE TO config.go AND UNCOMMENT ALL CODE IN THIS FILE WHEN YOU
-// BUILD THE APPLICATION IT HAS BEEN GIT IGNORED TO HIDE ANY SERVER
-// DETAIL CONSTANT SUCH AS IP'S AND PORTS
-const DEBUG = true
-
-const WIKI_BASE_URL string = "http://wiki.project1999.com"
-
-// SQL DB Config
-const SQL_HOST = "";
-const SQL_PORT = "";
-const SQL_USER = "";
-const SQL_PASS = "";
-const SQL_DB   = ""
-
-const PORT = "8080"
-
-// Memcached Config
-const MC_HOST = "";
-const MC_PORT = "";
-
-const CACHE_TIME_IN_SECS = 60
-const MAX_CONNECTIONS = 20
- */
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Config
+ |--------------------------------------------------------------------------
+ |
+ | Everything that differs between deployments. Settings start from the
+ | defaults below, are overridden by environment variables named in each
+ | field's env tag, and then by the TOML file at CONFIG_FILE if one is set.
+ | The result is validated on boot so a bad deploy fails straight away. See
+ | config.example.toml for every setting
+ |
+ */
+
+type Config struct {
+	Debug     bool   `toml:"debug" env:"DEBUG"`
+	LogFormat string `toml:"log_format" env:"LOG_FORMAT"`
+	Port      string `toml:"port" env:"PORT"`
+	GrpcPort  string `toml:"grpc_port" env:"GRPC_PORT"`
+
+	WikiBaseUrl            string  `toml:"wiki_base_url" env:"WIKI_BASE_URL"`
+	WikiTimeoutInSecs      int     `toml:"wiki_timeout_in_secs" env:"WIKI_TIMEOUT_IN_SECS"`
+	WikiMaxAttempts        int     `toml:"wiki_max_attempts" env:"WIKI_MAX_ATTEMPTS"`
+	WikiRetryBaseDelayInMs int     `toml:"wiki_retry_base_delay_in_ms" env:"WIKI_RETRY_BASE_DELAY_IN_MS"`
+	WikiRequestsPerSecond  float64 `toml:"wiki_requests_per_second" env:"WIKI_REQUESTS_PER_SECOND"`
+	WikiBurst              int     `toml:"wiki_burst" env:"WIKI_BURST"`
+	WikiMaxWaitInSecs      int     `toml:"wiki_max_wait_in_secs" env:"WIKI_MAX_WAIT_IN_SECS"`
+
+	SqlDriver             string `toml:"sql_driver" env:"SQL_DRIVER"`
+	SqlitePath            string `toml:"sqlite_path" env:"SQLITE_PATH"`
+	SqlHost               string `toml:"sql_host" env:"SQL_HOST"`
+	SqlPort               string `toml:"sql_port" env:"SQL_PORT"`
+	SqlUser               string `toml:"sql_user" env:"SQL_USER"`
+	SqlPass               string `toml:"sql_pass" env:"SQL_PASS"`
+	SqlDb                 string `toml:"sql_db" env:"SQL_DB"`
+	MaxConnections        int    `toml:"max_connections" env:"MAX_CONNECTIONS"`
+	MaxIdleConnections    int    `toml:"max_idle_connections" env:"MAX_IDLE_CONNECTIONS"`
+	ConnMaxLifetimeInSecs int    `toml:"conn_max_lifetime_in_secs" env:"CONN_MAX_LIFETIME_IN_SECS"`
+	MigrateOnBoot         bool   `toml:"migrate_on_boot" env:"MIGRATE_ON_BOOT"`
+
+	RedisHost       string `toml:"redis_host" env:"REDIS_HOST"`
+	RedisPort       string `toml:"redis_port" env:"REDIS_PORT"`
+	RedisTtlInSecs  int    `toml:"redis_ttl_in_secs" env:"REDIS_TTL_IN_SECS"`
+	CacheTimeInSecs int    `toml:"cache_time_in_secs" env:"CACHE_TIME_IN_SECS"`
+	ItemCacheSize   int    `toml:"item_cache_size" env:"ITEM_CACHE_SIZE"`
+
+	MaxConcurrentExpensiveRequests int `toml:"max_concurrent_expensive_requests" env:"MAX_CONCURRENT_EXPENSIVE_REQUESTS"`
+	RetryAfterInSecs               int `toml:"retry_after_in_secs" env:"RETRY_AFTER_IN_SECS"`
+	ParseWorkers                   int `toml:"parse_workers" env:"PARSE_WORKERS"`
+	RollupIntervalInSecs           int `toml:"rollup_interval_in_secs" env:"ROLLUP_INTERVAL_IN_SECS"`
+	RetryIntervalInSecs            int `toml:"retry_interval_in_secs" env:"RETRY_INTERVAL_IN_SECS"`
+	ShutdownTimeoutInSecs          int `toml:"shutdown_timeout_in_secs" env:"SHUTDOWN_TIMEOUT_IN_SECS"`
+
+	CanaryPages          string `toml:"canary_pages" env:"CANARY_PAGES"`
+	CanaryIntervalInSecs int    `toml:"canary_interval_in_secs" env:"CANARY_INTERVAL_IN_SECS"`
+
+	RescrapeAgeInDays      int `toml:"rescrape_age_in_days" env:"RESCRAPE_AGE_IN_DAYS"`
+	RescrapeIntervalInSecs int `toml:"rescrape_interval_in_secs" env:"RESCRAPE_INTERVAL_IN_SECS"`
+	RescrapeBatchSize      int `toml:"rescrape_batch_size" env:"RESCRAPE_BATCH_SIZE"`
+
+	PageSnapshotsPerItem int `toml:"page_snapshots_per_item" env:"PAGE_SNAPSHOTS_PER_ITEM"`
+
+	ScrapeEffectDetails bool `toml:"scrape_effect_details" env:"SCRAPE_EFFECT_DETAILS"`
+
+	UsageBufferSize          int `toml:"usage_buffer_size" env:"USAGE_BUFFER_SIZE"`
+	UsageFlushIntervalInSecs int `toml:"usage_flush_interval_in_secs" env:"USAGE_FLUSH_INTERVAL_IN_SECS"`
+	UsageFlushSize           int `toml:"usage_flush_size" env:"USAGE_FLUSH_SIZE"`
+
+	FeedMaxSubscribers int `toml:"feed_max_subscribers" env:"FEED_MAX_SUBSCRIBERS"`
+	FeedBufferSize     int `toml:"feed_buffer_size" env:"FEED_BUFFER_SIZE"`
+
+	WebhookRetryIntervalInSecs int `toml:"webhook_retry_interval_in_secs" env:"WEBHOOK_RETRY_INTERVAL_IN_SECS"`
+	WebhookMaxAttempts         int `toml:"webhook_max_attempts" env:"WEBHOOK_MAX_ATTEMPTS"`
+	WebhookTimeoutInSecs       int `toml:"webhook_timeout_in_secs" env:"WEBHOOK_TIMEOUT_IN_SECS"`
+
+	AliasLearningIntervalInSecs int `toml:"alias_learning_interval_in_secs" env:"ALIAS_LEARNING_INTERVAL_IN_SECS"`
+	AliasLearningLines          int `toml:"alias_learning_lines" env:"ALIAS_LEARNING_LINES"`
+	AliasMinOccurrences         int `toml:"alias_min_occurrences" env:"ALIAS_MIN_OCCURRENCES"`
+
+	SecretsProvider              string `toml:"secrets_provider" env:"SECRETS_PROVIDER"`
+	SecretsRefreshIntervalInSecs int    `toml:"secrets_refresh_interval_in_secs" env:"SECRETS_REFRESH_INTERVAL_IN_SECS"`
+	AwsRegion                    string `toml:"aws_region" env:"AWS_REGION"`
+	AwsAccessKeyId               string `toml:"aws_access_key_id" env:"AWS_ACCESS_KEY_ID"`
+	AwsSecretAccessKey           string `toml:"aws_secret_access_key" env:"AWS_SECRET_ACCESS_KEY"`
+	AwsSessionToken              string `toml:"aws_session_token" env:"AWS_SESSION_TOKEN"`
+	VaultAddr                    string `toml:"vault_addr" env:"VAULT_ADDR"`
+	VaultToken                   string `toml:"vault_token" env:"VAULT_TOKEN"`
+
+	RequireApiKey bool   `toml:"require_api_key" env:"REQUIRE_API_KEY"`
+	AdminApiKey   string `toml:"admin_api_key" env:"ADMIN_API_KEY"`
+	ReadOnly      bool   `toml:"read_only" env:"READ_ONLY"`
+
+	DiscordPublicKey string `toml:"discord_public_key" env:"DISCORD_PUBLIC_KEY"`
+
+	SnapshotEndpoint      string `toml:"snapshot_endpoint" env:"SNAPSHOT_ENDPOINT"`
+	SnapshotRegion        string `toml:"snapshot_region" env:"SNAPSHOT_REGION"`
+	SnapshotBucket        string `toml:"snapshot_bucket" env:"SNAPSHOT_BUCKET"`
+	SnapshotPrefix        string `toml:"snapshot_prefix" env:"SNAPSHOT_PREFIX"`
+	SnapshotAccessKey     string `toml:"snapshot_access_key" env:"SNAPSHOT_ACCESS_KEY"`
+	SnapshotSecretKey     string `toml:"snapshot_secret_key" env:"SNAPSHOT_SECRET_KEY"`
+	SnapshotPublicUrl     string `toml:"snapshot_public_url" env:"SNAPSHOT_PUBLIC_URL"`
+	SnapshotHourUtc       int    `toml:"snapshot_hour_utc" env:"SNAPSHOT_HOUR_UTC"`
+	SnapshotRetentionDays int    `toml:"snapshot_retention_days" env:"SNAPSHOT_RETENTION_DAYS"`
+
+	ImageStore  string `toml:"image_store" env:"IMAGE_STORE"`
+	ImagePath   string `toml:"image_path" env:"IMAGE_PATH"`
+	ImageBucket string `toml:"image_bucket" env:"IMAGE_BUCKET"`
+	ImagePrefix string `toml:"image_prefix" env:"IMAGE_PREFIX"`
+
+	ItemStore string `toml:"item_store" env:"ITEM_STORE"`
+	MongoUri  string `toml:"mongo_uri" env:"MONGO_URI"`
+	MongoDb   string `toml:"mongo_db" env:"MONGO_DB"`
+}
+
+// The loaded settings, this holds the defaults until LoadConfig is called
+var Settings = DefaultConfig()
+
+func DefaultConfig() Config {
+	return Config{
+		LogFormat: "text",
+		Port: "8080",
+		WikiBaseUrl: "http://wiki.project1999.com",
+		WikiTimeoutInSecs: 10,
+		WikiMaxAttempts: 3,
+		WikiRetryBaseDelayInMs: 500,
+		WikiRequestsPerSecond: 2,
+		WikiBurst: 5,
+		WikiMaxWaitInSecs: 30,
+		SqlDriver: SQL_DRIVER_MYSQL,
+		SqlitePath: "service-wiki.db",
+		SqlPort: "3306",
+		MaxConnections: 20,
+		MaxIdleConnections: 10,
+		ConnMaxLifetimeInSecs: 300,
+		MigrateOnBoot: true,
+		RedisPort: "6379",
+		RedisTtlInSecs: 3600,
+		CacheTimeInSecs: 60,
+		ItemCacheSize: 1000,
+		MaxConcurrentExpensiveRequests: 16,
+		RetryAfterInSecs: 5,
+		ParseWorkers: 8,
+		RollupIntervalInSecs: 300,
+		RetryIntervalInSecs: 60,
+		ShutdownTimeoutInSecs: 30,
+		CanaryPages: "Cloak_of_Flames,Fungus_Covered_Scale_Tunic,Short_Sword_of_Ykesha",
+		CanaryIntervalInSecs: 3600,
+		RescrapeAgeInDays: 30,
+		RescrapeIntervalInSecs: 60,
+		RescrapeBatchSize: 5,
+		PageSnapshotsPerItem: 5,
+		ScrapeEffectDetails: true,
+		UsageBufferSize: 10000,
+		UsageFlushIntervalInSecs: 15,
+		UsageFlushSize: 500,
+		FeedMaxSubscribers: 1000,
+		FeedBufferSize: 64,
+		WebhookRetryIntervalInSecs: 10,
+		WebhookMaxAttempts: 8,
+		WebhookTimeoutInSecs: 10,
+		AliasLearningIntervalInSecs: 86400,
+		AliasLearningLines: 5000,
+		AliasMinOccurrences: 5,
+		SecretsRefreshIntervalInSecs: 300,
+		SnapshotEndpoint: "https://s3.amazonaws.com",
+		SnapshotRegion: "us-east-1",
+		SnapshotPrefix: "snapshots/",
+		SnapshotHourUtc: 3,
+		SnapshotRetentionDays: 30,
+		ImageStore: IMAGE_STORE_DISK,
+		ImagePath: "images",
+		ImagePrefix: "images/",
+		ItemStore: ITEM_STORE_SQL,
+		MongoDb: "service_wiki",
+	}
+}
+
+// Builds the config from the defaults, the environment and CONFIG_FILE, in that
+// order, then resolves any secret references. Returns every problem found
+// rather than just the first
+func LoadConfig() (Config, []string) {
+	config := DefaultConfig()
+
+	if err := config.applyEnv(os.Getenv); err != nil {
+		return config, []string{ err.Error() }
+	}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if _, err := toml.DecodeFile(path, &config); err != nil {
+			return config, []string{ "Couldn't read " + path + ": " + err.Error() }
+		}
+	}
+	if problems := config.resolveSecrets(); len(problems) > 0 {
+		return config, problems
+	}
+
+	return config, config.Validate()
+}
+
+// Sets every field whose env variable is set, using reflection so a new setting
+// only needs its tag
+func (c *Config) applyEnv(getenv func(string) string) error {
+	value := reflect.ValueOf(c).Elem()
+	for idx := 0; idx < value.NumField(); idx++ {
+		field := value.Type().Field(idx)
+		raw := getenv(field.Tag.Get("env"))
+		if raw == "" {
+			continue
+		}
+
+		switch field.Type.Kind() {
+		case reflect.String:
+			value.Field(idx).SetString(raw)
+		case reflect.Int:
+			parsed, err := strconv.Atoi(raw)
+			if err != nil {
+				return errors.New(field.Tag.Get("env") + " must be a whole number")
+			}
+			value.Field(idx).SetInt(int64(parsed))
+		case reflect.Float64:
+			parsed, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return errors.New(field.Tag.Get("env") + " must be a number")
+			}
+			value.Field(idx).SetFloat(parsed)
+		case reflect.Bool:
+			parsed, err := strconv.ParseBool(raw)
+			if err != nil {
+				return errors.New(field.Tag.Get("env") + " must be true or false")
+			}
+			value.Field(idx).SetBool(parsed)
+		}
+	}
+	return nil
+}
+
+// Returns a description of every invalid setting, the config is fine if nothing
+// is returned
+func (c Config) Validate() []string {
+	var problems []string
+
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		problems = append(problems, "log_format must be text or json")
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port <= 0 || port > 65535 {
+		problems = append(problems, "port must be a port number")
+	}
+	if port, err := strconv.Atoi(c.GrpcPort); c.GrpcPort != "" && (err != nil || port <= 0 || port > 65535 || c.GrpcPort == c.Port) {
+		problems = append(problems, "grpc_port must be empty or a port number other than port")
+	}
+	if c.GrpcPort != "" && !grpcAvailable {
+		problems = append(problems, "grpc_port needs a binary built with -tags grpc")
+	}
+	if parsed, err := url.Parse(c.WikiBaseUrl); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		problems = append(problems, "wiki_base_url must be an http(s) url")
+	}
+	if strings.HasSuffix(c.WikiBaseUrl, "/") {
+		problems = append(problems, "wiki_base_url must not end with a /")
+	}
+	if dialect, exists := sqlDialects[c.SqlDriver]; exists {
+		problems = append(problems, dialect.validate(c)...)
+	} else {
+		problems = append(problems, "sql_driver must be mysql or sqlite")
+	}
+	if c.SecretsProvider != "" && c.SecretsProvider != SECRETS_PROVIDER_AWS && c.SecretsProvider != SECRETS_PROVIDER_VAULT {
+		problems = append(problems, "secrets_provider must be aws or vault")
+	}
+	if c.AdminApiKey != "" && len(c.AdminApiKey) < ADMIN_API_KEY_MIN_LENGTH {
+		problems = append(problems, "admin_api_key must be at least " + strconv.Itoa(ADMIN_API_KEY_MIN_LENGTH) + " characters")
+	}
+	if c.DiscordPublicKey != "" {
+		if key, err := hex.DecodeString(c.DiscordPublicKey); err != nil || len(key) != 32 {
+			problems = append(problems, "discord_public_key must be a hex encoded Ed25519 public key")
+		}
+	}
+
+	if c.SnapshotBucket != "" {
+		if parsed, err := url.Parse(c.SnapshotEndpoint); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			problems = append(problems, "snapshot_endpoint must be an http(s) url")
+		}
+		if c.SnapshotRegion == "" || c.SnapshotAccessKey == "" || c.SnapshotSecretKey == "" {
+			problems = append(problems, "snapshot_region, snapshot_access_key and snapshot_secret_key are required when snapshot_bucket is set")
+		}
+	}
+	if strings.HasSuffix(c.SnapshotPublicUrl, "/") {
+		problems = append(problems, "snapshot_public_url must not end with a /")
+	}
+	if c.WikiRequestsPerSecond <= 0 {
+		problems = append(problems, "wiki_requests_per_second must be greater than 0")
+	}
+	if c.RescrapeAgeInDays < 0 {
+		problems = append(problems, "rescrape_age_in_days must be 0 or more")
+	}
+	if c.SnapshotHourUtc < 0 || c.SnapshotHourUtc > 23 {
+		problems = append(problems, "snapshot_hour_utc must be between 0 and 23")
+	}
+	switch c.ImageStore {
+	case IMAGE_STORE_DISK:
+		if c.ImagePath == "" {
+			problems = append(problems, "image_path is required when image_store is disk")
+		}
+	case IMAGE_STORE_S3:
+		if c.ImageBucket == "" {
+			problems = append(problems, "image_bucket is required when image_store is s3")
+		}
+		if c.SnapshotRegion == "" || c.SnapshotAccessKey == "" || c.SnapshotSecretKey == "" {
+			problems = append(problems, "snapshot_region, snapshot_access_key and snapshot_secret_key are required when image_store is s3")
+		}
+	default:
+		problems = append(problems, "image_store must be disk or s3")
+	}
+	switch c.ItemStore {
+	case ITEM_STORE_SQL:
+	case ITEM_STORE_MONGO:
+		if c.MongoUri == "" || c.MongoDb == "" {
+			problems = append(problems, "mongo_uri and mongo_db are required when item_store is mongo")
+		}
+		if !mongoAvailable {
+			problems = append(problems, "item_store mongo needs a binary built with -tags mongo")
+		}
+	default:
+		problems = append(problems, "item_store must be sql or mongo")
+	}
+
+	// Everything else is a count or a duration which must be positive, the item
+	// cache size can be 0 to disable the cache
+	positive := map[string]int{
+		"wiki_timeout_in_secs": c.WikiTimeoutInSecs,
+		"wiki_max_attempts": c.WikiMaxAttempts,
+		"wiki_retry_base_delay_in_ms": c.WikiRetryBaseDelayInMs,
+		"wiki_burst": c.WikiBurst,
+		"wiki_max_wait_in_secs": c.WikiMaxWaitInSecs,
+		"max_connections": c.MaxConnections,
+		"max_idle_connections": c.MaxIdleConnections,
+		"conn_max_lifetime_in_secs": c.ConnMaxLifetimeInSecs,
+		"redis_ttl_in_secs": c.RedisTtlInSecs,
+		"cache_time_in_secs": c.CacheTimeInSecs,
+		"max_concurrent_expensive_requests": c.MaxConcurrentExpensiveRequests,
+		"retry_after_in_secs": c.RetryAfterInSecs,
+		"parse_workers": c.ParseWorkers,
+		"rollup_interval_in_secs": c.RollupIntervalInSecs,
+		"retry_interval_in_secs": c.RetryIntervalInSecs,
+		"shutdown_timeout_in_secs": c.ShutdownTimeoutInSecs,
+		"canary_interval_in_secs": c.CanaryIntervalInSecs,
+		"rescrape_interval_in_secs": c.RescrapeIntervalInSecs,
+		"rescrape_batch_size": c.RescrapeBatchSize,
+		"page_snapshots_per_item": c.PageSnapshotsPerItem,
+		"usage_buffer_size": c.UsageBufferSize,
+		"usage_flush_interval_in_secs": c.UsageFlushIntervalInSecs,
+		"usage_flush_size": c.UsageFlushSize,
+		"feed_max_subscribers": c.FeedMaxSubscribers,
+		"feed_buffer_size": c.FeedBufferSize,
+		"webhook_retry_interval_in_secs": c.WebhookRetryIntervalInSecs,
+		"webhook_max_attempts": c.WebhookMaxAttempts,
+		"webhook_timeout_in_secs": c.WebhookTimeoutInSecs,
+		"alias_learning_interval_in_secs": c.AliasLearningIntervalInSecs,
+		"alias_learning_lines": c.AliasLearningLines,
+		"alias_min_occurrences": c.AliasMinOccurrences,
+		"secrets_refresh_interval_in_secs": c.SecretsRefreshIntervalInSecs,
+		"snapshot_retention_days": c.SnapshotRetentionDays,
+	}
+	var names []string
+	for name, value := range positive {
+		if value <= 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		problems = append(problems, name + " must be greater than 0")
+	}
+	if c.ItemCacheSize < 0 {
+		problems = append(problems, "item_cache_size can't be negative")
+	}
+
+	return problems
+}
+
+func seconds(secs int) time.Duration {
+	return time.Duration(secs) * time.Second
+}
@@ -5,4 +5,25 @@ package main
 type Controller interface {}
 
 // Instantiate all controllers here so that we can bind them to our routes
-var IC = new(ItemController)
\ No newline at end of file
+var IC = new(ItemController)
+var QC = new(QuarantineController)
+var MC = new(MarketController)
+var SC = new(SpellController)
+var NC = new(NpcController)
+var ZC = new(ZoneController)
+var QTC = new(QuestController)
+var RPC = new(RecipeController)
+var FC = new(FactionController)
+var CC = new(ClassController)
+var JC = new(JobController)
+var RC = new(ServerController)
+var KC = new(CacheController)
+var SLC = new(SellerController)
+var XC = new(ExportController)
+var IPC = new(ImportController)
+var DC = new(DiscordController)
+var AC = new(AdminController)
+var OC = new(OpenApiController)
+var GC = new(GraphqlController)
+var EC = new(FeedController)
+var IMC = new(ImageController)
\ No newline at end of file
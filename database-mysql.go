@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | MySQL backend
+ |--------------------------------------------------------------------------
+ |
+ | The default backend, and the dialect every query in the service is
+ | written in. Connections are made through mysqlConnector so rotated
+ | credentials are picked up, and the schema is brought up to date by the
+ | migrations in migrations/
+ |
+ */
+
+const SQL_DRIVER_MYSQL = "mysql"
+
+// MySQL error numbers for a deadlock and a lock wait timeout, both of which
+// roll back the transaction and succeed if it is simply run again
+const (
+	MYSQL_ER_LOCK_DEADLOCK     = 1213
+	MYSQL_ER_LOCK_WAIT_TIMEOUT = 1205
+)
+
+// MySQL error numbers for adding a column or key which is already there
+const (
+	MYSQL_ER_DUP_FIELDNAME = 1060
+	MYSQL_ER_DUP_KEYNAME   = 1061
+)
+
+type mysqlDialect struct{}
+
+// parseTime is enabled so that DATETIME columns can be scanned straight into time.Time.
+// The credentials are read as each connection is made so rotated secrets apply
+func (d *Database) ConnectionString() string {
+	return LiveSetting("sql_user", Settings.SqlUser) + ":" + LiveSetting("sql_pass", Settings.SqlPass) +
+		"@tcp(" + LiveSetting("sql_host", Settings.SqlHost) + ":" + LiveSetting("sql_port", Settings.SqlPort) + ")/" +
+		LiveSetting("sql_db", Settings.SqlDb) + "?parseTime=true"
+}
+
+func (mysqlDialect) validate(c Config) []string {
+	if c.SqlHost == "" || c.SqlUser == "" || c.SqlDb == "" {
+		return []string{"sql_host, sql_user and sql_db are required"}
+	}
+	return nil
+}
+
+func (mysqlDialect) open(d *Database) (*sql.DB, error) {
+	Log.Info("Connecting to database", "host", Settings.SqlHost, "port", Settings.SqlPort, "database", Settings.SqlDb)
+	return sql.OpenDB(mysqlConnector{ d }), nil
+}
+
+func (mysqlDialect) describe() string {
+	return Settings.SqlHost + ":" + Settings.SqlPort + "/" + Settings.SqlDb
+}
+
+// Queries are already written for MySQL
+func (mysqlDialect) rewrite(query string) (string, bool) {
+	return query, false
+}
+
+// Read only mirrors never migrate, the primary they mirror does that
+func (mysqlDialect) prepareSchema(ctx context.Context, migrate bool) error {
+	if !migrate {
+		return nil
+	}
+	return RunMigrations(ctx)
+}
+
+func (mysqlDialect) fetchSchemaColumns() (map[string]map[string]bool, error) {
+	return fetchSchemaColumns()
+}
+
+func (mysqlDialect) fetchSchemaUniqueKeys() (map[string]bool, error) {
+	return fetchSchemaUniqueKeys()
+}
+
+func (mysqlDialect) isRetryable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == MYSQL_ER_LOCK_DEADLOCK || mysqlErr.Number == MYSQL_ER_LOCK_WAIT_TIMEOUT
+	}
+	return false
+}
+
+// The MySQL driver returns ErrInvalidConn when the connection drops mid query
+func (mysqlDialect) isConnectionLost(err error) bool {
+	return errors.Is(err, mysql.ErrInvalidConn)
+}
+
+// Whether the error is from adding a column or key the table already has
+func isMysqlDuplicateSchema(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == MYSQL_ER_DUP_FIELDNAME || mysqlErr.Number == MYSQL_ER_DUP_KEYNAME
+	}
+	return false
+}
+
+// Builds each MySQL connection from the current connection string
+type mysqlConnector struct {
+	database *Database
+}
+
+func (c mysqlConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	config, err := mysql.ParseDSN(c.database.ConnectionString())
+	if err != nil {
+		return nil, err
+	}
+	connector, err := mysql.NewConnector(config)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+func (c mysqlConnector) Driver() driver.Driver {
+	return mysql.MySQLDriver{}
+}
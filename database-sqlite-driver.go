@@ -0,0 +1,6 @@
+//go:build sqlite
+
+package main
+
+// The SQLite driver is pure Go so builds with it stay free of cgo
+import _ "modernc.org/sqlite"
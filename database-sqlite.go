@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | SQLite backend
+ |--------------------------------------------------------------------------
+ |
+ | Setting sql_driver = "sqlite" runs the service from the single file at
+ | sqlite_path instead of MySQL, so contributors and small deployments
+ | don't have to provision a database server. Queries are written for
+ | MySQL and rewritten into SQLite's dialect as they're sent, upserts in
+ | particular become ON CONFLICT clauses naming the table's unique keys
+ | from schema.go, with RETURNING id standing in for LAST_INSERT_ID(id).
+ | The tables are created, and any missing columns added, from schema.go
+ | on boot. The driver is only compiled into builds made with -tags
+ | sqlite so MySQL deployments don't carry it
+ |
+ */
+
+const SQL_DRIVER_SQLITE = "sqlite"
+
+// Writers wait this long for a lock rather than failing straight away, WAL
+// lets readers carry on while a write is in progress
+func sqliteConnectionString() string {
+	return "file:" + Settings.SqlitePath + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) validate(c Config) []string {
+	var problems []string
+	if c.SqlitePath == "" {
+		problems = append(problems, "sqlite_path is required when sql_driver is sqlite")
+	}
+	if !sqliteAvailable() {
+		problems = append(problems, "sql_driver sqlite needs a binary built with -tags sqlite")
+	}
+	return problems
+}
+
+func (sqliteDialect) open(d *Database) (*sql.DB, error) {
+	Log.Info("Opening SQLite database", "path", Settings.SqlitePath)
+	return sql.Open(SQL_DRIVER_SQLITE, sqliteConnectionString())
+}
+
+func (sqliteDialect) describe() string {
+	return Settings.SqlitePath
+}
+
+func (sqliteDialect) rewrite(query string) (string, bool) {
+	return sqliteQuery(query)
+}
+
+// SQLite databases are created on first boot rather than migrated, a mirror
+// of a SQLite file is the file so there's nothing to leave alone
+func (sqliteDialect) prepareSchema(ctx context.Context, migrate bool) error {
+	return BootstrapSqliteSchema()
+}
+
+func (sqliteDialect) fetchSchemaColumns() (map[string]map[string]bool, error) {
+	return fetchSqliteSchemaColumns()
+}
+
+func (sqliteDialect) fetchSchemaUniqueKeys() (map[string]bool, error) {
+	return fetchSqliteSchemaUniqueKeys()
+}
+
+// SQLite has a single writer, a transaction which outwaits busy_timeout
+// gets SQLITE_BUSY and can be run again like a lock wait timeout
+func (sqliteDialect) isRetryable(err error) bool {
+	return strings.Contains(err.Error(), "database is locked")
+}
+
+// The database is a local file, there's no connection to lose
+func (sqliteDialect) isConnectionLost(err error) bool {
+	return false
+}
+
+// Returns true if this build has the SQLite driver compiled in
+func sqliteAvailable() bool {
+	for _, driver := range sql.Drivers() {
+		if driver == SQL_DRIVER_SQLITE {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	sqliteInsertTableRegex  = regexp.MustCompile(`(?i)^\s*INSERT\s+(?:IGNORE\s+)?INTO\s+([A-Za-z_]+)`)
+	sqliteLastInsertIdRegex = regexp.MustCompile(`id = LAST_INSERT_ID\(id\)(, )?`)
+	sqliteValuesRegex       = regexp.MustCompile(`VALUES\(([A-Za-z_]+)\)`)
+	sqliteIfRegex           = regexp.MustCompile(`\bIF\(`)
+	sqliteIntervalRegex     = regexp.MustCompile(`DATE_(ADD|SUB)\(([^,]+), INTERVAL (.+?) (SECOND|HOUR|DAY)\)`)
+	sqliteDateFormatRegex   = regexp.MustCompile(`DATE_FORMAT\((.+?), '([^']*)'\)`)
+	sqliteFirstValueRegex   = regexp.MustCompile(`SUBSTRING_INDEX\((GROUP_CONCAT\([^()]*\)), ',', 1\)`)
+)
+
+var sqliteIntervalUnits = map[string]string{
+	"SECOND": "seconds",
+	"HOUR": "hours",
+	"DAY": "days",
+}
+
+// Rewrites a MySQL query into SQLite's dialect. Returns true if the query is
+// an upsert which now ends with RETURNING id, as SQLite doesn't report the id
+// of a row an upsert updated
+func sqliteQuery(query string) (string, bool) {
+	returning := false
+	if insert, update, found := strings.Cut(query, "ON DUPLICATE KEY UPDATE "); found {
+		update = sqliteLastInsertIdRegex.ReplaceAllStringFunc(update, func(match string) string {
+			returning = true
+			if strings.HasSuffix(match, ", ") {
+				return ""
+			}
+			return "id = id"
+		})
+		update = sqliteValuesRegex.ReplaceAllString(update, "excluded.$1")
+
+		var clauses []string
+		for _, target := range sqliteConflictTargets(insert) {
+			clauses = append(clauses, "ON CONFLICT " + target + "DO UPDATE SET " + update)
+		}
+		query = insert + strings.Join(clauses, " ")
+	}
+
+	query = strings.Replace(query, "INSERT IGNORE ", "INSERT OR IGNORE ", -1)
+	query = sqliteIfRegex.ReplaceAllString(query, "IIF(")
+	query = strings.Replace(query, "NOW()", "CURRENT_TIMESTAMP", -1)
+	query = strings.Replace(query, "CURDATE()", "DATE('now')", -1)
+	query = sqliteIntervalRegex.ReplaceAllStringFunc(query, func(match string) string {
+		parts := sqliteIntervalRegex.FindStringSubmatch(match)
+		sign := "+"
+		if parts[1] == "SUB" {
+			sign = "-"
+		}
+		return "DATETIME(" + parts[2] + ", '" + sign + "' || (" + parts[3] + ") || ' " + sqliteIntervalUnits[parts[4]] + "')"
+	})
+	query = sqliteDateFormatRegex.ReplaceAllString(query, "STRFTIME('$2', $1)")
+	query = sqliteFirstValueRegex.ReplaceAllString(query, "SUBSTR($1 || ',', 1, INSTR($1 || ',', ',') - 1)")
+
+	if returning {
+		query += " RETURNING id"
+	}
+	return query, returning
+}
+
+// MySQL updates the row whichever unique key an insert collides with, SQLite
+// wants each key named, so an upsert gets a conflict target for every unique
+// key in schema.go of the table it inserts into. Tables without one only have
+// their primary key to collide with, which SQLite allows to go unnamed
+func sqliteConflictTargets(insert string) []string {
+	match := sqliteInsertTableRegex.FindStringSubmatch(insert)
+	if match == nil {
+		return []string{ "" }
+	}
+
+	var targets []string
+	for _, key := range requiredUniqueKeys {
+		if strings.EqualFold(key.table, match[1]) {
+			targets = append(targets, "(" + strings.Join(key.columns, ", ") + ") ")
+		}
+	}
+	if len(targets) == 0 {
+		return []string{ "" }
+	}
+	return targets
+}
+
+// Creates every table and unique key in schema.go which doesn't exist yet, and
+// adds any columns missing from the tables which do. Columns are untyped apart
+// from ids and timestamps, so SQLite stores whatever the service writes, and
+// compare case insensitively like MySQL's default collation
+func BootstrapSqliteSchema() error {
+	columns, err := fetchSqliteSchemaColumns()
+	if err != nil {
+		return err
+	}
+
+	var statements []string
+	for _, table := range requiredTables {
+		existing, exists := columns[table.name]
+		if !exists {
+			var definitions []string
+			for _, column := range table.columns {
+				definitions = append(definitions, sqliteColumnDefinition(column))
+			}
+			statements = append(statements, "CREATE TABLE " + table.name + " (" + strings.Join(definitions, ", ") + ")")
+			continue
+		}
+		for _, column := range table.columns {
+			if !existing[strings.ToLower(column)] && column != "id" {
+				statements = append(statements, "ALTER TABLE " + table.name + " ADD COLUMN " + sqliteColumnDefinition(column))
+			}
+		}
+	}
+	for _, key := range retiredUniqueKeys {
+		statements = append(statements, "DROP INDEX IF EXISTS " + key.table + "_" + strings.Join(key.columns, "_") + "_unique")
+	}
+	for _, key := range requiredUniqueKeys {
+		statements = append(statements, "CREATE UNIQUE INDEX IF NOT EXISTS " + key.table + "_" + strings.Join(key.columns, "_") + "_unique " +
+			"ON " + key.table + " (" + strings.Join(key.columns, ", ") + ")")
+	}
+
+	for _, statement := range statements {
+		if _, err := DB.conn.Exec(statement); err != nil {
+			Log.Error("Failed to create SQLite schema", "statement", statement, "err", err)
+			return err
+		}
+	}
+	Log.Info("SQLite schema is up to date", "statements", len(statements))
+	return nil
+}
+
+func sqliteColumnDefinition(column string) string {
+	switch {
+	case column == "id":
+		return "id INTEGER PRIMARY KEY AUTOINCREMENT"
+	case strings.HasSuffix(column, "_at") || column == "day" || column == "bucket":
+		return column + " DATETIME"
+	case column == "version":
+		// Rows which predate the column are the first version
+		return "version INTEGER NOT NULL DEFAULT " + strconv.Itoa(DEFAULT_ITEM_VERSION)
+	case column == "server":
+		// Rows which predate the column belong to the default tenant
+		return "server TEXT NOT NULL DEFAULT '' COLLATE NOCASE"
+	}
+	return column + " COLLATE NOCASE"
+}
+
+// The SQLite equivalent of fetchSchemaColumns
+func fetchSqliteSchemaColumns() (map[string]map[string]bool, error) {
+	query := "SELECT m.name, p.name " +
+		"FROM sqlite_master AS m " +
+		"INNER JOIN pragma_table_info(m.name) AS p " +
+		"WHERE m.type = 'table'"
+
+	rows, err := DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer DB.CloseRows(rows)
+
+	columns := make(map[string]map[string]bool)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, err
+		}
+		table = strings.ToLower(table)
+		if columns[table] == nil {
+			columns[table] = make(map[string]bool)
+		}
+		columns[table][strings.ToLower(column)] = true
+	}
+	return columns, rows.Err()
+}
+
+// The SQLite equivalent of fetchSchemaUniqueKeys
+func fetchSqliteSchemaUniqueKeys() (map[string]bool, error) {
+	query := "SELECT m.name, l.name, i.name " +
+		"FROM sqlite_master AS m " +
+		"INNER JOIN pragma_index_list(m.name) AS l " +
+		"INNER JOIN pragma_index_info(l.name) AS i " +
+		"WHERE m.type = 'table' " +
+		"AND l.\"unique\" = 1 " +
+		"ORDER BY m.name, l.name, i.seqno"
+
+	rows, err := DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer DB.CloseRows(rows)
+
+	indexes := make(map[string][]string)
+	tables := make(map[string]string)
+	for rows.Next() {
+		var table, index, column string
+		if err := rows.Scan(&table, &index, &column); err != nil {
+			return nil, err
+		}
+		id := table + "." + index
+		tables[id] = table
+		indexes[id] = append(indexes[id], column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool)
+	for id, columns := range indexes {
+		keys[uniqueKeyName(tables[id], columns)] = true
+	}
+	return keys, nil
+}
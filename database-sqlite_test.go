@@ -0,0 +1,128 @@
+//go:build sqlite
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// Run with go test -tags sqlite, the round trip needs the driver compiled in
+func TestSqliteQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want string
+		returning bool
+	}{
+		{
+			query: "SELECT id FROM items WHERE name = ?",
+			want: "SELECT id FROM items WHERE name = ?",
+		},
+		{
+			query: "INSERT INTO item_aliases (server, alias, item_name, created_at) VALUES (?, ?, ?, ?) " +
+				"ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), item_name = VALUES(item_name), created_at = VALUES(created_at)",
+			want: "INSERT INTO item_aliases (server, alias, item_name, created_at) VALUES (?, ?, ?, ?) " +
+				"ON CONFLICT (server, alias) DO UPDATE SET item_name = excluded.item_name, created_at = excluded.created_at RETURNING id",
+			returning: true,
+		},
+		{
+			// Tables without a unique key only collide on their primary key
+			query: "INSERT INTO notes (id, body) VALUES (?, ?) ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)",
+			want: "INSERT INTO notes (id, body) VALUES (?, ?) ON CONFLICT DO UPDATE SET id = id RETURNING id",
+			returning: true,
+		},
+		{
+			query: "INSERT IGNORE INTO item_aliases (server, alias) VALUES (?, ?)",
+			want: "INSERT OR IGNORE INTO item_aliases (server, alias) VALUES (?, ?)",
+		},
+		{
+			query: "SELECT IF(status = ?, 1, 0) FROM alias_candidates",
+			want: "SELECT IIF(status = ?, 1, 0) FROM alias_candidates",
+		},
+		{
+			query: "SELECT id FROM items WHERE scraped_at < DATE_SUB(NOW(), INTERVAL ? DAY)",
+			want: "SELECT id FROM items WHERE scraped_at < DATETIME(CURRENT_TIMESTAMP, '-' || (?) || ' days')",
+		},
+		{
+			query: "SELECT id FROM items WHERE scraped_at > DATE_ADD(CURDATE(), INTERVAL 1 HOUR)",
+			want: "SELECT id FROM items WHERE scraped_at > DATETIME(DATE('now'), '+' || (1) || ' hours')",
+		},
+		{
+			query: "SELECT DATE_FORMAT(created_at, '%Y-%m-%d') FROM prices",
+			want: "SELECT STRFTIME('%Y-%m-%d', created_at) FROM prices",
+		},
+		{
+			query: "SELECT SUBSTRING_INDEX(GROUP_CONCAT(name ORDER BY id), ',', 1) FROM items",
+			want: "SELECT SUBSTR(GROUP_CONCAT(name ORDER BY id) || ',', 1, INSTR(GROUP_CONCAT(name ORDER BY id) || ',', ',') - 1) FROM items",
+		},
+	}
+
+	for _, test := range tests {
+		got, returning := sqliteQuery(test.query)
+		if got != test.want {
+			t.Errorf("sqliteQuery(%q)\n got %q\nwant %q", test.query, got, test.want)
+		}
+		if returning != test.returning {
+			t.Errorf("sqliteQuery(%q) returning = %v, want %v", test.query, returning, test.returning)
+		}
+	}
+}
+
+// An upsert which collides with an existing row updates it and reports that
+// row's id, the same as LAST_INSERT_ID(id) does on MySQL
+func TestSqliteUpsert(t *testing.T) {
+	saved := Settings
+	defer func() {
+		DB.Close()
+		DB = Database{}
+		Settings = saved
+	}()
+	Settings.SqlDriver = SQL_DRIVER_SQLITE
+	Settings.SqlitePath = filepath.Join(t.TempDir(), "service-wiki.db")
+
+	if !DB.Open() {
+		t.Fatal("Failed to open the SQLite database")
+	}
+	if err := BootstrapSqliteSchema(); err != nil {
+		t.Fatalf("BootstrapSqliteSchema() = %v", err)
+	}
+
+	ctx := context.Background()
+	query := "INSERT INTO item_aliases (server, alias, item_name, created_at) VALUES (?, ?, ?, NOW()) " +
+		"ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), item_name = VALUES(item_name), created_at = VALUES(created_at)"
+
+	first, err := DB.InsertContext(ctx, query, "", "fbss", "Fine Steel Short Sword")
+	if err != nil {
+		t.Fatalf("first upsert = %v", err)
+	}
+	other, err := DB.InsertContext(ctx, query, "", "cof", "Cloak of Flames")
+	if err != nil {
+		t.Fatalf("second alias = %v", err)
+	}
+	// Aliases compare case insensitively, as on MySQL
+	second, err := DB.InsertContext(ctx, query, "", "FBSS", "Flowing Black Silk Sash")
+	if err != nil {
+		t.Fatalf("second upsert = %v", err)
+	}
+	if second != first || other == first {
+		t.Errorf("upsert ids = %d, %d, %d, want the first and last to match", first, other, second)
+	}
+
+	rows, err := DB.QueryContext(ctx, "SELECT COUNT(*), MAX(item_name) FROM item_aliases WHERE alias = ?", "fbss")
+	if err != nil {
+		t.Fatalf("select = %v", err)
+	}
+	defer DB.CloseRows(rows)
+	var count int
+	var name string
+	if !rows.Next() {
+		t.Fatal("select returned no rows")
+	}
+	if err := rows.Scan(&count, &name); err != nil {
+		t.Fatalf("scan = %v", err)
+	}
+	if count != 1 || name != "Flowing Black Silk Sash" {
+		t.Errorf("item_aliases has %d rows for fbss naming %q, want 1 naming Flowing Black Silk Sash", count, name)
+	}
+}
@@ -1,127 +1,434 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"strings"
+	"time"
 	"database/sql"
-	_ "github.com/go-sql-driver/mysql"
+	"database/sql/driver"
 )
 
 type Database struct {
 	conn *sql.DB
+	dialect sqlDialect
 }
 
-func (d *Database) ConnectionString() string {
-	return SQL_USER + ":" + SQL_PASS + "@tcp(" + SQL_HOST + ":" + SQL_PORT + ")/" + SQL_DB
+/*
+ |-------------------------------------------------------------------------
+ | Type: sqlDialect
+ |--------------------------------------------------------------------------
+ |
+ | What differs between the backends sql_driver can pick, everything else
+ | goes through database/sql the same way. Queries are written once in
+ | MySQL's dialect and rewritten by the backend as they're sent, so the
+ | rest of the service doesn't need to know which one it's talking to.
+ | Each backend lives in its own database-<driver>.go
+ |
+ */
+
+type sqlDialect interface {
+	// Problems with the backend's settings, checked before anything connects
+	validate(c Config) []string
+	open(d *Database) (*sql.DB, error)
+	// Where the data lives, for logs and the self test
+	describe() string
+	// Rewrites a MySQL query for the backend, returning true if an upsert
+	// now ends with RETURNING id
+	rewrite(query string) (string, bool)
+	// Brings the schema up to date on boot. migrate is false when schema
+	// changes should be left alone, i.e. read only mirrors
+	prepareSchema(ctx context.Context, migrate bool) error
+	fetchSchemaColumns() (map[string]map[string]bool, error)
+	fetchSchemaUniqueKeys() (map[string]bool, error)
+	// Whether a failed transaction can be run again from the start
+	isRetryable(err error) bool
+	// Whether the driver's error means the connection dropped mid query
+	isConnectionLost(err error) bool
+}
+
+var sqlDialects = map[string]sqlDialect{
+	SQL_DRIVER_MYSQL: mysqlDialect{},
+	SQL_DRIVER_SQLITE: sqliteDialect{},
 }
 
 func (d *Database) Open() bool {
-	conn := d.ConnectionString();
-	fmt.Println("Connecting to: ", conn)
-	db, err := sql.Open("mysql", conn)
+	d.dialect = sqlDialects[Settings.SqlDriver]
+	db, err := d.dialect.open(d)
 	if err != nil {
-		fmt.Println(err.Error())
+		Log.Error("Failed to open database", "err", err)
+		return false
 	}
-	d.conn = db
-	d.conn.SetMaxOpenConns(MAX_CONNECTIONS)
+	db.SetMaxOpenConns(Settings.MaxConnections)
+	db.SetMaxIdleConns(Settings.MaxIdleConnections)
 
-	// Check that we can ping the DB box as the connection is lazy loaded when we fire the query
-	err = d.conn.Ping()
+	// Connections made with credentials which have since rotated are replaced
+	// rather than kept until the old credentials are revoked
+	lifetime := seconds(Settings.ConnMaxLifetimeInSecs)
+	if Settings.SecretsProvider != "" && seconds(Settings.SecretsRefreshIntervalInSecs) < lifetime {
+		lifetime = seconds(Settings.SecretsRefreshIntervalInSecs)
+	}
+	db.SetConnMaxLifetime(lifetime)
+
+	// Check that we can ping the DB box as the connection is lazy loaded when we fire the query.
+	// The connection is only kept once it answers, so the next query tries again
+	err = db.Ping()
 	if err != nil {
-		fmt.Println(err.Error())
+		Log.Error("Failed to ping database", "err", err)
+		db.Close()
+		return false
 	}
 
+	d.conn = db
+	return true
+}
+
+// Opens the connection if it isn't open yet, returns false if it couldn't be
+func (d *Database) connected() bool {
+	if d.conn == nil {
+		Log.Info("Spawning a new connection")
+		return d.Open()
+	}
 	return true
 }
 
 // Given a query string and a list of variadic parameters bindings this
-// method will
+// method will prepare and send the query, returning the rows or a QueryError
 func (d *Database) Query(query string, parameters ...interface{}) (*sql.Rows, error) {
-	if d.conn == nil {
-		fmt.Println("Spawning a new connection")
-		d.Open()
+	return d.QueryContext(context.Background(), query, parameters...)
+}
+
+// Same as Query but the query is abandoned if ctx is cancelled, used by the
+// scrape path so shutting down doesn't wait on slow queries
+func (d *Database) QueryContext(ctx context.Context, query string, parameters ...interface{}) (*sql.Rows, error) {
+	if !d.connected() {
+		return nil, ErrDatabaseRead
 	}
 
-	LogInDebugMode("Interfaces: ", parameters)
+	query, _ = d.dialect.rewrite(query)
+	logger := LoggerFrom(ctx)
+	logger.Debug("Preparing query", "query", query, "parameters", parameters)
+
+	var rows *sql.Rows
+	err := retryLostConnection(ctx, query, func() (bool, error) {
+		var err error
+		rows, err = d.queryOnce(ctx, query, parameters)
+		return true, err
+	})
+	return rows, wrapQueryError(query, err)
+}
 
-	LogInDebugMode("Preparing query: " + query)
-	stmt, err := d.conn.Prepare(query)
+func (d *Database) queryOnce(ctx context.Context, query string, parameters []interface{}) (*sql.Rows, error) {
+	logger := LoggerFrom(ctx)
+	stmt, err := d.conn.PrepareContext(ctx, query)
 	if err != nil {
-		fmt.Println(err.Error())
+		logger.Error("Failed to prepare query", "query", query, "err", err)
 		return nil, err
 	}
 	defer stmt.Close()
 
-	if len(parameters) > 0 {
-		rows, err := stmt.Query(parameters...)
-		if err != nil {
-			fmt.Println("Error sending query: ", err.Error())
-			return nil, err
+	rows, err := stmt.QueryContext(ctx, parameters...)
+	if err != nil {
+		logger.Error("Failed to send query", "query", query, "err", err)
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Runs a write in its own transaction and returns the last insert id. Failed
+// writes are rolled back and a QueryError returned so callers can surface it
+func (d *Database) Insert(query string, parameters ...interface{}) (int64, error) {
+	return d.InsertContext(context.Background(), query, parameters...)
+}
+
+// Same as Insert but the transaction is rolled back if ctx is cancelled before
+// it commits, so a write is either fully applied or not at all
+func (d *Database) InsertContext(ctx context.Context, query string, parameters ...interface{}) (int64, error) {
+	if !d.connected() {
+		return -1, ErrDatabaseWrite
+	}
+
+	query, returning := d.dialect.rewrite(query)
+	var id int64
+	err := retryLostConnection(ctx, query, func() (bool, error) {
+		var err error
+		var committing bool
+		id, committing, err = d.insertOnce(ctx, query, returning, parameters)
+		return !committing, err
+	})
+	return id, wrapQueryError(query, err)
+}
+
+// Returns whether the write failed while committing, as the connection being
+// lost then leaves it unknown whether the write was applied
+func (d *Database) insertOnce(ctx context.Context, query string, returning bool, parameters []interface{}) (int64, bool, error) {
+	logger := LoggerFrom(ctx)
+	tx, err := d.conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Error("Failed to create transaction", "err", err)
+		return -1, false, err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		logger.Error("Failed to prepare insert query", "query", query, "err", err)
+		return -1, false, err
+	}
+	defer stmt.Close()
+
+	var id int64
+	if returning {
+		if err := stmt.QueryRowContext(ctx, parameters...).Scan(&id); err != nil {
+			logger.Error("Failed to exec insert query", "query", query, "parameters", parameters, "err", err)
+			return -1, false, err
 		}
-		return rows, nil
 	} else {
-		rows, err := stmt.Query()
+		res, err := stmt.ExecContext(ctx, parameters...)
 		if err != nil {
-			fmt.Println("Error sending query: ", err.Error())
-			return nil, err
+			logger.Error("Failed to exec insert query", "query", query, "parameters", parameters, "err", err)
+			return -1, false, err
 		}
-		return rows, nil
+		if id, err = res.LastInsertId(); err != nil {
+			logger.Error("Failed to fetch last insert id", "err", err)
+			id = -1
+		}
+	}
+	logger.Debug("Inserted row", "id", id)
+
+	if err = tx.Commit(); err != nil {
+		logger.Error("Failed to commit transaction", "err", err)
+		return -1, true, err
 	}
+	return id, false, nil
 }
 
-func (d *Database) Insert(query string, parameters ...interface{}) (int64, error) {
-	tx, err := d.conn.Begin()
+/*
+ |-------------------------------------------------------------------------
+ | Type: QueryError
+ |--------------------------------------------------------------------------
+ |
+ | Returned by Query and Insert when the driver fails, so the statement
+ | which failed is carried along with the error. Unwraps to the driver's
+ | error so errors.As still finds a *mysql.MySQLError
+ |
+ | @member query (string): The SQL which was sent, after any rewriting
+ | for SQLite
+ | @member err (error): The driver's error
+ |
+ */
+
+type QueryError struct {
+	query string
+	err error
+}
+
+func (e *QueryError) Error() string {
+	return e.err.Error() + " (query: " + e.query + ")"
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.err
+}
+
+// Cancellations are returned as they are so callers can still compare them
+// against context.Canceled
+func wrapQueryError(query string, err error) error {
+	if err == nil || err == context.Canceled || err == context.DeadlineExceeded {
+		return err
+	}
+	return &QueryError{ query: query, err: err }
+}
+
+// Number of times a query is sent again after the driver loses its connection
+const DB_CONNECTION_RETRIES = 2
+
+// Runs fn again on a fresh connection while it fails because the connection
+// was lost, i.e. MySQL restarting or wait_timeout closing an idle connection.
+// fn returns whether it's safe to run again, which it isn't once a write may
+// have been committed
+func retryLostConnection(ctx context.Context, query string, fn func() (bool, error)) error {
+	for attempt := 1; ; attempt++ {
+		retryable, err := fn()
+		if err == nil || !retryable || !isConnectionLostError(err) || attempt > DB_CONNECTION_RETRIES {
+			return err
+		}
+
+		delay := time.Duration(attempt * 100) * time.Millisecond
+		LoggerFrom(ctx).Warn("Retrying query after losing the connection", "attempt", attempt, "delay", delay, "query", query, "err", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// database/sql already retries driver.ErrBadConn before anything is sent,
+// drivers have their own errors for a connection dropping mid query
+func isConnectionLostError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || (DB.dialect != nil && DB.dialect.isConnectionLost(err)) {
+		return true
+	}
+	message := err.Error()
+	return strings.Contains(message, "connection lost") || strings.Contains(message, "broken pipe") ||
+		strings.Contains(message, "connection reset by peer")
+}
+
+// Number of times a transaction is run again after losing a deadlock
+const DB_DEADLOCK_RETRIES = 3
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Tx
+ |--------------------------------------------------------------------------
+ |
+ | A transaction handed to the func passed to Database.Transaction, queries
+ | and inserts run through it are committed or rolled back together. The
+ | last error is kept so Transaction can tell a deadlock apart from any other
+ | failure, even though callers return the sentinels from errors.go
+ |
+ */
+
+type Tx struct {
+	ctx context.Context
+	tx *sql.Tx
+	dialect sqlDialect
+	err error
+}
+
+func (t *Tx) Query(query string, parameters ...interface{}) (*sql.Rows, error) {
+	query, _ = t.dialect.rewrite(query)
+	LoggerFrom(t.ctx).Debug("Preparing query", "query", query, "parameters", parameters)
+	rows, err := t.tx.QueryContext(t.ctx, query, parameters...)
 	if err != nil {
-		fmt.Println("Error creating transaction: ", err.Error())
+		LoggerFrom(t.ctx).Error("Failed to send query", "query", query, "err", err)
+		t.err = err
 	}
-	defer tx.Rollback()
-	stmt, err := tx.Prepare(query)
+	return rows, wrapQueryError(query, err)
+}
+
+// Runs the write and returns the last insert id
+func (t *Tx) Insert(query string, parameters ...interface{}) (int64, error) {
+	query, returning := t.dialect.rewrite(query)
+	if returning {
+		var id int64
+		if err := t.tx.QueryRowContext(t.ctx, query, parameters...).Scan(&id); err != nil {
+			LoggerFrom(t.ctx).Error("Failed to exec insert query", "query", query, "parameters", parameters, "err", err)
+			t.err = err
+			return -1, wrapQueryError(query, err)
+		}
+		return id, nil
+	}
+
+	res, err := t.tx.ExecContext(t.ctx, query, parameters...)
 	if err != nil {
-		fmt.Println("Error preparing insert query: ", err)
+		LoggerFrom(t.ctx).Error("Failed to exec insert query", "query", query, "parameters", parameters, "err", err)
+		t.err = err
+		return -1, wrapQueryError(query, err)
 	}
 
-	res, err := stmt.Exec(parameters...)
+	id, err := res.LastInsertId()
 	if err != nil {
-		fmt.Println("Query failed: " + query + ", Parameters: ", fmt.Sprint(parameters))
-		fmt.Println("Exec err when inserting: ", err.Error())
-	} else {
-		id, err := res.LastInsertId()
+		LoggerFrom(t.ctx).Error("Failed to fetch last insert id", "err", err)
+		return -1, nil
+	}
+	return id, nil
+}
+
+// Runs the write and returns the number of rows it changed, for writes which
+// have to know whether they won a row another replica may also be after
+func (t *Tx) Update(query string, parameters ...interface{}) (int64, error) {
+	query, _ = t.dialect.rewrite(query)
+	res, err := t.tx.ExecContext(t.ctx, query, parameters...)
+	if err != nil {
+		LoggerFrom(t.ctx).Error("Failed to exec update query", "query", query, "parameters", parameters, "err", err)
+		t.err = err
+		return 0, wrapQueryError(query, err)
+	}
+
+	changed, err := res.RowsAffected()
+	if err != nil {
+		LoggerFrom(t.ctx).Error("Failed to fetch rows affected", "err", err)
+		return 0, err
+	}
+	return changed, nil
+}
+
+// Runs fn in a transaction which is committed if fn returns nil and rolled back
+// otherwise, or if ctx is cancelled. Transactions which lose a deadlock or
+// their connection are run again from the start up to DB_DEADLOCK_RETRIES
+// times, so fn mustn't have any side effects outside of the transaction. Returns fn's error, or
+// ErrDatabaseWrite if the transaction couldn't be started or committed
+func (d *Database) Transaction(ctx context.Context, fn func(tx *Tx) error) error {
+	if !d.connected() {
+		return ErrDatabaseWrite
+	}
+	logger := LoggerFrom(ctx)
+
+	for attempt := 1; ; attempt++ {
+		sqlTx, err := d.conn.BeginTx(ctx, nil)
 		if err != nil {
-			fmt.Println("Error when fetching last insert id: ", err.Error())
-		} else {
-			LogInDebugMode("returning iD: ", id)
-			err = tx.Commit()
-			if err != nil {
-				panic(err.Error())
+			logger.Error("Failed to create transaction", "err", err)
+			return ErrDatabaseWrite
+		}
+
+		tx := &Tx{ ctx: ctx, tx: sqlTx, dialect: d.dialect }
+		err = fn(tx)
+		if err == nil {
+			if err = sqlTx.Commit(); err != nil {
+				logger.Error("Failed to commit transaction", "err", err)
+				// A commit which lost its connection may have been applied
+				if isConnectionLostError(err) {
+					return ErrDatabaseWrite
+				}
+				tx.err = err
+				err = ErrDatabaseWrite
 			}
-			stmt.Close()
-			return id, nil
+		} else {
+			sqlTx.Rollback()
+		}
+		if err == nil || !d.isRetryableTxError(tx.err) || attempt > DB_DEADLOCK_RETRIES {
+			return err
 		}
-	}
 
-	err = tx.Commit()
-	if err != nil {
-		panic(err.Error())
+		delay := time.Duration(attempt * 50) * time.Millisecond
+		logger.Warn("Retrying transaction", "attempt", attempt, "delay", delay, "err", tx.err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
-	stmt.Close()
-	return -1, err
+}
+
+func (d *Database) isRetryableTxError(err error) bool {
+	return isConnectionLostError(err) || (err != nil && d.dialect.isRetryable(err))
+}
+
+// Returns a comma separated list of count bind parameters for use in an IN clause
+func Placeholders(count int) string {
+	return strings.TrimRight(strings.Repeat("?, ", count), ", ")
 }
 
 func (d *Database) Close() {
 	if d.conn != nil {
-		fmt.Println("Closing DB connection")
+		Log.Info("Closing DB connection")
 		err := d.conn.Close()
 		if err == nil {
-			fmt.Println("DB connection disposed successfully")
+			Log.Info("DB connection disposed successfully")
 		} else {
-			fmt.Println("Failed to close DB connection: ", err)
+			Log.Error("Failed to close DB connection", "err", err)
 		}
 	} else {
-		fmt.Println("DB Connection was already closed")
+		Log.Info("DB Connection was already closed")
 	}
 }
 
 func (d *Database) CloseRows(rows *sql.Rows) {
 	if err := rows.Close(); err != nil {
-		fmt.Println("Close error: ", err)
+		Log.Error("Failed to close rows", "err", err)
 	}
 }
\ No newline at end of file
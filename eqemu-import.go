@@ -0,0 +1,573 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | EQEmu import
+ |--------------------------------------------------------------------------
+ |
+ | Fills in items from a dump of an EQEmu server's items table, which is
+ | more reliable than the wiki for the numbers it has. Dumps are either
+ | mysqldump output (.sql) or a delimited file whose first line names the
+ | columns (.csv or .txt, comma, tab or pipe delimited as Lucy's are), and
+ | may be gzipped. Rows are mapped through LucyItem, the same mapping the
+ | export uses in reverse.
+ |
+ | Items are matched on their name. The dump's stats replace ours of the
+ | same code, stats it doesn't have such as effects and modifiers are kept,
+ | and flags and vendor prices only fill gaps as the wiki knows about
+ | QUEST ITEM and the markup vendors charge. Items corrected by hand are
+ | left alone, and items we don't have are only created when asked for,
+ | dumps have plenty of items players never see
+ |
+ */
+
+var (
+	sqlCreateTableRegex = regexp.MustCompile(`(?is)^CREATE\s+TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?([^\s(]+)\s*\((.*)\)`)
+	sqlInsertRegex      = regexp.MustCompile(`(?is)^(?:INSERT|REPLACE)\s+(?:(?:LOW_PRIORITY|DELAYED|HIGH_PRIORITY|IGNORE)\s+)*INTO\s+([^\s(]+)\s*(?:\(([^)]*)\))?\s*VALUES\s*`)
+)
+
+// Column definitions in a CREATE TABLE which aren't columns
+var sqlKeyDefinitions = []string{"PRIMARY", "KEY", "UNIQUE", "INDEX", "FULLTEXT", "SPATIAL", "CONSTRAINT", "FOREIGN", "CHECK"}
+
+// Reads the dump at path, saving items a batch at a time. Only an unreadable
+// dump or a cancelled ctx are returned as errors, rows which can't be imported
+// are counted as failed
+func ImportEqemuItems(ctx context.Context, path string, create bool) (ImportResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.EqualFold(filepath.Ext(path), ".gz") {
+		gzipped, err := gzip.NewReader(file)
+		if err != nil {
+			return ImportResult{}, err
+		}
+		defer gzipped.Close()
+		reader = gzipped
+		path = strings.TrimSuffix(path, filepath.Ext(path))
+	}
+
+	importer := eqemuImporter{ ctx: ctx, create: create, seen: make(map[string]int) }
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".sql":
+		err = readSqlDump(reader, importer.add)
+	case ".csv", ".txt", ".tsv":
+		err = readDelimitedDump(reader, importer.add)
+	default:
+		err = errors.New("dumps must be .sql, .csv or .txt, optionally gzipped")
+	}
+	if err == nil {
+		err = importer.flush()
+	}
+	if err != nil {
+		LoggerFrom(ctx).Error("Failed to import EQEmu items", "path", path, "err", err)
+		return importer.result, err
+	}
+
+	LoggerFrom(ctx).Info("Imported EQEmu items", "imported", importer.result.imported, "failed", importer.result.failed,
+		"missing", importer.missing, "corrected", importer.corrected)
+	return importer.result, nil
+}
+
+type eqemuImporter struct {
+	ctx context.Context
+	create bool
+	result ImportResult
+	// Line each name was first read from, EQEmu has several rows for some names
+	seen map[string]int
+	batch []Item
+	lines []int
+	missing int
+	corrected int
+}
+
+func (e *eqemuImporter) add(line int, row LucyItem) error {
+	item, problem := row.Item()
+	if problem != "" {
+		e.result.fail(line, problem)
+		return nil
+	}
+	key := strings.ToLower(item.name)
+	if first, exists := e.seen[key]; exists {
+		e.result.fail(line, "already read " + item.name + " from line " + strconv.Itoa(first))
+		return nil
+	}
+	e.seen[key] = line
+
+	e.batch = append(e.batch, item)
+	e.lines = append(e.lines, line)
+	if len(e.batch) < IMPORT_BATCH_SIZE {
+		return nil
+	}
+	return e.flush()
+}
+
+// Merges the batch into the items we have and saves it
+func (e *eqemuImporter) flush() error {
+	if len(e.batch) == 0 {
+		return nil
+	}
+	defer func() {
+		e.batch, e.lines = nil, nil
+	}()
+
+	var parameters []interface{}
+	for _, item := range e.batch {
+		parameters = append(parameters, item.name)
+	}
+	query := "SELECT " + itemColumns + " FROM items " +
+		"WHERE name IN (" + Placeholders(len(parameters)) + ") AND version = " + strconv.Itoa(DEFAULT_ITEM_VERSION)
+	existing := fetchItems(query, parameters...)
+	attachStatistics(existing)
+	attachEffects(existing)
+	byName := make(map[string]Item)
+	for _, item := range existing {
+		byName[strings.ToLower(item.name)] = item
+	}
+
+	query = "SELECT name FROM items " +
+		"WHERE name IN (" + Placeholders(len(parameters)) + ") AND COALESCE(is_manual_override, 0) = 1"
+	rows, err := DB.QueryContext(e.ctx, query, parameters...)
+	if err != nil {
+		return err
+	}
+	corrected := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		corrected[strings.ToLower(name)] = true
+	}
+	DB.CloseRows(rows)
+
+	var merged []Item
+	var lines []int
+	for idx, item := range e.batch {
+		key := strings.ToLower(item.name)
+		if corrected[key] {
+			LoggerFrom(e.ctx).Debug("Not importing item corrected by hand", "item", item.name)
+			e.corrected++
+			continue
+		}
+		if stored, exists := byName[key]; exists {
+			item = mergeEqemuItem(stored, item)
+		} else if !e.create {
+			e.missing++
+			continue
+		}
+		merged = append(merged, item)
+		lines = append(lines, e.lines[idx])
+	}
+	return e.result.save(e.ctx, merged, lines)
+}
+
+// Returns the stored item with the dump's stats in place of its own
+func mergeEqemuItem(stored Item, dumped Item) Item {
+	replaced := make(map[string]bool)
+	for _, stat := range dumped.statistics {
+		replaced[stat.code] = true
+	}
+
+	// Only the wiki knows about QUEST ITEM and the like, so its flags are kept
+	for _, stat := range stored.statistics {
+		if stat.code == "AFFINITY" {
+			replaced["AFFINITY"] = false
+		}
+	}
+
+	var statistics []Statistic
+	for _, stat := range stored.statistics {
+		if !replaced[stat.code] {
+			statistics = append(statistics, stat)
+		}
+	}
+	for _, stat := range dumped.statistics {
+		if replaced[stat.code] {
+			statistics = append(statistics, stat)
+		}
+	}
+	stored.statistics = statistics
+
+	if !stored.vendorSellPrice.Valid {
+		stored.vendorSellPrice = dumped.vendorSellPrice
+	}
+	if dumped.container != nil {
+		stored.container = dumped.container
+	}
+	return stored
+}
+
+// Reads every row of the items table from mysqldump output. Columns come from
+// the INSERT if it lists them, otherwise from the CREATE TABLE before it
+func readSqlDump(reader io.Reader, row func(line int, item LucyItem) error) error {
+	dump := sqlDumpReader{ reader: bufio.NewReader(reader), line: 1 }
+	var columns []string
+	for {
+		statement, line, err := dump.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if match := sqlCreateTableRegex.FindStringSubmatch(statement); match != nil {
+			if isItemsTable(match[1]) {
+				columns = sqlTableColumns(match[2])
+			}
+			continue
+		}
+
+		match := sqlInsertRegex.FindStringSubmatchIndex(statement)
+		if match == nil || !isItemsTable(statement[match[2]:match[3]]) {
+			continue
+		}
+		insertColumns := columns
+		if match[4] >= 0 {
+			insertColumns = nil
+			for _, column := range splitTopLevel(statement[match[4]:match[5]]) {
+				insertColumns = append(insertColumns, unquoteIdentifier(column))
+			}
+		}
+		if len(insertColumns) == 0 {
+			return errors.New("the INSERT into items on line " + strconv.Itoa(line) + " has no column list and there's no CREATE TABLE before it")
+		}
+
+		line += strings.Count(statement[:match[1]], "\n")
+		err = parseSqlTuples(statement[match[1]:], line, func(line int, values []string) error {
+			return row(line, NewLucyItemFromRow(insertColumns, values))
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func isItemsTable(name string) bool {
+	parts := strings.Split(name, ".")
+	return strings.EqualFold(unquoteIdentifier(parts[len(parts)-1]), "items")
+}
+
+func unquoteIdentifier(name string) string {
+	return strings.Trim(strings.TrimSpace(name), "`\"")
+}
+
+// Returns the column names from the body of a CREATE TABLE
+func sqlTableColumns(body string) []string {
+	var columns []string
+	for _, definition := range splitTopLevel(body) {
+		fields := strings.Fields(definition)
+		if len(fields) == 0 {
+			continue
+		}
+		isKey := false
+		for _, keyword := range sqlKeyDefinitions {
+			if strings.EqualFold(fields[0], keyword) {
+				isKey = true
+			}
+		}
+		if !isKey {
+			columns = append(columns, unquoteIdentifier(fields[0]))
+		}
+	}
+	return columns
+}
+
+// Splits on commas which aren't quoted or inside parentheses, so a column
+// typed decimal(10,2) stays whole
+func splitTopLevel(list string) []string {
+	var parts []string
+	depth := 0
+	var quote rune
+	start := 0
+	for idx, char := range list {
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"' || char == '`':
+			quote = char
+		case char == '(':
+			depth++
+		case char == ')':
+			depth--
+		case char == ',' && depth == 0:
+			parts = append(parts, list[start:idx])
+			start = idx + 1
+		}
+	}
+	return append(parts, list[start:])
+}
+
+// Parses the (...),(...) tuples after VALUES, NULL is read as empty. line is
+// the line the tuples start on so each row can be reported by its own
+func parseSqlTuples(tuples string, line int, row func(line int, values []string) error) error {
+	idx := 0
+	for idx < len(tuples) {
+		char := tuples[idx]
+		if char == '\n' {
+			line++
+		}
+		if char != '(' {
+			idx++
+			continue
+		}
+
+		tupleLine := line
+		var values []string
+		idx++
+		for {
+			for idx < len(tuples) && (tuples[idx] == ' ' || tuples[idx] == '\t' || tuples[idx] == '\r' || tuples[idx] == '\n') {
+				if tuples[idx] == '\n' {
+					line++
+				}
+				idx++
+			}
+			if idx >= len(tuples) {
+				return errors.New("row on line " + strconv.Itoa(tupleLine) + " isn't closed")
+			}
+
+			var value string
+			if tuples[idx] == '\'' || tuples[idx] == '"' {
+				var builder strings.Builder
+				quote := tuples[idx]
+				idx++
+				for ; idx < len(tuples); idx++ {
+					if tuples[idx] == '\n' {
+						line++
+					}
+					if tuples[idx] == '\\' && idx + 1 < len(tuples) {
+						idx++
+						builder.WriteByte(sqlEscape(tuples[idx]))
+						continue
+					}
+					if tuples[idx] == quote {
+						// A doubled quote is a quote
+						if idx + 1 < len(tuples) && tuples[idx + 1] == quote {
+							builder.WriteByte(quote)
+							idx++
+							continue
+						}
+						break
+					}
+					builder.WriteByte(tuples[idx])
+				}
+				idx++
+				value = builder.String()
+			} else {
+				end := strings.IndexAny(tuples[idx:], ",)")
+				if end < 0 {
+					return errors.New("row on line " + strconv.Itoa(tupleLine) + " isn't closed")
+				}
+				value = strings.TrimSpace(tuples[idx:idx + end])
+				if strings.EqualFold(value, "NULL") {
+					value = ""
+				}
+				idx += end
+			}
+			values = append(values, value)
+
+			for idx < len(tuples) && tuples[idx] != ',' && tuples[idx] != ')' {
+				idx++
+			}
+			if idx >= len(tuples) {
+				return errors.New("row on line " + strconv.Itoa(tupleLine) + " isn't closed")
+			}
+			idx++
+			if tuples[idx - 1] == ')' {
+				break
+			}
+		}
+		if err := row(tupleLine, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The character a backslash escape in a MySQL string stands for
+func sqlEscape(char byte) byte {
+	switch char {
+	case '0':
+		return 0
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'Z':
+		return 26
+	}
+	return char
+}
+
+// Splits a dump into statements on the semicolons which aren't quoted or in a
+// comment, dropping the comments
+type sqlDumpReader struct {
+	reader *bufio.Reader
+	line int
+}
+
+// Returns the next statement and the line it starts on, io.EOF once there
+// are none left
+func (d *sqlDumpReader) next() (string, int, error) {
+	var statement strings.Builder
+	start := 0
+	var quote rune
+	for {
+		char, _, err := d.reader.ReadRune()
+		if err == io.EOF && statement.Len() > 0 && strings.TrimSpace(statement.String()) != "" {
+			return strings.TrimSpace(statement.String()), start, nil
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		if char == '\n' {
+			d.line++
+		}
+
+		if quote != 0 {
+			statement.WriteRune(char)
+			if char == '\\' && quote != '`' {
+				escaped, _, err := d.reader.ReadRune()
+				if err != nil {
+					return "", 0, err
+				}
+				if escaped == '\n' {
+					d.line++
+				}
+				statement.WriteRune(escaped)
+			} else if char == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch {
+		case char == '#' || (char == '-' && d.peek() == '-'):
+			line, err := d.reader.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return "", 0, err
+			}
+			if strings.HasSuffix(line, "\n") {
+				d.line++
+			}
+			continue
+		case char == '/' && d.peek() == '*':
+			if err := d.skipBlockComment(); err != nil {
+				return "", 0, err
+			}
+			continue
+		case char == ';':
+			if strings.TrimSpace(statement.String()) == "" {
+				statement.Reset()
+				continue
+			}
+			return strings.TrimSpace(statement.String()), start, nil
+		case char == '\'' || char == '"' || char == '`':
+			quote = char
+		}
+
+		if start == 0 && !strings.ContainsRune(" \t\r\n", char) {
+			start = d.line
+		}
+		statement.WriteRune(char)
+	}
+}
+
+func (d *sqlDumpReader) peek() byte {
+	next, err := d.reader.Peek(1)
+	if err != nil {
+		return 0
+	}
+	return next[0]
+}
+
+func (d *sqlDumpReader) skipBlockComment() error {
+	previous := rune(0)
+	for {
+		char, _, err := d.reader.ReadRune()
+		if err != nil {
+			return err
+		}
+		if char == '\n' {
+			d.line++
+		}
+		if previous == '*' && char == '/' {
+			return nil
+		}
+		previous = char
+	}
+}
+
+// Reads a delimited dump whose first line names the columns. The delimiter is
+// whichever of pipe, tab or comma the first line has most of
+func readDelimitedDump(reader io.Reader, row func(line int, item LucyItem) error) error {
+	buffered := bufio.NewReader(reader)
+	header, err := buffered.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	delimiter := ','
+	for _, candidate := range []rune{'|', '\t'} {
+		if strings.Count(header, string(candidate)) > strings.Count(header, string(delimiter)) {
+			delimiter = candidate
+		}
+	}
+
+	records := csv.NewReader(io.MultiReader(strings.NewReader(header), buffered))
+	records.Comma = delimiter
+	records.FieldsPerRecord = -1
+	// Lucy's dumps don't quote, so a stray quote is just part of the value
+	records.LazyQuotes = true
+
+	columns, err := records.Read()
+	if err != nil {
+		return err
+	}
+	hasName := false
+	for _, column := range columns {
+		hasName = hasName || strings.EqualFold(strings.TrimSpace(column), "name")
+	}
+	if !hasName {
+		return errors.New("the first line must name the columns, including name")
+	}
+
+	for {
+		record, err := records.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := records.FieldPos(0)
+		if err := row(line, NewLucyItemFromRow(columns, record)); err != nil {
+			return err
+		}
+	}
+}
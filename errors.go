@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// Errors returned when fetching or saving items, these are what the controllers
+// switch on so the underlying cause is logged where it happens rather than
+// being passed up
+var (
+	ErrPageNotFound    = errors.New("the page doesn't exist on the wiki")
+	ErrNotAnItemPage   = errors.New("the wiki page isn't an item page")
+	ErrDisambiguation  = errors.New("the wiki page lists several items which go by that name")
+	ErrWikiUnavailable = errors.New("the wiki couldn't be reached")
+	ErrWikiBadResponse = errors.New("the wiki returned a maintenance, CAPTCHA or otherwise unusable page")
+	ErrWikiRateLimited = errors.New("too many wiki requests are queued, try again shortly")
+	ErrParseIncomplete = errors.New("the item page couldn't be fully parsed")
+	ErrDatabaseRead    = errors.New("failed to read from the database")
+	ErrDatabaseWrite   = errors.New("failed to write to the database")
+	ErrWritesPaused    = errors.New("item updates are paused while the wiki canary is failing")
+	ErrReadOnly        = errors.New("the wiki isn't fetched in read only mode")
+)
+
+// Label for each error in logs and the scrape outcome counts, errors not
+// listed here are counted as unknown
+var errorLabels = map[error]string{
+	ErrPageNotFound: "page_not_found",
+	ErrNotAnItemPage: "not_an_item_page",
+	ErrDisambiguation: "disambiguation",
+	ErrWikiUnavailable: "wiki_unavailable",
+	ErrWikiBadResponse: "wiki_bad_response",
+	ErrWikiRateLimited: "wiki_rate_limited",
+	ErrParseIncomplete: "parse_incomplete",
+	ErrDatabaseRead: "database_read",
+	ErrDatabaseWrite: "database_write",
+	ErrWritesPaused: "writes_paused",
+	ErrReadOnly: "read_only",
+	context.Canceled: "cancelled",
+	context.DeadlineExceeded: "timeout",
+}
+
+// Maps an error from the item layer onto the status we respond with
+func StatusForError(err error) int {
+	switch err {
+	case nil:
+		return http.StatusOK
+	case ErrPageNotFound, ErrReadOnly:
+		return http.StatusNotFound
+	case ErrNotAnItemPage:
+		return http.StatusUnprocessableEntity
+	case ErrDisambiguation:
+		return http.StatusMultipleChoices
+	case ErrParseIncomplete:
+		return http.StatusBadGateway
+	case ErrWikiUnavailable, ErrWikiBadResponse, ErrWikiRateLimited, ErrWritesPaused, context.Canceled, context.DeadlineExceeded:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func ErrorLabel(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	if label, exists := errorLabels[err]; exists {
+		return label
+	}
+	return "unknown"
+}
+
+// Transient errors are worth retrying later, anything else means the line
+// genuinely didn't resolve to an item. Cancelled fetches are transient as they
+// were only interrupted by a shutdown
+func IsTransientError(err error) bool {
+	return err == ErrWikiUnavailable || err == ErrWikiBadResponse || err == ErrWikiRateLimited || err == ErrDatabaseRead ||
+		err == ErrDatabaseWrite || err == ErrWritesPaused || err == context.Canceled || err == context.DeadlineExceeded
+}
+
+// Number of wiki scrapes which ended with each ErrorLabel since we started, so
+// alerts can tell the wiki being down apart from its markup changing
+var scrapeOutcomes = struct {
+	sync.Mutex
+	counts map[string]int64
+}{ counts: make(map[string]int64) }
+
+func RecordScrapeOutcome(err error) {
+	scrapeOutcomes.Lock()
+	scrapeOutcomes.counts[ErrorLabel(err)]++
+	scrapeOutcomes.Unlock()
+}
+
+func ScrapeOutcomes() map[string]int64 {
+	scrapeOutcomes.Lock()
+	defer scrapeOutcomes.Unlock()
+
+	counts := make(map[string]int64, len(scrapeOutcomes.counts))
+	for label, count := range scrapeOutcomes.counts {
+		counts[label] = count
+	}
+	return counts
+}
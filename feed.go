@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: FeedEvent
+ |--------------------------------------------------------------------------
+ |
+ | Something which happened that live clients, such as price trackers, are
+ | pushed over /ws/feed rather than having to poll for. An item event is
+ | sent whenever an item is saved and an auction event whenever an auction
+ | line resolves to at least one item. Items are shared by every tenant but
+ | auctions are only sent to subscribers of the tenant they were reported
+ | to. Events are relayed between replicas over FEED_CHANNEL so every
+ | subscriber sees every event, whichever replica it happened on
+ |
+ | @member kind (string): One of the FEED_EVENT constants
+ | @member tenant (string): The tenant the event belongs to
+ | @member shared (bool): True if every tenant is sent the event
+ | @member data (json.RawMessage): The saved item or the auction line
+ | @member at (time.Time): When the event happened
+ |
+ */
+
+type FeedEvent struct {
+	kind string
+	tenant string
+	shared bool
+	data json.RawMessage
+	at time.Time
+}
+
+const (
+	FEED_EVENT_ITEM    = "item"
+	FEED_EVENT_AUCTION = "auction"
+)
+
+const FEED_CHANNEL = "feed:events"
+
+// What an auction event's data looks like, items are the ones the line
+// resolved to and price is the one advertised for the item, if any
+type FeedAuction struct {
+	Seller      string            `json:"seller,omitempty"`
+	Line        string            `json:"line"`
+	AuctionedAt time.Time         `json:"auctionedAt"`
+	Items       []FeedAuctionItem `json:"items"`
+}
+
+type FeedAuctionItem struct {
+	Id         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Price      *float64 `json:"price"`
+	Confidence float64  `json:"confidence"`
+}
+
+// How events travel between replicas
+type feedMessage struct {
+	Instance string          `json:"instance"`
+	Type     string          `json:"type"`
+	Tenant   string          `json:"tenant"`
+	Shared   bool            `json:"shared"`
+	Data     json.RawMessage `json:"data"`
+	At       time.Time       `json:"at"`
+}
+
+// A client of /ws/feed, events is closed if the client falls so far behind
+// that feed_buffer_size events are waiting for it
+type FeedSubscriber struct {
+	tenant string
+	kinds map[string]bool
+	events chan FeedEvent
+}
+
+var Feed = struct {
+	sync.Mutex
+	subscribers map[*FeedSubscriber]bool
+}{ subscribers: make(map[*FeedSubscriber]bool) }
+
+// Registers a subscriber for the tenant's events of the given kinds, or every
+// kind if none are given. Returns false if feed_max_subscribers are connected
+func SubscribeFeed(tenant string, kinds []string) (*FeedSubscriber, bool) {
+	subscriber := &FeedSubscriber{
+		tenant: tenant,
+		kinds: make(map[string]bool),
+		events: make(chan FeedEvent, Settings.FeedBufferSize),
+	}
+	for _, kind := range kinds {
+		subscriber.kinds[kind] = true
+	}
+
+	Feed.Lock()
+	defer Feed.Unlock()
+	if len(Feed.subscribers) >= Settings.FeedMaxSubscribers {
+		return nil, false
+	}
+	Feed.subscribers[subscriber] = true
+	return subscriber, true
+}
+
+func UnsubscribeFeed(subscriber *FeedSubscriber) {
+	Feed.Lock()
+	defer Feed.Unlock()
+	if Feed.subscribers[subscriber] {
+		delete(Feed.subscribers, subscriber)
+		close(subscriber.events)
+	}
+}
+
+// Sends the event to every local subscriber who wants it without waiting on
+// any of them, subscribers who have fallen behind are dropped
+func broadcastFeedEvent(event FeedEvent) {
+	Feed.Lock()
+	defer Feed.Unlock()
+
+	for subscriber := range Feed.subscribers {
+		if !event.shared && subscriber.tenant != event.tenant {
+			continue
+		}
+		if len(subscriber.kinds) > 0 && !subscriber.kinds[event.kind] {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			Log.Warn("Dropping feed subscriber which has fallen behind", "tenant", subscriber.tenant)
+			delete(Feed.subscribers, subscriber)
+			close(subscriber.events)
+		}
+	}
+}
+
+// Sends the event to subscribers here and on every other replica
+func PublishFeedEvent(kind string, tenant string, shared bool, data interface{}) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		Log.Error("Failed to encode feed event", "type", kind, "err", err)
+		return
+	}
+	event := FeedEvent{ kind, tenant, shared, encoded, time.Now().UTC() }
+	broadcastFeedEvent(event)
+
+	if redisPool == nil {
+		return
+	}
+	message, err := json.Marshal(feedMessage{ instanceId, kind, tenant, shared, encoded, event.at })
+	if err != nil {
+		Log.Error("Failed to encode feed event", "type", kind, "err", err)
+		return
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("PUBLISH", FEED_CHANNEL, message); err != nil {
+		Log.Error("Redis error publishing feed event", "type", kind, "err", err)
+	}
+}
+
+// Broadcasts an event another replica published, ignoring our own as they've
+// already been sent
+func receiveFeedMessage(message redis.Message) {
+	var received feedMessage
+	if err := json.Unmarshal(message.Data, &received); err != nil {
+		Log.Error("Failed to decode feed event", "err", err)
+		return
+	}
+	if received.Instance == instanceId {
+		return
+	}
+	broadcastFeedEvent(FeedEvent{ received.Type, received.Tenant, received.Shared, received.Data, received.At })
+}
+
+func (e FeedEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+		At   time.Time       `json:"at"`
+	}{e.kind, e.data, e.at})
+}
@@ -0,0 +1,15 @@
+//go:build !graphql
+
+package main
+
+import (
+	"net/http"
+)
+
+// Builds without -tags graphql don't have the generated executor, so /graphql
+// says so rather than the route disappearing
+func NewGraphqlHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "GraphQL needs a binary built with -tags graphql", http.StatusNotImplemented)
+	})
+}
@@ -0,0 +1,262 @@
+//go:build graphql
+
+package main
+
+//go:generate go run github.com/99designs/gqlgen generate
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/eqdata/service-wiki/graph"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | GraphQL
+ |--------------------------------------------------------------------------
+ |
+ | Serves graph/schema.graphqls at /graphql so front-ends can fetch an item
+ | along with its effects, drop sources and prices in one request rather
+ | than stitching several REST calls together. The executor and models in
+ | graph/ are generated by gqlgen, the resolvers are here as they need our
+ | types. Like batch-get only what we've already scraped is returned, and
+ | nested fields are resolved one query per parent so the complexity of a
+ | query is capped to stop a single request fanning out across the catalog.
+ | graph/ isn't committed, so this is only built with -tags graphql after
+ | go generate -tags graphql has written it, see the README
+ |
+ */
+
+// Each field costs 1 and lists multiply their children by their size
+const MAX_GRAPHQL_COMPLEXITY = 2000
+
+// Built once when the router is created
+func NewGraphqlHandler() *handler.Server {
+	schema := graph.NewExecutableSchema(graph.Config{ Resolvers: graphResolver{} })
+
+	server := handler.New(schema)
+	server.AddTransport(transport.GET{})
+	server.AddTransport(transport.POST{})
+	server.Use(extension.Introspection{})
+	server.Use(extension.FixedComplexityLimit(MAX_GRAPHQL_COMPLEXITY))
+	return server
+}
+
+type graphResolver struct{}
+
+func (r graphResolver) Query() graph.QueryResolver { return graphQueryResolver{} }
+func (r graphResolver) Item() graph.ItemResolver { return graphItemResolver{} }
+func (r graphResolver) Effect() graph.EffectResolver { return graphEffectResolver{} }
+func (r graphResolver) Auction() graph.AuctionResolver { return graphAuctionResolver{} }
+
+type graphQueryResolver struct{}
+
+func (r graphQueryResolver) Item(ctx context.Context, name string) (*graph.Item, error) {
+	item, err := IC.cachedItem(ctx, name)
+	if err == ErrPageNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	Usage.Track(item.id, USAGE_VIEW)
+	return graphItem(item), nil
+}
+
+func (r graphQueryResolver) Items(ctx context.Context, names []string) ([]*graph.Item, error) {
+	if len(names) > MAX_PER_PAGE {
+		return nil, errors.New("At most " + strconv.Itoa(MAX_PER_PAGE) + " names can be sent")
+	}
+
+	items := make([]*graph.Item, len(names))
+	for idx, name := range names {
+		item, err := r.Item(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		items[idx] = item
+	}
+	return items, nil
+}
+
+func (r graphQueryResolver) Search(ctx context.Context, q string, limit int) ([]*graph.SearchResult, error) {
+	if strings.TrimSpace(q) == "" {
+		return nil, errors.New("Please send a search query")
+	}
+	if limit < 1 || limit > MAX_PER_PAGE {
+		return nil, errors.New("limit must be a number between 1 and " + strconv.Itoa(MAX_PER_PAGE))
+	}
+
+	results := []*graph.SearchResult{}
+	for _, result := range SearchItems(TenantFrom(ctx), q, limit) {
+		results = append(results, &graph.SearchResult{ Score: result.score, Item: graphItem(result.item) })
+	}
+	return results, nil
+}
+
+func (r graphQueryResolver) Spell(ctx context.Context, name string) (*graph.Spell, error) {
+	spell := Spell{ name: spellLineName(strings.Replace(strings.TrimSpace(name), "_", " ", -1)) }
+	if !spell.fetchDataFromSQL() {
+		return nil, nil
+	}
+	return graphSpell(spell), nil
+}
+
+func (r graphQueryResolver) Auctions(ctx context.Context, seller string, page int, perPage int) ([]*graph.Auction, error) {
+	if page < 1 {
+		return nil, errors.New("page must be a positive number")
+	}
+	if perPage < 1 || perPage > MAX_PER_PAGE {
+		return nil, errors.New("perPage must be a number between 1 and " + strconv.Itoa(MAX_PER_PAGE))
+	}
+
+	auctions, _ := FetchSellerAuctions(TenantFrom(ctx), NormaliseSeller(seller), page, perPage)
+	return graphAuctions(auctions), nil
+}
+
+type graphItemResolver struct{}
+
+func (r graphItemResolver) Sources(ctx context.Context, obj *graph.Item) ([]*graph.ItemSource, error) {
+	sources, err := FetchItemSources(ctx, graphId(obj.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*graph.ItemSource{}
+	for _, source := range sources {
+		results = append(results, &graph.ItemSource{ Npc: source.npcName, Zone: source.zone })
+	}
+	return results, nil
+}
+
+func (r graphItemResolver) Prices(ctx context.Context, obj *graph.Item, minConfidence float64) (*graph.PriceSummary, error) {
+	if minConfidence < 0 || minConfidence > 1 {
+		return nil, errors.New("minConfidence must be between 0 and 1")
+	}
+
+	summary := FetchPriceSummary(TenantFrom(ctx), graphId(obj.ID), minConfidence)
+	vendors := []*graph.VendorPrice{}
+	for _, vendor := range FetchMerchantPrices(graphId(obj.ID), obj.Name) {
+		vendors = append(vendors, &graph.VendorPrice{ Merchant: vendor.merchantName, Price: vendor.price })
+	}
+
+	return &graph.PriceSummary{
+		Count: int(summary.count),
+		Average: nullFloatPointer(summary.average),
+		Min: nullFloatPointer(summary.min),
+		Max: nullFloatPointer(summary.max),
+		MinConfidence: summary.minConfidence,
+		Vendors: vendors,
+	}, nil
+}
+
+func (r graphItemResolver) Auctions(ctx context.Context, obj *graph.Item, limit int) ([]*graph.Auction, error) {
+	if limit < 1 || limit > MAX_PER_PAGE {
+		return nil, errors.New("limit must be a number between 1 and " + strconv.Itoa(MAX_PER_PAGE))
+	}
+	return graphAuctions(FetchItemAuctions(TenantFrom(ctx), graphId(obj.ID), limit)), nil
+}
+
+type graphEffectResolver struct{}
+
+// Effects are named after the spell they cast
+func (r graphEffectResolver) Spell(ctx context.Context, obj *graph.Effect) (*graph.Spell, error) {
+	return graphQueryResolver{}.Spell(ctx, obj.Name)
+}
+
+type graphAuctionResolver struct{}
+
+func (r graphAuctionResolver) Item(ctx context.Context, obj *graph.Auction) (*graph.Item, error) {
+	if obj.ItemName == "" {
+		return nil, nil
+	}
+	return graphQueryResolver{}.Item(ctx, obj.ItemName)
+}
+
+// Ids are sent as strings, as GraphQL expects
+func graphId(id string) int64 {
+	parsed, _ := strconv.ParseInt(id, 10, 64)
+	return parsed
+}
+
+func graphItem(item Item) *graph.Item {
+	statistics := []*graph.Statistic{}
+	for _, stat := range item.statistics {
+		stat.label = StatLabel(stat.code)
+		statistics = append(statistics, &graph.Statistic{
+			Code: stat.code,
+			Label: stat.label,
+			Value: nullFloatPointer(stat.value),
+			Unit: stat.Unit(),
+			Text: stat.Text(),
+		})
+	}
+	effects := []*graph.Effect{}
+	for _, effect := range item.effects {
+		effects = append(effects, &graph.Effect{
+			Name: effect.name,
+			URI: effect.uri,
+			Type: effect.Type(),
+			Restriction: effect.restriction,
+		})
+	}
+
+	return &graph.Item{
+		ID: strconv.FormatInt(item.id, 10),
+		Name: item.name,
+		DisplayName: item.displayName,
+		ImageSrc: item.imageSrc,
+		Price: float64(item.price),
+		VendorSellPrice: nullFloatPointer(item.vendorSellPrice),
+		VendorBuyPrice: nullFloatPointer(item.vendorBuyPrice),
+		Statistics: statistics,
+		Effects: effects,
+	}
+}
+
+func graphSpell(spell Spell) *graph.Spell {
+	classes := []*graph.SpellClass{}
+	for _, class := range spell.classes {
+		classes = append(classes, &graph.SpellClass{ Class: class.class, Level: int(class.level) })
+	}
+
+	var mana *int
+	if spell.mana.Valid {
+		value := int(spell.mana.Int64)
+		mana = &value
+	}
+
+	return &graph.Spell{
+		ID: strconv.FormatInt(spell.id, 10),
+		Name: spell.name,
+		ImageSrc: spell.imageSrc,
+		Mana: mana,
+		CastTime: nullFloatPointer(spell.castTime),
+		RecastTime: nullFloatPointer(spell.recastTime),
+		Duration: spell.duration,
+		Skill: spell.skill,
+		Target: spell.target,
+		Description: spell.description,
+		Classes: classes,
+	}
+}
+
+func graphAuctions(auctions []Auction) []*graph.Auction {
+	results := []*graph.Auction{}
+	for _, auction := range auctions {
+		results = append(results, &graph.Auction{
+			ID: strconv.FormatInt(auction.id, 10),
+			Seller: auction.seller,
+			ItemName: auction.itemName,
+			Price: nullFloatPointer(auction.price),
+			Line: auction.line,
+			AuctionedAt: auction.auctionedAt,
+		})
+	}
+	return results
+}
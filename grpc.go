@@ -0,0 +1,333 @@
+//go:build grpc
+
+package main
+
+//go:generate protoc --go_out=. --go_opt=module=github.com/eqdata/service-wiki --go-grpc_out=. --go-grpc_opt=module=github.com/eqdata/service-wiki proto/item_service.proto
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"time"
+	"github.com/eqdata/service-wiki/itempb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | gRPC
+ |--------------------------------------------------------------------------
+ |
+ | Serves the ItemService in proto/item_service.proto on grpc_port for
+ | internal consumers such as the log parsing daemon, which would rather
+ | stream auction lines over a typed protocol than post JSON. Each method
+ | shares its logic with an HTTP route and is treated as that route: it
+ | has the route's concurrency limit, is refused in read only mode if the
+ | route is, and is bound to a tenant by an API key sent in the x-api-key
+ | or authorization metadata. The messages and service in itempb/ are
+ | generated by protoc and aren't committed, so this is only built with
+ | -tags grpc after go generate -tags grpc has written them, see the
+ | README. Leaving grpc_port empty disables the service
+ |
+ */
+
+// The HTTP route each method shares its logic with
+var grpcMethodRoutes = map[string]string{
+	itempb.ItemService_GetItem_FullMethodName: "Create Item",
+	itempb.ItemService_BatchGetItems_FullMethodName: "Batch Get Items",
+	itempb.ItemService_StoreAuctionLines_FullMethodName: "Store Items",
+}
+
+var grpcServer *grpc.Server
+
+// Whether this build can serve gRPC, Validate refuses grpc_port without it
+const grpcAvailable = true
+
+type itemServiceServer struct {
+	itempb.UnimplementedItemServiceServer
+	limiters map[string]*ConcurrencyLimiter
+}
+
+// Starts serving gRPC in the background until StopGrpc is called, does nothing
+// if grpc_port isn't set
+func ServeGrpc() {
+	if Settings.GrpcPort == "" {
+		return
+	}
+
+	listener, err := net.Listen("tcp", ":" + Settings.GrpcPort)
+	if err != nil {
+		Log.Error("Failed to listen for gRPC", "port", Settings.GrpcPort, "err", err)
+		return
+	}
+
+	service := &itemServiceServer{ limiters: make(map[string]*ConcurrencyLimiter) }
+	for method, name := range grpcMethodRoutes {
+		if limit, exists := expensiveRoutes[name]; exists {
+			service.limiters[method] = NewConcurrencyLimiter(limit)
+		}
+	}
+
+	grpcServer = grpc.NewServer(
+		grpc.UnaryInterceptor(service.intercept),
+		grpc.StreamInterceptor(service.interceptStream),
+	)
+	itempb.RegisterItemServiceServer(grpcServer, service)
+
+	Log.Info("Starting gRPC server", "port", Settings.GrpcPort)
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			Log.Error("gRPC server stopped", "err", err)
+		}
+	}()
+}
+
+// Waits for calls in progress to finish, cancelling them if ctx is done first
+func StopGrpc(ctx context.Context) {
+	if grpcServer == nil {
+		return
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		Log.Warn("Timed out draining gRPC calls")
+		grpcServer.Stop()
+	}
+}
+
+func (s *itemServiceServer) GetItem(ctx context.Context, request *itempb.GetItemRequest) (*itempb.Item, error) {
+	labels, ok := grpcLabels(request.GetLabels())
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "labels must be short or long")
+	}
+
+	item, found, err := IC.fetchItem(ctx, request.GetName())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if !found {
+		return nil, status.Error(codes.NotFound, "Couldn't find " + request.GetName())
+	}
+
+	items := []Item{ item }
+	applyStatLabels(items, labels)
+	return protoItem(items[0]), nil
+}
+
+func (s *itemServiceServer) BatchGetItems(request *itempb.BatchGetItemsRequest, stream itempb.ItemService_BatchGetItemsServer) error {
+	labels, ok := grpcLabels(request.GetLabels())
+	if !ok {
+		return status.Error(codes.InvalidArgument, "labels must be short or long")
+	}
+	if len(request.GetNames()) == 0 {
+		return status.Error(codes.InvalidArgument, "No names were present in the request")
+	}
+
+	for _, name := range request.GetNames() {
+		result := &itempb.ItemResult{ Name: name }
+		item, err := IC.cachedItem(stream.Context(), name)
+		if err != nil {
+			result.Error = ErrorLabel(err)
+		} else {
+			items := []Item{ item }
+			applyStatLabels(items, labels)
+			result.Item = protoItem(items[0])
+			Usage.Track(item.id, USAGE_VIEW)
+		}
+		if err := stream.Send(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *itemServiceServer) StoreAuctionLines(stream itempb.ItemService_StoreAuctionLinesServer) error {
+	var lines []string
+	for {
+		line, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line.GetLine())
+	}
+
+	LoggerFrom(stream.Context()).Debug("Storing items", "count", len(lines))
+	if len(lines) == 0 {
+		return status.Error(codes.InvalidArgument, "No lines were sent")
+	}
+
+	job := Jobs.Start(TenantFrom(stream.Context()), lines)
+	return stream.SendAndClose(&itempb.Job{ Id: job.id, Status: JOB_STATUS_QUEUED, Total: int32(len(lines)) })
+}
+
+func (s *itemServiceServer) intercept(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var response interface{}
+	err := s.serve(ctx, info.FullMethod, func(ctx context.Context) error {
+		var err error
+		response, err = handler(ctx, request)
+		return err
+	})
+	return response, err
+}
+
+func (s *itemServiceServer) interceptStream(server interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return s.serve(stream.Context(), info.FullMethod, func(ctx context.Context) error {
+		return handler(server, contextStream{ stream, ctx })
+	})
+}
+
+// The gRPC equivalent of the Logger, Tenancy and Limit middleware
+func (s *itemServiceServer) serve(ctx context.Context, method string, call func(ctx context.Context) error) error {
+	start := time.Now()
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	requestId := grpcMetadata(md, "x-request-id")
+	if requestId == "" {
+		requestId = newRequestId()
+	}
+	logger := Log.With("request_id", requestId)
+	ctx = WithLogger(ctx, logger)
+
+	err := func() error {
+		route := Route{ name: grpcMethodRoutes[method], method: "POST" }
+		if !RouteAllowed(route) {
+			return status.Error(codes.PermissionDenied, "This mirror is read only")
+		}
+
+		key := grpcMetadata(md, "x-api-key")
+		if auth := grpcMetadata(md, "authorization"); key == "" && strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+		}
+		if key == "" && Settings.RequireApiKey {
+			return status.Error(codes.Unauthenticated, "Please send an API key")
+		}
+		if key != "" {
+			tenant, exists, err := resolveApiKey(ctx, key)
+			if err != nil {
+				return grpcError(err)
+			}
+			if !exists {
+				return status.Error(codes.Unauthenticated, "Invalid API key")
+			}
+			ctx = WithTenant(ctx, tenant)
+			ctx = WithLogger(ctx, LoggerFrom(ctx).With("tenant", tenant))
+		}
+
+		if limiter, exists := s.limiters[method]; exists {
+			if !limiter.TryAcquire() {
+				return status.Error(codes.ResourceExhausted, "Too many requests in progress, please try again shortly")
+			}
+			defer limiter.Release()
+
+			if !globalLimiter.TryAcquire() {
+				return status.Error(codes.ResourceExhausted, "Too many requests in progress, please try again shortly")
+			}
+			defer globalLimiter.Release()
+		}
+
+		return call(ctx)
+	}()
+
+	logger.Info("Call served",
+		"method", method,
+		"route", grpcMethodRoutes[method],
+		"code", status.Code(err).String(),
+		"duration", time.Since(start),
+	)
+	return err
+}
+
+// Lets the stream carry the context the interceptor built
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s contextStream) Context() context.Context {
+	return s.ctx
+}
+
+func grpcMetadata(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Empty labels are short, as when the HTTP parameter is left out
+func grpcLabels(labels string) (string, bool) {
+	if labels == "" {
+		return STAT_LABELS_SHORT, true
+	}
+	return labels, labels == STAT_LABELS_SHORT || labels == STAT_LABELS_LONG
+}
+
+// Maps an error from the item layer onto the code we respond with, as
+// StatusForError does for HTTP
+func grpcError(err error) error {
+	code := codes.Internal
+	switch err {
+	case ErrPageNotFound, ErrReadOnly:
+		code = codes.NotFound
+	case ErrNotAnItemPage:
+		code = codes.FailedPrecondition
+	case ErrDisambiguation:
+		code = codes.InvalidArgument
+	case ErrParseIncomplete:
+		code = codes.DataLoss
+	case ErrWikiUnavailable, ErrWikiBadResponse, ErrWikiRateLimited, ErrWritesPaused:
+		code = codes.Unavailable
+	case context.Canceled:
+		code = codes.Canceled
+	case context.DeadlineExceeded:
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, err.Error())
+}
+
+func protoItem(item Item) *itempb.Item {
+	statistics := []*itempb.Statistic{}
+	for _, stat := range item.statistics {
+		statistics = append(statistics, &itempb.Statistic{
+			Code: stat.code,
+			Label: stat.label,
+			Value: nullFloatPointer(stat.value),
+			Unit: stat.Unit(),
+			Text: stat.Text(),
+		})
+	}
+	effects := []*itempb.Effect{}
+	for _, effect := range item.effects {
+		effects = append(effects, &itempb.Effect{
+			Name: effect.name,
+			Uri: effect.uri,
+			Type: effect.Type(),
+			Restriction: effect.restriction,
+		})
+	}
+
+	return &itempb.Item{
+		Id: item.id,
+		Name: item.name,
+		DisplayName: item.displayName,
+		ImageSrc: item.imageSrc,
+		Price: float64(item.price),
+		VendorSellPrice: nullFloatPointer(item.vendorSellPrice),
+		VendorBuyPrice: nullFloatPointer(item.vendorBuyPrice),
+		Statistics: statistics,
+		Effects: effects,
+	}
+}
@@ -2,7 +2,12 @@ package main
 
 import (
 	"strings"
-	"fmt"
+	"sort"
+	"strconv"
+	"regexp"
+	"net/http"
+	"database/sql"
+	"golang.org/x/net/html"
 )
 
 // MIGRATE THIS TO stringutil eventually
@@ -10,7 +15,6 @@ import (
 func TitleCase(name string, urlFriendly bool) string {
 
 	uriParts := strings.Split(name, " ")
-	LogInDebugMode("STRING PARTS ARE: ", uriParts)
 
 	uriString := ""
 	for _, part := range uriParts {
@@ -32,9 +36,201 @@ func TitleCase(name string, urlFriendly bool) string {
 	return uriString
 }
 
-// Replaces fmt.Println and is used for logging debug messages
-func LogInDebugMode(message string, args ...interface{}) {
-	if DEBUG {
-		fmt.Println(message, args)
+// Returns the Levenshtein edit distance between two strings, that is the number
+// of single character insertions, deletions or substitutions required to turn a into b
+func Levenshtein(a string, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+
+	if len(ra) == 0 {
+		return len(rb)
+	} else if len(rb) == 0 {
+		return len(ra)
+	}
+
+	// We only ever need the previous row of the matrix so keep two rows around
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// Converts a nullable float to a pointer so that the JSON encoder writes null
+// rather than the sql.NullFloat64 struct
+func nullFloatPointer(value sql.NullFloat64) *float64 {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Float64
+}
+
+// The reverse of nullFloatPointer, for reading back what it wrote
+func nullFloatFromPointer(value *float64) sql.NullFloat64 {
+	if value == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{ Float64: *value, Valid: true }
+}
+
+// Returns the median of the values, values is sorted in place
+func Median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sort.Float64s(values)
+	middle := len(values) / 2
+	if len(values) % 2 == 0 {
+		return (values[middle-1] + values[middle]) / 2
+	}
+	return values[middle]
+}
+
+func nullIntPointer(value sql.NullInt64) *int64 {
+	if !value.Valid {
+		return nil
 	}
+	return &value.Int64
+}
+
+// Parses a string as an integer, returning an invalid NullInt64 if it isn't one
+func parseNullInt(raw string) sql.NullInt64 {
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: value, Valid: true}
+}
+
+// Reads an integer query parameter, falling back to the default if it wasn't sent.
+// Returns false if the parameter was sent but isn't a number between min and max
+func IntQueryParam(r *http.Request, key string, fallback int, min int, max int) (int, bool) {
+	raw := r.URL.Query().Get(key)
+	if raw == "" {
+		return fallback, true
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < min || value > max {
+		return fallback, false
+	}
+	return value, true
+}
+
+// Reads the optional server query parameter, returning an empty string if it
+// wasn't sent. Requests with a tenant's API key are always for the tenant's
+// server. Returns false if it was sent but isn't a valid server name, or isn't
+// the tenant's server
+func ServerQueryParam(r *http.Request) (string, bool) {
+	raw := r.URL.Query().Get("server")
+	if tenant := TenantFrom(r.Context()); tenant != "" {
+		server, ok := NormaliseServer(raw)
+		return tenant, raw == "" || (ok && server == tenant)
+	}
+	if raw == "" {
+		return "", true
+	}
+	return NormaliseServer(raw)
+}
+
+// Reads the optional labels query parameter, either short (the default) or
+// long. Returns false if it's anything else
+func LabelsQueryParam(r *http.Request) (string, bool) {
+	switch raw := r.URL.Query().Get("labels"); raw {
+	case "", STAT_LABELS_SHORT:
+		return STAT_LABELS_SHORT, true
+	case STAT_LABELS_LONG:
+		return raw, true
+	default:
+		return "", false
+	}
+}
+
+var tagRegex = regexp.MustCompile(`(?s)<[^>]*>`)
+
+// Extracts the text of every cell in every table in the body, indexed by
+// table, then row, then cell. Rows of nested tables only belong to the
+// innermost table they sit in
+func ExtractHtmlTables(body string) [][][]string {
+	var tables [][][]string
+
+	document, err := ParseHtml(body)
+	if err != nil {
+		Log.Error("Failed to parse html", "err", err)
+		return tables
+	}
+
+	for _, table := range FindAll(document, ByTag("table")) {
+		var rows [][]string
+		for _, row := range FindAll(table, ByTag("tr")) {
+			if closestTable(row) != table {
+				continue
+			}
+			var cells []string
+			for cell := row.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+					cells = append(cells, TextContent(cell))
+				}
+			}
+			if len(cells) > 0 {
+				rows = append(rows, cells)
+			}
+		}
+		tables = append(tables, rows)
+	}
+
+	return tables
+}
+
+func closestTable(n *html.Node) *html.Node {
+	for parent := n.Parent; parent != nil; parent = parent.Parent {
+		if parent.Type == html.ElementNode && parent.Data == "table" {
+			return parent
+		}
+	}
+	return nil
+}
+
+// Removes any html tags and collapses whitespace
+func StripTags(html string) string {
+	text := tagRegex.ReplaceAllString(html, " ")
+	text = strings.Replace(text, "&nbsp;", " ", -1)
+	text = strings.Replace(text, "&#160;", " ", -1)
+	return strings.Join(strings.Fields(text), " ")
 }
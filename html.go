@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Parses a page body into a DOM tree, the parser is forgiving of broken markup
+// so this only fails if the reader itself fails
+func ParseHtml(body string) (*html.Node, error) {
+	return html.Parse(strings.NewReader(body))
+}
+
+// Walks the tree depth first returning every element which satisfies the predicate
+func FindAll(node *html.Node, predicate func(*html.Node) bool) []*html.Node {
+	var found []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && predicate(n) {
+			found = append(found, n)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+	return found
+}
+
+// Returns the first element which satisfies the predicate, or nil
+func FindFirst(node *html.Node, predicate func(*html.Node) bool) *html.Node {
+	if node.Type == html.ElementNode && predicate(node) {
+		return node
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if found := FindFirst(child, predicate); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Predicate matching elements by tag name i.e. ByTag("p")
+func ByTag(tag string) func(*html.Node) bool {
+	return func(n *html.Node) bool {
+		return n.Data == tag
+	}
+}
+
+// Predicate matching elements with a class, the wiki isn't consistent with the
+// casing of its class names so the comparison is case insensitive
+func ByClass(class string) func(*html.Node) bool {
+	return func(n *html.Node) bool {
+		for _, candidate := range strings.Fields(Attr(n, "class")) {
+			if strings.EqualFold(candidate, class) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Returns the value of an attribute, or an empty string if it isn't set
+func Attr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if strings.EqualFold(attr.Key, key) {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// Returns all of the text beneath a node with its whitespace collapsed
+func TextContent(n *html.Node) string {
+	var buffer bytes.Buffer
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			buffer.WriteString(n.Data)
+			buffer.WriteString(" ")
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(buffer.String()), " ")
+}
+
+// Splits the children of a node on <br> tags and renders each group back to
+// markup, this is how the wiki separates the lines of an item's stats
+func SplitOnBreaks(n *html.Node) []string {
+	var lines []string
+	var buffer bytes.Buffer
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.Data == "br" {
+			lines = append(lines, buffer.String())
+			buffer.Reset()
+			continue
+		}
+		if err := html.Render(&buffer, child); err != nil {
+			Log.Debug("Failed to render node", "err", err)
+		}
+	}
+	lines = append(lines, buffer.String())
+
+	// Render escapes text and attributes, the stat parser expects plain text
+	for idx, line := range lines {
+		lines[idx] = html.UnescapeString(line)
+	}
+
+	return lines
+}
+
+// Returns the text of each line of a node, split on <br> tags the same way as
+// SplitOnBreaks. Blank lines are left out
+func TextLines(n *html.Node) []string {
+	var lines []string
+	var parts []string
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.Data == "br" {
+			if line := strings.Join(parts, " "); line != "" {
+				lines = append(lines, line)
+			}
+			parts = nil
+			continue
+		}
+		if text := TextContent(child); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	if line := strings.Join(parts, " "); line != "" {
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// Returns the page a link points at, falling back to the link text for links
+// without a title
+func LinkTitle(n *html.Node) string {
+	if title := strings.TrimSpace(Attr(n, "title")); title != "" {
+		return title
+	}
+	return TextContent(n)
+}
+
+// Returns the elements of a page section, that is everything after the heading
+// holding one of the anchor ids up until the next heading. The wiki isn't
+// consistent in how it names its sections so several ids may be given
+func SectionNodes(document *html.Node, ids ...string) []*html.Node {
+	var nodes []*html.Node
+
+	anchor := FindFirst(document, func(n *html.Node) bool {
+		for _, id := range ids {
+			if strings.EqualFold(Attr(n, "id"), id) {
+				return true
+			}
+		}
+		return false
+	})
+	heading := anchor
+	for heading != nil && !isHeading(heading) {
+		heading = heading.Parent
+	}
+	if heading == nil {
+		return nodes
+	}
+
+	for sibling := heading.NextSibling; sibling != nil; sibling = sibling.NextSibling {
+		if sibling.Type != html.ElementNode {
+			continue
+		}
+		if isHeading(sibling) {
+			break
+		}
+		nodes = append(nodes, sibling)
+	}
+	return nodes
+}
+
+func isHeading(n *html.Node) bool {
+	if n.Type != html.ElementNode || len(n.Data) != 2 || n.Data[0] != 'h' {
+		return false
+	}
+	return n.Data[1] >= '1' && n.Data[1] <= '6'
+}
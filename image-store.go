@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Image store
+ |--------------------------------------------------------------------------
+ |
+ | Where downloaded item icons are kept so they're served by us rather than
+ | hotlinked from the wiki. Images are stored under the hex SHA-256 of
+ | their contents, so items sharing an icon share one copy and an image is
+ | never written twice. image_store picks the backend: disk keeps them in
+ | image_path, s3 keeps them in image_bucket using the snapshot endpoint
+ | and credentials
+ |
+ */
+
+type ImageStore interface {
+	Put(ctx context.Context, hash string, body []byte, contentType string) error
+	Get(ctx context.Context, hash string) ([]byte, error)
+}
+
+const (
+	IMAGE_STORE_DISK = "disk"
+	IMAGE_STORE_S3   = "s3"
+)
+
+// Set once the config has been loaded
+var Images ImageStore = DiskImageStore{ "images" }
+
+func NewImageStore() ImageStore {
+	if Settings.ImageStore == IMAGE_STORE_S3 {
+		return S3ImageStore{ Settings.ImageBucket, Settings.ImagePrefix }
+	}
+	return DiskImageStore{ Settings.ImagePath }
+}
+
+// Images are spread over directories named after the first two characters of
+// their hash so no single directory holds the whole catalog
+type DiskImageStore struct {
+	path string
+}
+
+func (s DiskImageStore) Put(ctx context.Context, hash string, body []byte, contentType string) error {
+	path := s.file(hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Written aside and renamed so a reader never sees half an image
+	temp := path + ".tmp"
+	if err := ioutil.WriteFile(temp, body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+func (s DiskImageStore) Get(ctx context.Context, hash string) ([]byte, error) {
+	return ioutil.ReadFile(s.file(hash))
+}
+
+func (s DiskImageStore) file(hash string) string {
+	return filepath.Join(s.path, hash[:2], hash)
+}
+
+type S3ImageStore struct {
+	bucket string
+	prefix string
+}
+
+func (s S3ImageStore) Put(ctx context.Context, hash string, body []byte, contentType string) error {
+	return s.s3Bucket().PutObject(ctx, s.prefix + hash, body, contentType)
+}
+
+func (s S3ImageStore) Get(ctx context.Context, hash string) ([]byte, error) {
+	return s.s3Bucket().GetObject(ctx, s.prefix + hash)
+}
+
+// Built for every call so rotated credentials are picked up
+func (s S3ImageStore) s3Bucket() S3Bucket {
+	return NewS3Bucket(Settings.SnapshotEndpoint, Settings.SnapshotRegion, s.bucket,
+		LiveSetting("snapshot_access_key", Settings.SnapshotAccessKey), LiveSetting("snapshot_secret_key", Settings.SnapshotSecretKey))
+}
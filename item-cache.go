@@ -0,0 +1,198 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ItemLRU
+ |--------------------------------------------------------------------------
+ |
+ | Process local cache of fully hydrated items which sits in front of Redis,
+ | so repeated lookups for the same item within cache_time_in_secs skip
+ | Redis, SQL and the wiki entirely. Once it holds capacity items the least
+ | recently used item is evicted
+ |
+ | @member capacity (int): Maximum number of items held
+ | @member order (*list.List): Most recently used entry at the front
+ | @member entries (map[string]*list.Element): Entries keyed by itemCacheKey
+ |
+ */
+
+type ItemLRU struct {
+	mutex sync.Mutex
+	capacity int
+	order *list.List
+	entries map[string]*list.Element
+	hits int64
+	misses int64
+	evictions int64
+}
+
+type itemLRUEntry struct {
+	key string
+	item Item
+	cachedAt time.Time
+}
+
+// Resized once the config has been loaded
+var LocalItems = NewItemLRU(Settings.ItemCacheSize)
+
+func NewItemLRU(capacity int) *ItemLRU {
+	return &ItemLRU{
+		capacity: capacity,
+		order: list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *ItemLRU) Get(name string) (Item, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := itemCacheKey(name)
+	element, exists := c.entries[key]
+	if exists && time.Since(element.Value.(*itemLRUEntry).cachedAt) > seconds(Settings.CacheTimeInSecs) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		exists = false
+	}
+	if !exists {
+		c.misses++
+		return Item{}, false
+	}
+
+	c.hits++
+	c.order.MoveToFront(element)
+	return copyItem(element.Value.(*itemLRUEntry).item), true
+}
+
+func (c *ItemLRU) Set(name string, item Item) {
+	if c.capacity <= 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := itemCacheKey(name)
+	if element, exists := c.entries[key]; exists {
+		element.Value = &itemLRUEntry{ key: key, item: copyItem(item), cachedAt: time.Now() }
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&itemLRUEntry{ key: key, item: copyItem(item), cachedAt: time.Now() })
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*itemLRUEntry).key)
+		c.evictions++
+	}
+}
+
+// Drops every entry for the item, whatever name it was cached under, so that
+// the next lookup reads the saved copy. Returns the number of entries dropped
+func (c *ItemLRU) Invalidate(id int64, name string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	dropped := 0
+	key := itemCacheKey(name)
+	for entryKey, element := range c.entries {
+		if entryKey == key || (id > 0 && element.Value.(*itemLRUEntry).item.id == id) {
+			c.order.Remove(element)
+			delete(c.entries, entryKey)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+func (c *ItemLRU) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// Callers are free to append to an item's slices, so the cache never hands
+// out or keeps a slice that anyone else holds
+func copyItem(item Item) Item {
+	item.statistics = append([]Statistic(nil), item.statistics...)
+	item.effects = append([]Effect(nil), item.effects...)
+	item.sources = append([]ItemSource(nil), item.sources...)
+	item.factions = append([]ItemFaction(nil), item.factions...)
+	item.candidates = append([]string(nil), item.candidates...)
+	item.versions = append([]Item(nil), item.versions...)
+	if item.container != nil {
+		container := *item.container
+		item.container = &container
+	}
+	item.rules = nil
+	return item
+}
+
+func (c *ItemLRU) MarshalJSON() ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return json.Marshal(struct {
+		Size      int   `json:"size"`
+		Capacity  int   `json:"capacity"`
+		Hits      int64 `json:"hits"`
+		Misses    int64 `json:"misses"`
+		Evictions int64 `json:"evictions"`
+	}{c.order.Len(), c.capacity, c.hits, c.misses, c.evictions})
+}
+
+// Checks the local cache then Redis, Redis hits are kept locally as well
+func FetchCachedItem(name string) (Item, bool) {
+	if item, exists := LocalItems.Get(name); exists {
+		return item, true
+	}
+	if item, exists := FetchRedisItem(name); exists {
+		LocalItems.Set(name, item)
+		return item, true
+	}
+	return Item{}, false
+}
+
+// Writes the item to the local cache and Redis
+func CacheItem(name string, item Item) {
+	LocalItems.Set(name, item)
+	CacheRedisItem(name, item)
+}
+
+// Drops the item from the local cache, Redis and every other replica's local
+// cache. Copies in Redis under names other than the item's own expire after
+// redis_ttl_in_secs
+func UncacheItem(id int64, name string) {
+	LocalItems.Invalidate(id, name)
+	DeleteRedisItem(name)
+	PublishItemInvalidation(id, name)
+}
+
+// Hit and miss counts for each layer of the item cache, along with how many
+// fetches were coalesced into one already in flight
+func ItemCacheStats() map[string]interface{} {
+	return map[string]interface{}{
+		"coalesced": ItemFetches.Coalesced(),
+		"local": LocalItems,
+		"invalidations": map[string]interface{}{
+			"published": atomic.LoadInt64(&invalidationsPublished),
+			"received": atomic.LoadInt64(&invalidationsReceived),
+		},
+		"redis": map[string]interface{}{
+			"enabled": redisPool != nil,
+			"hits": atomic.LoadInt64(&redisHits),
+			"misses": atomic.LoadInt64(&redisMisses),
+		},
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !mongo
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// Builds without -tags mongo don't have the driver, Validate refuses
+// item_store mongo so this is never asked to connect
+const mongoAvailable = false
+
+func NewMongoItemStore(ctx context.Context, uri string, database string) (ItemDocumentStore, error) {
+	return nil, errors.New("item_store mongo needs a binary built with -tags mongo")
+}
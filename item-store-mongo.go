@@ -0,0 +1,70 @@
+//go:build mongo
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const mongoAvailable = true
+
+// Documents are keyed by itemCacheKey so any spelling of the name finds them,
+// the item is stored in the Redis cache's format
+type MongoItemStore struct {
+	client *mongo.Client
+	items *mongo.Collection
+}
+
+type mongoItemDocument struct {
+	Key       string     `bson:"_id"`
+	Item      cachedItem `bson:"item"`
+	UpdatedAt time.Time  `bson:"updatedAt"`
+}
+
+func NewMongoItemStore(ctx context.Context, uri string, database string) (ItemDocumentStore, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetMaxPoolSize(uint64(Settings.MaxConnections)))
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+	return MongoItemStore{ client: client, items: client.Database(database).Collection("items") }, nil
+}
+
+func (s MongoItemStore) Get(ctx context.Context, name string) (Item, bool, error) {
+	var document mongoItemDocument
+	filter := bson.M{
+		"_id": itemCacheKey(name),
+		"updatedAt": bson.M{ "$gte": time.Now().Add(-seconds(Settings.RedisTtlInSecs)) },
+	}
+	err := s.items.FindOne(ctx, filter).Decode(&document)
+	if err == mongo.ErrNoDocuments {
+		return Item{}, false, nil
+	}
+	if err != nil {
+		return Item{}, false, err
+	}
+	return document.Item.Item(), true, nil
+}
+
+func (s MongoItemStore) Put(ctx context.Context, item Item) error {
+	document := mongoItemDocument{ Key: itemCacheKey(item.name), Item: newCachedItem(item), UpdatedAt: time.Now() }
+	_, err := s.items.ReplaceOne(ctx, bson.M{ "_id": document.Key }, document, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s MongoItemStore) Delete(ctx context.Context, name string) error {
+	_, err := s.items.DeleteOne(ctx, bson.M{ "_id": itemCacheKey(name) })
+	return err
+}
+
+func (s MongoItemStore) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
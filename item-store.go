@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Item document store
+ |--------------------------------------------------------------------------
+ |
+ | Optionally keeps a copy of every item as a single document, with its
+ | stats and effects embedded, so a lookup which misses the caches is one
+ | read rather than a join over statistics and item_effects. item_store
+ | picks the backend: sql keeps items in SQL only, mongo keeps documents in
+ | the items collection of mongo_db as well. SQL stays the record, the
+ | documents are written after every save and filled in as items are read
+ | from SQL, so a failed write only costs a slower lookup. Auction prices
+ | are averaged in SQL, so documents older than redis_ttl_in_secs count as
+ | misses and are rewritten, the same as an expired Redis entry
+ |
+ */
+
+type ItemDocumentStore interface {
+	// Returns false if there's no document for the item
+	Get(ctx context.Context, name string) (Item, bool, error)
+	Put(ctx context.Context, item Item) error
+	Delete(ctx context.Context, name string) error
+	Close(ctx context.Context) error
+}
+
+const (
+	ITEM_STORE_SQL   = "sql"
+	ITEM_STORE_MONGO = "mongo"
+)
+
+// Nil unless item_store is mongo, set by OpenItemStore
+var Documents ItemDocumentStore
+
+// Connects to the document store if one is configured, returns false if it
+// couldn't be reached
+func OpenItemStore(ctx context.Context) bool {
+	if Settings.ItemStore != ITEM_STORE_MONGO {
+		return true
+	}
+
+	store, err := NewMongoItemStore(ctx, LiveSetting("mongo_uri", Settings.MongoUri), Settings.MongoDb)
+	if err != nil {
+		Log.Error("Failed to connect to MongoDB", "err", err)
+		return false
+	}
+	Documents = store
+	return true
+}
+
+func CloseItemStore() {
+	if Documents == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), seconds(Settings.ShutdownTimeoutInSecs))
+	defer cancel()
+	if err := Documents.Close(ctx); err != nil {
+		Log.Error("Failed to close item document store", "err", err)
+	}
+}
+
+// Returns the item's document, false on a miss or if there's no document
+// store. Errors are logged and treated as a miss so we fall back to SQL
+func FetchItemDocument(ctx context.Context, name string) (Item, bool) {
+	if Documents == nil {
+		return Item{}, false
+	}
+	item, exists, err := Documents.Get(ctx, name)
+	if err != nil {
+		LoggerFrom(ctx).Error("Failed to fetch item document", "item", name, "err", err)
+		return Item{}, false
+	}
+	return item, exists
+}
+
+func StoreItemDocument(ctx context.Context, item Item) {
+	if Documents == nil {
+		return
+	}
+	if err := Documents.Put(ctx, item); err != nil {
+		LoggerFrom(ctx).Error("Failed to store item document", "item", item.name, "err", err)
+	}
+}
+
+func DeleteItemDocument(ctx context.Context, name string) {
+	if Documents == nil {
+		return
+	}
+	if err := Documents.Delete(ctx, name); err != nil {
+		LoggerFrom(ctx).Error("Failed to delete item document", "item", name, "err", err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ConcurrencyLimiter
+ |--------------------------------------------------------------------------
+ |
+ | Caps how many requests can be inside a handler at once. Routes which
+ | scrape the wiki or write in bulk each get their own limiter, and they
+ | all share globalLimiter so that a spike across several of them can't
+ | overload the database or the wiki either
+ |
+ */
+
+type ConcurrencyLimiter struct {
+	slots chan struct{}
+}
+
+// Resized once the config has been loaded
+var globalLimiter = NewConcurrencyLimiter(Settings.MaxConcurrentExpensiveRequests)
+
+func NewConcurrencyLimiter(limit int) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{ slots: make(chan struct{}, limit) }
+}
+
+// Takes a slot without waiting, returns false if every slot is in use
+func (l *ConcurrencyLimiter) TryAcquire() bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *ConcurrencyLimiter) Release() {
+	<-l.slots
+}
+
+// Rejects the request with a 503 and a Retry-After header when either the
+// route or the global limit has been reached, rather than queueing it
+func Limit(inner http.Handler, route *ConcurrencyLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !route.TryAcquire() {
+			tooBusy(w)
+			return
+		}
+		defer route.Release()
+
+		if !globalLimiter.TryAcquire() {
+			tooBusy(w)
+			return
+		}
+		defer globalLimiter.Release()
+
+		inner.ServeHTTP(w, r)
+	})
+}
+
+func tooBusy(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(Settings.RetryAfterInSecs))
+	http.Error(w, "Too many requests in progress, please try again shortly", http.StatusServiceUnavailable)
+}
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: RateLimiter
+ |--------------------------------------------------------------------------
+ |
+ | Token bucket which refills at rate tokens a second up to burst. Callers
+ | queue for a token rather than being rejected, unless the queue is so long
+ | they would wait more than maxWait. Every request we make to the wiki goes
+ | through wikiLimiter so that bulk imports can't get us banned
+ |
+ */
+
+type RateLimiter struct {
+	mutex sync.Mutex
+	rate float64
+	burst float64
+	tokens float64
+	updatedAt time.Time
+	maxWait time.Duration
+}
+
+// Rebuilt once the config has been loaded
+var wikiLimiter = NewRateLimiter(Settings.WikiRequestsPerSecond, Settings.WikiBurst, seconds(Settings.WikiMaxWaitInSecs))
+
+func NewRateLimiter(rate float64, burst int, maxWait time.Duration) *RateLimiter {
+	return &RateLimiter{
+		rate: rate,
+		burst: float64(burst),
+		tokens: float64(burst),
+		updatedAt: time.Now(),
+		maxWait: maxWait,
+	}
+}
+
+// Blocks until a token is available. Returns ErrWikiRateLimited straight away
+// if that would take longer than maxWait, or the context's error if it is
+// cancelled while waiting
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mutex.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.updatedAt).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.updatedAt = now
+
+	// Tokens go negative as callers queue, each one waits for its own token
+	l.tokens--
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	if wait > l.maxWait {
+		l.tokens++
+		l.mutex.Unlock()
+		return ErrWikiRateLimited
+	}
+	l.mutex.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		l.mutex.Lock()
+		l.tokens++
+		l.mutex.Unlock()
+		return ctx.Err()
+	}
+}
@@ -1,26 +1,110 @@
 package main
 
 import (
-	"log"
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
 	"time"
 )
 
-// Logs the time in which we resolve the HTTP request handler func on the server,
-// this will allow us to measure how fast we are serving requests and discover
-// and potential bottle necks.
+// Process wide logger, debug lines are only written when debug is on and
+// log_format switches between logfmt style text and JSON for log aggregation.
+// It is rebuilt once the config has been loaded
+var Log = slog.New(newLogHandler())
+
+func newLogHandler() slog.Handler {
+	options := &slog.HandlerOptions{ Level: slog.LevelInfo }
+	if Settings.Debug {
+		options.Level = slog.LevelDebug
+	}
+
+	if Settings.LogFormat == "json" {
+		return slog.NewJSONHandler(os.Stdout, options)
+	}
+	return slog.NewTextHandler(os.Stdout, options)
+}
+
+type loggerContextKey struct{}
+
+// Returns a copy of ctx carrying the logger, anything logged through
+// LoggerFrom(ctx) further down is tagged with the logger's attributes
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// Returns the logger for the request or fetch ctx belongs to, or Log if
+// nothing has tagged it
+func LoggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return Log
+}
+
+// Records the status a handler responded with so it can be logged
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// WebSocket upgrades take over the connection, which is recorded as switching
+// protocols as the upgrade is written straight to the connection
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buffer, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, buffer, err
+}
+
+// Tags every request with an id, taken from X-Request-Id if the caller sent
+// one, and logs the time in which we resolve the HTTP request handler func so
+// we can measure how fast we are serving requests and discover any bottle necks.
+// Handlers log through LoggerFrom(r.Context()) so their lines carry the id too
 func Logger(inner http.Handler, name string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		inner.ServeHTTP(w, r)
+		requestId := r.Header.Get("X-Request-Id")
+		if requestId == "" {
+			requestId = newRequestId()
+		}
+		w.Header().Set("X-Request-Id", requestId)
 
-		log.Printf(
-			"%s\t%s\t%s\t%s",
-			r.Method,
-			r.RequestURI,
-			name,
-			time.Since(start),
+		logger := Log.With("request_id", requestId)
+		recorder := &statusRecorder{ ResponseWriter: w, status: http.StatusOK }
+
+		inner.ServeHTTP(recorder, r.WithContext(WithLogger(r.Context(), logger)))
+
+		logger.Info("Request served",
+			"method", r.Method,
+			"uri", r.RequestURI,
+			"route", name,
+			"status", recorder.status,
+			"duration", time.Since(start),
 		)
 	})
-}
\ No newline at end of file
+}
+
+func newRequestId() string {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		return hex.EncodeToString([]byte(time.Now().Format("150405.000000")))
+	}
+	return hex.EncodeToString(bytes)
+}
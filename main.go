@@ -1,43 +1,260 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"log/slog"
+	"net"
 	"net/http"
-	"log"
-	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 )
 
 // Global connection to be used by the server
 var DB = Database{}
 
+// Cancelled when we receive a SIGTERM, every request context is derived from
+// this so in-flight scrapes are abandoned rather than killed mid-write
+var AppContext, cancelAppContext = context.WithCancel(context.Background())
+
+// Background jobs which should be allowed to finish before we exit
+var BackgroundWork sync.WaitGroup
+
 func main() {
-	// Register the cleanup listener:
+	dedupeStatistics := flag.Bool("dedupe-statistics", false, "Remove duplicate statistics and exit, migration 0003 does this itself when it adds the unique key on statistics")
+	backfillRestrictions := flag.Bool("backfill-restrictions", false, "Fill item_classes, item_races and item_slots from every item's CLASS, RACE and SLOT stats and exit")
+	migrate := flag.Bool("migrate", false, "Apply any pending schema migrations and exit")
+	reparse := flag.Bool("reparse", false, "Parse items again from their stored page snapshots and exit, item names can follow to only reparse those")
+	flag.Parse()
+
+	// Verifies a deployment and exits rather than serving
+	if flag.Arg(0) == "selftest" {
+		os.Exit(RunSelfTest())
+	}
+
+	config, problems := LoadConfig()
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			Log.Error(problem)
+		}
+		Log.Error("Config is invalid", "problems", len(problems))
+		os.Exit(1)
+	}
+	applyConfig(config)
+
+	// Initialise DB connections
+	Log.Info("Initialising database connection")
+	if !DB.Open() {
+		os.Exit(1)
+	}
+	Log.Info("Connection initialised")
+
+	// SQLite databases are created on first boot from schema.go, MySQL ones
+	// from the migrations
+	if err := DB.dialect.prepareSchema(AppContext, *migrate || (Settings.MigrateOnBoot && !Settings.ReadOnly)); err != nil {
+		os.Exit(1)
+	}
+	if *migrate {
+		DB.Close()
+		return
+	}
+
+	if *dedupeStatistics {
+		if _, _, err := DedupeStatistics(AppContext); err != nil {
+			os.Exit(1)
+		}
+		DB.Close()
+		return
+	}
+
+	// Fail fast if the database is missing anything we rely on
+	if problems = CheckSchema(); len(problems) > 0 {
+		for _, problem := range problems {
+			Log.Error(problem)
+		}
+		Log.Error("Schema check failed", "problems", len(problems))
+		os.Exit(1)
+	}
+	Log.Info("Schema check passed")
+
+	if err := SeedRestrictionTables(AppContext); err != nil {
+		os.Exit(1)
+	}
+	if *backfillRestrictions {
+		failed := BackfillItemRestrictions(AppContext)
+		DB.Close()
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	OpenRedis()
+	if !OpenItemStore(AppContext) {
+		CloseRedis()
+		DB.Close()
+		os.Exit(1)
+	}
+
+	// Reparsed items are saved as a scrape would, so this needs the cache too
+	if *reparse {
+		failed := ReparseItems(AppContext, flag.Args())
+		CloseItemStore()
+		CloseRedis()
+		DB.Close()
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Fills in items from an EQEmu items table dump, saved as an import would
+	if flag.Arg(0) == "import-eqemu" {
+		flags := flag.NewFlagSet("import-eqemu", flag.ExitOnError)
+		create := flags.Bool("create", false, "Create items in the dump we don't have, rather than only filling in those we do")
+		flags.Parse(flag.Args()[1:])
+		if flags.NArg() != 1 {
+			Log.Error("Usage: import-eqemu [-create] <items.sql|items.csv>")
+			os.Exit(2)
+		}
+
+		result, err := ImportEqemuItems(AppContext, flags.Arg(0), *create)
+		CloseItemStore()
+		CloseRedis()
+		DB.Close()
+		if err != nil || result.failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Pick up rotated credentials
+	go RefreshSecrets()
+
+	// Drop items other replicas have saved from the local cache
+	go SubscribeItemInvalidations()
+
+	// A read only mirror serves what's already in the database, so none of the
+	// jobs which write to it or fetch from the wiki are run
+	if Settings.ReadOnly {
+		Log.Info("Running in read only mode")
+	} else {
+		// Keep the price roll-ups up to date in the background
+		go RollupPrices()
+
+		// Lines which failed because the wiki was unavailable are parsed again later
+		go RetryLines()
+
+		// Watch for wiki template changes which would break parsing
+		go RunCanary()
+
+		// Pick up corrections made to the wiki since items were scraped
+		go RescrapeStaleItems()
+
+		// Propose shorthand sellers use as aliases
+		go RunAliasLearning()
+
+		// Upload a nightly dump of the catalog for anyone who wants all of it
+		go SnapshotCatalog()
+
+		// Download icons of saved items so they're served from here
+		go DownloadImages()
+
+		// Fill in effects from their spell pages
+		go ScrapeEffectDetails()
+
+		// Send webhook payloads, retrying callbacks which fail
+		go DeliverWebhooks()
+	}
+
+	// Item views and mentions are written in batches, by the primary only
+	if !Settings.ReadOnly {
+		Usage.Start()
+	}
+
+	// Internal consumers can call the item service over gRPC
+	ServeGrpc()
+
+	// Initialise router
+	Log.Info("Starting webserver", "port", Settings.Port)
+	server := &http.Server{
+		Addr: ":" + Settings.Port,
+		Handler: CreateRouter(),
+		BaseContext: func(net.Listener) context.Context {
+			return AppContext
+		},
+	}
+
+	// Register the shutdown listener, stopped is closed once the server has
+	// drained so main doesn't return while requests are still writing
+	stopped := make(chan struct{})
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
-		cleanup()
-		os.Exit(1)
+		shutdown(server)
+		close(stopped)
 	}()
 
-	// Initialise DB connections
-	fmt.Println("Initialising database connection")
-	DB.Open()
-	fmt.Println("Connection initialised")
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		Log.Error("Webserver stopped", "err", err)
+		os.Exit(1)
+	}
+	<-stopped
+}
 
-	// Initialise router
-	fmt.Println("Starting webserver...")
-	fmt.Println("Listening on port: " + PORT)
-	router := CreateRouter()
-	log.Fatal(http.ListenAndServe(":" + PORT, router))
+// Makes the config current, anything built from the defaults when the package
+// was initialised is rebuilt
+func applyConfig(config Config) {
+	Settings = config
+	Log = slog.New(newLogHandler())
+	wikiClient.Timeout = seconds(Settings.WikiTimeoutInSecs)
+	LocalItems = NewItemLRU(Settings.ItemCacheSize)
+	globalLimiter = NewConcurrencyLimiter(Settings.MaxConcurrentExpensiveRequests)
+	wikiLimiter = NewRateLimiter(Settings.WikiRequestsPerSecond, Settings.WikiBurst, seconds(Settings.WikiMaxWaitInSecs))
+	Usage = NewUsageWriter(Settings.UsageBufferSize)
+	Images = NewImageStore()
+}
+
+// Stops accepting connections, cancels in-flight scrapes and waits up to
+// shutdown_timeout_in_secs for requests and jobs to finish before cleaning up
+func shutdown(server *http.Server) {
+	Log.Info("Shutting down")
+	cancelAppContext()
+
+	ctx, cancel := context.WithTimeout(context.Background(), seconds(Settings.ShutdownTimeoutInSecs))
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		Log.Error("Failed to drain requests", "err", err)
+	}
+	StopGrpc(ctx)
+
+	// Only once requests have drained, so the usage they tracked is flushed
+	Usage.Stop()
+
+	finished := make(chan struct{})
+	go func() {
+		BackgroundWork.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		Log.Warn("Timed out waiting for background jobs")
+	}
+
+	cleanup()
 }
 
 func cleanup() {
-	fmt.Println("Beginning clean-up")
+	Log.Info("Beginning clean-up")
 
 	DB.Close()
+	CloseRedis()
+	CloseItemStore()
 
-	fmt.Println("Finished clean-up")
-}
\ No newline at end of file
+	Log.Info("Finished clean-up")
+}
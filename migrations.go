@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Schema migrations
+ |--------------------------------------------------------------------------
+ |
+ | The SQL files in migrations/ are compiled into the binary and applied
+ | in order on boot, so a new MySQL deployment creates its own schema.
+ | Files are named NNNN_description.sql and hold statements separated by
+ | semicolons at the end of a line. Each version is recorded in
+ | schema_migrations once it's applied, and a named lock stops replicas
+ | booting together from running the same migration twice. MySQL commits
+ | DDL as it goes so a migration isn't atomic, which is why they should
+ | stick to IF NOT EXISTS where they can. MySQL has no IF NOT EXISTS for
+ | ADD COLUMN or ADD KEY, so a column or key which is already there is
+ | skipped rather than failing the migration, that lets one half applied
+ | by hand or by an earlier failed run finish.
+ |
+ | 0001 is the schema as it was before migrations, a database which
+ | predates them is recorded as having run it and then brought up to date
+ | by the rest. Never change a migration once it has shipped, add another.
+ |
+ | schema.go still lists what the service needs, a migration which adds a
+ | column has to add it there too. That's what CheckSchema compares
+ | against and what SQLite databases are created from, as migrations are
+ | written for MySQL
+ |
+ */
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Held while migrations run, replicas wait up to this long for it
+const (
+	MIGRATION_LOCK_NAME            = "service-wiki-migrations"
+	MIGRATION_LOCK_TIMEOUT_IN_SECS = 300
+)
+
+type migration struct {
+	version int
+	name string
+	statements []string
+}
+
+// Returns the embedded migrations ordered by version
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s doesn't start with a version", entry.Name())
+		}
+		if existing, exists := seen[version]; exists {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", existing, entry.Name(), version)
+		}
+		seen[version] = entry.Name()
+
+		body, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{ version: version, name: name, statements: splitStatements(string(body)) })
+	}
+	sort.Slice(migrations, func(a, b int) bool {
+		return migrations[a].version < migrations[b].version
+	})
+	return migrations, nil
+}
+
+// Splits a migration into its statements, dropping -- comment lines. The
+// driver only runs one statement at a time
+func splitStatements(body string) []string {
+	var statements []string
+	var current []string
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current = append(current, line)
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(strings.Join(current, "\n")), ";"))
+			current = nil
+		}
+	}
+	if len(current) > 0 {
+		statements = append(statements, strings.TrimSpace(strings.Join(current, "\n")))
+	}
+	return statements
+}
+
+// Applies every migration which hasn't been applied yet. A database which
+// already has an items table but no schema_migrations predates migrations, so
+// the first migration is recorded without being run as it already has that
+// schema, and the later ones bring it up to date
+func RunMigrations(ctx context.Context) error {
+	if !DB.connected() {
+		return ErrDatabaseWrite
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		Log.Error("Failed to load migrations", "err", err)
+		return err
+	}
+
+	// The lock and the statements have to share a connection
+	conn, err := DB.conn.Conn(ctx)
+	if err != nil {
+		Log.Error("Failed to reserve a connection for migrations", "err", err)
+		return err
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", MIGRATION_LOCK_NAME, MIGRATION_LOCK_TIMEOUT_IN_SECS).Scan(&locked); err != nil || locked.Int64 != 1 {
+		Log.Error("Failed to take the migration lock", "err", err)
+		return fmt.Errorf("couldn't take the migration lock: %v", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", MIGRATION_LOCK_NAME)
+
+	query := "CREATE TABLE IF NOT EXISTS schema_migrations (" +
+		"version INT NOT NULL, " +
+		"name VARCHAR(255) NOT NULL, " +
+		"applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP, " +
+		"PRIMARY KEY (version)" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		Log.Error("Failed to create schema_migrations", "err", err)
+		return err
+	}
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 && len(migrations) > 0 {
+		var tables int
+		query = "SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'items'"
+		if err := conn.QueryRowContext(ctx, query).Scan(&tables); err != nil {
+			Log.Error("Failed to check for an existing schema", "err", err)
+			return err
+		}
+		if tables > 0 {
+			first := migrations[0]
+			Log.Info("Recording the existing schema as migrated", "version", first.version, "name", first.name)
+			if err := recordMigration(ctx, conn, first); err != nil {
+				return err
+			}
+			applied[first.version] = true
+		}
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		Log.Info("Applying migration", "version", m.version, "name", m.name, "statements", len(m.statements))
+		for _, statement := range m.statements {
+			if _, err := conn.ExecContext(ctx, statement); err != nil {
+				if isMysqlDuplicateSchema(err) {
+					Log.Info("Skipping a migration statement, the column or key is already there", "version", m.version, "name", m.name, "statement", statement)
+					continue
+				}
+				Log.Error("Migration failed", "version", m.version, "name", m.name, "statement", statement, "err", err)
+				return &QueryError{ query: statement, err: err }
+			}
+		}
+		if err := recordMigration(ctx, conn, m); err != nil {
+			return err
+		}
+		count++
+	}
+	Log.Info("Schema migrations are up to date", "applied", count, "total", len(migrations))
+	return nil
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]bool, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		Log.Error("Failed to read schema_migrations", "err", err)
+		return nil, err
+	}
+	defer DB.CloseRows(rows)
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func recordMigration(ctx context.Context, conn *sql.Conn, m migration) error {
+	if _, err := conn.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.version, m.name); err != nil {
+		Log.Error("Failed to record migration", "version", m.version, "name", m.name, "err", err)
+		return err
+	}
+	return nil
+}
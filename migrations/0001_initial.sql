@@ -0,0 +1,32 @@
+-- The schema the service started with, before any of the tables and columns
+-- later migrations add. Databases which predate migrations already have it, so
+-- they're recorded as having run this rather than running it
+
+CREATE TABLE IF NOT EXISTS items (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	name VARCHAR(255) NOT NULL,
+	displayName VARCHAR(255) NULL,
+	imageSrc VARCHAR(512) NULL,
+	PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS statistics (
+	item_id INT UNSIGNED NOT NULL,
+	code VARCHAR(64) NOT NULL,
+	value DOUBLE NULL,
+	effect VARCHAR(255) NOT NULL DEFAULT ''
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS effects (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	name VARCHAR(255) NOT NULL,
+	uri VARCHAR(512) NOT NULL DEFAULT '',
+	PRIMARY KEY (id),
+	UNIQUE KEY effects_name_unique (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS item_effects (
+	item_id INT UNSIGNED NOT NULL,
+	effect_id INT UNSIGNED NOT NULL,
+	restriction VARCHAR(255) NOT NULL DEFAULT ''
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
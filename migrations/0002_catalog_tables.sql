@@ -0,0 +1,461 @@
+-- Everything added to the schema between the first release and migrations.
+-- A database may already have some of it, added by hand when CheckSchema asked
+-- for it, so tables are created if they don't exist and a column or key which
+-- is already there is skipped rather than failing the migration
+
+ALTER TABLE items ADD COLUMN version INT NOT NULL DEFAULT 1;
+ALTER TABLE items ADD COLUMN description TEXT NULL;
+ALTER TABLE items ADD COLUMN container_slots INT NULL;
+ALTER TABLE items ADD COLUMN container_max_size VARCHAR(16) NULL;
+ALTER TABLE items ADD COLUMN container_weight_reduction DECIMAL(5,2) NULL;
+ALTER TABLE items ADD COLUMN damage_delay_ratio DOUBLE NULL;
+ALTER TABLE items ADD COLUMN haste_adjusted_delay DOUBLE NULL;
+ALTER TABLE items ADD COLUMN observed_price DOUBLE NULL;
+ALTER TABLE items ADD COLUMN observed_count INT NOT NULL DEFAULT 0;
+ALTER TABLE items ADD COLUMN vendor_sell_price DOUBLE NULL;
+ALTER TABLE items ADD COLUMN vendor_buy_price DOUBLE NULL;
+ALTER TABLE items ADD COLUMN is_manual_override TINYINT(1) NOT NULL DEFAULT 0;
+ALTER TABLE items ADD COLUMN scraped_at DATETIME NULL;
+ALTER TABLE items ADD UNIQUE KEY items_name_version_unique (name, version);
+ALTER TABLE items ADD KEY items_scraped_at (scraped_at);
+
+ALTER TABLE statistics ADD KEY statistics_code_value (code, value);
+
+ALTER TABLE effects ADD COLUMN description TEXT NULL;
+ALTER TABLE effects ADD COLUMN mana INT NULL;
+ALTER TABLE effects ADD COLUMN duration VARCHAR(255) NULL;
+ALTER TABLE effects ADD COLUMN scraped_at DATETIME NULL;
+
+ALTER TABLE item_effects ADD COLUMN effect_type VARCHAR(32) NULL;
+ALTER TABLE item_effects ADD COLUMN trigger_level INT NULL;
+ALTER TABLE item_effects ADD COLUMN cast_time DOUBLE NULL;
+ALTER TABLE item_effects ADD COLUMN charges INT NULL;
+ALTER TABLE item_effects ADD KEY item_effects_item_id (item_id);
+ALTER TABLE item_effects ADD KEY item_effects_effect_id (effect_id);
+
+CREATE TABLE IF NOT EXISTS item_wikitext (
+	item_id INT UNSIGNED NOT NULL,
+	wikitext MEDIUMTEXT NOT NULL,
+	fetched_at DATETIME NOT NULL,
+	UNIQUE KEY item_wikitext_item_id_unique (item_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS page_snapshots (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	item_id INT UNSIGNED NOT NULL,
+	uri VARCHAR(512) NOT NULL,
+	body MEDIUMBLOB NOT NULL,
+	hash CHAR(64) NOT NULL,
+	size_bytes INT NOT NULL,
+	fetched_at DATETIME NOT NULL,
+	PRIMARY KEY (id),
+	KEY page_snapshots_item_id (item_id, fetched_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS item_rules (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	server VARCHAR(32) NOT NULL,
+	item_id INT UNSIGNED NOT NULL,
+	rule VARCHAR(32) NOT NULL,
+	reason VARCHAR(255) NOT NULL DEFAULT '',
+	exclude_from_market TINYINT(1) NOT NULL DEFAULT 0,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	UNIQUE KEY item_rules_server_item_id_rule_unique (server, item_id, rule)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS auctions (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	server VARCHAR(32) NOT NULL,
+	seller VARCHAR(64) NOT NULL,
+	item_id INT UNSIGNED NULL,
+	item_name VARCHAR(255) NOT NULL,
+	price DOUBLE NULL,
+	line TEXT NOT NULL,
+	auctioned_at DATETIME NOT NULL,
+	PRIMARY KEY (id),
+	KEY auctions_server_seller (server, seller),
+	KEY auctions_server_item_id (server, item_id, auctioned_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS retry_lines (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	line_hash CHAR(64) NOT NULL,
+	server VARCHAR(32) NOT NULL,
+	line TEXT NOT NULL,
+	reason VARCHAR(64) NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	UNIQUE KEY retry_lines_line_hash_unique (line_hash),
+	KEY retry_lines_next_attempt_at (next_attempt_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS quarantined_lines (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	line TEXT NOT NULL,
+	reason VARCHAR(255) NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS price_points (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	server VARCHAR(32) NOT NULL,
+	item_id INT UNSIGNED NOT NULL,
+	price DOUBLE NOT NULL,
+	confidence DOUBLE NOT NULL,
+	line TEXT NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	KEY price_points_server_item_id (server, item_id, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS price_rollups (
+	server VARCHAR(32) NOT NULL,
+	item_id INT UNSIGNED NOT NULL,
+	period VARCHAR(8) NOT NULL,
+	bucket DATETIME NOT NULL,
+	open DOUBLE NOT NULL,
+	high DOUBLE NOT NULL,
+	low DOUBLE NOT NULL,
+	close DOUBLE NOT NULL,
+	volume INT NOT NULL,
+	UNIQUE KEY price_rollups_server_item_id_period_bucket_unique (server, item_id, period, bucket)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS merchant_inventory (
+	merchant_name VARCHAR(255) NOT NULL,
+	item_name VARCHAR(255) NOT NULL,
+	item_id INT UNSIGNED NULL,
+	price DOUBLE NULL,
+	KEY merchant_inventory_item_id (item_id),
+	KEY merchant_inventory_item_name (item_name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS spells (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	name VARCHAR(255) NOT NULL,
+	imageSrc VARCHAR(512) NULL,
+	mana INT NULL,
+	cast_time DOUBLE NULL,
+	recast_time DOUBLE NULL,
+	duration VARCHAR(255) NOT NULL DEFAULT '',
+	skill VARCHAR(64) NOT NULL DEFAULT '',
+	target VARCHAR(64) NOT NULL DEFAULT '',
+	description TEXT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY spells_name_unique (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS spell_classes (
+	spell_id INT UNSIGNED NOT NULL,
+	class VARCHAR(3) NOT NULL,
+	level INT NOT NULL,
+	KEY spell_classes_spell_id (spell_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS spell_lines (
+	name VARCHAR(255) NOT NULL,
+	previous_name VARCHAR(255) NULL,
+	next_name VARCHAR(255) NULL,
+	UNIQUE KEY spell_lines_name_unique (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS pets (
+	spell_id INT UNSIGNED NOT NULL,
+	level INT NOT NULL,
+	hp INT NULL,
+	ac INT NULL,
+	min_damage INT NULL,
+	max_damage INT NULL,
+	attack_delay INT NULL,
+	KEY pets_spell_id (spell_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS npc_spawns (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	npc_name VARCHAR(255) NOT NULL,
+	zone VARCHAR(255) NOT NULL,
+	loc_y DOUBLE NOT NULL,
+	loc_x DOUBLE NOT NULL,
+	loc_z DOUBLE NULL,
+	text VARCHAR(512) NOT NULL DEFAULT '',
+	PRIMARY KEY (id),
+	KEY npc_spawns_npc_name (npc_name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS npcs (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	name VARCHAR(255) NOT NULL,
+	level VARCHAR(32) NOT NULL DEFAULT '',
+	zone VARCHAR(255) NOT NULL DEFAULT '',
+	hp INT NULL,
+	scraped_at DATETIME NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY npcs_name_unique (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS npc_drops (
+	npc_id INT UNSIGNED NOT NULL,
+	item_name VARCHAR(255) NOT NULL,
+	item_id INT UNSIGNED NULL,
+	chance DOUBLE NULL,
+	KEY npc_drops_npc_id (npc_id),
+	KEY npc_drops_item_id (item_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS zones (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	name VARCHAR(255) NOT NULL,
+	level_range VARCHAR(32) NOT NULL DEFAULT '',
+	scraped_at DATETIME NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY zones_name_unique (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS zone_connections (
+	zone_id INT UNSIGNED NOT NULL,
+	connected_zone VARCHAR(255) NOT NULL,
+	KEY zone_connections_zone_id (zone_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS zone_npcs (
+	zone_id INT UNSIGNED NOT NULL,
+	npc_name VARCHAR(255) NOT NULL,
+	KEY zone_npcs_zone_id (zone_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS quests (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	name VARCHAR(255) NOT NULL,
+	scraped_at DATETIME NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY quests_name_unique (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS quest_items (
+	quest_id INT UNSIGNED NOT NULL,
+	item_name VARCHAR(255) NOT NULL,
+	item_id INT UNSIGNED NULL,
+	role VARCHAR(16) NOT NULL,
+	KEY quest_items_quest_id (quest_id),
+	KEY quest_items_item_id (item_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS quest_steps (
+	quest_id INT UNSIGNED NOT NULL,
+	position INT NOT NULL,
+	npc_name VARCHAR(255) NOT NULL DEFAULT '',
+	text TEXT NOT NULL,
+	KEY quest_steps_quest_id (quest_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS recipes (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	name VARCHAR(255) NOT NULL,
+	tradeskill VARCHAR(64) NOT NULL DEFAULT '',
+	trivial INT NULL,
+	container VARCHAR(255) NOT NULL DEFAULT '',
+	scraped_at DATETIME NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY recipes_name_unique (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS recipe_components (
+	recipe_id INT UNSIGNED NOT NULL,
+	item_name VARCHAR(255) NOT NULL,
+	item_id INT UNSIGNED NULL,
+	count INT NOT NULL DEFAULT 1,
+	role VARCHAR(16) NOT NULL,
+	KEY recipe_components_recipe_id (recipe_id),
+	KEY recipe_components_item_id (item_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS alias_candidates (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	alias VARCHAR(255) NOT NULL,
+	item_name VARCHAR(255) NOT NULL,
+	occurrences INT NOT NULL,
+	share DOUBLE NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	updated_at DATETIME NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY alias_candidates_alias_unique (alias)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS item_aliases (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	alias VARCHAR(255) NOT NULL,
+	item_name VARCHAR(255) NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	UNIQUE KEY item_aliases_alias_unique (alias)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS item_factions (
+	item_id INT UNSIGNED NOT NULL,
+	faction_name VARCHAR(255) NOT NULL,
+	standing VARCHAR(32) NOT NULL,
+	KEY item_factions_item_id (item_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS classes (
+	abbreviation VARCHAR(3) NOT NULL,
+	name VARCHAR(64) NOT NULL,
+	UNIQUE KEY classes_abbreviation_unique (abbreviation)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS races (
+	abbreviation VARCHAR(3) NOT NULL,
+	name VARCHAR(64) NOT NULL,
+	size VARCHAR(16) NOT NULL DEFAULT '',
+	UNIQUE KEY races_abbreviation_unique (abbreviation)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS item_classes (
+	item_id INT UNSIGNED NOT NULL,
+	class VARCHAR(3) NOT NULL,
+	UNIQUE KEY item_classes_item_id_class_unique (item_id, class),
+	KEY item_classes_class (class)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS item_races (
+	item_id INT UNSIGNED NOT NULL,
+	race VARCHAR(3) NOT NULL,
+	UNIQUE KEY item_races_item_id_race_unique (item_id, race),
+	KEY item_races_race (race)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS item_slots (
+	item_id INT UNSIGNED NOT NULL,
+	slot VARCHAR(16) NOT NULL,
+	UNIQUE KEY item_slots_item_id_slot_unique (item_id, slot),
+	KEY item_slots_slot (slot)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS factions (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	name VARCHAR(255) NOT NULL,
+	scraped_at DATETIME NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY factions_name_unique (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS faction_npcs (
+	faction_id INT UNSIGNED NOT NULL,
+	npc_name VARCHAR(255) NOT NULL,
+	KEY faction_npcs_faction_id (faction_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS faction_quests (
+	faction_id INT UNSIGNED NOT NULL,
+	quest_name VARCHAR(255) NOT NULL,
+	KEY faction_quests_faction_id (faction_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS item_sources (
+	item_id INT UNSIGNED NOT NULL,
+	npc_name VARCHAR(255) NOT NULL,
+	zone VARCHAR(255) NOT NULL DEFAULT '',
+	KEY item_sources_item_id (item_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS item_usage (
+	item_id INT UNSIGNED NOT NULL,
+	kind VARCHAR(16) NOT NULL,
+	day DATE NOT NULL,
+	count INT NOT NULL DEFAULT 0,
+	UNIQUE KEY item_usage_item_id_kind_day_unique (item_id, kind, day)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS canary_expectations (
+	page VARCHAR(255) NOT NULL,
+	data MEDIUMTEXT NOT NULL,
+	updated_at DATETIME NOT NULL,
+	UNIQUE KEY canary_expectations_page_unique (page)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS api_keys (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	tenant VARCHAR(64) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	prefix VARCHAR(16) NOT NULL,
+	key_hash CHAR(64) NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	revoked_at DATETIME NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY api_keys_key_hash_unique (key_hash),
+	KEY api_keys_tenant (tenant)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS item_revisions (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	item_id INT UNSIGNED NOT NULL,
+	data MEDIUMTEXT NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	KEY item_revisions_item_id (item_id, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS snapshots (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	object_key VARCHAR(512) NOT NULL,
+	item_count INT NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS item_images (
+	item_id INT UNSIGNED NOT NULL,
+	src VARCHAR(512) NOT NULL,
+	hash CHAR(64) NOT NULL,
+	content_type VARCHAR(64) NOT NULL,
+	size_bytes INT NOT NULL,
+	fetched_at DATETIME NOT NULL,
+	UNIQUE KEY item_images_item_id_unique (item_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS parse_failures (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	name VARCHAR(255) NOT NULL,
+	url VARCHAR(512) NOT NULL,
+	body MEDIUMTEXT NOT NULL,
+	reason VARCHAR(255) NOT NULL,
+	occurrences INT NOT NULL DEFAULT 1,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	UNIQUE KEY parse_failures_name_unique (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	server VARCHAR(32) NOT NULL,
+	url VARCHAR(512) NOT NULL,
+	event VARCHAR(32) NOT NULL,
+	item_name VARCHAR(255) NOT NULL DEFAULT '',
+	max_price DOUBLE NULL,
+	format VARCHAR(16) NOT NULL,
+	secret VARCHAR(255) NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	KEY webhooks_server_event (server, event)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	webhook_id INT UNSIGNED NOT NULL,
+	event VARCHAR(32) NOT NULL,
+	payload MEDIUMTEXT NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	last_error VARCHAR(512) NULL,
+	next_attempt_at DATETIME NOT NULL,
+	delivered_at DATETIME NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	KEY webhook_deliveries_pending (delivered_at, next_attempt_at),
+	KEY webhook_deliveries_webhook_id (webhook_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
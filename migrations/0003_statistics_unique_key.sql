@@ -0,0 +1,25 @@
+-- Re-scrapes from before saveStats upserted left duplicate statistics behind,
+-- which have to go before the unique key saveStats relies on can be added. Rows
+-- have no id to tell duplicates apart, so the table is rebuilt with one row for
+-- each item, code and effect, keeping the highest value as -dedupe-statistics
+-- does. A database which already has the key loses nothing by this
+
+DROP TABLE IF EXISTS statistics_deduped;
+
+CREATE TABLE statistics_deduped (
+	item_id INT UNSIGNED NOT NULL,
+	code VARCHAR(64) NOT NULL,
+	value DOUBLE NULL,
+	effect VARCHAR(255) NOT NULL DEFAULT '',
+	UNIQUE KEY statistics_item_id_code_effect_unique (item_id, code, effect),
+	KEY statistics_code_value (code, value)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT INTO statistics_deduped (item_id, code, value, effect)
+SELECT item_id, code, MAX(value), COALESCE(effect, '')
+FROM statistics
+GROUP BY item_id, code, COALESCE(effect, '');
+
+RENAME TABLE statistics TO statistics_duplicated, statistics_deduped TO statistics;
+
+DROP TABLE statistics_duplicated;
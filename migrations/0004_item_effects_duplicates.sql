@@ -0,0 +1,16 @@
+-- Scraping an item again used to add another copy of each of its effects, as
+-- item_effects has no key to upsert on. The table is rebuilt with one row for
+-- each distinct effect of an item, saveEffects now replaces an item's rows
+-- rather than adding to them
+
+DROP TABLE IF EXISTS item_effects_deduped;
+
+CREATE TABLE item_effects_deduped LIKE item_effects;
+
+INSERT INTO item_effects_deduped (item_id, effect_id, restriction, effect_type, trigger_level, cast_time, charges)
+SELECT DISTINCT item_id, effect_id, restriction, effect_type, trigger_level, cast_time, charges
+FROM item_effects;
+
+RENAME TABLE item_effects TO item_effects_duplicated, item_effects_deduped TO item_effects;
+
+DROP TABLE item_effects_duplicated;
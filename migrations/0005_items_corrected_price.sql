@@ -0,0 +1,5 @@
+-- Prices corrected by hand were written over observed_price, which the next
+-- confident auction line averaged straight back in. They're kept apart now and
+-- preferred to the observed price wherever an item's price is read
+
+ALTER TABLE items ADD COLUMN corrected_price DOUBLE NULL;
@@ -0,0 +1,20 @@
+-- Aliases belong to a tenant as prices do, so one community's nicknames never
+-- match another's auction lines. The aliases and candidates we already have
+-- were the default tenant's. Prices corrected for other tenants are kept in
+-- price_corrections, the default tenant's stay in items.corrected_price
+
+ALTER TABLE item_aliases ADD COLUMN server VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE item_aliases ADD UNIQUE KEY item_aliases_server_alias_unique (server, alias);
+ALTER TABLE item_aliases DROP INDEX item_aliases_alias_unique;
+
+ALTER TABLE alias_candidates ADD COLUMN server VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE alias_candidates ADD UNIQUE KEY alias_candidates_server_alias_unique (server, alias);
+ALTER TABLE alias_candidates DROP INDEX alias_candidates_alias_unique;
+
+CREATE TABLE IF NOT EXISTS price_corrections (
+	server VARCHAR(32) NOT NULL,
+	item_id INT UNSIGNED NOT NULL,
+	price DOUBLE NOT NULL,
+	corrected_at DATETIME NOT NULL,
+	UNIQUE KEY price_corrections_server_item_id_unique (server, item_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,964 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | OpenAPI document
+ |--------------------------------------------------------------------------
+ |
+ | The machine readable contract for the auction log uploader, Discord bots
+ | and anyone else calling us. Paths and methods come straight from routes
+ | so they can't drift, routeDocs adds what each route takes and returns.
+ | Schemas are struct literals mirroring each type's MarshalJSON which are
+ | turned into JSON schema by reflection, a field whose type is named in
+ | openApiSchemas becomes a reference to that schema. Keep these in step
+ | when changing a MarshalJSON
+ |
+ */
+
+type routeDoc struct {
+	summary string
+	query []queryDoc
+	body interface{}
+	response interface{}
+	contentType string
+	status int
+}
+
+type queryDoc struct {
+	name string
+	kind string
+	description string
+}
+
+// The document, built from the routes when the router is created
+var openApiDocument []byte
+
+var pageQuery = []queryDoc{
+	{"page", "integer", "Page to return, starting from 1"},
+	{"per_page", "integer", "Results per page, up to " + strconv.Itoa(MAX_PER_PAGE)},
+}
+
+var serverQuery = queryDoc{"server", "string", "Include the rules this server has for each item, requests with an API key always use the key's server"}
+
+var tenantQuery = queryDoc{"server", "string", "The tenant to act for, the default tenant if it's left out"}
+
+var labelsQuery = queryDoc{"labels", "string", "short (the default) labels stats i.e. STR, long labels them i.e. Strength"}
+
+var reportStatsQuery = queryDoc{"stats", "string", "Comma separated stat codes to give a column each i.e. AC,HP,MANA, defaults to the common ones"}
+
+var itemFilterQuery = []queryDoc{
+	{"stat", "string", "Stat code to filter and rank by"},
+	{"min", "number", "Minimum value of stat"},
+	{"max", "number", "Maximum value of stat"},
+	{"class", "string", "Class which can use the item"},
+	{"race", "string", "Race which can use the item"},
+	{"wearer_size", "string", "small, medium or large, can't be used with race"},
+	{"slot", "string", "Slot the item is worn in i.e. PRIMARY, items worn in several slots match each"},
+	{"weapon_skill", "string", "Weapon skill i.e. 1H Slashing"},
+	{"clicky_weight", "integer", "Weight given to clickable effects when ranking, up to " + strconv.Itoa(MAX_CLICKY_WEIGHT)},
+}
+
+var routeDocs = map[string]routeDoc{
+	"Show Job": {summary: "Progress of an auction ingest job", response: &Job{}},
+	"List Items": {
+		summary: "Every item we have scraped, one page at a time",
+		query: append([]queryDoc{serverQuery, labelsQuery}, pageQuery...),
+		response: struct {
+			Page    int    `json:"page"`
+			PerPage int    `json:"perPage"`
+			Total   int64  `json:"total"`
+			Items   []Item `json:"items"`
+		}{},
+	},
+	"Store Items": {
+		summary: "Queues auction log lines to be parsed, poll the job in the Location header for progress",
+		body: []string{},
+		response: &Job{},
+		status: 202,
+	},
+	"Search Items": {
+		summary: "Fuzzy searches item names, ranked by closeness",
+		query: []queryDoc{{"q", "string", "Search query"}, {"limit", "integer", "Results to return, up to " + strconv.Itoa(MAX_PER_PAGE)}},
+		response: []SearchResult{},
+	},
+	"Query Items": {
+		summary: "Items matching the filters, ranked by stat when one is given",
+		query: append(append(itemFilterQuery, serverQuery, labelsQuery), pageQuery...),
+		response: struct {
+			Page    int    `json:"page"`
+			PerPage int    `json:"perPage"`
+			Items   []Item `json:"items"`
+		}{},
+	},
+	"Top Items": {
+		summary: "Weapons ranked by damage to delay ratio, or by delay once their own haste is applied",
+		query: append([]queryDoc{
+			{"type", "string", "Kind of item to rank, only weapon"},
+			{"order", "string", "ratio (the default) ranks the highest damage to delay ratio first, delay the fastest weapon first"},
+			{"class", "string", "Class which can use the item"},
+			{"race", "string", "Race which can use the item"},
+			{"wearer_size", "string", "small, medium or large, can't be used with race"},
+			{"slot", "string", "Slot the item is worn in i.e. PRIMARY"},
+			{"weapon_skill", "string", "Weapon skill i.e. 1H Slashing"},
+			serverQuery,
+			labelsQuery,
+		}, pageQuery...),
+		response: struct {
+			Page    int    `json:"page"`
+			PerPage int    `json:"perPage"`
+			Items   []Item `json:"items"`
+		}{},
+	},
+	"Batch Get Items": {
+		summary: "Looks up many items at once from the cache and SQL, without scraping",
+		query: []queryDoc{serverQuery, labelsQuery},
+		body: []string{},
+		response: map[string]struct {
+			Item   *Item  `json:"item,omitempty"`
+			Error  string `json:"error,omitempty"`
+			Status int    `json:"status,omitempty"`
+		}{},
+	},
+	"List Stat Labels": {summary: "The long label of every stat code", response: map[string]string{}},
+	"Item Sprite Sheet": {
+		summary: "One image holding the icons of many items, with CSS or JSON giving each icon's offset",
+		query: []queryDoc{
+			{"names", "string", "Comma separated item names"},
+			{"q", "string", "Search query, used when names isn't sent"},
+			{"limit", "integer", "Items to include when searching"},
+		},
+		// The offsets as JSON, sprites.png is the sheet itself and sprites.css the offsets as classes
+		response: struct {
+			Image      string       `json:"image"`
+			Width      int          `json:"width"`
+			Height     int          `json:"height"`
+			IconWidth  int          `json:"icon_width"`
+			IconHeight int          `json:"icon_height"`
+			Icons      []SpriteIcon `json:"icons"`
+			Missing    []string     `json:"missing"`
+		}{},
+	},
+	"Item Image": {
+		summary: "An item's icon, downloaded from the wiki once and served from here with an ETag",
+		response: "",
+		contentType: "image/png",
+	},
+	"Show Item": {
+		summary: "An item we've already scraped, this never scrapes the wiki",
+		query: []queryDoc{
+			serverQuery,
+			labelsQuery,
+			{"source", "string", "wikitext responds with the wikitext the item was parsed from instead"},
+			{"as_of", "string", "Date (2006-01-02) or RFC 3339 time to see the item's stats as they were then"},
+			{"version", "integer", "Which version of the item to show when its page has several, 1 (the default) is the first"},
+		},
+		response: Item{},
+	},
+	"Create Item": {summary: "Returns the item, scraping it from the wiki if we haven't already. Nicknames are served as the item they stand for, a 404 lists the closest names. Wiki redirects are followed and a 300 lists the items a disambiguation page names", response: Item{}},
+	"Item Prices": {
+		summary: "Price estimate from auctions along with any vendor prices",
+		query: []queryDoc{{"min_confidence", "number", "Ignore prices parsed with less confidence than this, between 0 and 1"}},
+		response: PriceSummary{},
+	},
+	"Item Plaintext": {
+		summary: "The item's stats as lines that can be pasted into EQ chat",
+		query: []queryDoc{{"max_length", "integer", "Maximum length of each line"}},
+		response: "",
+		contentType: "text/plain",
+	},
+	"Item Sources": {summary: "NPCs which drop the item and their zones", response: []ItemSource{}},
+	"Item Quests": {summary: "Quests which need or reward the item", response: []QuestItem{}},
+	"Item Recipes": {
+		summary: "Recipes which use or make the item",
+		response: struct {
+			UsedIn     []RecipeComponent `json:"used_in"`
+			ProducedBy []RecipeComponent `json:"produced_by"`
+		}{},
+	},
+	"Correct Item": {
+		summary: "Corrects the item's stats, price or icon by hand, corrected items are no longer re-scraped. imageSrc must be a path on the wiki. A price corrected for a server other than the default is only given in that server's price summary. Needs the admin API key",
+		query: []queryDoc{tenantQuery},
+		body: struct {
+			Statistics []StatisticCorrection `json:"statistics,omitempty"`
+			Price      *float64              `json:"price,omitempty"`
+			ImageSrc   *string               `json:"imageSrc,omitempty"`
+		}{},
+		response: Item{},
+	},
+	"Delete Item": {summary: "Removes the item along with its stats, effects, auctions and everything else stored against it. Needs the admin API key", status: 204},
+	"Preview Item": {
+		summary: "Fetches and parses the item's wiki page without saving anything, along with what the parser logged",
+		response: ItemPreview{},
+	},
+	"Item History": {
+		summary: "The item's revisions, newest first, each with the stats and effects which changed since the one before",
+		query: []queryDoc{{"limit", "integer", "Revisions to return"}},
+		response: []ItemRevision{},
+	},
+	"Item Price Trend": {
+		summary: "Hourly or daily open, high, low and close prices",
+		query: []queryDoc{{"period", "string", "hour or day (the default)"}, {"limit", "integer", "Periods to return, up to 1000"}},
+		response: []PriceRollup{},
+	},
+	"Show Spell Line": {summary: "Every rank of the spell's line in order", response: SpellLine{}},
+	"Create Spell": {summary: "Returns the spell, scraping it from the wiki if we haven't already", response: Spell{}},
+	"Show Spell Pets": {summary: "The pets a spell summons at each level", response: []Pet{}},
+	"Show NPC": {summary: "An NPC and its drops, scraping it if we haven't already", response: Npc{}},
+	"Create NPC": {summary: "Scrapes the NPC from the wiki again", response: Npc{}},
+	"Show NPC Spawns": {summary: "Where the NPC spawns", response: []NpcSpawn{}},
+	"Store Merchant Inventory": {summary: "Scrapes what a merchant sells and for how much", response: []MerchantItem{}},
+	"Show Zone": {summary: "A zone, its connections and NPCs, scraping it if we haven't already", response: Zone{}},
+	"Zone Items": {summary: "Items dropped by the zone's NPCs", response: []ZoneItem{}},
+	"Show Quest": {summary: "A quest, scraping it if we haven't already", response: Quest{}},
+	"Create Quest": {summary: "Scrapes the quest from the wiki again", response: Quest{}},
+	"Show Recipe": {summary: "A recipe, scraping it if we haven't already", response: Recipe{}},
+	"Create Recipe": {summary: "Scrapes the recipe from the wiki again", response: Recipe{}},
+	"Show Faction": {summary: "A faction's NPCs, merchants, quests and the items which need standing with it", response: Faction{}},
+	"Show Seller": {
+		summary: "A seller's auctions, newest first",
+		query: pageQuery,
+		response: struct {
+			Seller   string    `json:"seller"`
+			Page     int       `json:"page"`
+			PerPage  int       `json:"perPage"`
+			Total    int64     `json:"total"`
+			Auctions []Auction `json:"auctions"`
+		}{},
+	},
+	"Class Equipment Planner": {
+		summary: "The best items for each slot a class can wear, ranked by a stat",
+		query: []queryDoc{
+			{"stat", "string", "Stat code to rank by, defaults to AC"},
+			{"per_slot", "integer", "Items to return for each slot, up to 25"},
+			itemFilterQuery[len(itemFilterQuery)-1],
+		},
+		response: EquipmentPlan{},
+	},
+	"Market Index": {
+		summary: "Daily auction volume and median prices by item category",
+		query: []queryDoc{serverQuery, {"days", "integer", "Days to return, up to 365"}},
+		response: []MarketIndex{},
+	},
+	"List Server Item Rules": {summary: "Items a server has banned, duped or legacy rules for", response: []ItemRule{}},
+	"Store Server Item Rule": {
+		summary: "Adds a rule for an item on the server",
+		body: struct {
+			Item              string `json:"item"`
+			Rule              string `json:"rule"`
+			Reason            string `json:"reason"`
+			ExcludeFromMarket bool   `json:"excludeFromMarket"`
+		}{},
+		response: ItemRule{},
+		status: 201,
+	},
+	"Delete Server Item Rule": {summary: "Removes a rule", status: 204},
+	"Item Cache Stats": {summary: "Hit and miss counts for each layer of the item cache", response: map[string]interface{}{}},
+	"List Quarantined Lines": {summary: "Auction lines which couldn't be parsed", response: []QuarantinedLine{}},
+	"Replay Quarantined Line": {
+		summary: "Parses a quarantined line again, it's removed if it now resolves",
+		response: struct {
+			Id       int64  `json:"id"`
+			Line     string `json:"line"`
+			Resolved bool   `json:"resolved"`
+		}{},
+	},
+	"List Retry Lines": {summary: "Auction lines waiting to be retried after a transient failure", response: []RetryLine{}},
+	"Scrape Outcomes": {summary: "Counts of scrapes by outcome since boot", response: map[string]int64{}},
+	"List Parse Failures": {
+		summary: "Wiki pages we fetched but couldn't parse, most recent first",
+		query: []queryDoc{{"limit", "integer", "Failures to return"}},
+		response: []ParseFailure{},
+	},
+	"Retry Parse Failure": {
+		summary: "Fetches and parses the page again, the failure is removed if it now parses",
+		response: struct {
+			Id       int64  `json:"id"`
+			Name     string `json:"name"`
+			Resolved bool   `json:"resolved"`
+			Reason   string `json:"reason"`
+		}{},
+	},
+	"Refresh Item": {
+		summary: "Scrapes the item from the wiki again, skipping the cache, and replaces the stats and effects we had. Needs the admin API key",
+		response: Item{},
+	},
+	"List API Keys": {summary: "A tenant's API keys", response: []ApiKey{}},
+	"Store API Key": {
+		summary: "Creates an API key for the tenant, the key is only ever returned here",
+		body: struct {
+			Name string `json:"name"`
+		}{},
+		response: struct {
+			Key    string `json:"key"`
+			ApiKey ApiKey `json:"apiKey"`
+		}{},
+		status: 201,
+	},
+	"Revoke API Key": {summary: "Revokes an API key", status: 204},
+	"List Webhooks": {summary: "A tenant's webhooks", response: []Webhook{}},
+	"Store Webhook": {
+		summary: "Registers a webhook for the tenant, the secret payloads are signed with is only ever returned here",
+		body: struct {
+			Url      string   `json:"url"`
+			Event    string   `json:"event"`
+			Item     string   `json:"item,omitempty"`
+			MaxPrice *float64 `json:"max_price,omitempty"`
+			Format   string   `json:"format,omitempty"`
+		}{},
+		response: struct {
+			Secret  string  `json:"secret"`
+			Webhook Webhook `json:"webhook"`
+		}{},
+		status: 201,
+	},
+	"Delete Webhook": {summary: "Deletes a webhook and its queued deliveries", status: 204},
+	"List Webhook Deliveries": {summary: "A webhook's latest deliveries, newest first", response: []WebhookDelivery{}},
+	"List Item Aliases": {
+		summary: "A server's nicknames added by hand, alphabetically",
+		query: []queryDoc{tenantQuery, {"item", "string", "Only list this item's aliases"}},
+		response: []ItemAlias{},
+	},
+	"Store Item Alias": {
+		summary: "Adds a nickname for an item on a server, or points an existing one at a different item",
+		query: []queryDoc{tenantQuery},
+		body: struct {
+			Alias string `json:"alias"`
+			Item  string `json:"item"`
+		}{},
+		response: ItemAlias{},
+		status: 201,
+	},
+	"Delete Item Alias": {summary: "Removes one of a server's nicknames", query: []queryDoc{tenantQuery}, status: 204},
+	"List Alias Candidates": {
+		summary: "Shorthand learnt from a server's auction lines, most seen first",
+		query: []queryDoc{tenantQuery, {"status", "string", "pending (the default), approved or rejected"}, {"limit", "integer", "Candidates to return"}},
+		response: []AliasCandidate{},
+	},
+	"Learn Aliases": {
+		summary: "Learns alias candidates from the latest auction lines now",
+		response: struct {
+			Candidates int `json:"candidates"`
+		}{},
+	},
+	"Review Alias Candidate": {summary: "Approves or rejects one of a server's pending alias candidates", query: []queryDoc{tenantQuery}, status: 204},
+	"Wiki Canary": {summary: "Whether the canary pages still parse as expected", response: map[string]interface{}{}},
+	"Accept Wiki Canary": {
+		summary: "Accepts the current parse of the canary pages as expected",
+		response: map[string][]string{},
+	},
+	"Usage Stats": {summary: "The usage writer's counters", response: map[string]int64{}},
+	"Dedupe Statistics": {
+		summary: "Removes duplicate statistics rows",
+		response: struct {
+			Groups  int64 `json:"groups"`
+			Removed int64 `json:"removed"`
+		}{},
+	},
+	"Discord Item": {summary: "A Discord embed describing the item", response: DiscordEmbed{}},
+	"Discord Interactions": {summary: "Discord's interactions endpoint, requests must be signed by Discord", response: map[string]interface{}{}},
+	"Export Items": {
+		summary: "Streams every item in a format other tools can import",
+		query: []queryDoc{{"format", "string", "lucy (the default)"}},
+		response: "",
+		contentType: "text/plain",
+	},
+	"Export Items JSON": {
+		summary: "Streams every item, with its statistics and effects, as gzipped newline delimited JSON",
+		query: []queryDoc{{"since", "string", "Only items scraped since this date (2006-01-02) or RFC 3339 time"}},
+		response: "",
+		contentType: "application/x-ndjson",
+	},
+	"Export Items CSV": {
+		summary: "Streams every item as a CSV with a column per stat",
+		query: []queryDoc{reportStatsQuery},
+		response: "",
+		contentType: "text/csv",
+	},
+	"Import Items": {
+		summary: "Upserts every item in a dump made by /export/items.json, sent as newline delimited JSON and optionally gzipped. Lines which aren't valid items are skipped and reported, items corrected by hand are left alone. Needs the admin API key",
+		response: struct {
+			Imported  int `json:"imported"`
+			Corrected int `json:"corrected"`
+			Failed    int `json:"failed"`
+			Errors   []struct {
+				Line   int    `json:"line"`
+				Reason string `json:"reason"`
+			} `json:"errors"`
+		}{},
+	},
+	"Items Report": {
+		summary: "A spreadsheet of the items matching the same filters as /items/query",
+		query: append([]queryDoc{reportStatsQuery}, itemFilterQuery...),
+		response: "",
+		contentType: "application/octet-stream",
+	},
+	"List Snapshots": {summary: "Nightly snapshots of the catalog, newest first", response: []Snapshot{}},
+	"OpenAPI Document": {summary: "This document", response: map[string]interface{}{}},
+	"API Docs": {summary: "Swagger UI for this document", response: "", contentType: "text/html"},
+	"Live Feed": {
+		summary: "Upgrades to a WebSocket streaming an event whenever an item is saved or an auction line is ingested",
+		query: []queryDoc{{"types", "string", "Comma separated event types to stream, item and auction, defaults to both"}},
+		response: struct {
+			Type string                 `json:"type"`
+			Data map[string]interface{} `json:"data"`
+			At   time.Time              `json:"at"`
+		}{},
+		status: 101,
+	},
+	"GraphQL Query": {
+		summary: "Runs a GraphQL query over items, spells and auctions, see graph/schema.graphqls. 501 unless built with -tags graphql",
+		query: []queryDoc{
+			{"query", "string", "The GraphQL query"},
+			{"variables", "string", "JSON object of the query's variables"},
+			{"operationName", "string", "The operation to run if the query has more than one"},
+		},
+		response: map[string]interface{}{},
+	},
+	"GraphQL": {
+		summary: "Runs a GraphQL query over items, spells and auctions, see graph/schema.graphqls. 501 unless built with -tags graphql",
+		body: struct {
+			Query         string                 `json:"query"`
+			Variables     map[string]interface{} `json:"variables,omitempty"`
+			OperationName string                 `json:"operationName,omitempty"`
+		}{},
+		response: map[string]interface{}{},
+	},
+}
+
+// Schemas referenced by name from the others, each mirrors the type's MarshalJSON
+var openApiSchemas = map[string]interface{}{
+	"Item": struct {
+		Id                 int64          `json:"id"`
+		Name               string         `json:"name"`
+		DisplayName        string         `json:"displayName"`
+		ImageSrc           string         `json:"imageSrc"`
+		Price              float32        `json:"price"`
+		VendorSellPrice    *float64       `json:"vendorSellPrice"`
+		VendorBuyPrice     *float64       `json:"vendorBuyPrice"`
+		Statistics         []Statistic    `json:"statistics"`
+		Effects            []Effect       `json:"effects"`
+		WearerSizes        []string       `json:"wearerSizes,omitempty"`
+		Rules              []ItemRule     `json:"rules,omitempty"`
+		Version            int            `json:"version"`
+		Description        string         `json:"description"`
+		Container          *ItemContainer `json:"container,omitempty"`
+		DamageDelayRatio   *float64       `json:"damageDelayRatio,omitempty"`
+		HasteAdjustedDelay *float64       `json:"hasteAdjustedDelay,omitempty"`
+	}{},
+	"ItemContainer": struct {
+		Slots           int     `json:"slots"`
+		MaxSize         *string `json:"maxSize"`
+		WeightReduction float64 `json:"weightReduction"`
+	}{},
+	"Statistic": StatisticResponse{},
+	"Effect": EffectResponse{},
+	"SearchResult": struct {
+		Score float64 `json:"score"`
+		Item  Item    `json:"item"`
+	}{},
+	"ItemRule": struct {
+		Id                int64     `json:"id"`
+		Server            string    `json:"server"`
+		ItemId            int64     `json:"itemId"`
+		ItemName          string    `json:"itemName"`
+		Rule              string    `json:"rule"`
+		Reason            string    `json:"reason"`
+		ExcludeFromMarket bool      `json:"excludeFromMarket"`
+		CreatedAt         time.Time `json:"createdAt"`
+	}{},
+	"Job": struct {
+		Id          string       `json:"id"`
+		Status      string       `json:"status"`
+		Total       int          `json:"total"`
+		Processed   int          `json:"processed"`
+		CreatedAt   time.Time    `json:"createdAt"`
+		CompletedAt *time.Time   `json:"completedAt"`
+		Results     []LineResult `json:"results"`
+	}{},
+	"LineResult": struct {
+		Line   string       `json:"line"`
+		Status string       `json:"status"`
+		Reason string       `json:"reason,omitempty"`
+		Items  []ItemResult `json:"items"`
+	}{},
+	"ItemResult": struct {
+		Name       string  `json:"name"`
+		Confidence float64 `json:"confidence"`
+		Resolved   bool    `json:"resolved"`
+		Error      string  `json:"error,omitempty"`
+	}{},
+	"PriceSummary": struct {
+		Corrected     *float64       `json:"corrected"`
+		Count         int64          `json:"count"`
+		Average       *float64       `json:"average"`
+		Min           *float64       `json:"min"`
+		Max           *float64       `json:"max"`
+		MinConfidence float64        `json:"minConfidence"`
+		Vendors       []MerchantItem `json:"vendors"`
+	}{},
+	"PriceRollup": struct {
+		Period string    `json:"period"`
+		Bucket time.Time `json:"bucket"`
+		Open   float64   `json:"open"`
+		High   float64   `json:"high"`
+		Low    float64   `json:"low"`
+		Close  float64   `json:"close"`
+		Volume int64     `json:"volume"`
+	}{},
+	"MerchantItem": struct {
+		Merchant string  `json:"merchant"`
+		Item     string  `json:"item"`
+		Price    float64 `json:"price"`
+	}{},
+	"ItemSource": struct {
+		Npc  string `json:"npc"`
+		Zone string `json:"zone"`
+	}{},
+	"SpriteIcon": struct {
+		Item  string `json:"item"`
+		Class string `json:"class"`
+		X     int    `json:"x"`
+		Y     int    `json:"y"`
+	}{},
+	"Spell": struct {
+		Id          int64        `json:"id"`
+		Name        string       `json:"name"`
+		ImageSrc    string       `json:"imageSrc"`
+		Mana        *int64       `json:"mana"`
+		CastTime    *float64     `json:"castTime"`
+		RecastTime  *float64     `json:"recastTime"`
+		Duration    string       `json:"duration"`
+		Skill       string       `json:"skill"`
+		Target      string       `json:"target"`
+		Description string       `json:"description"`
+		Classes     []SpellClass `json:"classes"`
+	}{},
+	"SpellClass": struct {
+		Class string `json:"class"`
+		Level int64  `json:"level"`
+	}{},
+	"SpellLine": struct {
+		Name   string   `json:"name"`
+		Spells []string `json:"spells"`
+	}{},
+	"Pet": struct {
+		Level       int64  `json:"level"`
+		Hp          *int64 `json:"hp"`
+		Ac          *int64 `json:"ac"`
+		MinDamage   *int64 `json:"minDamage"`
+		MaxDamage   *int64 `json:"maxDamage"`
+		AttackDelay *int64 `json:"attackDelay"`
+	}{},
+	"Npc": struct {
+		Name      string    `json:"name"`
+		Level     string    `json:"level"`
+		Zone      string    `json:"zone"`
+		Hp        *int64    `json:"hp"`
+		Drops     []NpcDrop `json:"drops"`
+		ScrapedAt time.Time `json:"scraped_at"`
+	}{},
+	"NpcDrop": struct {
+		Item   string   `json:"item"`
+		ItemId *int64   `json:"item_id"`
+		Chance *float64 `json:"chance"`
+	}{},
+	"NpcSpawn": struct {
+		Zone string   `json:"zone"`
+		Y    float64  `json:"y"`
+		X    float64  `json:"x"`
+		Z    *float64 `json:"z"`
+		Text string   `json:"text"`
+	}{},
+	"Zone": struct {
+		Name        string    `json:"name"`
+		LevelRange  string    `json:"level_range"`
+		Connections []string  `json:"connections"`
+		Npcs        []string  `json:"npcs"`
+		ScrapedAt   time.Time `json:"scraped_at"`
+	}{},
+	"ZoneItem": struct {
+		Item string `json:"item"`
+		Npc  string `json:"npc"`
+	}{},
+	"Quest": struct {
+		Name      string      `json:"name"`
+		Items     []QuestItem `json:"items"`
+		Steps     []QuestStep `json:"steps"`
+		ScrapedAt time.Time   `json:"scraped_at"`
+	}{},
+	"QuestItem": struct {
+		Quest  string `json:"quest"`
+		Item   string `json:"item"`
+		ItemId *int64 `json:"item_id"`
+		Role   string `json:"role"`
+	}{},
+	"QuestStep": struct {
+		Npc  string `json:"npc"`
+		Text string `json:"text"`
+	}{},
+	"Recipe": struct {
+		Name       string            `json:"name"`
+		Tradeskill string            `json:"tradeskill"`
+		Trivial    *int64            `json:"trivial"`
+		Container  string            `json:"container"`
+		Components []RecipeComponent `json:"components"`
+		ScrapedAt  time.Time         `json:"scraped_at"`
+	}{},
+	"RecipeComponent": struct {
+		Recipe string `json:"recipe"`
+		Item   string `json:"item"`
+		ItemId *int64 `json:"item_id"`
+		Count  int    `json:"count"`
+		Role   string `json:"role"`
+	}{},
+	"Faction": struct {
+		Name      string        `json:"name"`
+		Npcs      []string      `json:"npcs"`
+		Merchants []string      `json:"merchants"`
+		Quests    []string      `json:"quests"`
+		Items     []ItemFaction `json:"items"`
+		ScrapedAt time.Time     `json:"scraped_at"`
+	}{},
+	"ItemFaction": struct {
+		Item     string `json:"item"`
+		Faction  string `json:"faction"`
+		Standing string `json:"standing"`
+	}{},
+	"Auction": struct {
+		Id          int64     `json:"id"`
+		Seller      string    `json:"seller"`
+		ItemId      *int64    `json:"itemId"`
+		ItemName    string    `json:"itemName"`
+		Price       *float64  `json:"price"`
+		Line        string    `json:"line"`
+		AuctionedAt time.Time `json:"auctionedAt"`
+	}{},
+	"EquipmentPlan": struct {
+		Class        string                   `json:"class"`
+		Stat         string                   `json:"stat"`
+		ClickyWeight int                      `json:"clickyWeight"`
+		Slots        map[string][]PlannedItem `json:"slots"`
+	}{},
+	"PlannedItem": struct {
+		Value       float64 `json:"value"`
+		ClickyScore int     `json:"clickyScore"`
+		Item        Item    `json:"item"`
+	}{},
+	"MarketIndex": struct {
+		Day        string                    `json:"day"`
+		Volume     int64                     `json:"volume"`
+		Categories map[string]*CategoryIndex `json:"categories"`
+	}{},
+	"CategoryIndex": struct {
+		Items  int     `json:"items"`
+		Volume int64   `json:"volume"`
+		Median float64 `json:"median"`
+	}{},
+	"QuarantinedLine": struct {
+		Id        int64     `json:"id"`
+		Line      string    `json:"line"`
+		Reason    string    `json:"reason"`
+		CreatedAt time.Time `json:"createdAt"`
+	}{},
+	"RetryLine": struct {
+		Id            int64     `json:"id"`
+		Server        string    `json:"server"`
+		Line          string    `json:"line"`
+		Reason        string    `json:"reason"`
+		Attempts      int       `json:"attempts"`
+		NextAttemptAt time.Time `json:"nextAttemptAt"`
+		CreatedAt     time.Time `json:"createdAt"`
+	}{},
+	"ApiKey": struct {
+		Id        int64      `json:"id"`
+		Tenant    string     `json:"tenant"`
+		Name      string     `json:"name"`
+		Prefix    string     `json:"prefix"`
+		CreatedAt time.Time  `json:"createdAt"`
+		RevokedAt *time.Time `json:"revokedAt"`
+	}{},
+	"ItemAlias": struct {
+		Id        int64     `json:"id"`
+		Server    string    `json:"server"`
+		Alias     string    `json:"alias"`
+		Item      string    `json:"item"`
+		CreatedAt time.Time `json:"createdAt"`
+	}{},
+	"AliasCandidate": struct {
+		Id          int64     `json:"id"`
+		Server      string    `json:"server"`
+		Alias       string    `json:"alias"`
+		Item        string    `json:"item"`
+		Occurrences int64     `json:"occurrences"`
+		Share       float64   `json:"share"`
+		Status      string    `json:"status"`
+		UpdatedAt   time.Time `json:"updated_at"`
+	}{},
+	"DiscordEmbed": struct {
+		Title       string              `json:"title"`
+		Url         string              `json:"url"`
+		Description string              `json:"description"`
+		Color       int                 `json:"color"`
+		Thumbnail   *discordEmbedImage  `json:"thumbnail,omitempty"`
+		Fields      []discordEmbedField `json:"fields"`
+	}{},
+	"Snapshot": struct {
+		Key       string    `json:"key"`
+		Url       *string   `json:"url"`
+		ItemCount int       `json:"itemCount"`
+		Size      int64     `json:"size"`
+		CreatedAt time.Time `json:"createdAt"`
+	}{},
+	"ItemPreview": struct {
+		Item        *Item             `json:"item"`
+		Spell       *Spell            `json:"spell,omitempty"`
+		Uri         string            `json:"uri"`
+		Parser      string            `json:"parser"`
+		BodySize    int               `json:"bodySize"`
+		DurationMs  int64             `json:"durationMs"`
+		Error       *string           `json:"error"`
+		Diagnostics []ParseDiagnostic `json:"diagnostics"`
+	}{},
+	"ParseFailure": struct {
+		Id          int64     `json:"id"`
+		Name        string    `json:"name"`
+		Url         string    `json:"url"`
+		Body        string    `json:"body"`
+		Reason      string    `json:"reason"`
+		Occurrences int64     `json:"occurrences"`
+		CreatedAt   time.Time `json:"createdAt"`
+		UpdatedAt   time.Time `json:"updatedAt"`
+	}{},
+	"Webhook": struct {
+		Id        int64     `json:"id"`
+		Server    string    `json:"server"`
+		Url       string    `json:"url"`
+		Event     string    `json:"event"`
+		Item      *string   `json:"item"`
+		MaxPrice  *float64  `json:"maxPrice"`
+		Format    string    `json:"format"`
+		CreatedAt time.Time `json:"createdAt"`
+	}{},
+	"WebhookDelivery": struct {
+		Id            int64                  `json:"id"`
+		Event         string                 `json:"event"`
+		Payload       map[string]interface{} `json:"payload"`
+		Attempts      int                    `json:"attempts"`
+		LastError     *string                `json:"lastError"`
+		NextAttemptAt time.Time              `json:"nextAttemptAt"`
+		DeliveredAt   *time.Time             `json:"deliveredAt"`
+		CreatedAt     time.Time              `json:"createdAt"`
+	}{},
+	"ItemRevision": struct {
+		Id         int64             `json:"id"`
+		Statistics []Statistic       `json:"statistics"`
+		Effects    []Effect          `json:"effects"`
+		Changes    *ItemRevisionDiff `json:"changes"`
+		CreatedAt  time.Time         `json:"createdAt"`
+	}{},
+	"ItemRevisionDiff": struct {
+		Statistics     []StatisticChange `json:"statistics"`
+		EffectsAdded   []Effect          `json:"effectsAdded"`
+		EffectsRemoved []Effect          `json:"effectsRemoved"`
+	}{},
+	"StatisticChange": struct {
+		Code string      `json:"code"`
+		From []Statistic `json:"from"`
+		To   []Statistic `json:"to"`
+	}{},
+}
+
+// Matches the path variables in a route pattern, with an optional regex
+var pathVariableRegex = regexp.MustCompile(`\{([a-z_]+)(?::([^}]+))?\}`)
+
+// Builds the OpenAPI 3 document for the routes
+func NewOpenApiDocument(routes Routes) ([]byte, error) {
+	paths := make(map[string]map[string]interface{})
+	for _, route := range routes {
+		if !RouteAllowed(route) {
+			continue
+		}
+		path := pathVariableRegex.ReplaceAllString(route.pattern, "{$1}")
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		paths[path][strings.ToLower(route.method)] = openApiOperation(route)
+	}
+
+	schemas := make(map[string]interface{})
+	for name, value := range openApiSchemas {
+		schemas[name] = jsonSchema(reflect.TypeOf(value), false)
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title": "service-wiki",
+			"description": "Item, spell, NPC and price data scraped from the Project 1999 wiki and auction logs",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-Api-Key"},
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"adminKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-Api-Key", "description": "admin_api_key, which can also be sent as a bearer token"},
+			},
+		},
+	}, "", "  ")
+}
+
+func openApiOperation(route Route) map[string]interface{} {
+	doc := routeDocs[route.name]
+
+	var parameters []interface{}
+	for _, match := range pathVariableRegex.FindAllStringSubmatch(route.pattern, -1) {
+		schema := map[string]interface{}{"type": "string"}
+		if match[2] != "" {
+			schema["enum"] = strings.Split(match[2], "|")
+		}
+		parameters = append(parameters, map[string]interface{}{
+			"name": match[1],
+			"in": "path",
+			"required": true,
+			"schema": schema,
+		})
+	}
+	for _, query := range doc.query {
+		parameters = append(parameters, map[string]interface{}{
+			"name": query.name,
+			"in": "query",
+			"description": query.description,
+			"schema": map[string]interface{}{"type": query.kind},
+		})
+	}
+
+	status := doc.status
+	if status == 0 {
+		status = 200
+	}
+	success := map[string]interface{}{"description": "OK"}
+	if doc.response != nil {
+		contentType := doc.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		success["content"] = map[string]interface{}{
+			contentType: map[string]interface{}{"schema": jsonSchema(reflect.TypeOf(doc.response), true)},
+		}
+	}
+
+	operation := map[string]interface{}{
+		"operationId": strings.Replace(route.name, " ", "", -1),
+		"summary": doc.summary,
+		"tags": []string{openApiTag(route.pattern)},
+		"responses": map[string]interface{}{
+			strconv.Itoa(status): success,
+			"default": map[string]interface{}{
+				"description": "The error, in plain text",
+				"content": map[string]interface{}{
+					"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+				},
+			},
+		},
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	if doc.body != nil {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": jsonSchema(reflect.TypeOf(doc.body), true)},
+			},
+		}
+	}
+
+	// Admin routes need the admin key and keyless routes don't need one,
+	// everything else takes a key which is only required if require_api_key is set
+	if IsAdminRoute(route) {
+		operation["security"] = []interface{}{
+			map[string][]string{"adminKey": {}},
+		}
+	} else if keylessRoutes[route.name] {
+		operation["security"] = []interface{}{}
+	} else {
+		operation["security"] = []interface{}{
+			map[string][]string{},
+			map[string][]string{"apiKey": {}},
+			map[string][]string{"bearer": {}},
+		}
+	}
+	return operation
+}
+
+// Groups operations by the first segment of their path, admin routes by the second
+func openApiTag(pattern string) string {
+	segments := strings.Split(strings.Trim(pattern, "/"), "/")
+	if segments[0] == "admin" && len(segments) > 1 {
+		return "admin"
+	}
+	return strings.SplitN(segments[0], ".", 2)[0]
+}
+
+// Returns the JSON schema for values of the type, named types with a schema in
+// openApiSchemas are referenced rather than inlined once ref is true
+func jsonSchema(t reflect.Type, ref bool) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct && t.Elem() != reflect.TypeOf(time.Time{}) {
+		if _, exists := openApiSchemas[t.Elem().Name()]; exists {
+			// Pointers to named types are only used so they marshal with their pointer receiver
+			return jsonSchema(t.Elem(), true)
+		}
+	}
+	if _, exists := openApiSchemas[t.Name()]; exists && ref && t.Name() != "" {
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := jsonSchema(t.Elem(), true)
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem(), true)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem(), true)}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for idx := 0; idx < t.NumField(); idx++ {
+			field := t.Field(idx)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" || !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = jsonSchema(field.Type, true)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+	return map[string]interface{}{}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Redis item cache
+ |--------------------------------------------------------------------------
+ |
+ | Auction parsers ask for the same popular items thousands of times a day,
+ | so fully hydrated items are cached in Redis keyed by their normalised
+ | name. The cache is optional, leaving redis_host empty disables it and
+ | any Redis failure is treated as a miss so we fall back to SQL
+ |
+ */
+
+var redisPool *redis.Pool
+
+// Reported by the cache stats endpoint, only updated when Redis is enabled
+var redisHits, redisMisses int64
+
+// Creates the connection pool, connections are only dialled when first used
+func OpenRedis() {
+	if Settings.RedisHost == "" {
+		Log.Info("Redis isn't configured, item cache is disabled")
+		return
+	}
+
+	redisPool = &redis.Pool{
+		MaxIdle: Settings.MaxConnections,
+		IdleTimeout: 5 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", Settings.RedisHost + ":" + Settings.RedisPort,
+				redis.DialConnectTimeout(time.Second),
+				redis.DialReadTimeout(time.Second),
+				redis.DialWriteTimeout(time.Second))
+		},
+	}
+}
+
+func CloseRedis() {
+	if redisPool != nil {
+		if err := redisPool.Close(); err != nil {
+			Log.Error("Failed to close Redis pool", "err", err)
+		}
+	}
+}
+
+// Names arrive with underscores from urls and in any case from auction lines
+func itemCacheKey(name string) string {
+	name = strings.ToLower(strings.Replace(name, "_", " ", -1))
+	return "item:" + strings.Join(strings.Fields(name), " ")
+}
+
+// Items are cached in their own format rather than the API format so the
+// API can change without invalidating everything we have cached
+type cachedItem struct {
+	Id              int64             `json:"id"`
+	Name            string            `json:"name"`
+	DisplayName     string            `json:"displayName"`
+	ImageSrc        string            `json:"imageSrc"`
+	Price           float32           `json:"price"`
+	VendorSellPrice sql.NullFloat64   `json:"vendorSellPrice"`
+	VendorBuyPrice  sql.NullFloat64   `json:"vendorBuyPrice"`
+	Description     string            `json:"description"`
+	Container       *cachedContainer  `json:"container,omitempty"`
+	Ratio           sql.NullFloat64   `json:"ratio"`
+	AdjustedDelay   sql.NullFloat64   `json:"adjustedDelay"`
+	Statistics      []cachedStatistic `json:"statistics"`
+	Effects         []cachedEffect    `json:"effects"`
+}
+
+// Also the shape of the documents in the item document store, so it is
+// written with the item's stats and effects embedded
+func newCachedItem(item Item) cachedItem {
+	cached := cachedItem{
+		Id: item.id,
+		Name: item.name,
+		DisplayName: item.displayName,
+		ImageSrc: item.imageSrc,
+		Price: item.price,
+		VendorSellPrice: item.vendorSellPrice,
+		VendorBuyPrice: item.vendorBuyPrice,
+		Description: item.description,
+		Ratio: item.ratio,
+		AdjustedDelay: item.hasteAdjustedDelay,
+	}
+	if item.container != nil {
+		cached.Container = &cachedContainer{ Slots: item.container.slots, MaxSize: item.container.maxSize, WeightReduction: item.container.weightReduction }
+	}
+	for _, stat := range item.statistics {
+		cached.Statistics = append(cached.Statistics, cachedStatistic{ Code: stat.code, Value: stat.value, Effect: stat.effect })
+	}
+	for _, effect := range item.effects {
+		cached.Effects = append(cached.Effects, newCachedEffect(effect))
+	}
+	return cached
+}
+
+func (c cachedItem) Item() Item {
+	item := Item{
+		id: c.Id,
+		name: c.Name,
+		displayName: c.DisplayName,
+		imageSrc: c.ImageSrc,
+		price: c.Price,
+		vendorSellPrice: c.VendorSellPrice,
+		vendorBuyPrice: c.VendorBuyPrice,
+		description: c.Description,
+		ratio: c.Ratio,
+		hasteAdjustedDelay: c.AdjustedDelay,
+	}
+	if c.Container != nil {
+		item.container = &ItemContainer{ slots: c.Container.Slots, maxSize: c.Container.MaxSize, weightReduction: c.Container.WeightReduction }
+	}
+	for _, stat := range c.Statistics {
+		item.statistics = append(item.statistics, Statistic{ code: stat.Code, value: stat.Value, effect: stat.Effect })
+	}
+	for _, effect := range c.Effects {
+		item.effects = append(item.effects, effect.Effect())
+	}
+	return item
+}
+
+type cachedContainer struct {
+	Slots           int     `json:"slots"`
+	MaxSize         string  `json:"maxSize"`
+	WeightReduction float64 `json:"weightReduction"`
+}
+
+type cachedStatistic struct {
+	Code   string          `json:"code"`
+	Value  sql.NullFloat64 `json:"value"`
+	Effect string          `json:"effect"`
+}
+
+type cachedEffect struct {
+	Uri         string        `json:"uri"`
+	Name        string        `json:"name"`
+	Restriction string        `json:"restriction"`
+	Charges     sql.NullInt64 `json:"charges"`
+	Description string        `json:"description,omitempty"`
+	Mana        sql.NullInt64 `json:"mana"`
+	Duration    string        `json:"duration,omitempty"`
+}
+
+func newCachedEffect(e Effect) cachedEffect {
+	return cachedEffect{ Uri: e.uri, Name: e.name, Restriction: e.restriction, Charges: e.charges,
+		Description: e.description, Mana: e.mana, Duration: e.duration }
+}
+
+// Everything but the charges and spell page details can be worked out again
+// from the restriction
+func (c cachedEffect) Effect() Effect {
+	e := Effect{ uri: c.Uri, name: c.Name, restriction: c.Restriction, description: c.Description, mana: c.Mana, duration: c.Duration }
+	e.parseRestriction()
+	if c.Charges.Valid {
+		e.charges = c.Charges
+	}
+	return e
+}
+
+// Returns the cached item for the name, returns false on a miss or if Redis
+// is disabled or unreachable
+func FetchRedisItem(name string) (Item, bool) {
+	var item Item
+	if redisPool == nil {
+		return item, false
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", itemCacheKey(name)))
+	if err != nil {
+		if err != redis.ErrNil {
+			Log.Error("Redis error fetching item", "item", name, "err", err)
+		}
+		atomic.AddInt64(&redisMisses, 1)
+		return item, false
+	}
+
+	var cached cachedItem
+	if err := json.Unmarshal(data, &cached); err != nil {
+		Log.Error("Failed to decode cached item", "item", name, "err", err)
+		atomic.AddInt64(&redisMisses, 1)
+		return item, false
+	}
+
+	Log.Debug("Redis hit", "item", name)
+	atomic.AddInt64(&redisHits, 1)
+	return cached.Item(), true
+}
+
+// Writes the item to Redis under the name for redis_ttl_in_secs
+func CacheRedisItem(name string, item Item) {
+	if redisPool == nil {
+		return
+	}
+
+	data, err := json.Marshal(newCachedItem(item))
+	if err != nil {
+		Log.Error("Failed to encode item for Redis", "item", name, "err", err)
+		return
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("SET", itemCacheKey(name), data, "EX", Settings.RedisTtlInSecs); err != nil {
+		Log.Error("Redis error caching item", "item", name, "err", err)
+	}
+}
+
+// Removes the item cached under the name from Redis
+func DeleteRedisItem(name string) {
+	if redisPool == nil {
+		return
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", itemCacheKey(name)); err != nil {
+		Log.Error("Redis error deleting item", "item", name, "err", err)
+	}
+}
+
+/*
+ |-------------------------------------------------------------------------
+ | Cache invalidation
+ |--------------------------------------------------------------------------
+ |
+ | Every replica keeps its own ItemLRU, so when an item is saved the other
+ | replicas would keep serving their copy for up to cache_time_in_secs. Save
+ | publishes the item on ITEM_INVALIDATION_CHANNEL and every replica drops
+ | it from its local cache as soon as the message arrives. Redis itself is
+ | shared so the copy there was already overwritten by the save
+ |
+ */
+
+const ITEM_INVALIDATION_CHANNEL = "items:invalidate"
+
+// Identifies this process so it can ignore its own messages, it already has
+// the fresh copy
+var instanceId = newRequestId()
+
+var invalidationsPublished, invalidationsReceived int64
+
+type itemInvalidation struct {
+	Instance string `json:"instance"`
+	Id       int64  `json:"id"`
+	Name     string `json:"name"`
+}
+
+func PublishItemInvalidation(id int64, name string) {
+	if redisPool == nil {
+		return
+	}
+
+	data, err := json.Marshal(itemInvalidation{ instanceId, id, name })
+	if err != nil {
+		Log.Error("Failed to encode item invalidation", "item", name, "err", err)
+		return
+	}
+
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("PUBLISH", ITEM_INVALIDATION_CHANNEL, data); err != nil {
+		Log.Error("Redis error publishing item invalidation", "item", name, "err", err)
+		return
+	}
+	atomic.AddInt64(&invalidationsPublished, 1)
+}
+
+// Drops items other replicas have saved from the local cache until we shut
+// down, reconnecting whenever the subscription is lost. Messages sent while
+// we're disconnected are missed so the whole local cache is cleared on
+// reconnect rather than risk serving a stale item
+func SubscribeItemInvalidations() {
+	if redisPool == nil {
+		return
+	}
+
+	for reconnect := false; ; reconnect = true {
+		if err := subscribeItemInvalidations(reconnect); err != nil {
+			Log.Error("Lost item invalidation subscription", "err", err)
+		}
+
+		select {
+		case <-time.After(seconds(Settings.RetryAfterInSecs)):
+		case <-AppContext.Done():
+			return
+		}
+	}
+}
+
+func subscribeItemInvalidations(reconnect bool) error {
+	// Pooled connections time out reads after a second which would drop an
+	// idle subscription, so this gets a connection of its own
+	conn, err := redis.Dial("tcp", Settings.RedisHost + ":" + Settings.RedisPort,
+		redis.DialConnectTimeout(time.Second))
+	if err != nil {
+		return err
+	}
+	pubsub := redis.PubSubConn{ Conn: conn }
+	defer pubsub.Close()
+
+	// Feed events from other replicas arrive on the same connection
+	if err := pubsub.Subscribe(ITEM_INVALIDATION_CHANNEL, FEED_CHANNEL); err != nil {
+		return err
+	}
+
+	// Receive blocks until a message arrives, closing the connection is the
+	// only way to interrupt it when we shut down
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-AppContext.Done():
+			pubsub.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		switch message := pubsub.Receive().(type) {
+		case redis.Message:
+			if message.Channel == FEED_CHANNEL {
+				receiveFeedMessage(message)
+				continue
+			}
+			var invalidation itemInvalidation
+			if err := json.Unmarshal(message.Data, &invalidation); err != nil {
+				Log.Error("Failed to decode item invalidation", "err", err)
+				continue
+			}
+			if invalidation.Instance == instanceId {
+				continue
+			}
+			dropped := LocalItems.Invalidate(invalidation.Id, invalidation.Name)
+			atomic.AddInt64(&invalidationsReceived, 1)
+			Log.Debug("Item invalidated by another replica", "item", invalidation.Name, "dropped", dropped)
+		case redis.Subscription:
+			Log.Info("Subscribed to channel", "channel", message.Channel)
+			if reconnect && message.Channel == ITEM_INVALIDATION_CHANNEL {
+				LocalItems.Clear()
+			}
+		case error:
+			if AppContext.Err() != nil {
+				return nil
+			}
+			return message
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Re-scraping
+ |--------------------------------------------------------------------------
+ |
+ | Items are otherwise only scraped the first time they're seen, so fixes
+ | made to the wiki never reach us. Every rescrape_interval_in_secs the
+ | rescrape_batch_size items scraped longest ago are fetched again if that
+ | was more than rescrape_age_in_days ago, which keeps the load on the
+ | wiki low and steady however many items are stale. Items are saved as a
+ | first scrape would save them, stats the page no longer has are removed
+ | and the item's effects are replaced rather than added to. Nothing is
+ | re-scraped while the wiki canary is failing as the saves would be
+ | refused anyway, and items corrected by hand are never re-scraped
+ |
+ */
+
+type staleItem struct {
+	id int64
+	name string
+	displayName string
+}
+
+// Re-scrapes stale items until we shut down, does nothing if
+// rescrape_age_in_days is 0
+func RescrapeStaleItems() {
+	if Settings.RescrapeAgeInDays == 0 {
+		Log.Info("Re-scraping is disabled as rescrape_age_in_days is 0")
+		return
+	}
+
+	for {
+		select {
+		case <-time.After(seconds(Settings.RescrapeIntervalInSecs)):
+		case <-AppContext.Done():
+			return
+		}
+
+		if CanaryPaused() {
+			continue
+		}
+		for _, stale := range fetchStaleItems(Settings.RescrapeBatchSize) {
+			if AppContext.Err() != nil {
+				return
+			}
+			if !rescrapeItem(AppContext, stale) {
+				// The wiki is struggling, so leave the rest of the batch for later
+				break
+			}
+		}
+	}
+}
+
+// Fetches the item from the wiki again and saves it, returns false if the
+// wiki couldn't be reached
+func rescrapeItem(ctx context.Context, stale staleItem) bool {
+	logger := LoggerFrom(ctx).With("item", stale.name)
+	ctx = WithLogger(ctx, logger)
+
+	item := Item{ id: stale.id, name: stale.name, displayName: stale.displayName }
+	err := item.fetchDataFromWiki(ctx)
+	if err == nil {
+		logger.Info("Re-scraped item", "id", stale.id)
+		return true
+	}
+	if IsTransientError(err) || err == ErrWritesPaused {
+		logger.Warn("Couldn't re-scrape item", "id", stale.id, "err", err)
+		return false
+	}
+
+	// The page has gone or no longer parses, so keep what we have and don't
+	// try again until the item is next due
+	logger.Warn("Re-scraped page had no usable item", "id", stale.id, "err", err)
+	rows, err := DB.QueryContext(ctx, "UPDATE items SET scraped_at = NOW() WHERE id = ?", stale.id)
+	if err != nil {
+		logger.Error("Failed to update scraped_at", "err", err)
+		return true
+	}
+	DB.CloseRows(rows)
+	return true
+}
+
+// Returns up to limit items last scraped more than rescrape_age_in_days ago,
+// oldest first. Items scraped before scraped_at was recorded come first, items
+// which have been corrected by hand are never returned. Only first versions are
+// returned as scraping those saves every version on the page
+func fetchStaleItems(limit int) []staleItem {
+	items := []staleItem{}
+
+	query := "SELECT id, name, displayName FROM items " +
+		"WHERE (scraped_at IS NULL OR scraped_at < DATE_SUB(NOW(), INTERVAL ? DAY)) " +
+		"AND COALESCE(is_manual_override, 0) = 0 " +
+		"AND version = ? " +
+		"ORDER BY scraped_at IS NOT NULL, scraped_at ASC " +
+		"LIMIT ?"
+	rows, err := DB.QueryContext(AppContext, query, Settings.RescrapeAgeInDays, DEFAULT_ITEM_VERSION, limit)
+	if err != nil {
+		return items
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var item staleItem
+		if err := rows.Scan(&item.id, &item.name, &item.displayName); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+	return items
+}
@@ -8,12 +8,28 @@ import (
 func CreateRouter() *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
 
+	// Built once here, the routes never change after boot
+	document, err := NewOpenApiDocument(routes)
+	if err != nil {
+		Log.Error("Failed to build the OpenAPI document", "err", err)
+	}
+	openApiDocument = document
+	graphqlHandler = NewGraphqlHandler()
+
 	for _, route := range routes {
 		// Decorate the HTTP handler with a server log so we can debug the routes
 		var handler http.Handler
 
 		handler = route.handler
 
+		if !RouteAllowed(route) {
+			handler = http.HandlerFunc(refuseWrites)
+		}
+		if limit, exists := expensiveRoutes[route.name]; exists {
+			handler = Limit(handler, NewConcurrencyLimiter(limit))
+		}
+
+		handler = Tenancy(handler, route)
 		handler = Logger(handler, route.name)
 
 		router.
@@ -25,3 +41,12 @@ func CreateRouter() *mux.Router {
 
 	return router
 }
+
+// Returns false if the route writes or scrapes and we're in read only mode
+func RouteAllowed(route Route) bool {
+	return !Settings.ReadOnly || route.method == "GET" || readOnlyRoutes[route.name]
+}
+
+func refuseWrites(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "This mirror is read only", http.StatusForbidden)
+}
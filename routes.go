@@ -1,6 +1,9 @@
 package main
 
-import "net/http"
+import (
+	"net/http"
+	"strings"
+)
 
 type Route struct {
 	name 	string
@@ -13,16 +16,552 @@ type Routes []Route
 
 // Define any application routes here
 var routes = Routes {
+	Route {
+		"Show Job",
+		"GET",
+		"/jobs/{id}",
+		JC.show,
+	},
+	Route {
+		"List Items",
+		"GET",
+		"/items",
+		IC.index,
+	},
 	Route {
 		"Store Items",
 		"POST",
 		"/items",
 		IC.store,
 	},
+	// Must be registered before /items/{item_name} or they are treated as item names
+	Route {
+		"Search Items",
+		"GET",
+		"/items/search",
+		IC.search,
+	},
+	Route {
+		"Query Items",
+		"GET",
+		"/items/query",
+		IC.query,
+	},
+	Route {
+		"Top Items",
+		"GET",
+		"/items/top",
+		IC.top,
+	},
+	Route {
+		"Batch Get Items",
+		"POST",
+		"/items/batch-get",
+		IC.batchGet,
+	},
+	Route {
+		"List Stat Labels",
+		"GET",
+		"/items/stat-labels",
+		IC.statLabels,
+	},
+	Route {
+		"Item Sprite Sheet",
+		"GET",
+		"/items/sprites.{format:png|css|json}",
+		IC.sprites,
+	},
+	Route {
+		"Item Image",
+		"GET",
+		"/images/{item_id:[0-9]+}",
+		IMC.show,
+	},
+	Route {
+		"Show Item",
+		"GET",
+		"/items/{item_name}",
+		IC.show,
+	},
 	Route {
 		"Create Item",
 		"POST",
 		"/items/{item_name}",
 		IC.fetchOrStore,
 	},
-}
\ No newline at end of file
+	Route {
+		"Correct Item",
+		"PATCH",
+		"/items/{item_name}",
+		IC.correct,
+	},
+	Route {
+		"Delete Item",
+		"DELETE",
+		"/items/{item_name}",
+		IC.destroy,
+	},
+	Route {
+		"Preview Item",
+		"POST",
+		"/items/{item_name}/preview",
+		IC.preview,
+	},
+	Route {
+		"Item Prices",
+		"GET",
+		"/items/{item_name}/prices",
+		IC.prices,
+	},
+	Route {
+		"Item Plaintext",
+		"GET",
+		"/items/{item_name}/plaintext",
+		IC.plaintext,
+	},
+	Route {
+		"Item Sources",
+		"GET",
+		"/items/{item_name}/sources",
+		IC.sources,
+	},
+	Route {
+		"Item Quests",
+		"GET",
+		"/items/{item_name}/quests",
+		QTC.itemQuests,
+	},
+	Route {
+		"Item Recipes",
+		"GET",
+		"/items/{item_name}/recipes",
+		RPC.itemRecipes,
+	},
+	Route {
+		"Item History",
+		"GET",
+		"/items/{item_name}/history",
+		IC.history,
+	},
+	Route {
+		"Item Price Trend",
+		"GET",
+		"/items/{item_name}/trend",
+		IC.trend,
+	},
+	Route {
+		"Show Spell Line",
+		"GET",
+		"/spell-lines/{spell_name}",
+		SC.line,
+	},
+	Route {
+		"Create Spell",
+		"POST",
+		"/spells/{spell_name}",
+		SC.store,
+	},
+	Route {
+		"Show Spell Pets",
+		"GET",
+		"/spells/{spell_name}/pets",
+		SC.pets,
+	},
+	Route {
+		"Show NPC",
+		"GET",
+		"/npcs/{npc_name}",
+		NC.show,
+	},
+	Route {
+		"Create NPC",
+		"POST",
+		"/npcs/{npc_name}",
+		NC.store,
+	},
+	Route {
+		"Show NPC Spawns",
+		"GET",
+		"/npcs/{npc_name}/spawns",
+		NC.spawns,
+	},
+	Route {
+		"Store Merchant Inventory",
+		"POST",
+		"/npcs/{npc_name}/inventory",
+		NC.inventory,
+	},
+	Route {
+		"Show Zone",
+		"GET",
+		"/zones/{zone_name}",
+		ZC.show,
+	},
+	Route {
+		"Zone Items",
+		"GET",
+		"/zones/{zone_name}/items",
+		ZC.items,
+	},
+	Route {
+		"Show Quest",
+		"GET",
+		"/quests/{quest_name}",
+		QTC.show,
+	},
+	Route {
+		"Create Quest",
+		"POST",
+		"/quests/{quest_name}",
+		QTC.store,
+	},
+	Route {
+		"Show Recipe",
+		"GET",
+		"/recipes/{recipe_name}",
+		RPC.show,
+	},
+	Route {
+		"Create Recipe",
+		"POST",
+		"/recipes/{recipe_name}",
+		RPC.store,
+	},
+	Route {
+		"Show Faction",
+		"GET",
+		"/factions/{faction_name}",
+		FC.show,
+	},
+	Route {
+		"Show Seller",
+		"GET",
+		"/sellers/{name}",
+		SLC.show,
+	},
+	Route {
+		"Class Equipment Planner",
+		"GET",
+		"/classes/{class}/equipment",
+		CC.equipment,
+	},
+	Route {
+		"Market Index",
+		"GET",
+		"/market/index",
+		MC.index,
+	},
+	Route {
+		"List Server Item Rules",
+		"GET",
+		"/servers/{server}/rules",
+		RC.rules,
+	},
+	Route {
+		"Store Server Item Rule",
+		"POST",
+		"/admin/servers/{server}/rules",
+		RC.storeRule,
+	},
+	Route {
+		"Delete Server Item Rule",
+		"DELETE",
+		"/admin/servers/{server}/rules/{id}",
+		RC.deleteRule,
+	},
+	Route {
+		"Item Cache Stats",
+		"GET",
+		"/admin/cache",
+		KC.stats,
+	},
+	Route {
+		"List Quarantined Lines",
+		"GET",
+		"/admin/quarantine",
+		QC.index,
+	},
+	Route {
+		"Replay Quarantined Line",
+		"POST",
+		"/admin/quarantine/{id}/replay",
+		QC.replay,
+	},
+	Route {
+		"List Retry Lines",
+		"GET",
+		"/admin/retries",
+		QC.retries,
+	},
+	Route {
+		"Scrape Outcomes",
+		"GET",
+		"/admin/scrapes",
+		QC.scrapes,
+	},
+	Route {
+		"List Parse Failures",
+		"GET",
+		"/admin/parse-failures",
+		QC.parseFailures,
+	},
+	Route {
+		"Retry Parse Failure",
+		"POST",
+		"/admin/parse-failures/{id}/retry",
+		QC.retryParseFailure,
+	},
+	Route {
+		"Refresh Item",
+		"POST",
+		"/admin/items/{item_name}/refresh",
+		IC.refresh,
+	},
+	Route {
+		"List API Keys",
+		"GET",
+		"/admin/tenants/{tenant}/keys",
+		AC.apiKeys,
+	},
+	Route {
+		"Store API Key",
+		"POST",
+		"/admin/tenants/{tenant}/keys",
+		AC.storeApiKey,
+	},
+	Route {
+		"Revoke API Key",
+		"DELETE",
+		"/admin/keys/{id}",
+		AC.revokeApiKey,
+	},
+	Route {
+		"List Webhooks",
+		"GET",
+		"/admin/tenants/{tenant}/webhooks",
+		AC.webhooks,
+	},
+	Route {
+		"Store Webhook",
+		"POST",
+		"/admin/tenants/{tenant}/webhooks",
+		AC.storeWebhook,
+	},
+	Route {
+		"Delete Webhook",
+		"DELETE",
+		"/admin/webhooks/{id}",
+		AC.deleteWebhook,
+	},
+	Route {
+		"List Webhook Deliveries",
+		"GET",
+		"/admin/webhooks/{id}/deliveries",
+		AC.webhookDeliveries,
+	},
+	Route {
+		"List Item Aliases",
+		"GET",
+		"/admin/aliases",
+		AC.itemAliases,
+	},
+	Route {
+		"Store Item Alias",
+		"POST",
+		"/admin/aliases",
+		AC.storeItemAlias,
+	},
+	Route {
+		"Delete Item Alias",
+		"DELETE",
+		"/admin/aliases/{id}",
+		AC.deleteItemAlias,
+	},
+	Route {
+		"List Alias Candidates",
+		"GET",
+		"/admin/alias-candidates",
+		AC.aliasCandidates,
+	},
+	Route {
+		"Learn Aliases",
+		"POST",
+		"/admin/alias-candidates/learn",
+		AC.learnAliases,
+	},
+	Route {
+		"Review Alias Candidate",
+		"POST",
+		"/admin/alias-candidates/{id}/{decision:approve|reject}",
+		AC.reviewAliasCandidate,
+	},
+	Route {
+		"Wiki Canary",
+		"GET",
+		"/admin/canary",
+		AC.canary,
+	},
+	Route {
+		"Accept Wiki Canary",
+		"POST",
+		"/admin/canary/accept",
+		AC.acceptCanary,
+	},
+	Route {
+		"Usage Stats",
+		"GET",
+		"/admin/usage",
+		AC.usage,
+	},
+	Route {
+		"Dedupe Statistics",
+		"POST",
+		"/admin/statistics/dedupe",
+		AC.dedupeStatistics,
+	},
+	Route {
+		"Discord Item",
+		"GET",
+		"/discord/item/{item_name}",
+		DC.item,
+	},
+	Route {
+		"Discord Interactions",
+		"POST",
+		"/discord/interactions",
+		DC.interactions,
+	},
+	Route {
+		"Export Items",
+		"GET",
+		"/export/items",
+		XC.items,
+	},
+	Route {
+		"Export Items JSON",
+		"GET",
+		"/export/items.json",
+		XC.itemsJson,
+	},
+	Route {
+		"Export Items CSV",
+		"GET",
+		"/export/items.csv",
+		XC.itemsCsv,
+	},
+	Route {
+		"Import Items",
+		"POST",
+		"/import/items",
+		IPC.items,
+	},
+	Route {
+		"Items Report",
+		"GET",
+		"/reports/items.{format:xlsx|csv}",
+		XC.itemsReport,
+	},
+	Route {
+		"List Snapshots",
+		"GET",
+		"/export/snapshots",
+		XC.snapshots,
+	},
+	Route {
+		"OpenAPI Document",
+		"GET",
+		"/openapi.json",
+		OC.document,
+	},
+	Route {
+		"API Docs",
+		"GET",
+		"/docs",
+		OC.ui,
+	},
+	Route {
+		"GraphQL Query",
+		"GET",
+		"/graphql",
+		GC.query,
+	},
+	Route {
+		"GraphQL",
+		"POST",
+		"/graphql",
+		GC.query,
+	},
+	Route {
+		"Live Feed",
+		"GET",
+		"/ws/feed",
+		EC.stream,
+	},
+}
+
+// Routes which scrape the wiki or write in bulk, mapped to the number of
+// requests to each which may run at once. These also count towards
+// max_concurrent_expensive_requests
+var expensiveRoutes = map[string]int {
+	"Store Items": 4,
+	"Create Item": 8,
+	"Preview Item": 4,
+	"Item Sprite Sheet": 4,
+	"Item Image": 8,
+	"Batch Get Items": 8,
+	"GraphQL Query": 8,
+	"GraphQL": 8,
+	"Create Spell": 4,
+	"Create NPC": 4,
+	"Show NPC Spawns": 4,
+	"Store Merchant Inventory": 4,
+	"Show Zone": 4,
+	"Show Quest": 4,
+	"Create Quest": 4,
+	"Show Recipe": 4,
+	"Create Recipe": 4,
+	"Show Faction": 4,
+	"Replay Quarantined Line": 2,
+	"Retry Parse Failure": 2,
+	"Refresh Item": 2,
+	"Export Items": 1,
+	"Export Items JSON": 1,
+	"Export Items CSV": 1,
+	"Import Items": 1,
+	"Items Report": 2,
+	"Dedupe Statistics": 1,
+	"Learn Aliases": 1,
+	"Discord Item": 8,
+	"Discord Interactions": 8,
+}
+
+// Routes which only read despite not being a GET, so stay open in read only
+// mode. Every other route that isn't a GET is refused
+var readOnlyRoutes = map[string]bool {
+	"Batch Get Items": true,
+	"GraphQL": true,
+	"Discord Interactions": true,
+}
+
+// Routes which never need an API key, even when require_api_key is set, as the
+// caller proves who they are some other way
+var keylessRoutes = map[string]bool {
+	"Discord Interactions": true,
+	"OpenAPI Document": true,
+	"API Docs": true,
+}
+
+// Routes outside /admin/ which are admin routes all the same, they act on
+// data every tenant shares in ways tenants mustn't
+var adminRoutes = map[string]bool {
+	"Delete Item": true,
+	"Import Items": true,
+	"Correct Item": true,
+}
+
+// Returns true if the route is only for admins, which means admin_api_key.
+// Tenants' keys can't call them
+func IsAdminRoute(route Route) bool {
+	return strings.HasPrefix(route.pattern, "/admin/") || adminRoutes[route.name]
+}
+
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | S3 compatible storage
+ |--------------------------------------------------------------------------
+ |
+ | Just enough of the S3 API to put, get and delete objects, signed with AWS
+ | Signature Version 4. Requests use path style urls (endpoint/bucket/key)
+ | as those work against AWS, MinIO, R2 and the like without any DNS set up
+ | for the bucket, and it saves pulling in an SDK for three calls
+ |
+ */
+
+type S3Bucket struct {
+	endpoint string
+	region string
+	bucket string
+	accessKey string
+	secretKey string
+}
+
+var s3Client = &http.Client{ Timeout: 5 * time.Minute }
+
+func NewS3Bucket(endpoint string, region string, bucket string, accessKey string, secretKey string) S3Bucket {
+	return S3Bucket{ strings.TrimRight(endpoint, "/"), region, bucket, accessKey, secretKey }
+}
+
+func (b S3Bucket) PutObject(ctx context.Context, key string, body []byte, contentType string) error {
+	_, err := b.do(ctx, "PUT", key, body, map[string]string{ "Content-Type": contentType })
+	return err
+}
+
+func (b S3Bucket) GetObject(ctx context.Context, key string) ([]byte, error) {
+	return b.do(ctx, "GET", key, nil, nil)
+}
+
+func (b S3Bucket) DeleteObject(ctx context.Context, key string) error {
+	_, err := b.do(ctx, "DELETE", key, nil, nil)
+	return err
+}
+
+// Returns the response body, which is only read if the request succeeded
+func (b S3Bucket) do(ctx context.Context, method string, key string, body []byte, headers map[string]string) ([]byte, error) {
+	uri := "/" + s3EscapePath(b.bucket + "/" + key)
+	request, err := http.NewRequestWithContext(ctx, method, b.endpoint + uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	b.sign(request, uri, body, time.Now().UTC())
+
+	response, err := s3Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return nil, errors.New(method + " " + key + " failed with " + strconv.Itoa(response.StatusCode) + ": " + string(message))
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+func (b S3Bucket) sign(request *http.Request, uri string, body []byte, now time.Time) {
+	signAwsRequest(request, "s3", b.region, b.accessKey, b.secretKey, uri, body, now)
+}
+
+// Adds the Signature Version 4 Authorization header for the service, see
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+func signAwsRequest(request *http.Request, service string, region string, accessKey string, secretKey string, uri string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		uri,
+		"",
+		"host:" + request.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		"host;x-amz-content-sha256;x-amz-date",
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSha256([]byte("AWS4" + secretKey), date)
+	key = hmacSha256(key, region)
+	key = hmacSha256(key, service)
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=" + accessKey + "/" + scope +
+		", SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=" + signature)
+}
+
+// Percent encodes everything except unreserved characters and slashes, which
+// is stricter than url.PathEscape and what the signature is computed over
+func s3EscapePath(path string) string {
+	var escaped strings.Builder
+	for _, c := range []byte(path) {
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			escaped.WriteByte(c)
+		} else {
+			escaped.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return escaped.String()
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
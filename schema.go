@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Schema self-check
+ |--------------------------------------------------------------------------
+ |
+ | Every table and column the service reads or writes is listed here, on
+ | boot we compare them against information_schema so that a database
+ | which is missing a migration fails straight away with a list of what is
+ | missing, rather than with scan errors on the first request. Upserts rely
+ | on unique keys so those are checked too
+ |
+ */
+
+type schemaTable struct {
+	name string
+	columns []string
+}
+
+type schemaUniqueKey struct {
+	table string
+	columns []string
+}
+
+var requiredTables = []schemaTable{
+	{"items", []string{"id", "name", "displayName", "imageSrc", "observed_price", "observed_count", "corrected_price", "vendor_sell_price", "vendor_buy_price", "scraped_at", "is_manual_override", "version", "description",
+		"container_slots", "container_max_size", "container_weight_reduction", "damage_delay_ratio", "haste_adjusted_delay"}},
+	{"statistics", []string{"item_id", "code", "value", "effect"}},
+	{"effects", []string{"id", "name", "uri", "description", "mana", "duration", "scraped_at"}},
+	{"item_effects", []string{"item_id", "effect_id", "restriction", "effect_type", "trigger_level", "cast_time", "charges"}},
+	{"item_wikitext", []string{"item_id", "wikitext", "fetched_at"}},
+	{"page_snapshots", []string{"id", "item_id", "uri", "body", "hash", "size_bytes", "fetched_at"}},
+	{"item_rules", []string{"id", "server", "item_id", "rule", "reason", "exclude_from_market", "created_at"}},
+	{"auctions", []string{"id", "server", "seller", "item_id", "item_name", "price", "line", "auctioned_at"}},
+	{"retry_lines", []string{"id", "line_hash", "server", "line", "reason", "attempts", "next_attempt_at", "created_at"}},
+	{"quarantined_lines", []string{"id", "line", "reason", "created_at"}},
+	{"price_points", []string{"id", "server", "item_id", "price", "confidence", "line", "created_at"}},
+	{"price_rollups", []string{"server", "item_id", "period", "bucket", "open", "high", "low", "close", "volume"}},
+	{"merchant_inventory", []string{"merchant_name", "item_name", "item_id", "price"}},
+	{"spells", []string{"id", "name", "imageSrc", "mana", "cast_time", "recast_time", "duration", "skill", "target", "description"}},
+	{"spell_classes", []string{"spell_id", "class", "level"}},
+	{"spell_lines", []string{"name", "previous_name", "next_name"}},
+	{"pets", []string{"spell_id", "level", "hp", "ac", "min_damage", "max_damage", "attack_delay"}},
+	{"npc_spawns", []string{"npc_name", "zone", "loc_y", "loc_x", "loc_z", "text"}},
+	{"npcs", []string{"id", "name", "level", "zone", "hp", "scraped_at"}},
+	{"npc_drops", []string{"npc_id", "item_name", "item_id", "chance"}},
+	{"zones", []string{"id", "name", "level_range", "scraped_at"}},
+	{"zone_connections", []string{"zone_id", "connected_zone"}},
+	{"zone_npcs", []string{"zone_id", "npc_name"}},
+	{"quests", []string{"id", "name", "scraped_at"}},
+	{"quest_items", []string{"quest_id", "item_name", "item_id", "role"}},
+	{"quest_steps", []string{"quest_id", "position", "npc_name", "text"}},
+	{"recipes", []string{"id", "name", "tradeskill", "trivial", "container", "scraped_at"}},
+	{"recipe_components", []string{"recipe_id", "item_name", "item_id", "count", "role"}},
+	{"alias_candidates", []string{"id", "server", "alias", "item_name", "occurrences", "share", "status", "updated_at"}},
+	{"item_aliases", []string{"id", "server", "alias", "item_name", "created_at"}},
+	{"item_factions", []string{"item_id", "faction_name", "standing"}},
+	{"classes", []string{"abbreviation", "name"}},
+	{"races", []string{"abbreviation", "name", "size"}},
+	{"item_classes", []string{"item_id", "class"}},
+	{"item_races", []string{"item_id", "race"}},
+	{"item_slots", []string{"item_id", "slot"}},
+	{"factions", []string{"id", "name", "scraped_at"}},
+	{"faction_npcs", []string{"faction_id", "npc_name"}},
+	{"faction_quests", []string{"faction_id", "quest_name"}},
+	{"item_sources", []string{"item_id", "npc_name", "zone"}},
+	{"item_usage", []string{"item_id", "kind", "day", "count"}},
+	{"canary_expectations", []string{"page", "data", "updated_at"}},
+	{"api_keys", []string{"id", "tenant", "name", "prefix", "key_hash", "created_at", "revoked_at"}},
+	{"item_revisions", []string{"id", "item_id", "data", "created_at"}},
+	{"snapshots", []string{"id", "object_key", "item_count", "size_bytes", "created_at"}},
+	{"item_images", []string{"item_id", "src", "hash", "content_type", "size_bytes", "fetched_at"}},
+	{"parse_failures", []string{"id", "name", "url", "body", "reason", "occurrences", "created_at", "updated_at"}},
+	{"webhooks", []string{"id", "server", "url", "event", "item_name", "max_price", "format", "secret", "created_at"}},
+	{"webhook_deliveries", []string{"id", "webhook_id", "event", "payload", "attempts", "last_error", "next_attempt_at", "delivered_at", "created_at"}},
+	{"price_corrections", []string{"server", "item_id", "price", "corrected_at"}},
+}
+
+var requiredUniqueKeys = []schemaUniqueKey{
+	{"item_images", []string{"item_id"}},
+	{"parse_failures", []string{"name"}},
+	{"items", []string{"name", "version"}},
+	{"effects", []string{"name"}},
+	{"item_rules", []string{"server", "item_id", "rule"}},
+	{"item_wikitext", []string{"item_id"}},
+	{"price_rollups", []string{"server", "item_id", "period", "bucket"}},
+	{"retry_lines", []string{"line_hash"}},
+	{"spells", []string{"name"}},
+	{"statistics", []string{"item_id", "code", "effect"}},
+	{"spell_lines", []string{"name"}},
+	{"npcs", []string{"name"}},
+	{"item_usage", []string{"item_id", "kind", "day"}},
+	{"canary_expectations", []string{"page"}},
+	{"zones", []string{"name"}},
+	{"api_keys", []string{"key_hash"}},
+	{"quests", []string{"name"}},
+	{"recipes", []string{"name"}},
+	{"factions", []string{"name"}},
+	{"alias_candidates", []string{"server", "alias"}},
+	{"item_aliases", []string{"server", "alias"}},
+	{"price_corrections", []string{"server", "item_id"}},
+	{"classes", []string{"abbreviation"}},
+	{"races", []string{"abbreviation"}},
+	{"item_classes", []string{"item_id", "class"}},
+	{"item_races", []string{"item_id", "race"}},
+	{"item_slots", []string{"item_id", "slot"}},
+}
+
+// Unique keys which have since been widened, these refuse rows the wider key
+// allows so have to be dropped
+var retiredUniqueKeys = []schemaUniqueKey{
+	{"items", []string{"name"}},
+	{"alias_candidates", []string{"alias"}},
+	{"item_aliases", []string{"alias"}},
+}
+
+// Returns a description of everything missing from the database, the schema
+// is fine if nothing is returned
+func CheckSchema() []string {
+	var problems []string
+
+	columns, err := DB.dialect.fetchSchemaColumns()
+	if err != nil {
+		return append(problems, "Couldn't read the schema from information_schema: " + err.Error())
+	}
+	uniqueKeys, err := DB.dialect.fetchSchemaUniqueKeys()
+	if err != nil {
+		return append(problems, "Couldn't read the indexes from information_schema: " + err.Error())
+	}
+
+	for _, table := range requiredTables {
+		existing, exists := columns[table.name]
+		if !exists {
+			problems = append(problems, "Missing table: " + table.name)
+			continue
+		}
+		for _, column := range table.columns {
+			if !existing[strings.ToLower(column)] {
+				problems = append(problems, "Missing column: " + table.name + "." + column)
+			}
+		}
+	}
+
+	for _, key := range requiredUniqueKeys {
+		if _, exists := columns[key.table]; !exists {
+			continue // Already reported as a missing table
+		}
+		if !uniqueKeys[uniqueKeyName(key.table, key.columns)] {
+			problems = append(problems, "Missing unique key on " + key.table + " (" + strings.Join(key.columns, ", ") + ")")
+		}
+	}
+	for _, key := range retiredUniqueKeys {
+		if uniqueKeys[uniqueKeyName(key.table, key.columns)] {
+			problems = append(problems, "Retired unique key on " + key.table + " (" + strings.Join(key.columns, ", ") + ") needs dropping")
+		}
+	}
+
+	return problems
+}
+
+// Returns the columns of every table in the current database, names are
+// lowercased as MySQL compares them case insensitively
+func fetchSchemaColumns() (map[string]map[string]bool, error) {
+	query := "SELECT TABLE_NAME, COLUMN_NAME " +
+		"FROM information_schema.COLUMNS " +
+		"WHERE TABLE_SCHEMA = DATABASE()"
+
+	rows, err := DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer DB.CloseRows(rows)
+
+	columns := make(map[string]map[string]bool)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, err
+		}
+		table = strings.ToLower(table)
+		if columns[table] == nil {
+			columns[table] = make(map[string]bool)
+		}
+		columns[table][strings.ToLower(column)] = true
+	}
+	return columns, rows.Err()
+}
+
+// Returns every unique key in the current database keyed by uniqueKeyName
+func fetchSchemaUniqueKeys() (map[string]bool, error) {
+	query := "SELECT TABLE_NAME, INDEX_NAME, COLUMN_NAME " +
+		"FROM information_schema.STATISTICS " +
+		"WHERE TABLE_SCHEMA = DATABASE() " +
+		"AND NON_UNIQUE = 0 " +
+		"ORDER BY TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX"
+
+	rows, err := DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer DB.CloseRows(rows)
+
+	indexes := make(map[string][]string)
+	tables := make(map[string]string)
+	for rows.Next() {
+		var table, index, column string
+		if err := rows.Scan(&table, &index, &column); err != nil {
+			return nil, err
+		}
+		id := table + "." + index
+		tables[id] = table
+		indexes[id] = append(indexes[id], column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool)
+	for id, columns := range indexes {
+		keys[uniqueKeyName(tables[id], columns)] = true
+	}
+	return keys, nil
+}
+
+// Column order doesn't matter for uniqueness, so keys are compared as sorted sets
+func uniqueKeyName(table string, columns []string) string {
+	sorted := make([]string, len(columns))
+	for idx, column := range columns {
+		sorted[idx] = strings.ToLower(column)
+	}
+	sort.Strings(sorted)
+	return fmt.Sprint(strings.ToLower(table), sorted)
+}
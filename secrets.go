@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Secrets
+ |--------------------------------------------------------------------------
+ |
+ | Any string setting can be a reference to a secret rather than the value
+ | itself, i.e. sql_pass = "secret:prod/service-wiki/rds#password", so
+ | credentials never sit in plain text config. References are resolved
+ | through secrets_provider when the config is loaded and again every
+ | secrets_refresh_interval_in_secs, so rotated credentials are picked up
+ | without a restart. The part after the # picks a key out of a JSON
+ | secret, RDS secrets hold the username, password, host and port this way.
+ | Settings which can rotate are read through LiveSetting
+ |
+ */
+
+type SecretProvider interface {
+	// Returns the current value of the named secret
+	Fetch(ctx context.Context, name string) (string, error)
+}
+
+const (
+	SECRETS_PROVIDER_AWS   = "aws"
+	SECRETS_PROVIDER_VAULT = "vault"
+)
+
+const SECRET_REFERENCE_PREFIX = "secret:"
+
+type secretReference struct {
+	setting string
+	name string
+	key string
+}
+
+// Settings the provider is built from
+var secretProviderSettings = map[string]bool{
+	"secrets_provider": true,
+	"aws_region": true,
+	"aws_access_key_id": true,
+	"aws_secret_access_key": true,
+	"aws_session_token": true,
+	"vault_addr": true,
+	"vault_token": true,
+}
+
+var secretsClient = &http.Client{ Timeout: 10 * time.Second }
+
+// The references in the config and the latest value of each, keyed by setting
+var secrets = struct {
+	sync.RWMutex
+	provider SecretProvider
+	references []secretReference
+	values map[string]string
+}{ values: make(map[string]string) }
+
+// Returns the latest value of the setting if it's a secret, which may have
+// rotated since boot, and value otherwise
+func LiveSetting(setting string, value string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	if latest, exists := secrets.values[setting]; exists {
+		return latest
+	}
+	return value
+}
+
+// Replaces every secret reference in the config with the secret's value,
+// returning a problem for each which couldn't be resolved
+func (c *Config) resolveSecrets() []string {
+	var problems []string
+	var references []secretReference
+
+	value := reflect.ValueOf(c).Elem()
+	for idx := 0; idx < value.NumField(); idx++ {
+		field := value.Type().Field(idx)
+		raw := value.Field(idx)
+		if field.Type.Kind() != reflect.String || !strings.HasPrefix(raw.String(), SECRET_REFERENCE_PREFIX) {
+			continue
+		}
+		if secretProviderSettings[field.Tag.Get("toml")] {
+			problems = append(problems, field.Tag.Get("toml") + " is needed to read secrets so can't be one")
+			continue
+		}
+		reference := secretReference{ setting: field.Tag.Get("toml") }
+		reference.name = strings.TrimPrefix(raw.String(), SECRET_REFERENCE_PREFIX)
+		if hash := strings.LastIndex(reference.name, "#"); hash >= 0 {
+			reference.name, reference.key = reference.name[:hash], reference.name[hash+1:]
+		}
+		references = append(references, reference)
+	}
+	if len(references) == 0 {
+		return problems
+	}
+
+	provider, err := c.secretProvider()
+	if err != nil {
+		return append(problems, err.Error())
+	}
+
+	values := make(map[string]string)
+	for _, reference := range references {
+		secret, err := reference.resolve(context.Background(), provider)
+		if err != nil {
+			problems = append(problems, "Couldn't resolve " + reference.setting + ": " + err.Error())
+			continue
+		}
+		values[reference.setting] = secret
+		for idx := 0; idx < value.NumField(); idx++ {
+			if value.Type().Field(idx).Tag.Get("toml") == reference.setting {
+				value.Field(idx).SetString(secret)
+			}
+		}
+	}
+
+	secrets.Lock()
+	secrets.provider = provider
+	secrets.references = references
+	secrets.values = values
+	secrets.Unlock()
+	return problems
+}
+
+func (c Config) secretProvider() (SecretProvider, error) {
+	switch c.SecretsProvider {
+	case SECRETS_PROVIDER_AWS:
+		if c.AwsRegion == "" || c.AwsAccessKeyId == "" || c.AwsSecretAccessKey == "" {
+			return nil, errors.New("aws_region, aws_access_key_id and aws_secret_access_key are required when secrets_provider is aws")
+		}
+		return AwsSecretsManager{ c.AwsRegion, c.AwsAccessKeyId, c.AwsSecretAccessKey, c.AwsSessionToken }, nil
+	case SECRETS_PROVIDER_VAULT:
+		if c.VaultAddr == "" || c.VaultToken == "" {
+			return nil, errors.New("vault_addr and vault_token are required when secrets_provider is vault")
+		}
+		return Vault{ strings.TrimRight(c.VaultAddr, "/"), c.VaultToken }, nil
+	case "":
+		return nil, errors.New("secrets_provider must be set to use secret: settings")
+	default:
+		return nil, errors.New("secrets_provider must be aws or vault")
+	}
+}
+
+func (r secretReference) resolve(ctx context.Context, provider SecretProvider) (string, error) {
+	secret, err := provider.Fetch(ctx, r.name)
+	if err != nil || r.key == "" {
+		return secret, err
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(secret))
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil {
+		return "", errors.New("the secret isn't a JSON object so has no key " + r.key)
+	}
+	field, exists := fields[r.key]
+	if !exists {
+		return "", errors.New("the secret has no key " + r.key)
+	}
+	return fmt.Sprint(field), nil
+}
+
+// Fetches every secret again every secrets_refresh_interval_in_secs until we
+// shut down. A secret which can't be fetched keeps its last value
+func RefreshSecrets() {
+	secrets.RLock()
+	provider, references := secrets.provider, secrets.references
+	secrets.RUnlock()
+	if len(references) == 0 {
+		return
+	}
+
+	for {
+		select {
+		case <-time.After(seconds(Settings.SecretsRefreshIntervalInSecs)):
+		case <-AppContext.Done():
+			return
+		}
+
+		for _, reference := range references {
+			secret, err := reference.resolve(AppContext, provider)
+			if err != nil {
+				Log.Error("Failed to refresh secret", "setting", reference.setting, "err", err)
+				continue
+			}
+			secrets.Lock()
+			rotated := secrets.values[reference.setting] != secret
+			secrets.values[reference.setting] = secret
+			secrets.Unlock()
+			if rotated {
+				Log.Info("Secret rotated", "setting", reference.setting)
+			}
+		}
+	}
+}
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: AwsSecretsManager
+ |--------------------------------------------------------------------------
+ |
+ | Reads secrets from AWS Secrets Manager by name or ARN, requests are
+ | signed the same way as our S3 requests
+ |
+ */
+
+type AwsSecretsManager struct {
+	region string
+	accessKey string
+	secretKey string
+	sessionToken string
+}
+
+func (a AwsSecretsManager) Fetch(ctx context.Context, name string) (string, error) {
+	body, err := json.Marshal(map[string]string{ "SecretId": name })
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := "https://secretsmanager." + a.region + ".amazonaws.com/"
+	request, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	request.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if a.sessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", a.sessionToken)
+	}
+	signAwsRequest(request, "secretsmanager", a.region, a.accessKey, a.secretKey, "/", body, time.Now().UTC())
+
+	var response struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := fetchSecretJson(request, &response); err != nil {
+		return "", err
+	}
+	return response.SecretString, nil
+}
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Vault
+ |--------------------------------------------------------------------------
+ |
+ | Reads secrets from HashiCorp Vault by path, i.e. secret/data/service-wiki.
+ | Both versions of the KV engine are supported, the secret's data is
+ | returned as a JSON object so references need a #key
+ |
+ */
+
+type Vault struct {
+	addr string
+	token string
+}
+
+func (v Vault) Fetch(ctx context.Context, name string) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", v.addr + "/v1/" + strings.TrimLeft(name, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("X-Vault-Token", v.token)
+
+	var response struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := fetchSecretJson(request, &response); err != nil {
+		return "", err
+	}
+
+	// KV version 2 nests the secret under data.data along with its metadata
+	if nested, exists := response.Data["data"]; exists && len(nested) > 0 && nested[0] == '{' {
+		if _, versioned := response.Data["metadata"]; versioned {
+			return string(nested), nil
+		}
+	}
+	data, err := json.Marshal(response.Data)
+	return string(data), err
+}
+
+func fetchSecretJson(request *http.Request, target interface{}) error {
+	response, err := secretsClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return errors.New("responded with " + strconv.Itoa(response.StatusCode) + ": " + string(message))
+	}
+	return json.NewDecoder(response.Body).Decode(target)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Self test
+ |--------------------------------------------------------------------------
+ |
+ | Running the binary as `service-wiki selftest` checks a deployment end to
+ | end without serving anything: the config is loaded and validated, the
+ | database is connected to and its schema checked, Redis is pinged if it's
+ | configured, and a known item page is fetched from the wiki and parsed.
+ | The page must parse to the stats below, and to the canary's accepted
+ | output if it has one, so a template change is caught before the
+ | service goes live. A JSON report of every check is written to stdout,
+ | logs go to stderr, and the exit code is 1 if any check failed
+ |
+ */
+
+const (
+	SELF_TEST_PASS = "pass"
+	SELF_TEST_FAIL = "fail"
+	SELF_TEST_SKIP = "skip"
+)
+
+// The reference page, and stats it has parsed to for as long as it's existed
+const SELF_TEST_PAGE = "Cloak_of_Flames"
+
+var selfTestExpectedStats = map[string]string{
+	"SLOT": "BACK",
+	"AC": "10",
+}
+
+// Checks which talk to the network give up after this long
+const SELF_TEST_CHECK_TIMEOUT = 30 * time.Second
+
+type SelfTestCheck struct {
+	name string
+	status string
+	detail string
+	duration time.Duration
+}
+
+type SelfTestReport struct {
+	checks []SelfTestCheck
+}
+
+// Runs every check and writes the report, returns the exit code
+func RunSelfTest() int {
+	var report SelfTestReport
+	defer func() { report.Write() }()
+
+	start := time.Now()
+	config, problems := LoadConfig()
+	if len(problems) > 0 {
+		report.add("config", SELF_TEST_FAIL, strings.Join(problems, "; "), start)
+		return report.ExitCode()
+	}
+	applyConfig(config)
+	report.add("config", SELF_TEST_PASS, "", start)
+
+	// The report is the only thing on stdout so it can be piped into jq
+	Log = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{ Level: slog.LevelWarn }))
+
+	databaseOk := report.run("database", selfTestDatabase)
+	if databaseOk {
+		report.run("schema", selfTestSchema)
+	} else {
+		report.skip("schema", "the database is unreachable")
+	}
+
+	if Settings.RedisHost == "" {
+		report.skip("redis", "redis_host isn't set")
+	} else {
+		report.run("redis", selfTestRedis)
+	}
+
+	if Settings.ReadOnly {
+		report.skip("wiki", "read only mirrors don't fetch from the wiki")
+	} else {
+		report.run("wiki", func(ctx context.Context) (string, error) {
+			return selfTestWiki(ctx, databaseOk)
+		})
+	}
+
+	DB.Close()
+	CloseRedis()
+	return report.ExitCode()
+}
+
+func selfTestDatabase(ctx context.Context) (string, error) {
+	if !DB.Open() {
+		return "", ErrDatabaseRead
+	}
+	if err := DB.conn.PingContext(ctx); err != nil {
+		return "", err
+	}
+	return DB.dialect.describe(), nil
+}
+
+func selfTestSchema(ctx context.Context) (string, error) {
+	// SQLite databases are brought up to date on boot, so test what boot would
+	// see. MySQL migrations are left for boot to apply
+	if err := DB.dialect.prepareSchema(ctx, false); err != nil {
+		return "", err
+	}
+	if problems := CheckSchema(); len(problems) > 0 {
+		return "", errors.New(strings.Join(problems, "; "))
+	}
+	return strconv.Itoa(len(requiredTables)) + " tables", nil
+}
+
+func selfTestRedis(ctx context.Context) (string, error) {
+	OpenRedis()
+	conn, err := redisPool.GetContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Do("PING"); err != nil {
+		return "", err
+	}
+	return Settings.RedisHost + ":" + Settings.RedisPort, nil
+}
+
+// Fetches and parses the reference page, comparing it with the stats it's
+// known to have and with the canary's expectation when we can read it
+func selfTestWiki(ctx context.Context, databaseOk bool) (string, error) {
+	body, err := FetchWikiPage(ctx, SELF_TEST_PAGE)
+	if err != nil {
+		return "", errors.New("couldn't fetch " + SELF_TEST_PAGE + ": " + ErrorLabel(err))
+	}
+
+	item := Item{ name: strings.Replace(SELF_TEST_PAGE, "_", " ", -1), displayName: SELF_TEST_PAGE }
+	if err := item.parseItemPage(ctx, body); err != nil {
+		return "", errors.New("couldn't parse " + SELF_TEST_PAGE + ": " + ErrorLabel(err))
+	}
+
+	parsed := make(map[string]string)
+	for _, stat := range item.statistics {
+		if stat.value.Valid {
+			parsed[stat.code] = strconv.FormatFloat(stat.value.Float64, 'f', -1, 64)
+		} else {
+			parsed[stat.code] = stat.effect
+		}
+	}
+	var mismatches []string
+	for code, expected := range selfTestExpectedStats {
+		if parsed[code] != expected {
+			mismatches = append(mismatches, code + " parsed as " + strconv.Quote(parsed[code]) + ", expected " + strconv.Quote(expected))
+		}
+	}
+	if len(mismatches) > 0 {
+		return "", errors.New(strings.Join(mismatches, "; "))
+	}
+
+	detail := SELF_TEST_PAGE + " parsed to " + strconv.Itoa(len(item.statistics)) + " stats"
+	if !databaseOk {
+		return detail, nil
+	}
+	expected, exists, err := fetchCanaryExpectation(ctx, SELF_TEST_PAGE)
+	if err != nil || !exists {
+		return detail + ", the canary has no expectation to compare with", nil
+	}
+	data, err := encodeItemRevision(item)
+	if err != nil {
+		return "", err
+	}
+	if string(data) != string(expected) {
+		return "", errors.New(SELF_TEST_PAGE + " no longer parses to the canary's accepted output")
+	}
+	return detail + " matching the canary", nil
+}
+
+// Runs the check with a timeout, returns true if it passed
+func (r *SelfTestReport) run(name string, check func(ctx context.Context) (string, error)) bool {
+	ctx, cancel := context.WithTimeout(AppContext, SELF_TEST_CHECK_TIMEOUT)
+	defer cancel()
+
+	start := time.Now()
+	detail, err := check(ctx)
+	if err != nil {
+		r.add(name, SELF_TEST_FAIL, err.Error(), start)
+		return false
+	}
+	r.add(name, SELF_TEST_PASS, detail, start)
+	return true
+}
+
+func (r *SelfTestReport) skip(name string, reason string) {
+	r.checks = append(r.checks, SelfTestCheck{ name: name, status: SELF_TEST_SKIP, detail: reason })
+}
+
+func (r *SelfTestReport) add(name string, status string, detail string, start time.Time) {
+	r.checks = append(r.checks, SelfTestCheck{ name, status, detail, time.Since(start) })
+}
+
+func (r SelfTestReport) Passed() bool {
+	for _, check := range r.checks {
+		if check.status == SELF_TEST_FAIL {
+			return false
+		}
+	}
+	return true
+}
+
+func (r SelfTestReport) ExitCode() int {
+	if r.Passed() {
+		return 0
+	}
+	return 1
+}
+
+func (r SelfTestReport) Write() {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(r)
+}
+
+func (r SelfTestReport) MarshalJSON() ([]byte, error) {
+	checks := r.checks
+	if checks == nil {
+		checks = []SelfTestCheck{}
+	}
+
+	return json.Marshal(struct {
+		Passed bool            `json:"passed"`
+		Checks []SelfTestCheck `json:"checks"`
+	}{r.Passed(), checks})
+}
+
+func (c SelfTestCheck) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name       string `json:"name"`
+		Status     string `json:"status"`
+		Detail     string `json:"detail,omitempty"`
+		DurationMs int64  `json:"duration_ms"`
+	}{c.name, c.status, c.detail, c.duration.Milliseconds()})
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: AliasCandidate
+ |--------------------------------------------------------------------------
+ |
+ | Represents shorthand we've seen sellers use for an item, learnt from the
+ | auction lines we've stored. Fragments the tokenizer only fuzzy matched,
+ | and words which are the initials of an item's name, are counted across
+ | lines and any which regularly resolve to the same item are proposed for
+ | review. Approved aliases are added to the name corpus so they match
+ | exactly from then on, rejected ones are never proposed again. Each
+ | tenant's shorthand is learnt from its own lines and only becomes its
+ | own alias
+ |
+ | @member id (int64): Primary key of the alias_candidates row
+ | @member server (string): The tenant whose lines the shorthand was seen in
+ | @member alias (string): The shorthand, in lower case i.e. ssoy
+ | @member itemName (string): The item it resolves to
+ | @member occurrences (int64): Lines the shorthand appeared in when last learnt
+ | @member share (float64): Fraction of those lines in which it meant itemName
+ | @member status (string): One of the ALIAS_* statuses
+ | @member updatedAt (time.Time): When the candidate was last learnt or reviewed
+ |
+ */
+
+type AliasCandidate struct {
+	id int64
+	server string
+	alias string
+	itemName string
+	occurrences int64
+	share float64
+	status string
+	updatedAt time.Time
+}
+
+const (
+	ALIAS_PENDING  = "pending"
+	ALIAS_APPROVED = "approved"
+	ALIAS_REJECTED = "rejected"
+)
+
+// Shorthand must resolve to the same item this often to be proposed, below
+// that it's ambiguous and an alias would cause more misses than it fixes
+const MIN_ALIAS_SHARE = 0.8
+
+// Learns alias candidates every alias_learning_interval_in_secs until we shut down
+func RunAliasLearning() {
+	for {
+		select {
+		case <-time.After(seconds(Settings.AliasLearningIntervalInSecs)):
+		case <-AppContext.Done():
+			return
+		}
+		if _, err := LearnAliases(AppContext); err != nil {
+			Log.Error("Failed to learn aliases", "err", err)
+		}
+	}
+}
+
+// Tokenizes the latest alias_learning_lines auction lines and proposes any
+// shorthand which appeared in at least alias_min_occurrences of a tenant's
+// lines and nearly always meant the same item. Returns the number of
+// candidates proposed or updated, reviewed candidates are left as they are
+func LearnAliases(ctx context.Context) (int, error) {
+	query := "SELECT DISTINCT server, line FROM (" +
+		"SELECT server, line FROM auctions ORDER BY id DESC LIMIT ?" +
+		") AS latest"
+
+	rows, err := DB.QueryContext(ctx, query, Settings.AliasLearningLines)
+	if err != nil {
+		return 0, ErrDatabaseRead
+	}
+	lines := make(map[string][]string)
+	total := 0
+	for rows.Next() {
+		var server, line string
+		if err := rows.Scan(&server, &line); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		lines[server] = append(lines[server], line)
+		total++
+	}
+	DB.CloseRows(rows)
+
+	var candidates []AliasCandidate
+	for server, serverLines := range lines {
+		learnt, err := learnServerAliases(ctx, server, serverLines)
+		if err != nil {
+			return 0, err
+		}
+		candidates = append(candidates, learnt...)
+	}
+	if len(candidates) == 0 {
+		Log.Info("Learnt no alias candidates", "lines", total)
+		return 0, nil
+	}
+
+	err = DB.Transaction(ctx, func(tx *Tx) error {
+		query := "INSERT INTO alias_candidates (server, alias, item_name, occurrences, share, status, updated_at) " +
+			"VALUES (?, ?, ?, ?, ?, ?, ?) " +
+			"ON DUPLICATE KEY UPDATE " +
+			"item_name = IF(status = ?, VALUES(item_name), item_name), " +
+			"occurrences = IF(status = ?, VALUES(occurrences), occurrences), " +
+			"share = IF(status = ?, VALUES(share), share), " +
+			"updated_at = IF(status = ?, VALUES(updated_at), updated_at)"
+		now := time.Now().UTC()
+		for _, c := range candidates {
+			_, err := tx.Insert(query, c.server, c.alias, c.itemName, c.occurrences, c.share, ALIAS_PENDING, now,
+				ALIAS_PENDING, ALIAS_PENDING, ALIAS_PENDING, ALIAS_PENDING)
+			if err != nil {
+				return ErrDatabaseWrite
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	Log.Info("Learnt alias candidates", "lines", total, "candidates", len(candidates))
+	return len(candidates), nil
+}
+
+// Returns the shorthand which appeared often enough in the server's lines, and
+// nearly always meant the same item, to be proposed
+func learnServerAliases(ctx context.Context, server string, lines []string) ([]AliasCandidate, error) {
+	names, lookup := Corpus.Names(server)
+	initials := nameInitials(names, lookup)
+
+	// counts[alias][item] is the number of lines the alias meant the item in
+	counts := make(map[string]map[string]int64)
+	for _, line := range lines {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		for alias, itemName := range lineShorthand(server, ParseAuctionLine(line).message, lookup, initials) {
+			if counts[alias] == nil {
+				counts[alias] = make(map[string]int64)
+			}
+			counts[alias][itemName]++
+		}
+	}
+
+	var candidates []AliasCandidate
+	for alias, items := range counts {
+		var total int64
+		best := AliasCandidate{ server: server, alias: alias }
+		for itemName, count := range items {
+			total += count
+			if count > best.occurrences || (count == best.occurrences && itemName < best.itemName) {
+				best.itemName = itemName
+				best.occurrences = count
+			}
+		}
+		best.share = float64(best.occurrences) / float64(total)
+		best.occurrences = total
+		if total >= int64(Settings.AliasMinOccurrences) && best.share >= MIN_ALIAS_SHARE {
+			candidates = append(candidates, best)
+		}
+	}
+	return candidates, nil
+}
+
+// Returns the shorthand in the message mapped to the item it means, either a
+// fragment the tokenizer only fuzzy matched or a word which is the initials
+// of exactly one item
+func lineShorthand(server string, message string, lookup map[string]string, initials map[string]string) map[string]string {
+	shorthand := make(map[string]string)
+
+	words := tokenWords(message)
+	consumed := make([]bool, len(words))
+	for _, match := range Tokenize(server, message) {
+		for idx := match.start; idx < match.end && idx < len(words); idx++ {
+			consumed[idx] = true
+		}
+		if match.confidence >= 1.0 {
+			continue
+		}
+		if fragment := trimPriceAndNoise(words[match.start:match.end]); fragment != "" {
+			if _, exists := lookup[fragment]; !exists {
+				shorthand[fragment] = match.name
+			}
+		}
+	}
+
+	for idx, word := range words {
+		if consumed[idx] || len(word) < 3 || auctionNoiseWords[word] {
+			continue
+		}
+		if _, isPrice := ParsePrice(word); isPrice {
+			continue
+		}
+		if _, exists := lookup[word]; exists {
+			continue
+		}
+		if itemName, exists := initials[word]; exists {
+			shorthand[word] = itemName
+		}
+	}
+	return shorthand
+}
+
+// Fuzzy windows sometimes take in the prices and noise words around an item,
+// those would never appear the same way twice so are trimmed off. Words in the
+// middle of the fragment are left alone
+func trimPriceAndNoise(words []string) string {
+	isNoise := func(word string) bool {
+		_, isPrice := ParsePrice(word)
+		return isPrice || auctionNoiseWords[word]
+	}
+	for len(words) > 0 && isNoise(words[0]) {
+		words = words[1:]
+	}
+	for len(words) > 0 && isNoise(words[len(words)-1]) {
+		words = words[:len(words)-1]
+	}
+	return strings.Join(words, " ")
+}
+
+// Maps the initials of every multi word name to the name, initials shared by
+// more than one item are left out as we couldn't tell which was meant
+func nameInitials(names []string, lookup map[string]string) map[string]string {
+	initials := make(map[string]string)
+	ambiguous := make(map[string]bool)
+	for _, name := range names {
+		words := strings.Fields(name)
+		if len(words) < 3 {
+			continue
+		}
+		var key strings.Builder
+		for _, word := range words {
+			key.WriteByte(word[0])
+		}
+		if existing, exists := initials[key.String()]; exists && existing != lookup[name] {
+			ambiguous[key.String()] = true
+		}
+		initials[key.String()] = lookup[name]
+	}
+	for key := range ambiguous {
+		delete(initials, key)
+	}
+	return initials
+}
+
+// Returns the server's candidates with the status, most seen first
+func FetchAliasCandidates(ctx context.Context, server string, status string, limit int) ([]AliasCandidate, error) {
+	candidates := []AliasCandidate{}
+
+	query := "SELECT id, server, alias, item_name, occurrences, share, status, updated_at " +
+		"FROM alias_candidates " +
+		"WHERE server = ? " +
+		"AND status = ? " +
+		"ORDER BY occurrences DESC, alias ASC " +
+		"LIMIT ?"
+
+	rows, err := DB.QueryContext(ctx, query, server, status, limit)
+	if err != nil {
+		return candidates, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var c AliasCandidate
+		if err := rows.Scan(&c.id, &c.server, &c.alias, &c.itemName, &c.occurrences, &c.share, &c.status, &c.updatedAt); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+		return candidates, ErrDatabaseRead
+	}
+	return candidates, nil
+}
+
+// Approves or rejects one of the server's pending candidates, returns false if
+// the server has no such candidate waiting for review
+func ReviewAliasCandidate(ctx context.Context, server string, id int64, status string) (bool, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT id FROM alias_candidates WHERE id = ? AND server = ? AND status = ?", id, server, ALIAS_PENDING)
+	if err != nil {
+		return false, ErrDatabaseRead
+	}
+	exists := rows.Next()
+	DB.CloseRows(rows)
+	if !exists {
+		return false, nil
+	}
+
+	rows, err = DB.QueryContext(ctx, "UPDATE alias_candidates SET status = ?, updated_at = ? WHERE id = ?", status, time.Now().UTC(), id)
+	if err != nil {
+		LoggerFrom(ctx).Error("Failed to review alias candidate", "id", id, "err", err)
+		return false, ErrDatabaseWrite
+	}
+	DB.CloseRows(rows)
+
+	// Approved aliases take effect once the corpus is next loaded
+	if status == ALIAS_APPROVED {
+		Corpus.Reload()
+	}
+	LoggerFrom(ctx).Info("Reviewed alias candidate", "id", id, "status", status)
+	return true, nil
+}
+
+func (a AliasCandidate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Id          int64     `json:"id"`
+		Server      string    `json:"server"`
+		Alias       string    `json:"alias"`
+		Item        string    `json:"item"`
+		Occurrences int64     `json:"occurrences"`
+		Share       float64   `json:"share"`
+		Status      string    `json:"status"`
+		UpdatedAt   time.Time `json:"updated_at"`
+	}{a.id, a.server, a.alias, a.itemName, a.occurrences, a.share, a.status, a.updatedAt})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Auction
+ |--------------------------------------------------------------------------
+ |
+ | Represents an item a character put up for auction. Lines copied from the
+ | EQ log look like [Mon Oct 16 21:04:11 2017] Soandso auctions, 'WTS ...',
+ | when a line is in that form we record who auctioned each item in it so
+ | that traders can be profiled. Lines without a seller aren't recorded
+ |
+ | @member server (string): The tenant the auction was reported to
+ | @member seller (string): Name of the character who auctioned the item
+ | @member itemId (int64): The item, or 0 if it didn't resolve
+ | @member itemName (string): Name of the item as matched from the line
+ | @member price (sql.NullFloat64): The advertised price, if there was one
+ | @member line (string): The raw auction line
+ | @member auctionedAt (time.Time): When the item was auctioned, taken from
+ | the log timestamp if there is one
+ |
+ */
+
+type Auction struct {
+	id int64
+	server string
+	seller string
+	itemId int64
+	itemName string
+	price sql.NullFloat64
+	line string
+	auctionedAt time.Time
+}
+
+// An auction line split into its parts, seller is empty if the line isn't a log line
+type AuctionLine struct {
+	seller string
+	message string
+	auctionedAt time.Time
+}
+
+var auctionLineRegex = regexp.MustCompile(`^(?:\[([^\]]+)\]\s*)?([A-Za-z]+) auctions?, '(.*)'$`)
+
+// The timestamp format the EQ client writes to its logs
+const EQ_LOG_TIME_FORMAT = "Mon Jan 02 15:04:05 2006"
+
+// Splits an EQ log line into the seller and what they said, lines which aren't
+// in the log format are returned as the message with no seller
+func ParseAuctionLine(rawLine string) AuctionLine {
+	line := AuctionLine{ message: strings.TrimSpace(rawLine), auctionedAt: time.Now() }
+
+	// Our own auctions are logged as "You auction", we don't know who "You" is
+	matches := auctionLineRegex.FindStringSubmatch(line.message)
+	if len(matches) == 0 || strings.EqualFold(matches[2], "you") {
+		if len(matches) > 0 {
+			line.message = strings.TrimSpace(matches[3])
+		}
+		return line
+	}
+
+	line.seller = NormaliseSeller(matches[2])
+	line.message = strings.TrimSpace(matches[3])
+	if matches[1] != "" {
+		if at, err := time.ParseInLocation(EQ_LOG_TIME_FORMAT, matches[1], time.Local); err == nil {
+			line.auctionedAt = at
+		} else {
+			Log.Debug("Couldn't parse log timestamp", "timestamp", matches[1])
+		}
+	}
+	return line
+}
+
+// Character names are a single word with only the first letter capitalised
+func NormaliseSeller(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[0:1]) + name[1:]
+}
+
+func (a *Auction) Save() {
+	query := "INSERT INTO auctions " +
+		"(server, seller, item_id, item_name, price, line, auctioned_at) " +
+		"VALUES (?, ?, NULLIF(?, 0), ?, ?, ?, ?)"
+
+	id, err := DB.Insert(query, a.server, a.seller, a.itemId, a.itemName, a.price, a.line, a.auctionedAt)
+	if err != nil {
+		Log.Error("Failed to save auction", "err", err)
+	} else {
+		a.id = id
+	}
+}
+
+// Returns a page of everything the seller has auctioned on the tenant's server,
+// newest first, along with the total number of auctions they have made
+func FetchSellerAuctions(server string, seller string, page int, perPage int) ([]Auction, int64) {
+	auctions := []Auction{}
+	var total int64
+
+	rows, err := DB.Query("SELECT COUNT(*) FROM auctions WHERE server = ? AND seller = ?", server, seller)
+	if err == nil {
+		for rows.Next() {
+			if err := rows.Scan(&total); err != nil {
+				Log.Error("Scan failed", "err", err)
+			}
+		}
+		DB.CloseRows(rows)
+	}
+
+	query := "SELECT id, server, seller, item_id, item_name, price, line, auctioned_at " +
+		"FROM auctions " +
+		"WHERE server = ? " +
+		"AND seller = ? " +
+		"ORDER BY auctioned_at DESC, id DESC " +
+		"LIMIT ? OFFSET ?"
+
+	rows, _ = DB.Query(query, server, seller, perPage, (page-1)*perPage)
+	if rows == nil {
+		return auctions, total
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var (
+			a Auction
+			itemId sql.NullInt64
+		)
+		err := rows.Scan(&a.id, &a.server, &a.seller, &itemId, &a.itemName, &a.price, &a.line, &a.auctionedAt)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		a.itemId = itemId.Int64
+		auctions = append(auctions, a)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+
+	return auctions, total
+}
+
+// Returns the latest auctions of the item on the tenant's server, newest first
+func FetchItemAuctions(server string, itemId int64, limit int) []Auction {
+	auctions := []Auction{}
+
+	query := "SELECT id, server, seller, item_id, item_name, price, line, auctioned_at " +
+		"FROM auctions " +
+		"WHERE server = ? " +
+		"AND item_id = ? " +
+		"ORDER BY auctioned_at DESC, id DESC " +
+		"LIMIT ?"
+
+	rows, err := DB.Query(query, server, itemId, limit)
+	if err != nil {
+		return auctions
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var a Auction
+		err := rows.Scan(&a.id, &a.server, &a.seller, &a.itemId, &a.itemName, &a.price, &a.line, &a.auctionedAt)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		auctions = append(auctions, a)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+
+	return auctions
+}
+
+func (a Auction) MarshalJSON() ([]byte, error) {
+	var itemId *int64
+	if a.itemId > 0 {
+		itemId = &a.itemId
+	}
+
+	return json.Marshal(struct {
+		Id          int64     `json:"id"`
+		Seller      string    `json:"seller"`
+		ItemId      *int64    `json:"itemId"`
+		ItemName    string    `json:"itemName"`
+		Price       *float64  `json:"price"`
+		Line        string    `json:"line"`
+		AuctionedAt time.Time `json:"auctionedAt"`
+	}{a.id, a.seller, itemId, a.itemName, nullFloatPointer(a.price), a.line, a.auctionedAt})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ChatSummary
+ |--------------------------------------------------------------------------
+ |
+ | Represents an item as plain text lines which can be pasted straight into
+ | EQ chat. The client drops anything outside of printable ASCII and cuts
+ | messages off at its length limit, so stats are packed into as few lines
+ | as fit rather than one per line
+ |
+ | @member name (string): The item's display name
+ | @member stats ([]string): One entry per stat and effect
+ | @member maxLength (int): Longest line we're allowed to return
+ |
+ */
+
+type ChatSummary struct {
+	name string
+	stats []string
+	maxLength int
+}
+
+// Longest message the EQ client will send without truncating it
+const EQ_CHAT_LINE_LIMIT = 240
+
+// Lines shorter than this can't fit a stat and its separator
+const EQ_CHAT_LINE_MIN = 40
+
+const CHAT_STAT_SEPARATOR = " | "
+
+func NewChatSummary(item Item, maxLength int) ChatSummary {
+	s := ChatSummary{
+		name: chatSafe(strings.Replace(item.displayName, "_", " ", -1)),
+		maxLength: maxLength,
+	}
+
+	// Effects carry wiki markup in their stat so are listed from the effects instead
+	for _, stat := range item.statistics {
+		if stat.code != "EFFECT" {
+			s.stats = append(s.stats, chatSafe(stat.Text()))
+		}
+	}
+	for _, effect := range item.effects {
+		s.stats = append(s.stats, chatSafe(strings.TrimSpace("Effect: " + effect.name + " " + effect.restriction)))
+	}
+	return s
+}
+
+// The name followed by the stats, each line is at most maxLength long. The odd
+// stat which is too long for a line on its own is cut short
+func (s ChatSummary) Lines() []string {
+	lines := []string{}
+	line := truncateChatLine(s.name + ":", s.maxLength)
+	separator := " "
+
+	for _, stat := range s.stats {
+		if len(line) + len(separator) + len(stat) <= s.maxLength {
+			line += separator + stat
+		} else {
+			lines = append(lines, line)
+			line = truncateChatLine(stat, s.maxLength)
+		}
+		separator = CHAT_STAT_SEPARATOR
+	}
+	return append(lines, line)
+}
+
+func (s ChatSummary) String() string {
+	return strings.Join(s.Lines(), "\n")
+}
+
+// Replaces anything the client can't display, collapsing whitespace so wiki
+// line breaks don't end up in the middle of a message
+func chatSafe(text string) string {
+	safe := strings.Map(func(r rune) rune {
+		switch {
+		case r == '’' || r == '‘':
+			return '\''
+		case r == '“' || r == '”':
+			return '"'
+		case r == '–' || r == '—':
+			return '-'
+		case r < ' ' || r > '~':
+			return ' '
+		}
+		return r
+	}, text)
+	return strings.Join(strings.Fields(safe), " ")
+}
+
+func truncateChatLine(line string, maxLength int) string {
+	if len(line) > maxLength {
+		return line[:maxLength-3] + "..."
+	}
+	return line
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: DiscordEmbed
+ |--------------------------------------------------------------------------
+ |
+ | Represents an item formatted as a Discord embed, most of our consumers
+ | are price check bots so this saves each of them building the same
+ | summary. The JSON is Discord's embed object and can be posted as is
+ |
+ | @member title (string): The item's display name
+ | @member url (string): Link to the item on the wiki
+ | @member iconUrl (string): Absolute url of the item's icon, if it has one
+ | @member stats ([]string): One line per stat and effect
+ | @member price (*float64): Average observed price in platinum
+ | @member priceCount (int64): Number of auctions the price is based on
+ | @member vendorPrice (*float64): What vendors sell the item for
+ |
+ */
+
+type DiscordEmbed struct {
+	title string
+	url string
+	iconUrl string
+	stats []string
+	price *float64
+	priceCount int64
+	vendorPrice *float64
+}
+
+// Discord rejects embeds whose description is longer than this
+const DISCORD_DESCRIPTION_LIMIT = 4096
+
+// Colour down the side of the embed
+const DISCORD_EMBED_COLOUR = 0xC9A227
+
+func NewDiscordEmbed(item Item, summary PriceSummary) DiscordEmbed {
+	e := DiscordEmbed{
+		title: strings.Replace(item.displayName, "_", " ", -1),
+		url: Settings.WikiBaseUrl + "/" + TitleCase(item.name, true),
+		price: nullFloatPointer(summary.average),
+		priceCount: summary.count,
+		vendorPrice: nullFloatPointer(item.vendorSellPrice),
+	}
+	if item.imageSrc != "" {
+		e.iconUrl = Settings.WikiBaseUrl + item.imageSrc
+	}
+
+	// Effects carry wiki markup in their stat so are listed from the effects instead
+	for _, stat := range item.statistics {
+		if stat.code != "EFFECT" {
+			e.stats = append(e.stats, stat.Text())
+		}
+	}
+	for _, effect := range item.effects {
+		e.stats = append(e.stats, strings.TrimSpace("Effect: " + effect.name + " " + effect.restriction))
+	}
+	return e
+}
+
+func (e DiscordEmbed) description() string {
+	description := strings.Join(e.stats, "\n")
+	if len(description) > DISCORD_DESCRIPTION_LIMIT {
+		description = description[:DISCORD_DESCRIPTION_LIMIT-3] + "..."
+	}
+	return description
+}
+
+type discordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordEmbedImage struct {
+	Url string `json:"url"`
+}
+
+func (e DiscordEmbed) MarshalJSON() ([]byte, error) {
+	price := "No recent auctions"
+	if e.price != nil {
+		price = formatPlatinum(*e.price) + " (" + strconv.FormatInt(e.priceCount, 10) + " auctions)"
+	}
+	fields := []discordEmbedField{{ "Price estimate", price, true }}
+	if e.vendorPrice != nil {
+		fields = append(fields, discordEmbedField{ "Vendor price", formatPlatinum(*e.vendorPrice), true })
+	}
+
+	var thumbnail *discordEmbedImage
+	if e.iconUrl != "" {
+		thumbnail = &discordEmbedImage{ e.iconUrl }
+	}
+
+	return json.Marshal(struct {
+		Title       string              `json:"title"`
+		Url         string              `json:"url"`
+		Description string              `json:"description"`
+		Color       int                 `json:"color"`
+		Thumbnail   *discordEmbedImage  `json:"thumbnail,omitempty"`
+		Fields      []discordEmbedField `json:"fields"`
+	}{e.title, e.url, e.description(), DISCORD_EMBED_COLOUR, thumbnail, fields})
+}
+
+// Prices under 1pp are written in copper, silver and gold would be more
+// precise but nobody price checks anything that cheap
+func formatPlatinum(price float64) string {
+	if price < 1 {
+		return strconv.FormatFloat(price * 1000, 'f', 0, 64) + "cp"
+	}
+	return strconv.FormatFloat(math.Round(price * 100) / 100, 'f', -1, 64) + "pp"
+}
@@ -1,8 +1,205 @@
 package main
 
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
 type Effect struct {
 	uri string
 	name string
 	restriction string // Worn, Must Equip etc.
-	description string
-}
\ No newline at end of file
+	description string // From the effect's spell page, once it's been scraped
+	mana sql.NullInt64 // Mana cost on the effect's spell page
+	duration string // Duration as written on the effect's spell page
+	effectType string // One of the EFFECT_TYPE constants, worked out from the restriction
+	triggerLevel sql.NullInt64 // Level needed for the effect to work, if it has one
+	castTime sql.NullFloat64 // Seconds a clicky takes to cast, 0 for instant
+	charges sql.NullInt64 // Charges a clicky has, null when unlimited or not a clicky
+}
+
+// The shape effects take in responses, type is one of the EFFECT_TYPE constants
+type EffectResponse struct {
+	Name         string   `json:"name"`
+	Uri          string   `json:"uri"`
+	Type         string   `json:"type"`
+	Restriction  string   `json:"restriction"`
+	TriggerLevel *int64   `json:"triggerLevel"`
+	CastTime     *float64 `json:"castTime"`
+	Charges      *int64   `json:"charges"`
+	Description  string   `json:"description"`
+	Mana         *int64   `json:"mana"`
+	Duration     string   `json:"duration"`
+}
+
+const (
+	EFFECT_TYPE_CLICKY  = "clicky"
+	EFFECT_TYPE_PROC    = "proc"
+	EFFECT_TYPE_WORN    = "worn"
+	EFFECT_TYPE_UNKNOWN = "unknown"
+)
+
+var (
+	effectLevelRegex = regexp.MustCompile(`(?i)\blevel:?\s*([0-9]+)`)
+	effectCastTimeRegex = regexp.MustCompile(`(?i)casting time:\s*(instant|[0-9]+(?:\.[0-9]+)?)`)
+	effectChargesRegex = regexp.MustCompile(`(?i)charges:\s*([0-9]+)`)
+)
+
+// Works out what triggers the effect from its restriction, which reads like
+// "(Combat)", "(Worn)" or "(Any Slot, Casting Time: Instant)"
+func (e Effect) Type() string {
+	if e.effectType != "" {
+		return e.effectType
+	}
+
+	restriction := strings.ToLower(e.restriction)
+	switch {
+	case strings.Contains(restriction, "casting time"):
+		return EFFECT_TYPE_CLICKY
+	case strings.Contains(restriction, "combat"):
+		return EFFECT_TYPE_PROC
+	case strings.Contains(restriction, "worn"):
+		return EFFECT_TYPE_WORN
+	default:
+		return EFFECT_TYPE_UNKNOWN
+	}
+}
+
+// Reads the type, trigger level, cast time and any charges out of the
+// restriction, i.e. "(Combat, Level 20)" or "(Any Slot, Casting Time: 3.0)"
+func (e *Effect) parseRestriction() {
+	// Cleared first so Type works it out from the restriction
+	e.effectType = ""
+	e.effectType = e.Type()
+
+	if match := effectLevelRegex.FindStringSubmatch(e.restriction); match != nil {
+		e.triggerLevel = parseNullInt(match[1])
+	}
+	if match := effectCastTimeRegex.FindStringSubmatch(e.restriction); match != nil {
+		if strings.EqualFold(match[1], "instant") {
+			e.castTime = sql.NullFloat64{ Float64: 0, Valid: true }
+		} else if seconds, err := strconv.ParseFloat(match[1], 64); err == nil {
+			e.castTime = sql.NullFloat64{ Float64: seconds, Valid: true }
+		}
+	}
+	if match := effectChargesRegex.FindStringSubmatch(e.restriction); match != nil {
+		e.charges = parseNullInt(match[1])
+	}
+}
+
+func (e Effect) Response() EffectResponse {
+	return EffectResponse{ e.name, e.uri, e.Type(), e.restriction, nullIntPointer(e.triggerLevel),
+		nullFloatPointer(e.castTime), nullIntPointer(e.charges), e.description, nullIntPointer(e.mana), e.duration }
+}
+
+// Rebuilds the effect a response was made from, used to import exported items
+func effectFromResponse(r EffectResponse) Effect {
+	e := Effect{ name: r.Name, uri: r.Uri, effectType: r.Type, restriction: r.Restriction,
+		description: r.Description, duration: r.Duration, castTime: nullFloatFromPointer(r.CastTime) }
+	if r.TriggerLevel != nil {
+		e.triggerLevel = sql.NullInt64{ Int64: *r.TriggerLevel, Valid: true }
+	}
+	if r.Charges != nil {
+		e.charges = sql.NullInt64{ Int64: *r.Charges, Valid: true }
+	}
+	if r.Mana != nil {
+		e.mana = sql.NullInt64{ Int64: *r.Mana, Valid: true }
+	}
+	return e
+}
+
+func (e Effect) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.Response())
+}
+
+// Scores how useful an item's clickable effect is so rankings can favour
+// utility items. Restrictions read like "(Any Slot, Casting Time: Instant)" and
+// only clickies have a casting time. A clicky scores 1, an instant clicky 2,
+// plus 1 for unlimited charges, which fail to parse so are stored without a value
+const clickyScoreSQL = "(SELECT COALESCE(MAX(CASE WHEN item_effects.restriction LIKE '%Casting Time%' " +
+	"THEN IF(item_effects.restriction LIKE '%Instant%', 2, 1) ELSE 0 END), 0) " +
+	"FROM item_effects WHERE item_effects.item_id = items.id) + " +
+	"(SELECT COUNT(*) FROM statistics WHERE statistics.item_id = items.id " +
+	"AND statistics.code = 'CHARGES' AND statistics.value IS NULL)"
+
+// Upper bound of the clicky_weight parameter on the ranking endpoints
+const MAX_CLICKY_WEIGHT = 1000
+
+// Effects waiting on their spell page, once it's full effects are left for the
+// next time an item with them is saved
+const EFFECT_QUEUE_SIZE = 1000
+
+var effectQueue = make(chan Effect, EFFECT_QUEUE_SIZE)
+
+// Queues the item's effects to have their details scraped from their spell
+// pages, effects whose details we already have are skipped when they're taken
+// off the queue
+func QueueEffectDetails(item Item) {
+	if !Settings.ScrapeEffectDetails || Settings.ReadOnly {
+		return
+	}
+	for _, effect := range item.effects {
+		if effect.name == "" || effect.uri == "" {
+			continue
+		}
+		select {
+		case effectQueue <- effect:
+		default:
+		}
+	}
+}
+
+// Scrapes queued effects until we shut down
+func ScrapeEffectDetails() {
+	for {
+		select {
+		case effect := <-effectQueue:
+			scraped, err := effectDetailsScraped(AppContext, effect.name)
+			if err != nil || scraped {
+				continue
+			}
+			if _, err := StoreEffectDetails(AppContext, effect); err != nil && err != context.Canceled {
+				Log.Warn("Failed to scrape effect details", "effect", effect.name, "uri", effect.uri, "err", err)
+			}
+		case <-AppContext.Done():
+			return
+		}
+	}
+}
+
+// Fetches the effect's spell page and stores its description, mana cost and
+// duration against the effect. Effects whose page is missing are marked as
+// scraped so they aren't fetched again
+func StoreEffectDetails(ctx context.Context, e Effect) (Effect, error) {
+	body, _, err := FetchResolvedWikiPage(ctx, wikiUriFromTitle(strings.TrimPrefix(e.uri, "/")))
+	if err != nil && err != ErrPageNotFound && err != ErrNotAnItemPage {
+		return e, err
+	}
+	if err == nil {
+		var spell Spell
+		spell.extractSpellDetails(body)
+		e.description = spell.description
+		e.mana = spell.mana
+		e.duration = spell.duration
+	}
+
+	query := "UPDATE effects SET description = ?, mana = ?, duration = ?, scraped_at = NOW() WHERE name = ?"
+	if _, err := DB.InsertContext(ctx, query, e.description, e.mana, e.duration, e.name); err != nil {
+		return e, ErrDatabaseWrite
+	}
+	LoggerFrom(ctx).Debug("Stored effect details", "effect", e.name, "found", err == nil)
+	return e, nil
+}
+
+func effectDetailsScraped(ctx context.Context, name string) (bool, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT 1 FROM effects WHERE name = ? AND scraped_at IS NOT NULL", name)
+	if err != nil {
+		return false, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+	return rows.Next(), nil
+}
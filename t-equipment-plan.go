@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: EquipmentPlan
+ |--------------------------------------------------------------------------
+ |
+ | Represents the best items a class can equip in each slot, ranked by a
+ | single stat. This is the server side primitive behind a gear planner.
+ | When clickyWeight is set each point of clicky score is worth that much of
+ | the stat, so utility items can outrank items with slightly better stats
+ |
+ | @member class (string): Class abbreviation i.e. WAR
+ | @member stat (string): The stat code items were ranked by i.e. AC
+ | @member clickyWeight (int): How much of the stat a point of clicky score is worth
+ | @member slots (map[string][]PlannedItem): Top items for each slot
+ |
+ */
+
+type EquipmentPlan struct {
+	class string
+	stat string
+	clickyWeight int
+	slots map[string][]PlannedItem
+}
+
+type PlannedItem struct {
+	value float64
+	clickyScore int
+	item Item
+}
+
+// Returns the abbreviation for a class, accepting either the full class name or
+// the abbreviation itself. Returns false if the class doesn't exist
+func NormaliseClass(class string) (string, bool) {
+	class = strings.ToLower(strings.TrimSpace(class))
+	if abbreviation, exists := classAbbreviations[class]; exists {
+		return abbreviation, true
+	}
+	for _, abbreviation := range classAbbreviations {
+		if strings.EqualFold(class, abbreviation) {
+			return abbreviation, true
+		}
+	}
+	return "", false
+}
+
+// Builds the plan for a class, keeping the top perSlot items for every slot.
+// Items worn in several slots (i.e. PRIMARY SECONDARY) are listed under each.
+// With a clickyWeight, items without the stat are included if they have a clicky
+func FetchEquipmentPlan(class string, stat string, perSlot int, clickyWeight int) EquipmentPlan {
+	plan := EquipmentPlan{ class: class, stat: stat, clickyWeight: clickyWeight, slots: make(map[string][]PlannedItem) }
+
+	query := "SELECT items.id, items.name, items.displayName, items.imageSrc, slot.slot, " +
+		"COALESCE(ranked.value, 0), " + clickyScoreSQL + " " +
+		"FROM items " +
+		"INNER JOIN item_slots AS slot " +
+		"ON slot.item_id = items.id " +
+		"LEFT JOIN statistics AS ranked " +
+		"ON ranked.item_id = items.id " +
+		"AND ranked.code = ? " +
+		"WHERE EXISTS (SELECT 1 FROM item_classes WHERE item_classes.item_id = items.id AND item_classes.class = ?) " +
+		"AND (ranked.value IS NOT NULL OR (? > 0 AND " + clickyScoreSQL + " > 0)) " +
+		"ORDER BY COALESCE(ranked.value, 0) + ? * " + clickyScoreSQL + " DESC, items.name ASC"
+
+	rows, err := DB.Query(query, stat, class, clickyWeight, clickyWeight)
+	if err != nil {
+		return plan
+	}
+
+	for rows.Next() {
+		var (
+			p PlannedItem
+			imageSrc sql.NullString
+			slots sql.NullString
+		)
+		err := rows.Scan(&p.item.id, &p.item.name, &p.item.displayName, &imageSrc, &slots, &p.value, &p.clickyScore)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		p.item.imageSrc = imageSrc.String
+
+		for _, slot := range strings.Fields(strings.ToUpper(slots.String)) {
+			if len(plan.slots[slot]) < perSlot {
+				plan.slots[slot] = append(plan.slots[slot], p)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+	DB.CloseRows(rows)
+
+	// Only hydrate the stats of the items that made the cut
+	var items []Item
+	seen := make(map[int64]bool)
+	for _, planned := range plan.slots {
+		for _, p := range planned {
+			if !seen[p.item.id] {
+				seen[p.item.id] = true
+				items = append(items, p.item)
+			}
+		}
+	}
+	attachStatistics(items)
+
+	statistics := make(map[int64][]Statistic)
+	for _, item := range items {
+		statistics[item.id] = item.statistics
+	}
+	for _, planned := range plan.slots {
+		for idx := range planned {
+			planned[idx].item.statistics = statistics[planned[idx].item.id]
+		}
+	}
+
+	return plan
+}
+
+func (p EquipmentPlan) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Class        string                   `json:"class"`
+		Stat         string                   `json:"stat"`
+		ClickyWeight int                      `json:"clickyWeight"`
+		Slots        map[string][]PlannedItem `json:"slots"`
+	}{p.class, p.stat, p.clickyWeight, p.slots})
+}
+
+func (p PlannedItem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Value       float64 `json:"value"`
+		ClickyScore int     `json:"clickyScore"`
+		Item        Item    `json:"item"`
+	}{p.value, p.clickyScore, p.item})
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Faction
+ |--------------------------------------------------------------------------
+ |
+ | Represents a faction as described by its wiki page, the NPCs who belong
+ | to it and the quests which raise or lower it. Merchants are the members
+ | we have an inventory for, and quests include any of ours whose steps
+ | involve a member, so both are looked up rather than scraped
+ |
+ | @member id (int64): Primary key of the factions row
+ | @member name (string): Name of the faction
+ | @member npcs ([]string): Names of the NPCs who belong to the faction
+ | @member quests ([]string): Names of the quests which affect the faction
+ | @member merchants ([]string): Members who sell items, only set by fetchLinks
+ | @member items ([]ItemFaction): Items which require the faction, only set by fetchLinks
+ | @member scrapedAt (time.Time): When the page was last scraped
+ |
+ */
+
+type Faction struct {
+	id int64
+	name string
+	npcs []string
+	quests []string
+	merchants []string
+	items []ItemFaction
+	scrapedAt time.Time
+}
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ItemFaction
+ |--------------------------------------------------------------------------
+ |
+ | Represents the standing an item page says is needed with a faction,
+ | usually to buy the item or to be given it by a quest
+ |
+ | @member itemName (string): Name of the item
+ | @member factionName (string): Name of the faction
+ | @member standing (string): The standing needed i.e. Amiable
+ |
+ */
+
+type ItemFaction struct {
+	itemName string
+	factionName string
+	standing string
+}
+
+// Standings as the wiki writes them mapped to the con the client shows
+var factionStandings = map[string]string{
+	"ally": "Ally", "allies": "Ally",
+	"warmly": "Warmly", "warm": "Warmly",
+	"kindly": "Kindly", "kind": "Kindly",
+	"amiable": "Amiable", "amiably": "Amiable",
+	"indifferent": "Indifferent", "indifferently": "Indifferent",
+	"apprehensive": "Apprehensive", "apprehensively": "Apprehensive",
+	"dubious": "Dubious", "dubiously": "Dubious",
+	"threatening": "Threatening", "threateningly": "Threatening",
+}
+
+// Matches "Requires Amiable faction with the Deepwater Knights" and "must be at
+// least kindly with Clan Runnyeye" along with "Faction: Warmly with ...". Faction
+// names are capitalised, so the name ends at the first lower case word other
+// than of, the or and
+var itemFactionRegex = regexp.MustCompile(`(?i:(?:requires?|must be|faction(?: required)?:)\s+(?:at least\s+)?(ally|allies|warmly|warm|kindly|kind|amiably|amiable|indifferently|indifferent|apprehensively|apprehensive|dubiously|dubious|threateningly|threatening)\s+(?:faction\s+|standing\s+)?(?:with|to|from|among)\s+(?:the\s+)?)([A-Z][A-Za-z'-]*(?:\s+(?:(?:of|the|and)\s+)*[A-Z][A-Za-z'-]*)*)`)
+
+// Parses the faction requirements from an item page's text, returns an empty
+// list if the item doesn't need any faction
+func ParseItemFactions(itemName string, text string) []ItemFaction {
+	factions := []ItemFaction{}
+	seen := make(map[string]bool)
+	for _, match := range itemFactionRegex.FindAllStringSubmatch(text, -1) {
+		factionName := strings.TrimSpace(match[2])
+		if seen[strings.ToLower(factionName)] {
+			continue
+		}
+		seen[strings.ToLower(factionName)] = true
+		factions = append(factions, ItemFaction{
+			itemName: itemName,
+			factionName: factionName,
+			standing: factionStandings[strings.ToLower(match[1])],
+		})
+	}
+	return factions
+}
+
+// Replaces the item's faction requirements, skipped if the item wasn't scraped
+func (i *Item) saveFactions(tx *Tx, id int64) error {
+	if i.factions == nil {
+		return nil
+	}
+
+	rows, err := tx.Query("DELETE FROM item_factions WHERE item_id = ?", id)
+	if err != nil {
+		return ErrDatabaseWrite
+	}
+	DB.CloseRows(rows)
+
+	if len(i.factions) == 0 {
+		return nil
+	}
+
+	var parameters []interface{}
+	query := "INSERT INTO item_factions " +
+		"(item_id, faction_name, standing) " +
+		"VALUES "
+	for _, faction := range i.factions {
+		query += "(?, ?, ?),"
+		parameters = append(parameters, id, faction.factionName, faction.standing)
+	}
+	query = query[0:len(query)-1]
+
+	if _, err := tx.Insert(query, parameters...); err != nil {
+		return ErrDatabaseWrite
+	}
+	return nil
+}
+
+// Parses a faction page, returns false if it lists no NPCs or quests as it's
+// then unlikely to be a faction
+func ParseFaction(name string, body string) (Faction, bool) {
+	faction := Faction{ name: name, npcs: []string{}, quests: []string{} }
+
+	document, err := ParseHtml(body)
+	if err != nil {
+		Log.Error("Failed to parse html", "err", err)
+		return faction, false
+	}
+
+	collect := func(list *[]string, ids ...string) {
+		seen := make(map[string]bool)
+		for _, section := range SectionNodes(document, ids...) {
+			for _, item := range FindAll(section, ByTag("li")) {
+				link := FindFirst(item, ByTag("a"))
+				if link == nil {
+					continue
+				}
+				linked := LinkTitle(link)
+				if linked != "" && !seen[strings.ToLower(linked)] {
+					seen[strings.ToLower(linked)] = true
+					*list = append(*list, linked)
+				}
+			}
+		}
+	}
+	collect(&faction.npcs, "NPCs", "Members", "Faction_Members", "Associated_NPCs")
+	collect(&faction.quests, "Quests", "Related_Quests", "Faction_Quests")
+
+	return faction, len(faction.npcs) > 0 || len(faction.quests) > 0
+}
+
+// Saves the faction and replaces its NPCs and quests
+func (f *Faction) Save(ctx context.Context) error {
+	return DB.Transaction(ctx, func(tx *Tx) error {
+		query := "INSERT INTO factions (name, scraped_at) " +
+			"VALUES (?, ?) " +
+			"ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), scraped_at = VALUES(scraped_at)"
+
+		f.scrapedAt = time.Now().UTC()
+		id, err := tx.Insert(query, f.name, f.scrapedAt)
+		if err != nil || id <= 0 {
+			LoggerFrom(ctx).Error("Failed to save faction", "faction", f.name, "err", err)
+			return ErrDatabaseWrite
+		}
+		f.id = id
+
+		for _, table := range []string{"faction_npcs", "faction_quests"} {
+			rows, err := tx.Query("DELETE FROM " + table + " WHERE faction_id = ?", f.id)
+			if err != nil {
+				return ErrDatabaseWrite
+			}
+			DB.CloseRows(rows)
+		}
+
+		if len(f.npcs) > 0 {
+			var parameters []interface{}
+			query = "INSERT INTO faction_npcs (faction_id, npc_name) VALUES "
+			for _, npcName := range f.npcs {
+				query += "(?, ?),"
+				parameters = append(parameters, f.id, npcName)
+			}
+			if _, err := tx.Insert(query[0:len(query)-1], parameters...); err != nil {
+				return ErrDatabaseWrite
+			}
+		}
+		if len(f.quests) > 0 {
+			var parameters []interface{}
+			query = "INSERT INTO faction_quests (faction_id, quest_name) VALUES "
+			for _, questName := range f.quests {
+				query += "(?, ?),"
+				parameters = append(parameters, f.id, questName)
+			}
+			if _, err := tx.Insert(query[0:len(query)-1], parameters...); err != nil {
+				return ErrDatabaseWrite
+			}
+		}
+
+		LoggerFrom(ctx).Info("Saved faction", "faction", f.name, "id", f.id, "npcs", len(f.npcs), "quests", len(f.quests))
+		return nil
+	})
+}
+
+// Returns the stored faction, returns false if we haven't scraped it
+func FetchFaction(ctx context.Context, name string) (Faction, bool, error) {
+	faction := Faction{ name: name, npcs: []string{}, quests: []string{} }
+
+	rows, err := DB.QueryContext(ctx, "SELECT id, name, scraped_at FROM factions WHERE name = ? LIMIT 1", name)
+	if err != nil {
+		return faction, false, ErrDatabaseRead
+	}
+	exists := false
+	for rows.Next() {
+		if err := rows.Scan(&faction.id, &faction.name, &faction.scrapedAt); err != nil {
+			Log.Error("Scan failed", "err", err)
+			DB.CloseRows(rows)
+			return faction, false, ErrDatabaseRead
+		}
+		exists = true
+	}
+	DB.CloseRows(rows)
+	if !exists {
+		return faction, false, nil
+	}
+
+	if faction.npcs, err = fetchNames(ctx, "SELECT npc_name FROM faction_npcs WHERE faction_id = ? ORDER BY npc_name ASC", faction.id); err != nil {
+		return faction, false, err
+	}
+	if faction.quests, err = fetchNames(ctx, "SELECT quest_name FROM faction_quests WHERE faction_id = ? ORDER BY quest_name ASC", faction.id); err != nil {
+		return faction, false, err
+	}
+	return faction, true, nil
+}
+
+// Looks up the merchants and items affected by the faction, and adds quests
+// of ours which involve its members to those its page lists
+func (f *Faction) fetchLinks(ctx context.Context) error {
+	var err error
+
+	query := "SELECT DISTINCT merchant_inventory.merchant_name " +
+		"FROM merchant_inventory " +
+		"INNER JOIN faction_npcs ON faction_npcs.npc_name = merchant_inventory.merchant_name " +
+		"WHERE faction_npcs.faction_id = ? " +
+		"ORDER BY 1 ASC"
+	if f.merchants, err = fetchNames(ctx, query, f.id); err != nil {
+		return err
+	}
+
+	query = "SELECT DISTINCT quests.name " +
+		"FROM quest_steps " +
+		"INNER JOIN quests ON quests.id = quest_steps.quest_id " +
+		"INNER JOIN faction_npcs ON faction_npcs.npc_name = quest_steps.npc_name " +
+		"WHERE faction_npcs.faction_id = ? " +
+		"ORDER BY 1 ASC"
+	involved, err := fetchNames(ctx, query, f.id)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, questName := range f.quests {
+		seen[strings.ToLower(questName)] = true
+	}
+	for _, questName := range involved {
+		if !seen[strings.ToLower(questName)] {
+			f.quests = append(f.quests, questName)
+		}
+	}
+
+	f.items = []ItemFaction{}
+	query = "SELECT items.name, item_factions.faction_name, item_factions.standing " +
+		"FROM item_factions " +
+		"INNER JOIN items ON items.id = item_factions.item_id " +
+		"WHERE item_factions.faction_name = ? " +
+		"ORDER BY items.name ASC"
+	rows, err := DB.QueryContext(ctx, query, f.name)
+	if err != nil {
+		return ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+	for rows.Next() {
+		var item ItemFaction
+		if err := rows.Scan(&item.itemName, &item.factionName, &item.standing); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		f.items = append(f.items, item)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+		return ErrDatabaseRead
+	}
+	return nil
+}
+
+// Returns the single name column of every row the query returns
+func fetchNames(ctx context.Context, query string, parameters ...interface{}) ([]string, error) {
+	names := []string{}
+
+	rows, err := DB.QueryContext(ctx, query, parameters...)
+	if err != nil {
+		return names, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+		return names, ErrDatabaseRead
+	}
+	return names, nil
+}
+
+func (f Faction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name      string        `json:"name"`
+		Npcs      []string      `json:"npcs"`
+		Merchants []string      `json:"merchants"`
+		Quests    []string      `json:"quests"`
+		Items     []ItemFaction `json:"items"`
+		ScrapedAt time.Time     `json:"scraped_at"`
+	}{f.name, f.npcs, f.merchants, f.quests, f.items, f.scrapedAt})
+}
+
+func (i ItemFaction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Item     string `json:"item"`
+		Faction  string `json:"faction"`
+		Standing string `json:"standing"`
+	}{i.itemName, i.factionName, i.standing})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ItemAlias
+ |--------------------------------------------------------------------------
+ |
+ | Represents a nickname for an item which an admin has added by hand, i.e.
+ | "fungi" for Fungus Covered Scale Tunic or "ssoy" for Short Sword of
+ | Ykesha. Aliases are part of the name corpus so auction lines using them
+ | match the item exactly, and items looked up by an alias are served as
+ | the item it stands for. Approved alias candidates work the same way,
+ | but where both exist the alias added here wins. Aliases belong to a
+ | tenant and only match that tenant's lines and lookups
+ |
+ | @member id (int64): Primary key of the item_aliases row
+ | @member server (string): The tenant the alias belongs to
+ | @member alias (string): The nickname, in lower case
+ | @member itemName (string): Name of the item the nickname stands for
+ | @member createdAt (time.Time): When the alias was added or last changed
+ |
+ */
+
+type ItemAlias struct {
+	id int64
+	server string
+	alias string
+	itemName string
+	createdAt time.Time
+}
+
+// Aliases are matched against lowercased, space separated auction text, so
+// they're stored the same way
+func NormaliseAlias(alias string) string {
+	return strings.Join(strings.Fields(strings.ToLower(strings.Replace(alias, "_", " ", -1))), " ")
+}
+
+// Adds the alias for the server, or points it at a different item if the
+// server already has it. Returns false if the item doesn't exist, aliases are
+// only added for items we've scraped so the corpus can resolve them
+func StoreItemAlias(ctx context.Context, server string, alias string, itemName string) (ItemAlias, bool, error) {
+	a := ItemAlias{ server: server, alias: NormaliseAlias(alias), createdAt: time.Now().UTC() }
+
+	name := strings.Replace(strings.TrimSpace(itemName), "_", " ", -1)
+	rows, err := DB.QueryContext(ctx, "SELECT name FROM items WHERE name = ? OR displayName = ? LIMIT 1", name, TitleCase(name, true))
+	if err != nil {
+		return a, false, ErrDatabaseRead
+	}
+	exists := rows.Next()
+	if exists {
+		if err := rows.Scan(&a.itemName); err != nil {
+			Log.Error("Scan failed", "err", err)
+			DB.CloseRows(rows)
+			return a, false, ErrDatabaseRead
+		}
+	}
+	DB.CloseRows(rows)
+	if !exists {
+		return a, false, nil
+	}
+
+	query := "INSERT INTO item_aliases (server, alias, item_name, created_at) VALUES (?, ?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), item_name = VALUES(item_name), created_at = VALUES(created_at)"
+	id, err := DB.InsertContext(ctx, query, a.server, a.alias, a.itemName, a.createdAt)
+	if err != nil {
+		return a, false, ErrDatabaseWrite
+	}
+	a.id = id
+
+	// Other replicas pick the alias up once their corpus next goes stale
+	Corpus.Reload()
+	LoggerFrom(ctx).Info("Stored item alias", "server", a.server, "alias", a.alias, "item", a.itemName)
+	return a, true, nil
+}
+
+// Removes the server's alias, returns false if the server has no such alias
+func DeleteItemAlias(ctx context.Context, server string, id int64) (bool, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT id FROM item_aliases WHERE id = ? AND server = ?", id, server)
+	if err != nil {
+		return false, ErrDatabaseRead
+	}
+	exists := rows.Next()
+	DB.CloseRows(rows)
+	if !exists {
+		return false, nil
+	}
+
+	if _, err := DB.InsertContext(ctx, "DELETE FROM item_aliases WHERE id = ?", id); err != nil {
+		return false, ErrDatabaseWrite
+	}
+	Corpus.Reload()
+	LoggerFrom(ctx).Info("Deleted item alias", "id", id)
+	return true, nil
+}
+
+// Returns every alias the server has in alphabetical order, or only those of
+// itemName if it isn't empty
+func FetchItemAliases(ctx context.Context, server string, itemName string) ([]ItemAlias, error) {
+	aliases := []ItemAlias{}
+
+	query := "SELECT id, server, alias, item_name, created_at FROM item_aliases WHERE server = ? "
+	parameters := []interface{}{ server }
+	if itemName != "" {
+		query += "AND item_name = ? "
+		parameters = append(parameters, strings.Replace(itemName, "_", " ", -1))
+	}
+	query += "ORDER BY alias ASC"
+
+	rows, err := DB.QueryContext(ctx, query, parameters...)
+	if err != nil {
+		return aliases, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var a ItemAlias
+		if err := rows.Scan(&a.id, &a.server, &a.alias, &a.itemName, &a.createdAt); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		aliases = append(aliases, a)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+		return aliases, ErrDatabaseRead
+	}
+	return aliases, nil
+}
+
+func (a ItemAlias) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Id        int64     `json:"id"`
+		Server    string    `json:"server"`
+		Alias     string    `json:"alias"`
+		Item      string    `json:"item"`
+		CreatedAt time.Time `json:"createdAt"`
+	}{a.id, a.server, a.alias, a.itemName, a.createdAt})
+}
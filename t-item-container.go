@@ -0,0 +1,129 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ItemContainer
+ |--------------------------------------------------------------------------
+ |
+ | Represents what a bag can hold. The wiki writes this as "Capacity: 10
+ | Size Capacity: LARGE" on one line and "Weight Reduction: 50%" on
+ | another, which the stat parser used to split apart in different ways
+ | from page to page. Containers are parsed on their own and stored in
+ | the container_ columns of the item, and are also saved as CAPACITY,
+ | SIZE CAPACITY and WEIGHT REDUCTION stats so stat queries find them
+ |
+ | @member slots (int): How many items the bag holds
+ | @member maxSize (string): The largest size of item which fits, one of
+ | the CONTAINER_SIZE_ constants, empty if the page doesn't say
+ | @member weightReduction (float64): Percentage taken off the weight of
+ | what's inside
+ |
+ */
+
+type ItemContainer struct {
+	slots int
+	maxSize string
+	weightReduction float64
+}
+
+const (
+	CONTAINER_SIZE_TINY   = "TINY"
+	CONTAINER_SIZE_SMALL  = "SMALL"
+	CONTAINER_SIZE_MEDIUM = "MEDIUM"
+	CONTAINER_SIZE_LARGE  = "LARGE"
+	CONTAINER_SIZE_GIANT  = "GIANT"
+)
+
+// The wiki isn't consistent in how it writes sizes, older pages abbreviate them
+var containerSizes = map[string]string{
+	"tiny": CONTAINER_SIZE_TINY,
+	"small": CONTAINER_SIZE_SMALL, "sm": CONTAINER_SIZE_SMALL,
+	"medium": CONTAINER_SIZE_MEDIUM, "med": CONTAINER_SIZE_MEDIUM,
+	"large": CONTAINER_SIZE_LARGE, "lg": CONTAINER_SIZE_LARGE,
+	"giant": CONTAINER_SIZE_GIANT,
+}
+
+var (
+	containerCapacityRegex = regexp.MustCompile(`(?i)\b(size\s+)?capacity:\s*([0-9A-Za-z]+)`)
+	containerWeightReductionRegex = regexp.MustCompile(`(?i)\bweight\s+reduction:\s*([0-9]+(?:\.[0-9]+)?)\s*%?`)
+)
+
+// Returns the canonical size, false if it isn't one
+func NormaliseContainerSize(size string) (string, bool) {
+	normalised, exists := containerSizes[strings.ToLower(strings.Trim(strings.TrimSpace(size), "."))]
+	return normalised, exists
+}
+
+// Reads any container details from a line of the item's stats into the item's
+// container and its stats. Returns what's left of the line for the stat parser,
+// as the wiki sometimes puts the weight on the same line
+func (i *Item) assignContainer(part string) string {
+	capacities := containerCapacityRegex.FindAllStringSubmatch(part, -1)
+	reduction := containerWeightReductionRegex.FindStringSubmatch(part)
+	if len(capacities) == 0 && reduction == nil {
+		return part
+	}
+
+	if i.container == nil {
+		i.container = &ItemContainer{}
+	}
+	for _, match := range capacities {
+		if match[1] != "" {
+			if size, exists := NormaliseContainerSize(match[2]); exists {
+				i.container.maxSize = size
+				i.statistics = append(i.statistics, Statistic{ code: "SIZE CAPACITY", effect: size })
+			}
+			continue
+		}
+		if slots, err := strconv.Atoi(match[2]); err == nil {
+			i.container.slots = slots
+			i.statistics = append(i.statistics, Statistic{ code: "CAPACITY", value: sql.NullFloat64{ Float64: float64(slots), Valid: true } })
+		}
+	}
+	if reduction != nil {
+		if percent, err := strconv.ParseFloat(reduction[1], 64); err == nil {
+			i.container.weightReduction = percent
+			i.statistics = append(i.statistics, Statistic{ code: "WEIGHT REDUCTION", value: sql.NullFloat64{ Float64: percent, Valid: true } })
+		}
+	}
+	part = containerCapacityRegex.ReplaceAllString(part, "")
+	return strings.TrimSpace(containerWeightReductionRegex.ReplaceAllString(part, ""))
+}
+
+// Values for the container_ columns, which are null for items that aren't bags
+func (c *ItemContainer) columns() (sql.NullInt64, sql.NullString, sql.NullFloat64) {
+	if c == nil {
+		return sql.NullInt64{}, sql.NullString{}, sql.NullFloat64{}
+	}
+	return sql.NullInt64{ Int64: int64(c.slots), Valid: true },
+		sql.NullString{ String: c.maxSize, Valid: c.maxSize != "" },
+		sql.NullFloat64{ Float64: c.weightReduction, Valid: true }
+}
+
+// Builds the container from the container_ columns, nil if the item isn't a bag
+func containerFromColumns(slots sql.NullInt64, maxSize sql.NullString, weightReduction sql.NullFloat64) *ItemContainer {
+	if !slots.Valid {
+		return nil
+	}
+	return &ItemContainer{ slots: int(slots.Int64), maxSize: maxSize.String, weightReduction: weightReduction.Float64 }
+}
+
+func (c ItemContainer) MarshalJSON() ([]byte, error) {
+	var maxSize *string
+	if c.maxSize != "" {
+		maxSize = &c.maxSize
+	}
+	return json.Marshal(struct {
+		Slots           int     `json:"slots"`
+		MaxSize         *string `json:"maxSize"`
+		WeightReduction float64 `json:"weightReduction"`
+	}{c.slots, maxSize, c.weightReduction})
+}
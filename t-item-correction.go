@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ItemCorrection
+ |--------------------------------------------------------------------------
+ |
+ | Represents a fix made to an item by hand, for when the wiki is wrong or
+ | the parser gets a page wrong and re-scraping won't help. Only what's set
+ | is changed. Corrected items are flagged with is_manual_override so the
+ | re-scraper and -reparse leave them alone, an admin refresh is the only
+ | thing which replaces them with the wiki's data again. Stats and icons
+ | are part of the catalog every tenant shares so only admins can correct
+ | them. Prices belong to a tenant, the default tenant's corrected price is
+ | the item's own and any other tenant's is kept in price_corrections and
+ | given in its price summary
+ |
+ | @member server (string): The tenant the price is corrected for
+ | @member statistics ([]StatisticCorrection): Stats to set or remove
+ | @member price (*float64): The price to show in place of the observed one,
+ | nil to leave it
+ | @member imageSrc (*string): The icon to set, nil to leave it
+ |
+ */
+
+type ItemCorrection struct {
+	server string
+	statistics []StatisticCorrection
+	price *float64
+	imageSrc *string
+}
+
+// A stat to set on the item, or to remove from it if remove is set. Stats are
+// matched on their code and effect as they are when scraped, value is nil for
+// stats which are only text such as SLOT
+type StatisticCorrection struct {
+	Code   string   `json:"code"`
+	Value  *float64 `json:"value"`
+	Effect string   `json:"effect"`
+	Remove bool     `json:"remove,omitempty"`
+}
+
+func (s StatisticCorrection) nullValue() sql.NullFloat64 {
+	if s.Value == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{ Float64: *s.Value, Valid: true }
+}
+
+// Returns why the correction can't be made, or an empty string if it can
+func (c *ItemCorrection) Validate() string {
+	if len(c.statistics) == 0 && c.price == nil && c.imageSrc == nil {
+		return "Please send statistics, price or imageSrc"
+	}
+	for idx := range c.statistics {
+		c.statistics[idx].Code = strings.ToUpper(strings.TrimSpace(c.statistics[idx].Code))
+		if c.statistics[idx].Code == "" {
+			return "Every statistic needs a code"
+		}
+	}
+	if c.price != nil && *c.price < 0 {
+		return "price can't be negative"
+	}
+	if c.imageSrc != nil {
+		src := strings.TrimSpace(*c.imageSrc)
+		if !IsWikiPath(src) {
+			return "imageSrc must be a path on the wiki, i.e. /images/Item_1234.png"
+		}
+		c.imageSrc = &src
+	}
+	return ""
+}
+
+// Applies the correction to the item and saves it, flagging the item as
+// manually overridden. A price corrected for a tenant other than the default
+// leaves the item as it is. The item must already be loaded
+func (i *Item) Correct(ctx context.Context, c ItemCorrection) error {
+	// Kept apart from observed_price, which auction lines keep averaging
+	var price sql.NullFloat64
+	if c.price != nil && c.server == "" {
+		price = sql.NullFloat64{ Float64: *c.price, Valid: true }
+		i.price = float32(*c.price)
+	}
+	if c.imageSrc != nil {
+		i.imageSrc = *c.imageSrc
+	}
+	for _, correction := range c.statistics {
+		i.correctStatistic(correction)
+	}
+	catalog := price.Valid || c.imageSrc != nil || len(c.statistics) > 0
+
+	err := DB.Transaction(ctx, func(tx *Tx) error {
+		if c.price != nil && c.server != "" {
+			query := "INSERT INTO price_corrections (server, item_id, price, corrected_at) VALUES (?, ?, ?, ?) " +
+				"ON DUPLICATE KEY UPDATE price = VALUES(price), corrected_at = VALUES(corrected_at)"
+			if _, err := tx.Insert(query, c.server, i.id, *c.price, time.Now().UTC()); err != nil {
+				LoggerFrom(tx.ctx).Error("Failed to save corrected price", "server", c.server, "err", err)
+				return ErrDatabaseWrite
+			}
+		}
+		if !catalog {
+			return nil
+		}
+
+		i.ratio, i.hasteAdjustedDelay = i.weaponRatios()
+		query := "UPDATE items SET imageSrc = ?, corrected_price = COALESCE(?, corrected_price), damage_delay_ratio = ?, " +
+			"haste_adjusted_delay = ?, is_manual_override = 1 WHERE id = ?"
+		rows, err := tx.Query(query, i.imageSrc, price, i.ratio, i.hasteAdjustedDelay, i.id)
+		if err != nil {
+			return ErrDatabaseWrite
+		}
+		DB.CloseRows(rows)
+
+		for _, correction := range c.statistics {
+			if correction.Remove {
+				rows, err := tx.Query("DELETE FROM statistics WHERE item_id = ? AND code = ? AND effect = ?", i.id, correction.Code, correction.Effect)
+				if err != nil {
+					return ErrDatabaseWrite
+				}
+				DB.CloseRows(rows)
+				continue
+			}
+			query := "INSERT INTO statistics (item_id, code, value, effect) VALUES (?, ?, ?, ?) " +
+				"ON DUPLICATE KEY UPDATE value = VALUES(value)"
+			if _, err := tx.Insert(query, i.id, correction.Code, correction.nullValue(), correction.Effect); err != nil {
+				LoggerFrom(tx.ctx).Error("Failed to save corrected statistic", "code", correction.Code, "err", err)
+				return ErrDatabaseWrite
+			}
+		}
+		if err := i.saveRestrictions(tx, i.id); err != nil {
+			return err
+		}
+		return SaveItemRevision(tx, *i)
+	})
+	if err != nil {
+		return err
+	}
+
+	LoggerFrom(ctx).Info("Corrected item", "id", i.id, "server", c.server, "statistics", len(c.statistics), "price", c.price != nil, "image", c.imageSrc != nil)
+	if !catalog {
+		return nil
+	}
+	CacheItem(i.name, *i)
+	StoreItemDocument(ctx, *i)
+	PublishItemInvalidation(i.id, i.name)
+	PublishFeedEvent(FEED_EVENT_ITEM, TenantFrom(ctx), true, *i)
+	if c.imageSrc != nil {
+		QueueItemImage(*i)
+	}
+	return nil
+}
+
+func (i *Item) correctStatistic(correction StatisticCorrection) {
+	for idx, stat := range i.statistics {
+		if stat.code != correction.Code || stat.effect != correction.Effect {
+			continue
+		}
+		if correction.Remove {
+			i.statistics = append(i.statistics[:idx], i.statistics[idx+1:]...)
+		} else {
+			i.statistics[idx].value = correction.nullValue()
+		}
+		return
+	}
+	if !correction.Remove {
+		i.statistics = append(i.statistics, Statistic{ code: correction.Code, value: correction.nullValue(), effect: correction.Effect })
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ItemImage
+ |--------------------------------------------------------------------------
+ |
+ | Represents an item's icon once we've downloaded it from the wiki into the
+ | image store. Icons are queued for download whenever an item is saved
+ | and are downloaded again only if the page's image changes. Icons which
+ | are asked for before they've been downloaded are fetched there and then
+ |
+ | @member itemId (int64): The item the icon belongs to
+ | @member src (string): The wiki path the icon was downloaded from
+ | @member hash (string): Hex SHA-256 of the icon, its key in the image store
+ | @member contentType (string): i.e. image/png
+ | @member size (int): Size of the icon in bytes
+ | @member fetchedAt (time.Time): When the icon was downloaded
+ |
+ */
+
+type ItemImage struct {
+	itemId int64
+	src string
+	hash string
+	contentType string
+	size int
+	fetchedAt time.Time
+}
+
+// Saved items waiting on their icon, once it's full icons are left to be
+// fetched when they're first asked for
+const IMAGE_QUEUE_SIZE = 1000
+
+type imageRequest struct {
+	itemId int64
+	src string
+}
+
+var imageQueue = make(chan imageRequest, IMAGE_QUEUE_SIZE)
+
+// Queues the item's icon to be downloaded, does nothing if it has no icon
+func QueueItemImage(item Item) {
+	if item.id <= 0 || item.imageSrc == "" || Settings.ReadOnly {
+		return
+	}
+	select {
+	case imageQueue <- imageRequest{ item.id, item.imageSrc }:
+	default:
+	}
+}
+
+// Downloads queued icons until we shut down
+func DownloadImages() {
+	for {
+		select {
+		case request := <-imageQueue:
+			image, exists, err := FetchItemImage(AppContext, request.itemId)
+			if err != nil || (exists && image.src == request.src) {
+				continue
+			}
+			if _, err := StoreItemImage(AppContext, request.itemId, request.src); err != nil && err != context.Canceled {
+				Log.Warn("Failed to download item image", "item_id", request.itemId, "src", request.src, "err", err)
+			}
+		case <-AppContext.Done():
+			return
+		}
+	}
+}
+
+// Downloads the icon at src into the image store and records it as the item's
+func StoreItemImage(ctx context.Context, itemId int64, src string) (ItemImage, error) {
+	body, contentType, err := FetchWikiImage(ctx, src)
+	if err != nil {
+		return ItemImage{}, err
+	}
+
+	sum := sha256.Sum256(body)
+	image := ItemImage{
+		itemId: itemId,
+		src: src,
+		hash: hex.EncodeToString(sum[:]),
+		contentType: contentType,
+		size: len(body),
+		fetchedAt: time.Now().UTC(),
+	}
+
+	stored, err := imageStored(ctx, image.hash)
+	if err != nil {
+		return image, err
+	}
+	if !stored {
+		if err := Images.Put(ctx, image.hash, body, image.contentType); err != nil {
+			LoggerFrom(ctx).Error("Failed to store item image", "item_id", itemId, "hash", image.hash, "err", err)
+			return image, err
+		}
+	}
+
+	query := "INSERT INTO item_images (item_id, src, hash, content_type, size_bytes, fetched_at) " +
+		"VALUES (?, ?, ?, ?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE src = VALUES(src), hash = VALUES(hash), content_type = VALUES(content_type), " +
+		"size_bytes = VALUES(size_bytes), fetched_at = VALUES(fetched_at)"
+	if _, err := DB.InsertContext(ctx, query, image.itemId, image.src, image.hash, image.contentType, image.size, image.fetchedAt); err != nil {
+		return image, ErrDatabaseWrite
+	}
+	LoggerFrom(ctx).Debug("Stored item image", "item_id", itemId, "hash", image.hash, "deduped", stored)
+	return image, nil
+}
+
+// Returns the item's downloaded icon, false if it hasn't been downloaded
+func FetchItemImage(ctx context.Context, itemId int64) (ItemImage, bool, error) {
+	image := ItemImage{ itemId: itemId }
+
+	query := "SELECT src, hash, content_type, size_bytes, fetched_at FROM item_images WHERE item_id = ?"
+	rows, err := DB.QueryContext(ctx, query, itemId)
+	if err != nil {
+		return image, false, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	if !rows.Next() {
+		return image, false, nil
+	}
+	if err := rows.Scan(&image.src, &image.hash, &image.contentType, &image.size, &image.fetchedAt); err != nil {
+		Log.Error("Scan failed", "err", err)
+		return image, false, ErrDatabaseRead
+	}
+	return image, true, nil
+}
+
+// True if an item already has an icon with this hash, in which case it's in
+// the image store
+func imageStored(ctx context.Context, hash string) (bool, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT 1 FROM item_images WHERE hash = ? LIMIT 1", hash)
+	if err != nil {
+		return false, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+	return rows.Next(), nil
+}
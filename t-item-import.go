@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ImportResult
+ |--------------------------------------------------------------------------
+ |
+ | What came of importing a dump made by GET /export/items.json, so a new
+ | deployment can be seeded without scraping every page again. Each line is
+ | an item as GET /items returns it, lines which don't make sense are
+ | skipped and the rest are upserted by name and version in transactions
+ | of IMPORT_BATCH_SIZE, the same as a scrape would save them. Items
+ | corrected by hand are left alone, as a scrape leaves them
+ |
+ | @member imported (int): Number of items saved
+ | @member corrected (int): Number of items skipped as they were corrected
+ | by hand
+ | @member failed (int): Number of lines which were skipped or whose batch
+ | couldn't be saved
+ | @member errors ([]ImportError): Why lines failed, only the first
+ | IMPORT_MAX_REPORTED_ERRORS are kept
+ |
+ */
+
+type ImportResult struct {
+	imported int
+	corrected int
+	failed int
+	errors []ImportError
+}
+
+type ImportError struct {
+	line int
+	reason string
+}
+
+// Number of items saved in each transaction
+const IMPORT_BATCH_SIZE = 100
+
+// Longest line we'll read, an item with every stat and effect is a few KB
+const IMPORT_MAX_LINE_BYTES = 1 << 20
+
+const IMPORT_MAX_REPORTED_ERRORS = 100
+
+// An item as Item.MarshalJSON writes it, the id is the exporting
+// deployment's so it's ignored
+type importedItem struct {
+	Name            string              `json:"name"`
+	DisplayName     string              `json:"displayName"`
+	ImageSrc        string              `json:"imageSrc"`
+	Price           float32             `json:"price"`
+	VendorSellPrice *float64            `json:"vendorSellPrice"`
+	VendorBuyPrice  *float64            `json:"vendorBuyPrice"`
+	Statistics      []StatisticResponse `json:"statistics"`
+	Effects         []EffectResponse    `json:"effects"`
+	Version         int                 `json:"version"`
+	Description     string              `json:"description"`
+	Container       *struct {
+		Slots           int     `json:"slots"`
+		MaxSize         *string `json:"maxSize"`
+		WeightReduction float64 `json:"weightReduction"`
+	} `json:"container"`
+}
+
+// Returns the item the line describes, or why it can't be imported
+func (d importedItem) Item() (Item, string) {
+	name := strings.TrimSpace(d.Name)
+	if name == "" {
+		return Item{}, "name is required"
+	}
+	if d.Version < DEFAULT_ITEM_VERSION {
+		return Item{}, "version must be at least " + strconv.Itoa(DEFAULT_ITEM_VERSION)
+	}
+
+	item := Item{
+		name: name,
+		displayName: d.DisplayName,
+		imageSrc: d.ImageSrc,
+		price: d.Price,
+		vendorSellPrice: nullFloatFromPointer(d.VendorSellPrice),
+		vendorBuyPrice: nullFloatFromPointer(d.VendorBuyPrice),
+		version: d.Version,
+		description: d.Description,
+	}
+	if item.displayName == "" {
+		item.displayName = TitleCase(name, true)
+	}
+	if d.Container != nil {
+		item.container = &ItemContainer{ slots: d.Container.Slots, weightReduction: d.Container.WeightReduction }
+		if d.Container.MaxSize != nil {
+			size, exists := NormaliseContainerSize(*d.Container.MaxSize)
+			if !exists {
+				return Item{}, "unknown container size " + *d.Container.MaxSize
+			}
+			item.container.maxSize = size
+		}
+	}
+	for _, response := range d.Statistics {
+		if strings.TrimSpace(response.Code) == "" {
+			return Item{}, "statistics must have a code"
+		}
+		item.statistics = append(item.statistics, statisticFromResponse(response))
+	}
+	for _, response := range d.Effects {
+		if response.Name == "" || response.Uri == "" {
+			return Item{}, "effects must have a name and uri"
+		}
+		item.effects = append(item.effects, effectFromResponse(response))
+	}
+	return item, ""
+}
+
+// Reads the dump a line at a time, saving a batch whenever one fills up. The
+// import stops early if ctx is cancelled, the batches already saved stay saved
+func ImportItems(ctx context.Context, body io.Reader) (ImportResult, error) {
+	var result ImportResult
+	var batch []Item
+	var batchLines []int
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64 * 1024), IMPORT_MAX_LINE_BYTES)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var decoded importedItem
+		if err := json.Unmarshal(scanner.Bytes(), &decoded); err != nil {
+			result.fail(line, err.Error())
+			continue
+		}
+		item, problem := decoded.Item()
+		if problem != "" {
+			result.fail(line, problem)
+			continue
+		}
+
+		batch = append(batch, item)
+		batchLines = append(batchLines, line)
+		if len(batch) == IMPORT_BATCH_SIZE {
+			if err := result.save(ctx, batch, batchLines); err != nil {
+				return result, err
+			}
+			batch, batchLines = nil, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		LoggerFrom(ctx).Warn("Failed to read import", "err", err)
+		return result, err
+	}
+	if err := result.save(ctx, batch, batchLines); err != nil {
+		return result, err
+	}
+
+	// New names have to be known to the auction line tokenizer
+	if result.imported > 0 {
+		Corpus.Reload()
+	}
+	LoggerFrom(ctx).Info("Imported items", "imported", result.imported, "corrected", result.corrected, "failed", result.failed)
+	return result, nil
+}
+
+func (r *ImportResult) fail(line int, reason string) {
+	r.failed++
+	if len(r.errors) < IMPORT_MAX_REPORTED_ERRORS {
+		r.errors = append(r.errors, ImportError{ line: line, reason: reason })
+	}
+}
+
+// Saves the batch in one transaction, a batch which can't be saved counts
+// each of its lines as failed. Only a cancelled ctx is returned as an error
+func (r *ImportResult) save(ctx context.Context, batch []Item, lines []int) error {
+	batch, lines, err := r.skipCorrected(ctx, batch, lines)
+	if err != nil {
+		return err
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err = DB.Transaction(ctx, func(tx *Tx) error {
+		for idx := range batch {
+			if err := batch[idx].saveImported(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		for _, line := range lines {
+			r.fail(line, err.Error())
+		}
+		return nil
+	}
+
+	// Documents are written again when the items are next read from SQL
+	for _, item := range batch {
+		UncacheItem(item.id, item.name)
+		DeleteItemDocument(ctx, item.name)
+	}
+	r.imported += len(batch)
+	return nil
+}
+
+// Removes the items corrected by hand from the batch, counting them. A batch
+// whose corrections can't be read counts each of its lines as failed
+func (r *ImportResult) skipCorrected(ctx context.Context, batch []Item, lines []int) ([]Item, []int, error) {
+	if len(batch) == 0 {
+		return batch, lines, nil
+	}
+
+	var parameters []interface{}
+	for _, item := range batch {
+		parameters = append(parameters, item.name)
+	}
+	query := "SELECT name FROM items " +
+		"WHERE name IN (" + Placeholders(len(parameters)) + ") AND COALESCE(is_manual_override, 0) = 1"
+	rows, err := DB.QueryContext(ctx, query, parameters...)
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+	if err != nil {
+		for _, line := range lines {
+			r.fail(line, ErrDatabaseRead.Error())
+		}
+		return nil, nil, nil
+	}
+	corrected := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		corrected[strings.ToLower(name)] = true
+	}
+	DB.CloseRows(rows)
+
+	var kept []Item
+	var keptLines []int
+	for idx, item := range batch {
+		if corrected[strings.ToLower(item.name)] {
+			LoggerFrom(ctx).Debug("Not importing item corrected by hand", "item", item.name)
+			r.corrected++
+			continue
+		}
+		kept = append(kept, item)
+		keptLines = append(keptLines, lines[idx])
+	}
+	return kept, keptLines, nil
+}
+
+// Writes the item the way Save does, apart from anything which needs the wiki.
+// Its effects are replaced as they are by Save, so a dump can be imported again
+func (i *Item) saveImported(tx *Tx) error {
+	// The upsert sets the id, which has to be undone if we're retried
+	i.id = 0
+	if err := i.upsert(tx); err != nil {
+		return err
+	}
+
+	if err := i.saveEffects(tx, i.id); err != nil {
+		return err
+	}
+
+	// Effect details come with the dump, so only effects we haven't scraped
+	// ourselves take them
+	for _, effect := range i.effects {
+		if effect.description == "" {
+			continue
+		}
+		query := "UPDATE effects SET description = ?, mana = ?, duration = ?, scraped_at = NOW() " +
+			"WHERE name = ? AND scraped_at IS NULL"
+		if _, err := tx.Insert(query, effect.description, effect.mana, effect.duration, effect.name); err != nil {
+			return ErrDatabaseWrite
+		}
+	}
+
+	if err := i.saveStats(tx, i.id); err != nil {
+		return err
+	}
+	if err := i.saveRestrictions(tx, i.id); err != nil {
+		return err
+	}
+	return SaveItemRevision(tx, *i)
+}
+
+func (r ImportResult) MarshalJSON() ([]byte, error) {
+	errors := r.errors
+	if errors == nil {
+		errors = []ImportError{}
+	}
+	return json.Marshal(struct {
+		Imported  int           `json:"imported"`
+		Corrected int           `json:"corrected"`
+		Failed    int           `json:"failed"`
+		Errors    []ImportError `json:"errors"`
+	}{r.imported, r.corrected, r.failed, errors})
+}
+
+func (e ImportError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Line   int    `json:"line"`
+		Reason string `json:"reason"`
+	}{e.line, e.reason})
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Item collection queries
+ |--------------------------------------------------------------------------
+ |
+ | Item.fetchDataFromSQL deals with a single item, the functions in here
+ | load many items at once. Items are loaded with one query and their
+ | statistics with a second, rather than one query per item
+ |
+ */
+
+const DEFAULT_PER_PAGE = 25
+const MAX_PER_PAGE = 100
+
+// Returns a single page of items ordered by name along with the total number of items
+func ListItems(page int, perPage int) ([]Item, int64) {
+	var total int64
+
+	rows, err := DB.Query("SELECT COUNT(*) FROM items")
+	if err == nil {
+		for rows.Next() {
+			if err := rows.Scan(&total); err != nil {
+				Log.Error("Scan failed", "err", err)
+			}
+		}
+		DB.CloseRows(rows)
+	}
+
+	query := "SELECT " + itemColumns + " " +
+		"FROM items " +
+		"ORDER BY name ASC " +
+		"LIMIT ? OFFSET ?"
+
+	items := fetchItems(query, perPage, (page-1)*perPage)
+	attachStatistics(items)
+
+	return items, total
+}
+
+// Columns every query passed to fetchItems must select, in this order. A price
+// corrected by hand is preferred to the one observed in auctions
+const itemColumns = "items.id, items.name, items.displayName, items.imageSrc, " +
+	"COALESCE(items.corrected_price, items.observed_price), items.vendor_sell_price, items.vendor_buy_price, " +
+	"items.damage_delay_ratio, items.haste_adjusted_delay, items.version, items.description, " +
+	"items.container_slots, items.container_max_size, items.container_weight_reduction"
+
+// Runs a query selecting itemColumns from items and scans each row into an Item
+func fetchItems(query string, parameters ...interface{}) []Item {
+	items := []Item{}
+
+	rows, err := DB.Query(query, parameters...)
+	if err != nil {
+		return items
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var (
+			item Item
+			imageSrc sql.NullString
+			price sql.NullFloat64
+			description sql.NullString
+			containerSlots sql.NullInt64
+			containerMaxSize sql.NullString
+			containerWeightReduction sql.NullFloat64
+		)
+		err := rows.Scan(&item.id, &item.name, &item.displayName, &imageSrc, &price, &item.vendorSellPrice, &item.vendorBuyPrice,
+			&item.ratio, &item.hasteAdjustedDelay, &item.version, &description,
+			&containerSlots, &containerMaxSize, &containerWeightReduction)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		item.imageSrc = imageSrc.String
+		item.price = float32(price.Float64)
+		item.description = description.String
+		item.container = containerFromColumns(containerSlots, containerMaxSize, containerWeightReduction)
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+
+	return items
+}
+
+// Loads the statistics for every item in a single query and assigns them to
+// their items
+func attachStatistics(items []Item) {
+	if len(items) == 0 {
+		return
+	}
+
+	byId := make(map[int64]*Item)
+	var parameters []interface{}
+	for idx := range items {
+		byId[items[idx].id] = &items[idx]
+		parameters = append(parameters, items[idx].id)
+	}
+
+	query := "SELECT item_id, code, value, effect " +
+		"FROM statistics " +
+		"WHERE item_id IN (" + Placeholders(len(parameters)) + ")"
+
+	rows, err := DB.Query(query, parameters...)
+	if err != nil {
+		return
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var (
+			itemId int64
+			stat Statistic
+			code sql.NullString
+			effect sql.NullString
+		)
+		err := rows.Scan(&itemId, &code, &stat.value, &effect)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		stat.code = code.String
+		stat.effect = effect.String
+		if item, exists := byId[itemId]; exists {
+			item.statistics = append(item.statistics, stat)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+}
+
+// Loads the effects for every item in a single query and assigns them to
+// their items
+func attachEffects(items []Item) {
+	if len(items) == 0 {
+		return
+	}
+
+	byId := make(map[int64]*Item)
+	var parameters []interface{}
+	for idx := range items {
+		byId[items[idx].id] = &items[idx]
+		parameters = append(parameters, items[idx].id)
+	}
+
+	query := "SELECT item_effects.item_id, " + effectColumns + " " +
+		"FROM item_effects " +
+		"INNER JOIN effects " +
+		"ON effects.id = item_effects.effect_id " +
+		"WHERE item_effects.item_id IN (" + Placeholders(len(parameters)) + ")"
+
+	rows, err := DB.Query(query, parameters...)
+	if err != nil {
+		return
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var itemId int64
+		e, err := scanEffect(rows, &itemId)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		if item, exists := byId[itemId]; exists {
+			item.effects = append(item.effects, e)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+}
+
+// Returns up to limit items with an id above afterId, ordered by id so an
+// export can carry on from the last item it wrote however the catalog changes
+// underneath it. Items are loaded with their statistics and effects, and when
+// since isn't the zero time only items scraped since then are returned
+func ExportItems(afterId int64, since time.Time, limit int) []Item {
+	parameters := []interface{}{afterId}
+	query := "SELECT " + itemColumns + " " +
+		"FROM items " +
+		"WHERE items.id > ? "
+	if !since.IsZero() {
+		query += "AND items.scraped_at >= ? "
+		parameters = append(parameters, since.UTC())
+	}
+	query += "ORDER BY items.id ASC " +
+		"LIMIT ?"
+	parameters = append(parameters, limit)
+
+	items := fetchItems(query, parameters...)
+	attachStatistics(items)
+	attachEffects(items)
+	return items
+}
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: SearchResult
+ |--------------------------------------------------------------------------
+ |
+ | An item which matched a search along with how closely it matched,
+ | scores above 1.0 are substring matches, scores below are fuzzy matches
+ |
+ */
+
+type SearchResult struct {
+	score float64
+	item Item
+}
+
+// Fuzzy matches scoring below this are left out of search results
+const MIN_SEARCH_SCORE = 0.6
+
+// Searches the name corpus and the server's aliases for the query, substring
+// matches rank above fuzzy matches and shorter names rank above longer ones so
+// the closest names come first
+func SearchItems(server string, q string, limit int) []SearchResult {
+	results := []SearchResult{}
+
+	q = strings.ToLower(strings.TrimSpace(strings.Replace(q, "_", " ", -1)))
+	if q == "" {
+		return results
+	}
+
+	names, lookup := Corpus.Names(server)
+	scores := make(map[string]float64)
+	for _, candidate := range names {
+		score := searchScore(q, candidate)
+		if score < MIN_SEARCH_SCORE {
+			continue
+		}
+		name := lookup[candidate]
+		if score > scores[name] {
+			scores[name] = score
+		}
+	}
+	if len(scores) == 0 {
+		return results
+	}
+
+	var ranked []string
+	for name := range scores {
+		ranked = append(ranked, name)
+	}
+	sort.Slice(ranked, func(a, b int) bool {
+		if scores[ranked[a]] == scores[ranked[b]] {
+			return ranked[a] < ranked[b]
+		}
+		return scores[ranked[a]] > scores[ranked[b]]
+	})
+	if len(ranked) > limit {
+		ranked = ranked[0:limit]
+	}
+
+	var parameters []interface{}
+	for _, name := range ranked {
+		parameters = append(parameters, name)
+	}
+	query := "SELECT " + itemColumns + " " +
+		"FROM items " +
+		"WHERE name IN (" + Placeholders(len(parameters)) + ")"
+
+	items := fetchItems(query, parameters...)
+	attachStatistics(items)
+
+	byName := make(map[string]Item)
+	for _, item := range items {
+		byName[item.name] = item
+	}
+	for _, name := range ranked {
+		if item, exists := byName[name]; exists {
+			results = append(results, SearchResult{ score: scores[name], item: item })
+		}
+	}
+
+	return results
+}
+
+// Returns the items in the order they were named, names we haven't stored are
+// returned with just their name so callers can report them
+func FetchItemsByName(names []string) []Item {
+	items := []Item{}
+	if len(names) == 0 {
+		return items
+	}
+
+	var parameters []interface{}
+	for _, name := range names {
+		parameters = append(parameters, name)
+	}
+	query := "SELECT " + itemColumns + " " +
+		"FROM items " +
+		"WHERE name IN (" + Placeholders(len(parameters)) + ")"
+
+	byName := make(map[string]Item)
+	for _, item := range fetchItems(query, parameters...) {
+		byName[strings.ToLower(item.name)] = item
+	}
+	for _, name := range names {
+		if item, exists := byName[strings.ToLower(name)]; exists {
+			items = append(items, item)
+		} else {
+			items = append(items, Item{ name: name })
+		}
+	}
+	return items
+}
+
+// Scores how closely a lowercase candidate name matches the query. Substrings
+// score between 1 and 2 depending on how much of the name they cover, otherwise
+// we compare the query against every run of words in the name with the same
+// number of words as the query, so "rbe" still finds "robe of the oracle"
+func searchScore(q string, candidate string) float64 {
+	if strings.Contains(candidate, q) {
+		return 1.0 + float64(len(q))/float64(len(candidate))
+	}
+
+	queryWords := len(strings.Fields(q))
+	words := strings.Fields(candidate)
+	best := 0.0
+	for start := 0; start+queryWords <= len(words); start++ {
+		window := strings.Join(words[start:start+queryWords], " ")
+		score := 1.0 - float64(Levenshtein(q, window))/float64(maxInt(len(q), len(window)))
+		if score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+func (s SearchResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Score float64 `json:"score"`
+		Item  Item    `json:"item"`
+	}{s.score, s.item})
+}
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ItemFilter
+ |--------------------------------------------------------------------------
+ |
+ | Criteria for the item browser, every member is optional
+ |
+ | @member stat (string): Only return items which have this stat code
+ | @member min (sql.NullFloat64): Minimum value of the stat
+ | @member max (sql.NullFloat64): Maximum value of the stat
+ | @member class (string): Only return items usable by this class i.e. WAR
+ | @member slot (string): Only return items worn in this slot i.e. PRIMARY
+ | @member races ([]string): Only return items usable by one of these races,
+ | this is how both the race and wearer_size parameters are applied
+ | @member weaponSkill (string): Only return weapons using this skill, one of
+ | the WEAPON_SKILL constants
+ | @member clickyWeight (int): When set, items are also ranked by their clicky
+ | score, each point being worth this much of the stat
+ |
+ */
+
+type ItemFilter struct {
+	stat string
+	min sql.NullFloat64
+	max sql.NullFloat64
+	class string
+	slot string
+	races []string
+	weaponSkill string
+	clickyWeight int
+}
+
+// Returns a page of items matching the filter, when filtering on a stat the
+// items with the highest value of that stat come first. A clickyWeight also
+// factors in clickable effects
+func QueryItems(filter ItemFilter, page int, perPage int) []Item {
+	var parameters []interface{}
+
+	query := "SELECT " + itemColumns + " " +
+		"FROM items "
+
+	if filter.stat != "" {
+		query += "INNER JOIN statistics AS filtered " +
+			"ON filtered.item_id = items.id " +
+			"AND filtered.code = ? "
+		parameters = append(parameters, strings.ToUpper(filter.stat))
+		if filter.min.Valid {
+			query += "AND filtered.value >= ? "
+			parameters = append(parameters, filter.min.Float64)
+		}
+		if filter.max.Valid {
+			query += "AND filtered.value <= ? "
+			parameters = append(parameters, filter.max.Float64)
+		}
+	}
+
+	conditions, conditionParameters := filter.conditionsSQL()
+	query += "WHERE 1 = 1 " + conditions
+	parameters = append(parameters, conditionParameters...)
+
+	if filter.stat != "" && filter.clickyWeight > 0 {
+		query += "ORDER BY filtered.value + ? * " + clickyScoreSQL + " DESC, items.name ASC "
+		parameters = append(parameters, filter.clickyWeight)
+	} else if filter.stat != "" {
+		query += "ORDER BY filtered.value DESC, items.name ASC "
+	} else if filter.clickyWeight > 0 {
+		query += "ORDER BY " + clickyScoreSQL + " DESC, items.name ASC "
+	} else {
+		query += "ORDER BY items.name ASC "
+	}
+	query += "LIMIT ? OFFSET ?"
+	parameters = append(parameters, perPage, (page-1)*perPage)
+
+	items := fetchItems(query, parameters...)
+	attachStatistics(items)
+
+	return items
+}
+
+// Builds the conditions for the class, slot, weapon skill and race filters,
+// each starting with AND so they can follow any WHERE
+func (filter ItemFilter) conditionsSQL() (string, []interface{}) {
+	var query string
+	var parameters []interface{}
+
+	if filter.class != "" {
+		query += "AND EXISTS (SELECT 1 FROM item_classes WHERE item_classes.item_id = items.id AND item_classes.class = ?) "
+		parameters = append(parameters, filter.class)
+	}
+	if filter.slot != "" {
+		query += "AND EXISTS (SELECT 1 FROM item_slots WHERE item_slots.item_id = items.id AND item_slots.slot = ?) "
+		parameters = append(parameters, filter.slot)
+	}
+
+	if filter.weaponSkill != "" {
+		// Skills scraped before they were normalised are stored with spaces i.e. 2H BLUNT
+		query += "AND EXISTS (SELECT 1 FROM statistics WHERE statistics.item_id = items.id " +
+			"AND statistics.code = 'SKILL' AND REPLACE(statistics.effect, ' ', '_') = ?) "
+		parameters = append(parameters, filter.weaponSkill)
+	}
+
+	if len(filter.races) > 0 {
+		condition, raceParameters := raceFitSQL(filter.races)
+		query += "AND " + condition + " "
+		parameters = append(parameters, raceParameters...)
+	}
+
+	return query, parameters
+}
+
+// The kinds of item /items/top ranks and the orders it ranks them in. Ratio
+// puts the highest damage to delay ratio first, delay the fastest weapon
+const (
+	TOP_ITEMS_TYPE_WEAPON = "weapon"
+	TOP_ITEMS_ORDER_RATIO = "ratio"
+	TOP_ITEMS_ORDER_DELAY = "delay"
+)
+
+// Returns a page of the weapons matching the filter, ranked by their stored
+// damage to delay ratio or by their haste adjusted delay. The filter's stat and
+// clicky weight aren't used
+func QueryTopWeapons(filter ItemFilter, order string, page int, perPage int) []Item {
+	conditions, parameters := filter.conditionsSQL()
+
+	query := "SELECT " + itemColumns + " " +
+		"FROM items " +
+		"WHERE items.damage_delay_ratio IS NOT NULL " + conditions
+	if order == TOP_ITEMS_ORDER_DELAY {
+		query += "ORDER BY items.haste_adjusted_delay ASC, items.damage_delay_ratio DESC, items.name ASC "
+	} else {
+		query += "ORDER BY items.damage_delay_ratio DESC, items.name ASC "
+	}
+	query += "LIMIT ? OFFSET ?"
+	parameters = append(parameters, perPage, (page-1)*perPage)
+
+	items := fetchItems(query, parameters...)
+	attachStatistics(items)
+
+	return items
+}
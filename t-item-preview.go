@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ItemPreview
+ |--------------------------------------------------------------------------
+ |
+ | Represents what scraping an item would store, without storing it. The
+ | page is fetched and parsed exactly as a scrape would, but nothing is
+ | saved or cached, so the parser can be debugged against the live wiki
+ | safely. Everything the parser logged along the way, down to debug
+ | level, is kept as diagnostics whatever the configured log level
+ |
+ | @member item (Item): What the page parsed to
+ | @member spell (*Spell): What the page parsed to if it's a spell page
+ | @member uri (string): The wiki page which was fetched, after redirects
+ | @member parser (string): Which parser the page was given to, item or spell
+ | @member bodySize (int): Size of the page in bytes
+ | @member duration (time.Duration): How long fetching and parsing took
+ | @member err (error): Why the page didn't parse, nil if it did
+ | @member diagnostics ([]ParseDiagnostic): What the parser logged
+ |
+ */
+
+type ItemPreview struct {
+	item Item
+	spell *Spell
+	uri string
+	parser string
+	bodySize int
+	duration time.Duration
+	err error
+	diagnostics []ParseDiagnostic
+}
+
+// A line the parser logged while the preview was built
+type ParseDiagnostic struct {
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Attrs   map[string]interface{} `json:"attrs,omitempty"`
+}
+
+// Fetches and parses the item's page without saving anything. Errors fetching
+// the page are returned, errors parsing it are part of the preview
+func PreviewItem(ctx context.Context, name string) (ItemPreview, error) {
+	start := time.Now()
+	item := Item{ name: strings.Replace(name, "_", " ", -1), displayName: TitleCase(name, true) }
+	preview := ItemPreview{ uri: item.wikiUri() }
+
+	recorder := &diagnosticsHandler{ inner: LoggerFrom(ctx).Handler(), diagnostics: &preview.diagnostics, lock: &sync.Mutex{} }
+	ctx = WithLogger(ctx, slog.New(recorder))
+
+	body, uri, err := FetchResolvedWikiPage(ctx, preview.uri)
+	if err != nil {
+		return preview, err
+	}
+	preview.uri = uri
+	preview.bodySize = len(body)
+
+	if _, isDisambiguation := WikiDisambiguationLinks(body); isDisambiguation {
+		preview.err = ErrDisambiguation
+		preview.item = item
+		preview.duration = time.Since(start)
+		return preview, nil
+	}
+
+	// The same check extractItemDataFromHttpResponse makes
+	if len(spellClassRegex.FindAllStringSubmatch(body, -1)) > 0 && len(spellLevelRegex.FindAllStringSubmatch(body, -1)) > 0 {
+		preview.parser = "spell"
+		spell := Spell{ name: spellLineName(item.name) }
+		if !spell.extractSpellData(body) {
+			preview.err = ErrNotAnItemPage
+		}
+		preview.spell = &spell
+	} else {
+		preview.parser = "item"
+		preview.err = item.parseItemPage(ctx, body)
+	}
+
+	preview.item = item
+	preview.duration = time.Since(start)
+	return preview, nil
+}
+
+func (p ItemPreview) MarshalJSON() ([]byte, error) {
+	var item *Item
+	if p.parser == "item" {
+		item = &p.item
+	}
+	var parseError *string
+	if p.err != nil {
+		label := ErrorLabel(p.err)
+		parseError = &label
+	}
+	diagnostics := p.diagnostics
+	if diagnostics == nil {
+		diagnostics = []ParseDiagnostic{}
+	}
+
+	return json.Marshal(struct {
+		Item        *Item             `json:"item"`
+		Spell       *Spell            `json:"spell,omitempty"`
+		Uri         string            `json:"uri"`
+		Parser      string            `json:"parser"`
+		BodySize    int               `json:"bodySize"`
+		DurationMs  int64             `json:"durationMs"`
+		Error       *string           `json:"error"`
+		Diagnostics []ParseDiagnostic `json:"diagnostics"`
+	}{item, p.spell, p.uri, p.parser, p.bodySize, p.duration.Milliseconds(), parseError, diagnostics})
+}
+
+// Keeps every record, whatever its level, and passes on the ones the inner
+// handler wants so the preview is still logged as normal
+type diagnosticsHandler struct {
+	inner slog.Handler
+	diagnostics *[]ParseDiagnostic
+	lock *sync.Mutex
+}
+
+func (h *diagnosticsHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return true
+}
+
+func (h *diagnosticsHandler) Handle(ctx context.Context, record slog.Record) error {
+	diagnostic := ParseDiagnostic{ Level: strings.ToLower(record.Level.String()), Message: record.Message }
+	record.Attrs(func(attr slog.Attr) bool {
+		if diagnostic.Attrs == nil {
+			diagnostic.Attrs = make(map[string]interface{})
+		}
+		value := attr.Value.Resolve().Any()
+		switch typed := value.(type) {
+		case error:
+			value = typed.Error()
+		case time.Duration:
+			value = typed.String()
+		}
+		diagnostic.Attrs[attr.Key] = value
+		return true
+	})
+
+	h.lock.Lock()
+	*h.diagnostics = append(*h.diagnostics, diagnostic)
+	h.lock.Unlock()
+
+	if h.inner.Enabled(ctx, record.Level) {
+		return h.inner.Handle(ctx, record)
+	}
+	return nil
+}
+
+// Attributes added by With are passed on but left out of the diagnostics, they
+// only repeat the item and request
+func (h *diagnosticsHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &diagnosticsHandler{ h.inner.WithAttrs(attrs), h.diagnostics, h.lock }
+}
+
+func (h *diagnosticsHandler) WithGroup(name string) slog.Handler {
+	return &diagnosticsHandler{ h.inner.WithGroup(name), h.diagnostics, h.lock }
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strconv"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ItemReportRow
+ |--------------------------------------------------------------------------
+ |
+ | Represents an item as a spreadsheet row, with a column for each of the
+ | stats people sort and filter loot by, or for each of the stats asked
+ | for. Text stats such as SLOT and CLASS are kept as the wiki writes them,
+ | numeric stats are numbers so they can be summed and sorted. Stats an
+ | item doesn't have are left blank
+ |
+ | @member item (Item): The item, with its statistics loaded
+ | @member stats ([]string): Stat codes with a column each, in order
+ |
+ */
+
+type ItemReportRow struct {
+	item Item
+	stats []string
+}
+
+// Text stats, then numeric stats, in the order they appear in the sheet
+var reportTextStats = []string{"SLOT", "CLASS", "RACE", "SKILL"}
+
+var reportNumericStats = []string{
+	"AC", "HP", "MANA", "STR", "STA", "AGI", "DEX", "WIS", "INT", "CHA",
+	"SV FIRE", "SV COLD", "SV DISEASE", "SV MAGIC", "SV POISON",
+	"DMG", "ATK DELAY", "RANGE", "HASTE", "WT",
+}
+
+// The stat columns a report has when none are asked for
+func DefaultReportStats() []string {
+	return append(append([]string{}, reportTextStats...), reportNumericStats...)
+}
+
+func ItemReportHeader(stats []string) []string {
+	header := []string{"Name"}
+	header = append(header, stats...)
+	return append(header, "Price", "Vendor Price", "Wiki")
+}
+
+func NewItemReportRow(item Item, stats []string) ItemReportRow {
+	return ItemReportRow{ item: item, stats: stats }
+}
+
+// The row's cells, each is a string, a float64 or nil if it's blank
+func (r ItemReportRow) Cells() []interface{} {
+	text := map[string]string{}
+	numbers := map[string]float64{}
+	for _, stat := range r.item.statistics {
+		if stat.value.Valid {
+			numbers[stat.code] = stat.value.Float64
+		} else {
+			text[stat.code] = stat.effect
+		}
+	}
+
+	cells := []interface{}{ TitleCase(r.item.name, false) }
+	for _, code := range r.stats {
+		if value, exists := numbers[code]; exists {
+			cells = append(cells, value)
+		} else if value, exists := text[code]; exists {
+			cells = append(cells, value)
+		} else {
+			cells = append(cells, nil)
+		}
+	}
+
+	if r.item.price > 0 {
+		cells = append(cells, float64(r.item.price))
+	} else {
+		cells = append(cells, nil)
+	}
+	if r.item.vendorSellPrice.Valid {
+		cells = append(cells, r.item.vendorSellPrice.Float64)
+	} else {
+		cells = append(cells, nil)
+	}
+	return append(cells, Settings.WikiBaseUrl + "/" + TitleCase(r.item.name, true))
+}
+
+// The cells as strings for CSV, where blanks are empty strings
+func (r ItemReportRow) Strings() []string {
+	var values []string
+	for _, cell := range r.Cells() {
+		switch value := cell.(type) {
+		case float64:
+			values = append(values, strconv.FormatFloat(value, 'f', -1, 64))
+		case string:
+			values = append(values, value)
+		default:
+			values = append(values, "")
+		}
+	}
+	return values
+}
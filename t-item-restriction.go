@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Class, race and slot restrictions
+ |--------------------------------------------------------------------------
+ |
+ | The CLASS and RACE stats are free text ("WAR PAL SHD", "ALL except
+ | IKS"), which can only be searched with LIKE. When an item is saved
+ | they're also expanded into the item_classes and item_races tables, one
+ | row for each class or race that can use the item, so ALL is stored as
+ | every class. Both reference the classes and races tables, which are
+ | filled from classAbbreviations and raceAbbreviations on boot. The SLOT
+ | stat is split into item_slots the same way, one row for each slot
+ |
+ */
+
+var restrictionTokenRegex = regexp.MustCompile(`[A-Za-z]+`)
+
+// Every slot an item can be worn in, as the wiki writes them
+var itemSlots = []string{
+	"CHARM", "EAR", "HEAD", "FACE", "NECK", "SHOULDERS", "ARMS", "BACK", "WRIST", "RANGE",
+	"HANDS", "PRIMARY", "SECONDARY", "FINGER", "CHEST", "LEGS", "FEET", "WAIST", "AMMO",
+}
+
+// Returns the canonical slot, accepting the plurals some pages use i.e. EARS
+// or FINGERS. Returns false if the slot doesn't exist
+func NormaliseSlot(slot string) (string, bool) {
+	slot = strings.ToUpper(strings.TrimSpace(slot))
+	for _, known := range itemSlots {
+		if slot == known || slot == known + "S" {
+			return known, true
+		}
+	}
+	return "", false
+}
+
+// Splits a SLOT stat into the canonical slots it lists, in the order listed
+func ParseItemSlots(effect string) []string {
+	var slots []string
+	seen := make(map[string]bool)
+	for _, token := range restrictionTokenRegex.FindAllString(effect, -1) {
+		if slot, exists := NormaliseSlot(token); exists && !seen[slot] {
+			seen[slot] = true
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+// Expands a CLASS stat into the abbreviations of the classes which can use the
+// item. ALL means every class apart from any listed after it
+func ParseItemClasses(effect string) []string {
+	return expandRestriction(effect, classAbbreviations, NormaliseClass)
+}
+
+// Expands a RACE stat into the abbreviations of the races which can use the
+// item, the same way as ParseItemClasses
+func ParseItemRaces(effect string) []string {
+	return expandRestriction(effect, raceAbbreviations, NormaliseRace)
+}
+
+func expandRestriction(effect string, abbreviations map[string]string, normalise func(string) (string, bool)) []string {
+	tokens := restrictionTokenRegex.FindAllString(effect, -1)
+
+	isAll := false
+	listed := make(map[string]bool)
+	for _, token := range tokens {
+		if strings.EqualFold(token, "ALL") {
+			isAll = true
+		} else if abbreviation, exists := normalise(token); exists {
+			listed[abbreviation] = true
+		}
+	}
+
+	var expanded []string
+	for _, abbreviation := range abbreviations {
+		if (isAll && !listed[abbreviation]) || (!isAll && listed[abbreviation]) {
+			expanded = append(expanded, abbreviation)
+		}
+	}
+	sort.Strings(expanded)
+	return expanded
+}
+
+// Returns the classes, races and slots the item's CLASS, RACE and SLOT stats
+// expand to
+func (i *Item) restrictions() ([]string, []string, []string) {
+	var classes, races, slots []string
+	for _, stat := range i.statistics {
+		switch stat.code {
+		case "CLASS":
+			classes = append(classes, ParseItemClasses(stat.effect)...)
+		case "RACE":
+			races = append(races, ParseItemRaces(stat.effect)...)
+		case "SLOT":
+			slots = append(slots, ParseItemSlots(stat.effect)...)
+		}
+	}
+	return classes, races, slots
+}
+
+// Replaces the item's rows in item_classes, item_races and item_slots with
+// those its CLASS, RACE and SLOT stats expand to
+func (i *Item) saveRestrictions(tx *Tx, id int64) error {
+	classes, races, slots := i.restrictions()
+	if err := replaceRestrictionRows(tx, "item_classes", "class", id, classes); err != nil {
+		return err
+	}
+	if err := replaceRestrictionRows(tx, "item_races", "race", id, races); err != nil {
+		return err
+	}
+	return replaceRestrictionRows(tx, "item_slots", "slot", id, slots)
+}
+
+func replaceRestrictionRows(tx *Tx, table string, column string, id int64, values []string) error {
+	rows, err := tx.Query("DELETE FROM " + table + " WHERE item_id = ?", id)
+	if err != nil {
+		LoggerFrom(tx.ctx).Error("Failed to remove old restrictions", "table", table, "err", err)
+		return ErrDatabaseWrite
+	}
+	DB.CloseRows(rows)
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	var parameters []interface{}
+	query := "INSERT IGNORE INTO " + table + " (item_id, " + column + ") VALUES "
+	for _, value := range values {
+		query += "(?, ?),"
+		parameters = append(parameters, id, value)
+	}
+	query = query[0:len(query)-1]
+
+	if _, err := tx.Insert(query, parameters...); err != nil {
+		LoggerFrom(tx.ctx).Error("Failed to save restrictions", "table", table, "err", err)
+		return ErrDatabaseWrite
+	}
+	return nil
+}
+
+// Fills the classes and races tables, this runs on every boot so classes or
+// races added to the maps are picked up
+func SeedRestrictionTables(ctx context.Context) error {
+	err := DB.Transaction(ctx, func(tx *Tx) error {
+		for name, abbreviation := range classAbbreviations {
+			query := "INSERT INTO classes (abbreviation, name) VALUES (?, ?) ON DUPLICATE KEY UPDATE name = VALUES(name)"
+			if _, err := tx.Insert(query, abbreviation, TitleCase(name, false)); err != nil {
+				return ErrDatabaseWrite
+			}
+		}
+		for name, abbreviation := range raceAbbreviations {
+			size := ""
+			for wearerSize, races := range raceSizes {
+				for _, race := range races {
+					if race == abbreviation {
+						size = wearerSize
+					}
+				}
+			}
+			query := "INSERT INTO races (abbreviation, name, size) VALUES (?, ?, ?) " +
+				"ON DUPLICATE KEY UPDATE name = VALUES(name), size = VALUES(size)"
+			if _, err := tx.Insert(query, abbreviation, TitleCase(name, false), size); err != nil {
+				return ErrDatabaseWrite
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		LoggerFrom(ctx).Error("Failed to seed classes and races", "err", err)
+	}
+	return err
+}
+
+// Fills item_classes, item_races and item_slots for every item from its stats,
+// for items saved before the tables existed. Returns the number of items which
+// failed
+func BackfillItemRestrictions(ctx context.Context) int {
+	query := "SELECT items.id, statistics.code, statistics.effect FROM items " +
+		"INNER JOIN statistics ON statistics.item_id = items.id " +
+		"AND statistics.code IN ('CLASS', 'RACE', 'SLOT') " +
+		"ORDER BY items.id ASC"
+	rows, err := DB.QueryContext(ctx, query)
+	if err != nil {
+		return 1
+	}
+	var ids []int64
+	items := make(map[int64]*Item)
+	for rows.Next() {
+		var (
+			id int64
+			stat Statistic
+		)
+		if err := rows.Scan(&id, &stat.code, &stat.effect); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		if items[id] == nil {
+			ids = append(ids, id)
+			items[id] = &Item{ id: id }
+		}
+		items[id].statistics = append(items[id].statistics, stat)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+	DB.CloseRows(rows)
+
+	failed := 0
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+		item := items[id]
+		err := DB.Transaction(ctx, func(tx *Tx) error {
+			return item.saveRestrictions(tx, id)
+		})
+		if err != nil {
+			failed++
+		}
+	}
+	Log.Info("Backfilled item restrictions", "items", len(ids), "failed", failed)
+	return failed
+}
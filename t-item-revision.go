@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ItemRevision
+ |--------------------------------------------------------------------------
+ |
+ | Represents the stats and effects an item had from a point in time. A
+ | revision is recorded whenever a save changes them, so the item as it was
+ | stored on any date is the latest revision created before then. Useful
+ | for researching pre-nerf values, wiki vandalism and parser regressions
+ |
+ | @member id (int64): Primary key of the item_revisions row
+ | @member itemId (int64): The item the revision belongs to
+ | @member statistics ([]Statistic): The item's stats as of the revision
+ | @member effects ([]Effect): The item's effects as of the revision
+ | @member changes (*ItemRevisionDiff): What changed since the revision before, nil for the first
+ | @member createdAt (time.Time): When the revision was saved
+ |
+ */
+
+type ItemRevision struct {
+	id int64
+	itemId int64
+	statistics []Statistic
+	effects []Effect
+	changes *ItemRevisionDiff
+	createdAt time.Time
+}
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ItemRevisionDiff
+ |--------------------------------------------------------------------------
+ |
+ | Represents what changed between two revisions of an item. Stats are
+ | compared by code, as some codes such as CLASS can appear more than once
+ | their changes hold every stat with the code on either side. Effects are
+ | compared by name and restriction
+ |
+ | @member statistics ([]StatisticChange): Stats which were added, removed or changed
+ | @member effectsAdded ([]Effect): Effects the newer revision has which the older didn't
+ | @member effectsRemoved ([]Effect): Effects the older revision had which the newer doesn't
+ |
+ */
+
+type ItemRevisionDiff struct {
+	statistics []StatisticChange
+	effectsAdded []Effect
+	effectsRemoved []Effect
+}
+
+// from is empty if the stat was added, to is empty if it was removed
+type StatisticChange struct {
+	code string
+	from []Statistic
+	to []Statistic
+}
+
+// Stored in the same format as the Redis cache, so the API format can change
+// without rewriting every revision
+type itemRevisionData struct {
+	Statistics []cachedStatistic `json:"statistics"`
+	Effects    []cachedEffect    `json:"effects"`
+}
+
+func encodeItemRevision(item Item) ([]byte, error) {
+	data := itemRevisionData{ Statistics: []cachedStatistic{}, Effects: []cachedEffect{} }
+	for _, stat := range item.statistics {
+		data.Statistics = append(data.Statistics, cachedStatistic{ Code: stat.code, Value: stat.value, Effect: stat.effect })
+	}
+	for _, effect := range item.effects {
+		data.Effects = append(data.Effects, newCachedEffect(effect))
+	}
+	return json.Marshal(data)
+}
+
+// Records the item's stats and effects unless they're the same as its latest
+// revision, returns ErrDatabaseWrite if the revision couldn't be saved
+func SaveItemRevision(tx *Tx, item Item) error {
+	data, err := encodeItemRevision(item)
+	if err != nil {
+		LoggerFrom(tx.ctx).Error("Failed to encode item revision", "err", err)
+		return ErrDatabaseWrite
+	}
+
+	query := "SELECT data FROM item_revisions WHERE item_id = ? ORDER BY created_at DESC, id DESC LIMIT 1"
+	rows, err := tx.Query(query, item.id)
+	if err != nil {
+		return ErrDatabaseWrite
+	}
+	var latest []byte
+	for rows.Next() {
+		if err := rows.Scan(&latest); err != nil {
+			Log.Error("Scan failed", "err", err)
+		}
+	}
+	DB.CloseRows(rows)
+
+	if bytes.Equal(latest, data) {
+		return nil
+	}
+
+	query = "INSERT INTO item_revisions (item_id, data) VALUES (?, ?)"
+	if _, err := tx.Insert(query, item.id, data); err != nil {
+		return ErrDatabaseWrite
+	}
+	LoggerFrom(tx.ctx).Debug("Saved item revision")
+	return nil
+}
+
+// Returns the item's latest revisions, newest first, each with what changed
+// since the revision before it
+func FetchItemRevisions(ctx context.Context, itemId int64, limit int) ([]ItemRevision, error) {
+	revisions := []ItemRevision{}
+
+	// One more than asked for so the oldest returned has something to diff with
+	query := "SELECT id, data, created_at " +
+		"FROM item_revisions " +
+		"WHERE item_id = ? " +
+		"ORDER BY created_at DESC, id DESC " +
+		"LIMIT ?"
+
+	rows, err := DB.QueryContext(ctx, query, itemId, limit + 1)
+	if err != nil {
+		return revisions, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		revision := ItemRevision{ itemId: itemId }
+		var raw []byte
+		if err := rows.Scan(&revision.id, &raw, &revision.createdAt); err != nil {
+			Log.Error("Scan failed", "err", err)
+			return revisions, ErrDatabaseRead
+		}
+		if err := revision.decode(raw); err != nil {
+			LoggerFrom(ctx).Error("Failed to decode item revision", "revision", revision.id, "err", err)
+			return revisions, ErrDatabaseRead
+		}
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+		return revisions, ErrDatabaseRead
+	}
+
+	for idx := 0; idx + 1 < len(revisions); idx++ {
+		changes := DiffItemRevisions(revisions[idx + 1], revisions[idx])
+		revisions[idx].changes = &changes
+	}
+	if len(revisions) > limit {
+		revisions = revisions[:limit]
+	}
+	return revisions, nil
+}
+
+// Returns what changed going from the older revision to the newer
+func DiffItemRevisions(older ItemRevision, newer ItemRevision) ItemRevisionDiff {
+	diff := ItemRevisionDiff{ statistics: []StatisticChange{}, effectsAdded: []Effect{}, effectsRemoved: []Effect{} }
+
+	var codes []string
+	from := make(map[string][]Statistic)
+	to := make(map[string][]Statistic)
+	for _, stat := range newer.statistics {
+		if _, seen := to[stat.code]; !seen {
+			codes = append(codes, stat.code)
+		}
+		to[stat.code] = append(to[stat.code], stat)
+	}
+	for _, stat := range older.statistics {
+		if _, seen := from[stat.code]; !seen {
+			if _, seen := to[stat.code]; !seen {
+				codes = append(codes, stat.code)
+			}
+		}
+		from[stat.code] = append(from[stat.code], stat)
+	}
+	for _, code := range codes {
+		if !sameStatistics(from[code], to[code]) {
+			change := StatisticChange{ code: code, from: from[code], to: to[code] }
+			if change.from == nil {
+				change.from = []Statistic{}
+			}
+			if change.to == nil {
+				change.to = []Statistic{}
+			}
+			diff.statistics = append(diff.statistics, change)
+		}
+	}
+
+	effectKey := func(effect Effect) string {
+		return effect.name + "\x00" + effect.restriction
+	}
+	had := make(map[string]bool)
+	for _, effect := range older.effects {
+		had[effectKey(effect)] = true
+	}
+	has := make(map[string]bool)
+	for _, effect := range newer.effects {
+		has[effectKey(effect)] = true
+		if !had[effectKey(effect)] {
+			diff.effectsAdded = append(diff.effectsAdded, effect)
+		}
+	}
+	for _, effect := range older.effects {
+		if !has[effectKey(effect)] {
+			diff.effectsRemoved = append(diff.effectsRemoved, effect)
+		}
+	}
+	return diff
+}
+
+// Order doesn't matter, the parser doesn't promise one for repeated codes
+func sameStatistics(a []Statistic, b []Statistic) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int)
+	for _, stat := range a {
+		counts[stat.Text()]++
+	}
+	for _, stat := range b {
+		counts[stat.Text()]--
+		if counts[stat.Text()] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the revision the item was at, at the time. Returns false if the item
+// has no revision that old
+func FetchItemRevisionAsOf(ctx context.Context, itemId int64, at time.Time) (ItemRevision, bool, error) {
+	revision := ItemRevision{ itemId: itemId }
+
+	query := "SELECT id, data, created_at " +
+		"FROM item_revisions " +
+		"WHERE item_id = ? AND created_at <= ? " +
+		"ORDER BY created_at DESC, id DESC " +
+		"LIMIT 1"
+
+	rows, err := DB.QueryContext(ctx, query, itemId, at)
+	if err != nil {
+		return revision, false, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	var raw []byte
+	exists := false
+	for rows.Next() {
+		if err := rows.Scan(&revision.id, &raw, &revision.createdAt); err != nil {
+			Log.Error("Scan failed", "err", err)
+			return revision, false, ErrDatabaseRead
+		}
+		exists = true
+	}
+	if !exists {
+		return revision, false, nil
+	}
+
+	if err := revision.decode(raw); err != nil {
+		LoggerFrom(ctx).Error("Failed to decode item revision", "revision", revision.id, "err", err)
+		return revision, false, ErrDatabaseRead
+	}
+	return revision, true, nil
+}
+
+func (r *ItemRevision) decode(raw []byte) error {
+	var data itemRevisionData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return err
+	}
+	for _, stat := range data.Statistics {
+		r.statistics = append(r.statistics, Statistic{ code: stat.Code, value: stat.Value, effect: stat.Effect })
+	}
+	for _, effect := range data.Effects {
+		r.effects = append(r.effects, effect.Effect())
+	}
+	return nil
+}
+
+func (r ItemRevision) MarshalJSON() ([]byte, error) {
+	statistics := r.statistics
+	if statistics == nil {
+		statistics = []Statistic{}
+	}
+	effects := r.effects
+	if effects == nil {
+		effects = []Effect{}
+	}
+
+	return json.Marshal(struct {
+		Id         int64             `json:"id"`
+		Statistics []Statistic       `json:"statistics"`
+		Effects    []Effect          `json:"effects"`
+		Changes    *ItemRevisionDiff `json:"changes"`
+		CreatedAt  time.Time         `json:"createdAt"`
+	}{r.id, statistics, effects, r.changes, r.createdAt})
+}
+
+func (d ItemRevisionDiff) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Statistics     []StatisticChange `json:"statistics"`
+		EffectsAdded   []Effect          `json:"effectsAdded"`
+		EffectsRemoved []Effect          `json:"effectsRemoved"`
+	}{d.statistics, d.effectsAdded, d.effectsRemoved})
+}
+
+func (c StatisticChange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Code string      `json:"code"`
+		From []Statistic `json:"from"`
+		To   []Statistic `json:"to"`
+	}{c.code, c.from, c.to})
+}
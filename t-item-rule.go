@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ItemRule
+ |--------------------------------------------------------------------------
+ |
+ | Represents a server specific rule about an item, some servers disallow
+ | duped or legacy items so clients ask for items with ?server= and any
+ | rules for that server are returned alongside them. Rules can also keep
+ | an item out of the market index for that server
+ |
+ | @member id (int64): Primary key of the item_rules row
+ | @member server (string): The server the rule applies to i.e. green
+ | @member itemId (int64): The item the rule applies to
+ | @member itemName (string): Name of the item, for display only
+ | @member rule (string): One of the ITEM_RULE constants
+ | @member reason (string): Free text explaining the rule
+ | @member excludeFromMarket (bool): Whether the item is left out of aggregates
+ | @member createdAt (time.Time): When the rule was added
+ |
+ */
+
+type ItemRule struct {
+	id int64
+	server string
+	itemId int64
+	itemName string
+	rule string
+	reason string
+	excludeFromMarket bool
+	createdAt time.Time
+}
+
+const (
+	ITEM_RULE_BANNED = "banned"
+	ITEM_RULE_DUPED  = "duped"
+	ITEM_RULE_LEGACY = "legacy"
+)
+
+var serverRegex = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// Returns the server name in the form it is stored, returns false if the name
+// isn't something we would store
+func NormaliseServer(server string) (string, bool) {
+	server = strings.ToLower(strings.TrimSpace(server))
+	return server, serverRegex.MatchString(server)
+}
+
+func IsItemRule(rule string) bool {
+	return rule == ITEM_RULE_BANNED || rule == ITEM_RULE_DUPED || rule == ITEM_RULE_LEGACY
+}
+
+// Writes the rule, an item can only have each rule once per server so saving
+// it again updates the reason and market exclusion instead
+func (r *ItemRule) Save() error {
+	query := "INSERT INTO item_rules " +
+		"(server, item_id, rule, reason, exclude_from_market) " +
+		"VALUES (?, ?, ?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), reason = VALUES(reason), " +
+		"exclude_from_market = VALUES(exclude_from_market)"
+
+	id, err := DB.Insert(query, r.server, r.itemId, r.rule, r.reason, r.excludeFromMarket)
+	if err != nil {
+		Log.Error("Failed to save item rule", "err", err)
+		return ErrDatabaseWrite
+	}
+	r.id = id
+	return nil
+}
+
+// Removes a rule from a server, returns false if the server has no such rule
+func DeleteItemRule(server string, id int64) bool {
+	exists := false
+	for _, rule := range FetchItemRules(server) {
+		if rule.id == id {
+			exists = true
+		}
+	}
+	if !exists {
+		return false
+	}
+
+	query := "DELETE FROM item_rules WHERE server = ? AND id = ?"
+	rows, err := DB.Query(query, server, id)
+	if err != nil {
+		Log.Error("Failed to delete item rule", "err", err)
+		return false
+	}
+	DB.CloseRows(rows)
+	return true
+}
+
+// Returns every rule for a server ordered by item name
+func FetchItemRules(server string) []ItemRule {
+	rules := []ItemRule{}
+
+	query := "SELECT item_rules.id, item_rules.server, item_rules.item_id, items.displayName, " +
+		"item_rules.rule, item_rules.reason, item_rules.exclude_from_market, item_rules.created_at " +
+		"FROM item_rules " +
+		"INNER JOIN items " +
+		"ON items.id = item_rules.item_id " +
+		"WHERE item_rules.server = ? " +
+		"ORDER BY items.name ASC, item_rules.rule ASC"
+
+	rows, err := DB.Query(query, server)
+	if err != nil {
+		return rules
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var r ItemRule
+		err := rows.Scan(&r.id, &r.server, &r.itemId, &r.itemName, &r.rule, &r.reason, &r.excludeFromMarket, &r.createdAt)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+	return rules
+}
+
+// Flags each item with the rules the server has for it, items without rules
+// are left untouched
+func attachRules(items []Item, server string) {
+	if len(items) == 0 || server == "" {
+		return
+	}
+
+	byId := make(map[int64][]*Item)
+	var parameters []interface{}
+	parameters = append(parameters, server)
+	for idx := range items {
+		if items[idx].id <= 0 {
+			continue
+		}
+		if _, exists := byId[items[idx].id]; !exists {
+			parameters = append(parameters, items[idx].id)
+		}
+		byId[items[idx].id] = append(byId[items[idx].id], &items[idx])
+	}
+	if len(byId) == 0 {
+		return
+	}
+
+	query := "SELECT id, server, item_id, rule, reason, exclude_from_market, created_at " +
+		"FROM item_rules " +
+		"WHERE server = ? " +
+		"AND item_id IN (" + Placeholders(len(parameters)-1) + ")"
+
+	rows, err := DB.Query(query, parameters...)
+	if err != nil {
+		return
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var r ItemRule
+		err := rows.Scan(&r.id, &r.server, &r.itemId, &r.rule, &r.reason, &r.excludeFromMarket, &r.createdAt)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		for _, item := range byId[r.itemId] {
+			r.itemName = item.displayName
+			item.rules = append(item.rules, r)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+}
+
+// SQL condition which leaves out items the server excludes from the market,
+// the server is its only parameter
+const marketExclusionSQL = "NOT EXISTS (SELECT 1 FROM item_rules WHERE item_rules.item_id = items.id " +
+	"AND item_rules.server = ? AND item_rules.exclude_from_market = 1)"
+
+func (r ItemRule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Id                int64     `json:"id"`
+		Server            string    `json:"server"`
+		ItemId            int64     `json:"itemId"`
+		ItemName          string    `json:"itemName"`
+		Rule              string    `json:"rule"`
+		Reason            string    `json:"reason"`
+		ExcludeFromMarket bool      `json:"excludeFromMarket"`
+		CreatedAt         time.Time `json:"createdAt"`
+	}{r.id, r.server, r.itemId, r.itemName, r.rule, r.reason, r.excludeFromMarket, r.createdAt})
+}
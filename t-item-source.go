@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ItemSource
+ |--------------------------------------------------------------------------
+ |
+ | Represents a mob an item drops from, as listed in the "Drops From"
+ | section of the item's wiki page. The wiki groups the mobs by zone
+ |
+ | @member npcName (string): Name of the mob as the page links it
+ | @member zone (string): Zone the mob is listed under, if any
+ |
+ */
+
+type ItemSource struct {
+	npcName string
+	zone string
+}
+
+// Parses the drop list, mobs are either listed beneath a zone heading or as
+// bullets nested under a zone bullet. Returns an empty list if the item isn't
+// dropped by anything
+func ParseItemSources(document *html.Node) []ItemSource {
+	sources := []ItemSource{}
+	seen := make(map[string]bool)
+
+	add := func(npcName string, zone string) {
+		key := strings.ToLower(npcName + "|" + zone)
+		if npcName == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		sources = append(sources, ItemSource{ npcName: npcName, zone: zone })
+	}
+
+	var walkList func(list *html.Node, zone string)
+	walkList = func(list *html.Node, zone string) {
+		for item := list.FirstChild; item != nil; item = item.NextSibling {
+			if item.Type != html.ElementNode || item.Data != "li" {
+				continue
+			}
+			var (
+				link *html.Node
+				nested *html.Node
+			)
+			for child := item.FirstChild; child != nil; child = child.NextSibling {
+				if child.Type != html.ElementNode {
+					continue
+				}
+				if child.Data == "ul" || child.Data == "ol" {
+					nested = child
+				} else if link == nil {
+					link = FindFirst(child, ByTag("a"))
+				}
+			}
+			if nested != nil {
+				if link != nil {
+					walkList(nested, LinkTitle(link))
+				} else {
+					walkList(nested, zone)
+				}
+			} else if link != nil {
+				add(LinkTitle(link), zone)
+			}
+		}
+	}
+
+	zone := ""
+	for _, node := range SectionNodes(document, "Drops_From", "Dropped_By") {
+		if node.Data == "ul" || node.Data == "ol" {
+			walkList(node, zone)
+			continue
+		}
+		if link := FindFirst(node, ByTag("a")); link != nil {
+			zone = LinkTitle(link)
+		} else if text := strings.TrimRight(TextContent(node), ": "); text != "" {
+			zone = text
+		}
+	}
+
+	return sources
+}
+
+// Replaces the item's sources, items saved without having been scraped keep
+// the sources they have
+func (i *Item) saveSources(tx *Tx, id int64) error {
+	if i.sources == nil {
+		return nil
+	}
+
+	rows, err := tx.Query("DELETE FROM item_sources WHERE item_id = ?", id)
+	if err != nil {
+		return ErrDatabaseWrite
+	}
+	DB.CloseRows(rows)
+
+	if len(i.sources) == 0 {
+		return nil
+	}
+
+	var parameters []interface{}
+	query := "INSERT INTO item_sources " +
+		"(item_id, npc_name, zone) " +
+		"VALUES "
+	for _, source := range i.sources {
+		query += "(?, ?, ?),"
+		parameters = append(parameters, id, source.npcName, source.zone)
+	}
+	query = query[0:len(query)-1]
+
+	if _, err := tx.Insert(query, parameters...); err != nil {
+		return ErrDatabaseWrite
+	}
+	return nil
+}
+
+// Returns every mob we know drops the item, grouped by zone
+func FetchItemSources(ctx context.Context, itemId int64) ([]ItemSource, error) {
+	sources := []ItemSource{}
+
+	query := "SELECT npc_name, zone " +
+		"FROM item_sources " +
+		"WHERE item_id = ? " +
+		"ORDER BY zone ASC, npc_name ASC"
+
+	rows, err := DB.QueryContext(ctx, query, itemId)
+	if err != nil {
+		return sources, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var source ItemSource
+		if err := rows.Scan(&source.npcName, &source.zone); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		sources = append(sources, source)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+		return sources, ErrDatabaseRead
+	}
+
+	return sources, nil
+}
+
+func (s ItemSource) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Npc  string `json:"npc"`
+		Zone string `json:"zone"`
+	}{s.npcName, s.zone})
+}
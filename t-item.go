@@ -1,14 +1,15 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"encoding/json"
 	"strings"
-	"net/http"
-	"io/ioutil"
 	"github.com/alexmk92/stringutil"
 	"regexp"
 	"strconv"
+	"time"
 	"database/sql"
+	"golang.org/x/net/html"
 )
 
 /*
@@ -17,14 +18,36 @@ import (
  |------------------------------------------------------------------
  |
  | Represents an item, when we fetch its data we first attempt to
- | hit our file cache, if the item doesn't exist there we fetch
- | it from the Wiki and then store it to our Mongo store
+ | hit the local cache and Redis, then the item document store if
+ | item_store is mongo, then SQL. If the item doesn't exist there we
+ | fetch it from the Wiki and save it to SQL and the document store
  |
  | @member name (string): Name of the item (url encoded)
  | @member displayName (string): Name of the item (browser friendly)
  | @member imageSrc (string): URL for the image stored on wiki
- | @member price (float32): The advertised price
+ | @member price (float32): Running average of the price the item is
+ | advertised at in auction lines, in platinum
+ | @member vendorSellPrice (sql.NullFloat64): What vendors sell the item for
+ | @member vendorBuyPrice (sql.NullFloat64): What vendors pay for the item
  | @member statistics ([]Statistic): An array of all stats for this item
+ | @member sources ([]ItemSource): Mobs the item drops from, only set when
+ | the item has just been scraped
+ | @member factions ([]ItemFaction): Faction standing the item needs, only set
+ | when the item has just been scraped
+ | @member rules ([]ItemRule): Rules the requested server has for this item
+ | @member candidates ([]string): Items the name could mean, only set when
+ | the wiki page was a disambiguation page
+ | @member version (int): Which itemData block on the page the item is, from
+ | 1. Pages with legacy and revamped versions of an item have several
+ | @member versions ([]Item): The page's other versions of the item, only set
+ | when the item has just been parsed
+ | @member container (*ItemContainer): What the item holds, nil unless it's
+ | a bag
+ | @member ratio (sql.NullFloat64): Damage divided by delay, only set for weapons
+ | @member hasteAdjustedDelay (sql.NullFloat64): Delay once the weapon's own
+ | haste is applied, only set for weapons
+ | @member description (string): The item's lore blurb followed by its tooltip
+ | as it reads on the wiki, one line per stat
  |
  */
 
@@ -34,191 +57,706 @@ type Item struct {
 	displayName string
 	imageSrc string
 	price float32
+	vendorSellPrice sql.NullFloat64
+	vendorBuyPrice sql.NullFloat64
 	statistics []Statistic
 	effects []Effect
+	sources []ItemSource
+	factions []ItemFaction
+	rules []ItemRule
+	candidates []string
+	version int
+	versions []Item
+	description string
+	container *ItemContainer
+	ratio sql.NullFloat64
+	hasteAdjustedDelay sql.NullFloat64
+	// Set by Refresh so the save drops any corrections made by hand
+	clearOverride bool
+}
+
+// The first version on the page is the item, unversioned lookups get that one.
+// Pages never have anywhere near MAX_ITEM_VERSIONS
+const (
+	DEFAULT_ITEM_VERSION = 1
+	MAX_ITEM_VERSIONS    = 20
+)
+
+// Longest a shared fetch may take, enough for the SQL lookup, the item and
+// spell pages and saving what was scraped
+const ITEM_FETCH_TIMEOUT = 60 * time.Second
+
+func (i *Item) Version() int {
+	if i.version < DEFAULT_ITEM_VERSION {
+		return DEFAULT_ITEM_VERSION
+	}
+	return i.version
 }
 
 // Public method to fetch data for this item, in Go public method are
 // capitalised by convention (doesn't actually enforce Public/Private methods in go)
-// this method will call fetchDataFromWiki and fetchDataFromCache where appropriate
-func (i *Item) FetchData() {
-	fmt.Println("Fetching data for item: ", i.name)
+// this method will call fetchDataFromWiki and fetchDataFromCache where appropriate.
+// The item caches are checked before SQL, items found in SQL are cached.
+// Concurrent fetches of the same uncached item share a single SQL lookup and
+// wiki scrape. That runs on AppContext for up to ITEM_FETCH_TIMEOUT rather than
+// on any one caller's ctx, so a caller giving up doesn't fail the others.
+// Returns one of the errors in errors.go, or the context's error, if it couldn't be fetched
+func (i *Item) FetchData(ctx context.Context) error {
+	start := time.Now()
+	logger := LoggerFrom(ctx).With("item", i.name)
+	ctx = WithLogger(ctx, logger)
+
+	requested := i.name
+	if cached, exists := FetchCachedItem(requested); exists {
+		*i = cached
+		logger.Debug("Fetched item from cache", "duration", time.Since(start))
+		return nil
+	}
+
+	// The tenant is carried over so a scrape's feed event goes to the right one
+	fetchCtx := WithTenant(WithLogger(AppContext, logger), TenantFrom(ctx))
+	item, err := ItemFetches.Do(ctx, itemCacheKey(requested), func() (Item, error) {
+		ctx, cancel := context.WithTimeout(fetchCtx, ITEM_FETCH_TIMEOUT)
+		defer cancel()
+		fetched := *i
+		err := fetched.fetchUncachedData(ctx, requested)
+		return fetched, err
+	})
+	if err != nil && err == ctx.Err() {
+		logger.Debug("Gave up waiting on item fetch", "duration", time.Since(start))
+		return err
+	}
+	*i = copyItem(item)
+	logger.Debug("Fetched item", "duration", time.Since(start), "err", err)
+	return err
+}
+
+// Looks the item up in SQL and then on the wiki, requested is the name the
+// item was asked for so it can be cached under that name
+func (i *Item) fetchUncachedData(ctx context.Context, requested string) error {
 	i.displayName = TitleCase(i.name, true)
 
-	if(i.fetchDataFromSQL()) {
-		fmt.Println("Exists in SQL")
-	} else {
-		if stringutil.CaseInsenstiveContains("spell:") {
-			i.fetchDataFromWiki()
-		} else {
-			i.displayName = "Spell:_" + i.displayName
-			i.name = "Spell: " + i.name
-			// Check that this wasn't actually a spell that existed if Spell: was omitted,
-			// otherwise this will permanently hit the Wiki
-			if(!i.fetchDataFromSQL()) {
-				i.displayName = strings.Replace(i.displayName, "Spell:_", "", 1)
-				i.name = strings.Replace(i.name, "Spell: ", "", 1)
-				i.fetchDataFromWiki()
-			} else {
-				fmt.Println("Exists in SQL")
-			}
-		}
+	if document, exists := FetchItemDocument(ctx, requested); exists {
+		LoggerFrom(ctx).Debug("Item exists in the document store")
+		*i = document
+		CacheItem(requested, *i)
+		return nil
+	}
+
+	exists, err := i.fetchDataFromSQL(ctx)
+	if err != nil {
+		return err
+	}
+	if exists {
+		LoggerFrom(ctx).Debug("Item exists in SQL")
+		CacheItem(requested, *i)
+		StoreItemDocument(ctx, *i)
+		return nil
+	}
+
+	if stringutil.CaseInsenstiveContains("spell:") {
+		return i.fetchDataFromWiki(ctx)
 	}
+
+	i.displayName = "Spell:_" + i.displayName
+	i.name = "Spell: " + i.name
+	// Check that this wasn't actually a spell that existed if Spell: was omitted,
+	// otherwise this will permanently hit the Wiki
+	exists, err = i.fetchDataFromSQL(ctx)
+	if err != nil {
+		return err
+	}
+	if exists {
+		LoggerFrom(ctx).Debug("Item exists in SQL")
+		CacheItem(requested, *i)
+		StoreItemDocument(ctx, *i)
+		return nil
+	}
+
+	i.displayName = strings.Replace(i.displayName, "Spell:_", "", 1)
+	i.name = strings.Replace(i.name, "Spell: ", "", 1)
+	return i.fetchDataFromWiki(ctx)
 }
 
-// Data didn't exist on our server, so we hit the wiki here
-func (i *Item) fetchDataFromWiki() {
+// Scrapes the item from the wiki again whether or not we have it, skipping
+// the caches and SQL. The scrape is saved as any other, replacing the stats
+// and effects we had in one transaction, so a badly parsed item can be fixed
+// once the parser or the page has been. Corrections made by hand are replaced
+// too. Items we haven't seen are scraped as they would be on first sight
+func (i *Item) Refresh(ctx context.Context) error {
+	logger := LoggerFrom(ctx).With("item", i.name)
+	ctx = WithLogger(ctx, logger)
 
-	uriString := TitleCase(strings.TrimSpace(strings.Replace(strings.ToLower(i.name), "spell:", "", -1)), true)
+	stored, _, err := fetchItemRow(ctx, i.name)
+	if err != nil {
+		return err
+	}
 
-	if uriString == "Silken_Cat-Fur_Girdle" {
-		uriString = "Silken_Cat-fur_Girdle"
+	stored.clearOverride = true
+	if err := stored.fetchDataFromWiki(ctx); err != nil {
+		logger.Warn("Couldn't refresh item", "id", stored.id, "err", err)
+		return err
 	}
+	logger.Info("Refreshed item", "id", stored.id)
+	*i = stored
+	return nil
+}
 
-	fmt.Println("Requesting data from: ", WIKI_BASE_URL + "/" + uriString)
+// Returns the id, name and display name of the item, whether or not we found
+// anything meaningful about it, looking for the spell too if the name was
+// sent without its Spell: prefix. Returns false with just the name set if
+// there's no such item
+func fetchItemRow(ctx context.Context, name string) (Item, bool, error) {
+	item := Item{ name: name, displayName: TitleCase(name, true) }
 
-	resp, err := http.Get(WIKI_BASE_URL + "/" + uriString)
-	if(err != nil) {
-		fmt.Println("ERROR GETTING DATA FROM WIKI: ", err)
+	query := "SELECT id, name, displayName FROM items " +
+		"WHERE (name = ? OR name = ? OR displayName = ?) AND version = " + strconv.Itoa(DEFAULT_ITEM_VERSION) + " " +
+		"ORDER BY name = ? DESC " +
+		"LIMIT 1"
+	rows, err := DB.QueryContext(ctx, query, name, "Spell: " + name, item.displayName, name)
+	if err != nil {
+		return item, false, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	if !rows.Next() {
+		return item, false, nil
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if(err != nil) {
-		fmt.Println("ERROR EXTRACTING BODY FROM RESPONSE: ", err)
+	if err := rows.Scan(&item.id, &item.name, &item.displayName); err != nil {
+		Log.Error("Scan failed", "err", err)
+		return item, false, ErrDatabaseRead
+	}
+	return item, true, nil
+}
+
+// All of our members are unexported, without this the encoder would write {}
+func (i Item) MarshalJSON() ([]byte, error) {
+	statistics := i.statistics
+	if statistics == nil {
+		statistics = []Statistic{}
+	}
+	effects := i.effects
+	if effects == nil {
+		effects = []Effect{}
+	}
+
+	var wearerSizes []string
+	for _, stat := range statistics {
+		if stat.code == "RACE" {
+			wearerSizes = WearerSizes(stat.effect)
+		}
+	}
+
+	return json.Marshal(struct {
+		Id                 int64          `json:"id"`
+		Name               string         `json:"name"`
+		DisplayName        string         `json:"displayName"`
+		ImageSrc           string         `json:"imageSrc"`
+		Price              float32        `json:"price"`
+		VendorSellPrice    *float64       `json:"vendorSellPrice"`
+		VendorBuyPrice     *float64       `json:"vendorBuyPrice"`
+		Statistics         []Statistic    `json:"statistics"`
+		Effects            []Effect       `json:"effects"`
+		WearerSizes        []string       `json:"wearerSizes,omitempty"`
+		Rules              []ItemRule     `json:"rules,omitempty"`
+		Version            int            `json:"version"`
+		Description        string         `json:"description"`
+		Container          *ItemContainer `json:"container,omitempty"`
+		DamageDelayRatio   *float64       `json:"damageDelayRatio,omitempty"`
+		HasteAdjustedDelay *float64       `json:"hasteAdjustedDelay,omitempty"`
+	}{i.id, i.name, i.displayName, i.imageSrc, i.price, nullFloatPointer(i.vendorSellPrice),
+		nullFloatPointer(i.vendorBuyPrice), statistics, effects, wearerSizes, i.rules, i.Version(), i.description,
+		i.container, nullFloatPointer(i.ratio), nullFloatPointer(i.hasteAdjustedDelay)})
+}
+
+// An item is considered resolved once we have found anything meaningful about it
+// either in SQL or on the wiki
+func (i *Item) Resolved() bool {
+	return i.imageSrc != "" || len(i.effects) > 0 || len(i.statistics) > 0
+}
+
+// Data didn't exist on our server, so we hit the wiki here. How every scrape
+// ended is counted by ErrorLabel
+func (i *Item) fetchDataFromWiki(ctx context.Context) (err error) {
+	defer func() { RecordScrapeOutcome(err) }()
+
+	requested := i.wikiUri()
+	body, uriString, err := FetchResolvedWikiPage(ctx, requested)
+	if err != nil {
+		return err
+	}
+	if links, isDisambiguation := WikiDisambiguationLinks(body); isDisambiguation {
+		LoggerFrom(ctx).Info("Wiki page is a disambiguation page", "uri", uriString, "candidates", len(links))
+		i.candidates = links
+		return ErrDisambiguation
+	}
+
+	// Items we haven't seen are saved under the name of the page we were
+	// redirected to, items we have keep theirs
+	redirected := uriString != requested
+	requestedName := i.name
+	if redirected && i.id <= 0 {
+		i.name = strings.Replace(uriString, "_", " ", -1)
+		i.displayName = uriString
 	}
 
 	if !stringutil.CaseInsenstiveContains(i.name, "spell:", "song:") && !stringutil.CaseInsenstiveContains(i.displayName, "spell:", "song:") {
-		i.extractItemDataFromHttpResponse(string(body))
+		err = i.extractItemDataFromHttpResponse(ctx, body)
 	} else {
-		i.extractSpellDataFromHttpBody(string(body))
+		err = i.extractSpellDataFromHttpBody(ctx, body)
+	}
+	if IsParseFailure(err) {
+		// Otherwise the item would just never be found, with nothing to say why
+		RecordParseFailure(ctx, i.name, uriString, body, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Keep the source alongside what we parsed so it can be reparsed later
+	i.saveWikitext(ctx, uriString)
+	SavePageSnapshot(ctx, i.id, uriString, body)
+
+	// The name we were asked for is another name for the item, so it's looked
+	// up as the item from now on rather than fetched from the wiki again. The
+	// wiki's redirects are the default tenant's aliases so every tenant has them
+	if redirected && !Corpus.IsName(requestedName) {
+		if _, _, err := StoreItemAlias(ctx, "", requestedName, i.name); err != nil {
+			LoggerFrom(ctx).Warn("Failed to store alias for redirect", "alias", requestedName, "err", err)
+		}
 	}
+	return nil
 }
 
-// Check our cache first to see if the item exists - this will eventually return something
-// other than a bool, it will return a parsed Item struct from a deserialised JSON object
-// sent back from the mongo store
-func (i *Item) fetchDataFromSQL() bool {
+// The page the item is on, i.e. Cloak_of_Flames. Spells are looked up without
+// their Spell: prefix
+func (i *Item) wikiUri() string {
+	uriString := TitleCase(strings.TrimSpace(strings.Replace(strings.ToLower(i.name), "spell:", "", -1)), true)
+
+	if uriString == "Silken_Cat-Fur_Girdle" {
+		uriString = "Silken_Cat-fur_Girdle"
+	}
+	return uriString
+}
+
+// Check our cache first to see if the item exists, if it does then the item is
+// hydrated with its image and statistics. Returns true if the item has any stats,
+// or ErrDatabaseRead if the query failed
+func (i *Item) fetchDataFromSQL(ctx context.Context) (bool, error) {
 	var (
 		id int64
 		name string
 		displayName string
-		statCode interface{}
-		statValue interface{}
+		imageSrc sql.NullString
+		description sql.NullString
+		containerSlots sql.NullInt64
+		containerMaxSize sql.NullString
+		containerWeightReduction sql.NullFloat64
+		price sql.NullFloat64
+		statCode sql.NullString
+		statValue sql.NullFloat64
+		statEffect sql.NullString
 	)
 
-	query := "SELECT items.id, name, displayName, code AS statCode, value AS statValue " +
+	query := "SELECT items.id, name, displayName, imageSrc, description, COALESCE(corrected_price, observed_price), " +
+		"vendor_sell_price, vendor_buy_price, " +
+		"container_slots, container_max_size, container_weight_reduction, damage_delay_ratio, haste_adjusted_delay, " +
+		"code AS statCode, value AS statValue, effect AS statEffect " +
 		"FROM items " +
 		"LEFT JOIN statistics " +
 		"ON items.id = statistics.item_id " +
-		"WHERE name = ? " +
-		"OR displayName = ?"
+		"WHERE (name = ? OR displayName = ?) " +
+		"AND version = ?"
 
-	rows, _ := DB.Query(query, i.name, i.name)
+	rows, err := DB.QueryContext(ctx, query, i.name, i.name, i.Version())
+	if err != nil {
+		return false, ErrDatabaseRead
+	}
 	if rows != nil {
 		hasStat := false
+		var stats []Statistic
 		for rows.Next() {
-			err := rows.Scan(&id, &name, &displayName, &statCode, &statValue)
+			err := rows.Scan(&id, &name, &displayName, &imageSrc, &description, &price, &i.vendorSellPrice, &i.vendorBuyPrice,
+				&containerSlots, &containerMaxSize, &containerWeightReduction, &i.ratio, &i.hasteAdjustedDelay, &statCode, &statValue, &statEffect)
 			if err != nil {
-				fmt.Println("Scan error: ", err)
+				Log.Error("Scan failed", "err", err)
 			}
-			if statCode == nil && statValue == nil {
-				fmt.Println("No stats exists for: ", displayName)
+			if !statCode.Valid && !statValue.Valid {
+				LoggerFrom(ctx).Debug("No stats exist for item", "item", displayName)
 			} else {
 				hasStat = true
+				stats = append(stats, Statistic{
+					code: statCode.String,
+					value: statValue,
+					effect: statEffect.String,
+				})
 			}
 			if id > 0 {
 				i.id = id
 			}
-			//fmt.Println("Row is: ", fmt.Sprint(id), name, displayName, fmt.Sprint(statCode), fmt.Sprint(statValue))
+			if imageSrc.Valid && imageSrc.String != "" {
+				i.imageSrc = imageSrc.String
+			}
+			i.description = description.String
+			i.container = containerFromColumns(containerSlots, containerMaxSize, containerWeightReduction)
+			i.price = float32(price.Float64)
 		}
 		if err := rows.Err(); err != nil {
-			fmt.Println("ROW ERROR: ", err.Error())
+			Log.Error("Row iteration failed", "err", err)
 		}
 		DB.CloseRows(rows)
-		return hasStat
+		if hasStat {
+			i.statistics = stats
+		}
+		return hasStat, nil
 	} else {
-		fmt.Println("No record found for item: ", i.name)
-		return false
+		LoggerFrom(ctx).Debug("No record found for item", "item", i.name)
+		return false, nil
 	}
 }
 
-// Extracts data from body
-func (i *Item) extractItemDataFromHttpResponse(body string) {
-	itemDataIndex := stringutil.CaseInsensitiveIndexOf(body, "itemData")
-	endOfItemDataIndex := stringutil.CaseInsensitiveIndexOf(body, "itembotbg")
+// Columns scanEffect reads, in this order, from item_effects joined to effects
+const effectColumns = "effects.name, effects.uri, effects.description, effects.mana, effects.duration, " +
+	"item_effects.restriction, item_effects.effect_type, " +
+	"item_effects.trigger_level, item_effects.cast_time, item_effects.charges"
 
+// Scans a row selecting effectColumns, after any leading columns which are
+// scanned into leading
+func scanEffect(rows *sql.Rows, leading ...interface{}) (Effect, error) {
+	var (
+		e Effect
+		description sql.NullString
+		duration sql.NullString
+		restriction sql.NullString
+		effectType sql.NullString
+	)
+	destinations := append(leading, &e.name, &e.uri, &description, &e.mana, &duration, &restriction, &effectType,
+		&e.triggerLevel, &e.castTime, &e.charges)
+	if err := rows.Scan(destinations...); err != nil {
+		return e, err
+	}
+	e.description = description.String
+	e.duration = duration.String
+	e.restriction = restriction.String
+	e.effectType = effectType.String
+
+	// Effects saved before they were typed are worked out from their restriction
+	if !effectType.Valid {
+		charges := e.charges
+		e.parseRestriction()
+		if charges.Valid {
+			e.charges = charges
+		}
+	}
+	return e, nil
+}
+
+// Loads the effects attached to this item, the item must already have an id
+func (i *Item) fetchEffectsFromSQL() {
+	if i.id <= 0 {
+		return
+	}
+
+	query := "SELECT " + effectColumns + " " +
+		"FROM item_effects " +
+		"INNER JOIN effects " +
+		"ON effects.id = item_effects.effect_id " +
+		"WHERE item_effects.item_id = ?"
+
+	rows, err := DB.Query(query, i.id)
+	if err != nil {
+		return
+	}
+
+	var effects []Effect
+	for rows.Next() {
+		e, err := scanEffect(rows)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		effects = append(effects, e)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+	DB.CloseRows(rows)
+
+	i.effects = effects
+}
+
+// Public method to load an item purely from SQL, this never falls back to the wiki.
+// Returns true if we have any cached data for the item
+func (i *Item) FetchCachedData(ctx context.Context) bool {
+	LoggerFrom(ctx).Debug("Fetching cached data for item", "item", i.name)
+	i.fetchDataFromSQL(ctx)
+	i.fetchEffectsFromSQL()
+	return i.id > 0 && i.Resolved()
+}
+
+// Extracts data from body and saves it, spell pages we were sent to by accident
+// are handed over to the spell parser
+func (i *Item) extractItemDataFromHttpResponse(ctx context.Context, body string) error {
 	// check if we got a spell page by accident:
-	reg := regexp.MustCompile("(?i)>(magician|necromancer|paladin|warrior|druid|enchanter|cleric|shadowknight|monk|shaman|wizard|bard|rogue|ranger)<")
-	classMatches := reg.FindAllStringSubmatch(body, -1)
-	reg = regexp.MustCompile("(?i)level[ \n]+([0-9]+)") // account for any poor formatting
-	levelMatches := reg.FindAllStringSubmatch(body, -1)
+	classMatches := spellClassRegex.FindAllStringSubmatch(body, -1)
+	levelMatches := spellLevelRegex.FindAllStringSubmatch(body, -1)
 
 	// If we did accidentally get a spell page, then we want to parse it here
 	if len(classMatches) > 0 && len(levelMatches) > 0 {
-		fmt.Println("Ack we found a spell")
-		i.extractSpellDataFromHttpBody(body)
-	} else if(itemDataIndex > -1 && endOfItemDataIndex > -1) {
+		LoggerFrom(ctx).Debug("Item page is a spell page")
+		return i.extractSpellDataFromHttpBody(ctx, body)
+	}
 
-		body = body[itemDataIndex:endOfItemDataIndex]
+	if err := i.parseItemPage(ctx, body); err != nil {
+		return err
+	}
+	return i.Save(ctx)
+}
 
-		// Extract the item image - this assumes that the format is consistent (tested with 30 items thus far)
-		imageSrc := body[stringutil.CaseInsensitiveIndexOf(body, "/images"):stringutil.CaseInsensitiveIndexOf(body, "width")-2]
+// Parses an item page without saving it, the page is parsed into a DOM so that
+// we aren't thrown by whitespace or attribute order changes in the wiki markup.
+// Pages without an itemData block aren't items so return ErrNotAnItemPage, pages
+// which have one but we can't read any stats from return ErrParseIncomplete.
+// The first block is the item, any others are parsed into its versions
+func (i *Item) parseItemPage(ctx context.Context, body string) error {
+	document, err := ParseHtml(body)
+	if err != nil {
+		LoggerFrom(ctx).Warn("Failed to parse wiki page", "err", err)
+		return ErrParseIncomplete
+	}
 
-		i.imageSrc = imageSrc
+	blocks := FindAll(document, func(n *html.Node) bool {
+		return ByClass("itemData")(n) || strings.EqualFold(Attr(n, "id"), "itemData")
+	})
+	if len(blocks) == 0 {
+		LoggerFrom(ctx).Debug("No itemData found")
+		return ErrNotAnItemPage
+	}
 
-		// Extract the item information snippet
-		openInfoParagraphIndex := stringutil.CaseInsensitiveIndexOf(body, "<p>") + 3 // +3 to ignore the <p> chars
-		closeInfoParagraphIndex := stringutil.CaseInsensitiveIndexOf(body, "</p>")
-		body = body[openInfoParagraphIndex:closeInfoParagraphIndex]
+	i.extractVendorPrices(TextContent(document))
+	i.sources = ParseItemSources(document)
+	i.factions = ParseItemFactions(i.name, TextContent(document))
+	lore := ParseItemLore(document)
+	if err := i.parseItemData(ctx, blocks[0]); err != nil {
+		return err
+	}
+	i.description = itemDescription(lore, i.description)
+
+	// Pages with legacy and revamped versions of the item have a block for
+	// each. Versions are numbered by their block, so one which doesn't parse
+	// is left out without renumbering the rest
+	i.versions = nil
+	for idx, block := range blocks[1:] {
+		version := Item{ name: i.name, displayName: i.displayName, version: DEFAULT_ITEM_VERSION + idx + 1 }
+		if version.version > MAX_ITEM_VERSIONS {
+			break
+		}
+		if err := version.parseItemData(ctx, block); err != nil {
+			LoggerFrom(ctx).Warn("Skipping item version which didn't parse", "version", version.version, "err", err)
+			continue
+		}
+		version.description = itemDescription(lore, version.description)
+		i.versions = append(i.versions, version)
+	}
+	return nil
+}
 
-		upperParts := strings.Split(strings.TrimSpace(body), "<br />")
+// Reads the image, stats, effects and tooltip out of a single itemData block
+func (i *Item) parseItemData(ctx context.Context, itemData *html.Node) error {
+	// Extract the item image, the wiki sometimes serves absolute urls so we
+	// only keep the path from /images onwards
+	if image := FindFirst(itemData, ByTag("img")); image != nil {
+		src := Attr(image, "src")
+		if idx := stringutil.CaseInsensitiveIndexOf(src, "/images"); idx > -1 {
+			src = src[idx:]
+		}
+		i.imageSrc = src
+	}
 
-		for _, part := range upperParts {
-			part = strings.TrimSpace(part)
-			if part == "" { continue }
+	// Extract the item information snippet, each stat is on its own line
+	paragraph := FindFirst(itemData, ByTag("p"))
+	if paragraph == nil {
+		LoggerFrom(ctx).Warn("No item information found")
+		return ErrParseIncomplete
+	}
 
-			reg := regexp.MustCompile(`([A-Za-z]+ ?)+:? ?(([0-9A-Za-z.+-]+ ?)+)`)
-			matches := reg.FindAllStringSubmatch(part, -1)
-			if len(matches) > 0 && !stringutil.CaseInsenstiveContains(part, "effect:") {
-				for _, match := range matches {
-					i.assignStatistic(strings.TrimSpace(match[0]))
-				}
-			} else {
-				// Race, class etc can be auto handled
-				i.assignStatistic(strings.TrimSpace(part))
+	i.description = strings.Join(TextLines(paragraph), "\n")
+
+	reg := regexp.MustCompile(`([A-Za-z]+ ?)+:? ?(([0-9A-Za-z.+-]+ ?)+)`)
+	i.container = nil
+	for _, part := range SplitOnBreaks(paragraph) {
+		part = strings.TrimSpace(i.assignContainer(part))
+		if part == "" { continue }
+
+		if stats := splitStatLine(part); len(stats) > 1 {
+			for _, stat := range stats {
+				i.assignStatistic(ctx, stat)
+			}
+			continue
+		}
+
+		matches := reg.FindAllStringSubmatch(part, -1)
+		if len(matches) > 0 && !stringutil.CaseInsenstiveContains(part, "effect:") {
+			for _, match := range matches {
+				i.assignStatistic(ctx, strings.TrimSpace(match[0]))
 			}
+		} else {
+			// Race, class etc can be auto handled
+			i.assignStatistic(ctx, strings.TrimSpace(part))
+		}
+	}
+
+	i.assignEffectCharges()
+
+	LoggerFrom(ctx).Debug("Parsed item", "version", i.Version(), "statistics", len(i.statistics), "effects", len(i.effects))
+	if len(i.statistics) == 0 && len(i.effects) == 0 {
+		LoggerFrom(ctx).Warn("No stats found in item information")
+		return ErrParseIncomplete
+	}
+	return nil
+}
+
+var (
+	instrumentModifierRegex = regexp.MustCompile(`(?i)^(?:instruments?:\s*)?(brass|percussion|string|stringed|wind|all)(?:\s+instruments?)?\s*:?\s*\(?([+-]?[0-9]+(?:\.[0-9]+)?)\s*%?\)?$`)
+	singingModifierRegex    = regexp.MustCompile(`(?i)^singing\s*:?\s*\(?([+-]?[0-9]+(?:\.[0-9]+)?)\s*%?\)?$`)
+	skillModifierRegex      = regexp.MustCompile(`(?i)^skill mod(?:ifier)?:?\s*([a-z ]+?)\s*:?\s*\(?([+-]?[0-9]+(?:\.[0-9]+)?)\s*%?\)?$`)
+)
+
+// The labels of stats the wiki puts several of on one line, "Atk Delay" comes
+// before "Atk" so the longer label wins
+var statLabelRegex = regexp.MustCompile(`(?i)\b(?:atk delay|sv (?:fire|cold|poison|magic|disease)|dmg|ac|hp|dex|agi|sta|str|mana|cha|atk|wis|int|endr|wt|haste|range|charges|slot|class|race|size|skill)\s*:`)
+
+// Splits a line such as "WT: 1.0 Size: MEDIUM" or "STR: +8 DEX: +8" into a
+// part for each stat. Values can have spaces in them, "Skill: 1H Slashing Atk
+// Delay: 24", so the line is split where a label starts rather than on spaces.
+// Lines which don't start with a label come back whole
+func splitStatLine(line string) []string {
+	line = strings.TrimSpace(line)
+	if stringutil.CaseInsenstiveContains(line, "effect:") {
+		return []string{ line }
+	}
+	labels := statLabelRegex.FindAllStringIndex(line, -1)
+	if len(labels) == 0 || labels[0][0] != 0 {
+		return []string{ line }
+	}
+
+	var stats []string
+	for idx, label := range labels {
+		end := len(line)
+		if idx+1 < len(labels) {
+			end = labels[idx+1][0]
 		}
+		stats = append(stats, strings.TrimSpace(line[label[0]:end]))
+	}
+	return stats
+}
 
-		fmt.Println(i.statistics)
-		fmt.Println(i.effects)
-		i.Save()
+// Bard instruments and skill mod items boost a skill by a percentage, written as
+// "Brass Instruments: +21%", "Singing: +15%" or "Skill Mod: Offense +10%".
+// Returns the skill in upper case, i.e. BRASS INSTRUMENTS, and the percentage
+func parseModifier(part string) (string, float64, bool) {
+	part = strings.TrimSpace(part)
+
+	var skill, percent string
+	if match := instrumentModifierRegex.FindStringSubmatch(part); match != nil {
+		skill = strings.ToUpper(match[1])
+		if skill == "STRING" {
+			skill = "STRINGED"
+		}
+		skill, percent = skill + " INSTRUMENTS", match[2]
+	} else if match := singingModifierRegex.FindStringSubmatch(part); match != nil {
+		skill, percent = "SINGING", match[1]
+	} else if match := skillModifierRegex.FindStringSubmatch(part); match != nil {
+		skill, percent = strings.ToUpper(strings.Join(strings.Fields(match[1]), " ")), match[2]
+	} else {
+		return "", 0, false
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimPrefix(percent, "+"), 64)
+	return skill, value, err == nil
+}
+
+// Clickies list their charges as a stat of the item rather than in the effect,
+// so they're copied onto the clicky once every stat has been read
+func (i *Item) assignEffectCharges() {
+	for _, stat := range i.statistics {
+		if stat.code != "CHARGES" || !stat.value.Valid {
+			continue
+		}
+		for idx := range i.effects {
+			if i.effects[idx].Type() == EFFECT_TYPE_CLICKY && !i.effects[idx].charges.Valid {
+				i.effects[idx].charges = sql.NullInt64{ Int64: int64(stat.value.Float64), Valid: true }
+			}
+		}
+	}
+}
+
+// Returns the item's lore or flavour text, which some pages have under a
+// heading of its own, or an empty string if the page has none
+func ParseItemLore(document *html.Node) string {
+	var paragraphs []string
+	for _, node := range SectionNodes(document, "Lore", "Item_Lore", "Flavor_Text", "Flavour_Text", "Description") {
+		if text := TextContent(node); text != "" {
+			paragraphs = append(paragraphs, text)
+		}
+	}
+	return strings.Join(paragraphs, "\n")
+}
+
+// Text pulled from the DOM has a space wherever two nodes met, which leaves
+// gaps before punctuation following a link
+var spaceBeforePunctuationRegex = regexp.MustCompile(` +([.,;:!?)])`)
+
+// The lore comes first as it's what people read, the tooltip follows it
+func itemDescription(lore string, tooltip string) string {
+	description := lore + tooltip
+	if lore != "" && tooltip != "" {
+		description = lore + "\n\n" + tooltip
+	}
+	return spaceBeforePunctuationRegex.ReplaceAllString(description, "$1")
+}
+
+// Vendor prices are written as "Sells for: 1pp 5gp" and "Buys for: 7sp"
+var vendorPriceRegex = regexp.MustCompile(`(?i)\b(sells|buys) for:?((?:\s*[0-9]+(?:\.[0-9]+)?\s*(?:pp|gp|sp|cp|p|g|s|c)\b)+)`)
+
+// Reads what vendors sell and buy the item for from the page text, prices
+// which aren't on the page are left null
+func (i *Item) extractVendorPrices(text string) {
+	for _, match := range vendorPriceRegex.FindAllStringSubmatch(text, -1) {
+		price, ok := ParseCoins(match[2])
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(match[1], "sells") && !i.vendorSellPrice.Valid {
+			i.vendorSellPrice = sql.NullFloat64{Float64: price, Valid: true}
+		} else if strings.EqualFold(match[1], "buys") && !i.vendorBuyPrice.Valid {
+			i.vendorBuyPrice = sql.NullFloat64{Float64: price, Valid: true}
+		}
 	}
 }
 
 // IMPROVE THIS!
-func (i *Item) extractSpellDataFromHttpBody(body string) {
+func (i *Item) extractSpellDataFromHttpBody(ctx context.Context, body string) error {
 
 	// If an item is sent with: Dead Men Floating then we can't be sure its a spell so we force it
 	// here to get the id
 	if i.id <= 0 {
 		i.name = "Spell: " + i.name
-		i.fetchDataFromSQL()
+		if _, err := i.fetchDataFromSQL(ctx); err != nil {
+			return err
+		}
 	}
 
 	if i.id > 0 {
 		// Check if its a spell page
-		reg := regexp.MustCompile("(?i)>(magician|necromancer|paladin|warrior|druid|enchanter|cleric|shadowknight|monk|shaman|wizard|bard|rogue|ranger)<")
-		classMatches := reg.FindAllStringSubmatch(body, -1)
-		reg = regexp.MustCompile("(?i)level[ \n]+([0-9]+)") // account for any poor formatting
-		levelMatches := reg.FindAllStringSubmatch(body, -1)
+		classMatches := spellClassRegex.FindAllStringSubmatch(body, -1)
+		levelMatches := spellLevelRegex.FindAllStringSubmatch(body, -1)
 
-		fmt.Println(levelMatches)
 		if len(classMatches) > 0 && len(levelMatches) > 0 {
 			srcMatches := regexp.MustCompile("(?i)(/images/.*?) ?\"").FindStringSubmatch(body)
 			if len(srcMatches) > 0 {
 				i.imageSrc = strings.TrimSpace(srcMatches[1])
 			}
-			fmt.Println(srcMatches)
 
 			var classes string
 			for idx, match := range classMatches {
@@ -230,20 +768,10 @@ func (i *Item) extractSpellDataFromHttpBody(body string) {
 				}
 			}
 
-			classes = strings.Replace(strings.ToLower(classes), "bard", "BRD", -1)
-			classes = strings.Replace(strings.ToLower(classes), "cleric", "CLR", -1)
-			classes = strings.Replace(strings.ToLower(classes), "enchanter", "ENC", -1)
-			classes = strings.Replace(strings.ToLower(classes), "shadowknight", "SHD", -1)
-			classes = strings.Replace(strings.ToLower(classes), "paladin", "PAL", -1)
-			classes = strings.Replace(strings.ToLower(classes), "magician", "MAG", -1)
-			classes = strings.Replace(strings.ToLower(classes), "necromancer", "NEC", -1)
-			classes = strings.Replace(strings.ToLower(classes), "warrior", "WAR", -1)
-			classes = strings.Replace(strings.ToLower(classes), "rogue", "ROG", -1)
-			classes = strings.Replace(strings.ToLower(classes), "ranger", "RNG", -1)
-			classes = strings.Replace(strings.ToLower(classes), "druid", "DRU", -1)
-			classes = strings.Replace(strings.ToLower(classes), "monk", "MNK", -1)
-			classes = strings.Replace(strings.ToLower(classes), "wizard", "WIZ", -1)
-			classes = strings.Replace(strings.ToLower(classes), "shaman", "SHM", -1)
+			classes = strings.ToLower(classes)
+			for class, abbreviation := range classAbbreviations {
+				classes = strings.Replace(classes, class, abbreviation, -1)
+			}
 
 			var stats []Statistic
 			var stat Statistic
@@ -252,23 +780,38 @@ func (i *Item) extractSpellDataFromHttpBody(body string) {
 
 			stats = append(stats, stat)
 			i.statistics = stats
-			i.Save()
+			if err := i.Save(ctx); err != nil {
+				return err
+			}
+
+			SaveSpellRanks(i.name, body)
+			SavePets(i.id, ParsePetTable(body))
+
+			// Keep the first class spell record in step with the scroll
+			spell := Spell{ name: spellLineName(i.name) }
+			if spell.extractSpellData(body) {
+				spell.Save()
+			}
+		} else {
+			LoggerFrom(ctx).Debug("Spell page has no classes or levels")
+			return ErrNotAnItemPage
 		}
 	}
+	return nil
 }
 
-func (i *Item) assignStatistic(part string) {
+func (i *Item) assignStatistic(ctx context.Context, part string) {
 	if strings.TrimSpace(part) == "" {
 		return
 	}
 
 	var stat Statistic
 
-	LogInDebugMode("Assigning part: ", part)
-	if stringutil.CaseInsenstiveContains(part, "size capacity:") {
-		parts := strings.Split(part, ":")
-		stat.code = "size capacity"
-		stat.effect = parts[1]
+	LoggerFrom(ctx).Debug("Assigning part", "item", i.name, "part", part)
+	if skill, percent, ok := parseModifier(part); ok {
+		stat.code = "MODIFIER"
+		stat.effect = skill
+		stat.value = sql.NullFloat64{Float64: percent, Valid: true}
 	} else if stringutil.CaseInsenstiveContains(part, "nodrop", "quest item", "lore item", "magic item", "temporary", "no drop", "no rent", "no trade", "norent", "notrade", "expendable") {
 		stat.code = "AFFINITY"
 		stat.effect = strings.ToUpper(part)
@@ -278,7 +821,19 @@ func (i *Item) assignStatistic(part string) {
 		stat.code = strings.ToUpper(strings.TrimSpace(parts[0]))
 		stat.effect = strings.ToUpper(strings.TrimSpace(parts[1]))
 		stat.value = sql.NullFloat64{Float64: 0, Valid: false}
-	} else if stringutil.CaseInsenstiveContains(part, "sv fire", "sv cold", "sv poison", "sv magic", "sv disease", "dmg:", "ac:", "hp:", "dex:", "agi:", "sta:", "str:", "mana:", "cha:", "atk:", "wis:", "int:", "endr:", "wt:", "atk delay:", "haste:", "instrument:", "instruments:", "range:", "charges:", "weight reduction:", "capacity:") {
+		if stat.code == "SLOT" {
+			if slots := ParseItemSlots(stat.effect); len(slots) > 0 {
+				stat.effect = strings.Join(slots, " ")
+			}
+		}
+		if stat.code == "SKILL" {
+			if skill, exists := NormaliseWeaponSkill(stat.effect); exists {
+				stat.effect = skill
+			} else {
+				LoggerFrom(ctx).Debug("Unknown weapon skill", "item", i.name, "skill", stat.effect)
+			}
+		}
+	} else if stringutil.CaseInsenstiveContains(part, "sv fire", "sv cold", "sv poison", "sv magic", "sv disease", "dmg:", "ac:", "hp:", "dex:", "agi:", "sta:", "str:", "mana:", "cha:", "atk:", "wis:", "int:", "endr:", "wt:", "atk delay:", "haste:", "instrument:", "instruments:", "range:", "charges:") {
 		parts := strings.Split(part, ":")
 
 		isPositiveNumber := true
@@ -296,11 +851,10 @@ func (i *Item) assignStatistic(part string) {
 		val, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
 
 		if err != nil {
-			fmt.Println("Stat error for item " + i.name + ": ", err)
+			LoggerFrom(ctx).Warn("Failed to parse stat", "item", i.name, "part", part, "err", err)
 		} else {
 			stat.value = sql.NullFloat64{Float64:val, Valid: true}
 			if !isPositiveNumber {
-				fmt.Println("It wasn't a pos number, setting to: ", fmt.Sprint(val * -1.0))
 				stat.value = sql.NullFloat64{Float64:(val * -1.0), Valid: true}
 			}
 		}
@@ -327,43 +881,223 @@ func (i *Item) assignStatistic(part string) {
 		}
 
 		e.restriction = strings.TrimSpace(regexp.MustCompile("((<a)(.*?)(</a>))").ReplaceAllString(part, ""))
+		e.parseRestriction()
 
 		i.effects = append(i.effects, e)
 		return
 	} else {
-		fmt.Println("Unkown stat: ", part)
+		LoggerFrom(ctx).Warn("Unknown stat", "item", i.name, "part", part)
 	}
 
 	if stat.code != "" {
 		i.statistics = append(i.statistics, stat)
 	} else {
-		LogInDebugMode("Nil stat code for: ", stat)
+		LoggerFrom(ctx).Debug("Nil stat code", "item", i.name, "part", part)
 	}
 }
 
-func (i *Item) Save() {
-	query := "UPDATE items SET imageSrc = ? WHERE name = ? OR displayName = ?"
-	rows, err := DB.Query(query, i.imageSrc, i.name, i.name)
-	DB.CloseRows(rows)
-	if err == nil {
-		i.saveEffects(i.id)
-		i.saveStats(i.id)
+// Writes the scraped data back to SQL and through to the item caches, returns
+// ErrDatabaseWrite if any of the writes failed. The cause is logged by the
+// write that failed. Every write is made in one transaction so a failure part way
+// through never leaves an item with half of its stats or effects. Items we
+// already have aren't overwritten while the wiki canary is failing
+func (i *Item) Save(ctx context.Context) error {
+	if i.id > 0 && CanaryPaused() {
+		LoggerFrom(ctx).Warn("Not saving item as the wiki canary is failing")
+		return ErrWritesPaused
+	}
 
-		fmt.Println("Saved stats for: " + i.name)
-	} else {
-		fmt.Println(err)
+	id := i.id
+	err := DB.Transaction(ctx, func(tx *Tx) error {
+		// The upsert sets the id, which has to be undone if we're retried
+		i.id = id
+		if err := i.upsert(tx); err != nil {
+			return err
+		}
+		if err := i.saveEffects(tx, i.id); err != nil {
+			return err
+		}
+		if err := i.saveStats(tx, i.id); err != nil {
+			return err
+		}
+		if err := i.saveRestrictions(tx, i.id); err != nil {
+			return err
+		}
+		if err := i.saveSources(tx, i.id); err != nil {
+			return err
+		}
+		if err := i.saveFactions(tx, i.id); err != nil {
+			return err
+		}
+		if err := SaveItemRevision(tx, *i); err != nil {
+			return err
+		}
+
+		// Other versions are upserted by name and version, so they find their
+		// rows without us having looked them up
+		for idx := range i.versions {
+			version := &i.versions[idx]
+			version.id = 0
+			if err := version.upsert(tx); err != nil {
+				return err
+			}
+			if err := version.saveEffects(tx, version.id); err != nil {
+				return err
+			}
+			if err := version.saveStats(tx, version.id); err != nil {
+				return err
+			}
+			if err := version.saveRestrictions(tx, version.id); err != nil {
+				return err
+			}
+			if err := SaveItemRevision(tx, *version); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		i.id = id
+		return err
+	}
+
+	LoggerFrom(ctx).Info("Saved item", "statistics", len(i.statistics), "effects", len(i.effects))
+	CacheItem(i.name, *i)
+	StoreItemDocument(ctx, *i)
+	PublishItemInvalidation(i.id, i.name)
+	PublishFeedEvent(FEED_EVENT_ITEM, TenantFrom(ctx), true, *i)
+	QueueItemImage(*i)
+	QueueEffectDetails(*i)
+	if id == 0 {
+		NotifyItemDiscovered(ctx, *i)
+	}
+	return nil
+}
+
+// Tables whose rows belong to a single item, keyed by item_id. These are
+// removed along with the item
+var itemOwnedTables = []string{
+	"statistics", "item_effects", "auctions", "price_points", "price_rollups", "item_rules",
+	"item_wikitext", "item_revisions", "item_images", "page_snapshots", "item_sources",
+	"item_factions", "item_usage", "item_classes", "item_races", "item_slots", "price_corrections",
+}
+
+// Removes the item along with its stats, effects, auctions and everything
+// else we've stored against it, for purging entries which should never have
+// been saved. Aliases for the item and every other version of it go too. The
+// item must have an id
+func (i *Item) Delete(ctx context.Context) error {
+	err := DB.Transaction(ctx, func(tx *Tx) error {
+		for _, table := range itemOwnedTables {
+			query := "DELETE FROM " + table + " WHERE item_id IN (SELECT id FROM (SELECT id FROM items WHERE name = ?) AS versions)"
+			if _, err := tx.Insert(query, i.name); err != nil {
+				LoggerFrom(tx.ctx).Error("Failed to delete item rows", "table", table, "err", err)
+				return ErrDatabaseWrite
+			}
+		}
+		if _, err := tx.Insert("DELETE FROM item_aliases WHERE item_name = ?", i.name); err != nil {
+			return ErrDatabaseWrite
+		}
+		if _, err := tx.Insert("DELETE FROM items WHERE name = ?", i.name); err != nil {
+			return ErrDatabaseWrite
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
+
+	LoggerFrom(ctx).Info("Deleted item", "id", i.id)
+	UncacheItem(i.id, i.name)
+	DeleteItemDocument(ctx, i.name)
+	Corpus.Reload()
+	return nil
 }
 
-func (i *Item) saveEffects(id int64) {
-	//fmt.Println("Saving effects for item: ", id)
+// Creates the items row for items we've only just discovered on the wiki, or
+// updates the one we have. Either way i.id is set to the row's id so the stats
+// and effects are saved against it
+func (i *Item) upsert(tx *Tx) error {
+	// Vendor prices are only on some pages, so we never null out ones we have
+	slots, maxSize, weightReduction := i.container.columns()
+	i.ratio, i.hasteAdjustedDelay = i.weaponRatios()
+	if i.id > 0 {
+		// The wiki's data replaces any corrections, so the item can be re-scraped
+		// again and its price is the observed one again
+		override := ""
+		if i.clearOverride {
+			override = "is_manual_override = 0, corrected_price = NULL, "
+		}
+		query := "UPDATE items SET " + override + "imageSrc = ?, description = ?, " +
+			"container_slots = ?, container_max_size = ?, container_weight_reduction = ?, " +
+			"damage_delay_ratio = ?, haste_adjusted_delay = ?, " +
+			"vendor_sell_price = COALESCE(?, vendor_sell_price), " +
+			"vendor_buy_price = COALESCE(?, vendor_buy_price), " +
+			"scraped_at = NOW() " +
+			"WHERE id = ?"
+		rows, err := tx.Query(query, i.imageSrc, i.description, slots, maxSize, weightReduction, i.ratio, i.hasteAdjustedDelay, i.vendorSellPrice, i.vendorBuyPrice, i.id)
+		if err != nil {
+			return ErrDatabaseWrite
+		}
+		DB.CloseRows(rows)
+		return nil
+	}
+
+	// The price is only known if we've seen the item auctioned, it is otherwise
+	// left for the price points to fill in. LAST_INSERT_ID(id) makes the existing
+	// row's id come back when the name is already taken
+	var price sql.NullFloat64
+	if i.price > 0 {
+		price = sql.NullFloat64{ Float64: float64(i.price), Valid: true }
+	}
+	query := "INSERT INTO items " +
+		"(name, displayName, version, imageSrc, description, container_slots, container_max_size, container_weight_reduction, " +
+		"damage_delay_ratio, haste_adjusted_delay, observed_price, vendor_sell_price, vendor_buy_price, scraped_at) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NOW()) " +
+		"ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), imageSrc = VALUES(imageSrc), description = VALUES(description), " +
+		"container_slots = VALUES(container_slots), container_max_size = VALUES(container_max_size), " +
+		"container_weight_reduction = VALUES(container_weight_reduction), " +
+		"damage_delay_ratio = VALUES(damage_delay_ratio), haste_adjusted_delay = VALUES(haste_adjusted_delay), " +
+		"vendor_sell_price = COALESCE(VALUES(vendor_sell_price), vendor_sell_price), " +
+		"vendor_buy_price = COALESCE(VALUES(vendor_buy_price), vendor_buy_price), " +
+		"scraped_at = VALUES(scraped_at)"
+
+	id, err := tx.Insert(query, i.name, i.displayName, i.Version(), i.imageSrc, i.description, slots, maxSize, weightReduction, i.ratio, i.hasteAdjustedDelay, price, i.vendorSellPrice, i.vendorBuyPrice)
+	if err != nil {
+		LoggerFrom(tx.ctx).Error("Failed to save item", "err", err)
+		return ErrDatabaseWrite
+	}
+	if id <= 0 {
+		LoggerFrom(tx.ctx).Error("Saved item has no id")
+		return ErrDatabaseWrite
+	}
+
+	i.id = id
+	LoggerFrom(tx.ctx).Info("Created item", "id", id)
+	return nil
+}
+
+// Replaces the item's effects with the scraped ones. item_effects has no
+// unique key to upsert on, so the item's rows are removed before they're
+// inserted again, otherwise every scrape would add another copy of each
+func (i *Item) saveEffects(tx *Tx, id int64) error {
+	rows, err := tx.Query("DELETE FROM item_effects WHERE item_id = ?", id)
+	if err != nil {
+		LoggerFrom(tx.ctx).Error("Failed to remove old effects", "err", err)
+		return ErrDatabaseWrite
+	}
+	DB.CloseRows(rows)
+
 	for _, effect := range i.effects {
 		if effect.name != "" && effect.uri != "" {
 			query := "SELECT id " +
 				"FROM effects " +
 				"WHERE name = ?"
 
-			rows, _ := DB.Query(query, effect.name)
+			rows, err := tx.Query(query, effect.name)
+			if err != nil {
+				return ErrDatabaseWrite
+			}
 			if rows != nil {
 				var effectId int64
 
@@ -372,13 +1106,13 @@ func (i *Item) saveEffects(id int64) {
 					exists = true
 					err := rows.Scan(&effectId)
 					if err != nil {
-						fmt.Println("Scan error: ", err)
+						Log.Error("Scan failed", "err", err)
 					}
-					fmt.Println("Got effect id: ", fmt.Sprint(effectId))
+					LoggerFrom(tx.ctx).Debug("Found effect", "effect", effect.name, "id", effectId)
 				}
 				err := rows.Err();
 				if err != nil {
-					fmt.Println("ROW ERROR: ", err.Error())
+					Log.Error("Row iteration failed", "err", err)
 				}
 				DB.CloseRows(rows)
 				if !exists {
@@ -386,62 +1120,86 @@ func (i *Item) saveEffects(id int64) {
 						"(name, uri)" +
 						"VALUES (?, ?)"
 
-					newEffectId, err := DB.Insert(query, effect.name, effect.uri)
+					newEffectId, err := tx.Insert(query, effect.name, effect.uri)
 					if err != nil {
-						fmt.Println(err.Error())
+						LoggerFrom(tx.ctx).Error("Failed to save effect", "effect", effect.name, "err", err)
+						return ErrDatabaseWrite
 					} else if newEffectId > 0 {
 						query := "INSERT INTO item_effects " +
-							"(item_id, effect_id, restriction) " +
-							"VALUES (?, ?, ?)"
+							"(item_id, effect_id, restriction, effect_type, trigger_level, cast_time, charges) " +
+							"VALUES (?, ?, ?, ?, ?, ?, ?)"
 
-						itemEffectId, err := DB.Insert(query, id, newEffectId, effect.restriction)
+						itemEffectId, err := tx.Insert(query, id, newEffectId, effect.restriction, effect.Type(),
+							effect.triggerLevel, effect.castTime, effect.charges)
 						if err != nil {
-							fmt.Println(err.Error())
+							LoggerFrom(tx.ctx).Error("Failed to save effect", "effect", effect.name, "err", err)
+							return ErrDatabaseWrite
 						} else if itemEffectId > 0 {
-							fmt.Println("Saved effect: " + effect.name + " for item: " + i.name)
+							LoggerFrom(tx.ctx).Debug("Saved effect", "effect", effect.name)
 						}
 					}
 				} else {
 					// Migrate to the Effect model so we dont repeat it
 					query := "INSERT INTO item_effects " +
-						"(item_id, effect_id, restriction) " +
-						"VALUES (?, ?, ?)"
+						"(item_id, effect_id, restriction, effect_type, trigger_level, cast_time, charges) " +
+						"VALUES (?, ?, ?, ?, ?, ?, ?)"
 
-					itemEffectId, err := DB.Insert(query, id, effectId, effect.restriction)
+					itemEffectId, err := tx.Insert(query, id, effectId, effect.restriction, effect.Type(),
+						effect.triggerLevel, effect.castTime, effect.charges)
 					if err != nil {
-						fmt.Println(err.Error())
+						LoggerFrom(tx.ctx).Error("Failed to save effect", "effect", effect.name, "err", err)
+						return ErrDatabaseWrite
 					} else if itemEffectId > 0 {
-						fmt.Println("Saved effect: " + effect.name + " for item: " + i.name)
+						LoggerFrom(tx.ctx).Debug("Saved effect", "effect", effect.name)
 					}
 				}
 
 				DB.CloseRows(rows)
 			} else {
-				fmt.Println("No rows for effect: ", effect.name)
+				LoggerFrom(tx.ctx).Debug("No rows for effect", "effect", effect.name)
 			}
 		} else {
-			fmt.Println("Invalid effect")
+			LoggerFrom(tx.ctx).Warn("Invalid effect", "effect", effect.name)
 		}
 	}
-
+	return nil
 }
 
-func (i *Item) saveStats(id int64) {
-	var parameters []interface{}
-	query := "INSERT INTO statistics" +
-		"(item_id, code, value, effect)" +
-		"VALUES "
+// Upserts the scraped stats on the unique (item_id, code, effect) key so that
+// scraping an item again doesn't duplicate them, then removes any stats the
+// item no longer has
+func (i *Item) saveStats(tx *Tx, id int64) error {
+	var keep []string
+	var keepParameters []interface{}
+	if len(i.statistics) > 0 {
+		var parameters []interface{}
+		query := "INSERT INTO statistics " +
+			"(item_id, code, value, effect) " +
+			"VALUES "
+
+		for _, statistic := range i.statistics {
+			query += "(?, ?, ?, ?),"
+			parameters = append(parameters, id, statistic.code, statistic.value, statistic.effect)
+			keep = append(keep, "(?, ?)")
+			keepParameters = append(keepParameters, statistic.code, statistic.effect)
+		}
+		query = query[0:len(query)-1] + " ON DUPLICATE KEY UPDATE value = VALUES(value)"
 
-	for _, statistic := range i.statistics {
-		//fmt.Println("Saving stat: " + fmt.Sprint(statistic.code) + " with value: " + fmt.Sprint(statistic.value))
-		query += "(?, ?, ?, ?),"
-		parameters = append(parameters, id, statistic.code, statistic.value, statistic.effect)
+		if _, err := tx.Insert(query, parameters...); err != nil {
+			LoggerFrom(tx.ctx).Error("Failed to save statistics", "err", err)
+			return ErrDatabaseWrite
+		}
 	}
-	query = query[0:len(query)-1]
 
-	//fmt.Println("Inserting new statistics with row id: ", int64(id))
-	_, err := DB.Insert(query, parameters...)
+	query := "DELETE FROM statistics WHERE item_id = ?"
+	if len(keep) > 0 {
+		query += " AND (code, effect) NOT IN (" + strings.Join(keep, ", ") + ")"
+	}
+	rows, err := tx.Query(query, append([]interface{}{ id }, keepParameters...)...)
 	if err != nil {
-		fmt.Println("Darn, we couldn't create this statistic: ", err)
+		LoggerFrom(tx.ctx).Error("Failed to remove old statistics", "err", err)
+		return ErrDatabaseWrite
 	}
-}
\ No newline at end of file
+	DB.CloseRows(rows)
+	return nil
+}
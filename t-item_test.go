@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// The pages in testdata/wiki follow the markup of the wiki's item pages, cut
+// down to what the parser reads. The _reformatted copy has its markup shuffled
+// to check the parse doesn't depend on whitespace, attribute order or how tags
+// are closed
+var allClasses = []string{"BRD", "CLR", "DRU", "ENC", "MAG", "MNK", "NEC", "PAL", "RNG", "ROG", "SHD", "SHM", "WAR", "WIZ"}
+var allRaces = []string{"BAR", "DEF", "DWF", "ELF", "ERU", "GNM", "HEF", "HFL", "HIE", "HUM", "IKS", "OGR", "TRL"}
+
+var shortSwordOfYkesha = itemPageTest{
+	image: "/images/Item_1085.png",
+	statistics: []string{
+		"AFFINITY MAGIC ITEM", "SLOT PRIMARY SECONDARY", "SKILL 1H_SLASHING", "ATK DELAY 24", "DMG 8",
+		"STR 8", "DEX 8", "SV COLD 10", "WT 7", "SIZE MEDIUM", "CLASS WAR RNG PAL SHD BRD ROG", "RACE ALL",
+	},
+	effects: []string{"Ykesha /Ykesha (Combat) proc"},
+	classes: []string{"BRD", "PAL", "RNG", "ROG", "SHD", "WAR"},
+	races: allRaces,
+	slots: []string{"PRIMARY", "SECONDARY"},
+}
+
+type itemPageTest struct {
+	page string
+	err error
+	image string
+	statistics []string // Code, then value or effect
+	effects []string // Name, uri, restriction and type
+	classes []string
+	races []string
+	slots []string
+}
+
+func TestParseItemPage(t *testing.T) {
+	tests := []itemPageTest{
+		{
+			page: "Cloak_of_Flames",
+			image: "/images/Item_1143.png",
+			statistics: []string{
+				"AFFINITY MAGIC ITEM", "SLOT BACK", "AC 10", "HASTE 36", "SV FIRE 10", "WT 1", "SIZE MEDIUM",
+				"CLASS ALL", "RACE ALL",
+			},
+			classes: allClasses,
+			races: allRaces,
+			slots: []string{"BACK"},
+		},
+		{
+			page: "Fungus_Covered_Scale_Tunic",
+			image: "/images/Item_1053.png",
+			statistics: []string{
+				"AFFINITY MAGIC ITEM", "AFFINITY LORE ITEM", "AFFINITY NO DROP", "SLOT CHEST", "AC 20", "HP 10",
+				"WT 20", "SIZE LARGE", "CLASS ALL", "RACE ALL",
+			},
+			effects: []string{"Regeneration /Regeneration (Worn) worn"},
+			classes: allClasses,
+			races: allRaces,
+			slots: []string{"CHEST"},
+		},
+		withPage(shortSwordOfYkesha, "Short_Sword_of_Ykesha"),
+		withPage(shortSwordOfYkesha, "Short_Sword_of_Ykesha_reformatted"),
+		{
+			page: "Plane_of_Sky",
+			err: ErrNotAnItemPage,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.page, func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join("testdata", "wiki", test.page + ".html"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			item := Item{ name: test.page }
+			err = item.parseItemPage(context.Background(), string(body))
+			if err != test.err {
+				t.Fatalf("parseItemPage() returned %v, want %v", err, test.err)
+			}
+			if item.imageSrc != test.image {
+				t.Errorf("imageSrc is %q, want %q", item.imageSrc, test.image)
+			}
+
+			var statistics []string
+			for _, stat := range item.statistics {
+				text := stat.effect
+				if stat.value.Valid {
+					text = strconv.FormatFloat(stat.value.Float64, 'f', -1, 64)
+				}
+				statistics = append(statistics, stat.code + " " + text)
+			}
+			if !reflect.DeepEqual(statistics, test.statistics) {
+				t.Errorf("statistics are %q, want %q", statistics, test.statistics)
+			}
+
+			var effects []string
+			for _, effect := range item.effects {
+				effects = append(effects, effect.name + " " + effect.uri + " " + effect.restriction + " " + effect.Type())
+			}
+			if !reflect.DeepEqual(effects, test.effects) {
+				t.Errorf("effects are %q, want %q", effects, test.effects)
+			}
+
+			classes, races, slots := item.restrictions()
+			if !reflect.DeepEqual(classes, test.classes) {
+				t.Errorf("classes are %q, want %q", classes, test.classes)
+			}
+			if !reflect.DeepEqual(races, test.races) {
+				t.Errorf("races are %q, want %q", races, test.races)
+			}
+			if !reflect.DeepEqual(slots, test.slots) {
+				t.Errorf("slots are %q, want %q", slots, test.slots)
+			}
+		})
+	}
+}
+
+func withPage(test itemPageTest, page string) itemPageTest {
+	test.page = page
+	return test
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Job
+ |--------------------------------------------------------------------------
+ |
+ | Represents a batch of POSTed auction lines being ingested in the
+ | background. Jobs only live in memory, once they have been complete for
+ | JOB_RETENTION they are forgotten
+ |
+ | @member id (string): Random id handed back to the caller
+ | @member tenant (string): The tenant which started the job, only they can see it
+ | @member lines ([]string): The raw lines to ingest
+ | @member results ([]LineResult): Result of each line, in line order
+ | @member processed (int): Number of lines which have finished
+ | @member status (string): One of the JOB_STATUS constants
+ |
+ */
+
+type Job struct {
+	mutex sync.RWMutex
+	id string
+	tenant string
+	lines []string
+	results []LineResult
+	processed int
+	status string
+	createdAt time.Time
+	completedAt time.Time
+}
+
+const (
+	JOB_STATUS_QUEUED   = "queued"
+	JOB_STATUS_RUNNING  = "running"
+	JOB_STATUS_COMPLETE = "complete"
+)
+
+// How long a completed job can be polled for before it is evicted
+const JOB_RETENTION = time.Hour
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: JobRegistry
+ |--------------------------------------------------------------------------
+ |
+ | Process wide store of every job we know about, keyed by id
+ |
+ */
+
+type JobRegistry struct {
+	mutex sync.RWMutex
+	jobs map[string]*Job
+}
+
+var Jobs = JobRegistry{ jobs: make(map[string]*Job) }
+
+// Registers a new job for the tenant's lines and starts it in the background
+func (r *JobRegistry) Start(tenant string, lines []string) *Job {
+	job := &Job{
+		id: newJobId(),
+		tenant: tenant,
+		lines: lines,
+		results: make([]LineResult, len(lines)),
+		status: JOB_STATUS_QUEUED,
+		createdAt: time.Now(),
+	}
+
+	r.mutex.Lock()
+	r.evictExpired()
+	r.jobs[job.id] = job
+	r.mutex.Unlock()
+
+	BackgroundWork.Add(1)
+	go job.Run()
+
+	return job
+}
+
+// Returns the tenant's job, other tenants' jobs don't exist as far as they
+// can tell
+func (r *JobRegistry) Get(tenant string, id string) (*Job, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	job, exists := r.jobs[id]
+	if !exists || job.tenant != tenant {
+		return nil, false
+	}
+	return job, true
+}
+
+// Must be called with the registry lock held
+func (r *JobRegistry) evictExpired() {
+	for id, job := range r.jobs {
+		job.mutex.RLock()
+		expired := job.status == JOB_STATUS_COMPLETE && time.Since(job.completedAt) > JOB_RETENTION
+		job.mutex.RUnlock()
+		if expired {
+			delete(r.jobs, id)
+		}
+	}
+}
+
+func newJobId() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		// Fall back to the clock, ids only need to be unique within this process
+		return hex.EncodeToString([]byte(time.Now().String()))
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// Ingests every line across a pool of parse_workers goroutines, as each line
+// can block on the wiki. Progress is recorded as each line finishes, on shutdown
+// the remaining lines fail quickly and are queued to be retried
+func (j *Job) Run() {
+	defer BackgroundWork.Done()
+
+	j.mutex.Lock()
+	j.status = JOB_STATUS_RUNNING
+	j.mutex.Unlock()
+
+	RunWorkerPool(Settings.ParseWorkers, len(j.lines), func(idx int) {
+		result := IC.parseLine(WithTenant(AppContext, j.tenant), j.lines[idx])
+
+		j.mutex.Lock()
+		j.results[idx] = result
+		j.processed++
+		j.mutex.Unlock()
+	})
+
+	j.mutex.Lock()
+	j.status = JOB_STATUS_COMPLETE
+	j.completedAt = time.Now()
+	j.mutex.Unlock()
+
+	Log.Debug("Completed job", "job", j.id, "lines", len(j.lines), "duration", j.completedAt.Sub(j.createdAt))
+}
+
+func (j *Job) MarshalJSON() ([]byte, error) {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+
+	// Only report the lines which have finished
+	results := []LineResult{}
+	for _, result := range j.results {
+		if result.status != "" {
+			results = append(results, result)
+		}
+	}
+
+	var completedAt *time.Time
+	if j.status == JOB_STATUS_COMPLETE {
+		completedAt = &j.completedAt
+	}
+
+	return json.Marshal(struct {
+		Id          string       `json:"id"`
+		Status      string       `json:"status"`
+		Total       int          `json:"total"`
+		Processed   int          `json:"processed"`
+		CreatedAt   time.Time    `json:"createdAt"`
+		CompletedAt *time.Time   `json:"completedAt"`
+		Results     []LineResult `json:"results"`
+	}{j.id, j.status, len(j.lines), j.processed, j.createdAt, completedAt, results})
+}
@@ -0,0 +1,61 @@
+package main
+
+import "encoding/json"
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: LineResult
+ |--------------------------------------------------------------------------
+ |
+ | Represents the outcome of ingesting a single raw auction line, these
+ | are aggregated and returned to whoever POSTed the lines
+ |
+ | @member line (string): The raw line as it was received
+ | @member status (string): One of the LINE_STATUS constants
+ | @member reason (string): Why the line was quarantined or failed, if it was
+ | @member items ([]ItemResult): Every item that was extracted from the line
+ |
+ */
+
+type LineResult struct {
+	line string
+	status string
+	reason string
+	items []ItemResult
+}
+
+type ItemResult struct {
+	name string
+	confidence float64
+	resolved bool
+	err string
+}
+
+const (
+	LINE_STATUS_PARSED      = "parsed"
+	LINE_STATUS_QUARANTINED = "quarantined"
+	LINE_STATUS_FAILED      = "failed"
+)
+
+func (l LineResult) MarshalJSON() ([]byte, error) {
+	items := l.items
+	if items == nil {
+		items = []ItemResult{}
+	}
+
+	return json.Marshal(struct {
+		Line   string       `json:"line"`
+		Status string       `json:"status"`
+		Reason string       `json:"reason,omitempty"`
+		Items  []ItemResult `json:"items"`
+	}{l.line, l.status, l.reason, items})
+}
+
+func (i ItemResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name       string  `json:"name"`
+		Confidence float64 `json:"confidence"`
+		Resolved   bool    `json:"resolved"`
+		Error      string  `json:"error,omitempty"`
+	}{i.name, i.confidence, i.resolved, i.err})
+}
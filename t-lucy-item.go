@@ -0,0 +1,323 @@
+package main
+
+import (
+	"database/sql"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: LucyItem
+ |--------------------------------------------------------------------------
+ |
+ | Represents an item in the pipe delimited dump format Lucy and the EQEmu
+ | items table use, which is what most community tooling (Gearcrafter,
+ | profile sites) still imports. Slots, classes and races are bitmasks and
+ | flags such as nodrop are inverted, 0 meaning the item is NO DROP. Rows
+ | read from an EQEmu dump go the other way through Item
+ |
+ | @member values (map[string]string): Column name to value, columns we
+ | don't know are written as 0
+ |
+ */
+
+type LucyItem struct {
+	values map[string]string
+}
+
+// The columns we export, in order. This is a subset of the full dump, the rest
+// describe data the wiki doesn't have
+var lucyColumns = []string{
+	"id", "name", "lore", "icon", "weight", "size", "slots", "classes", "races",
+	"ac", "hp", "mana", "astr", "asta", "aagi", "adex", "acha", "aint", "awis",
+	"fr", "cr", "dr", "mr", "pr", "damage", "delay", "range", "haste",
+	"itemtype", "maxcharges", "magic", "nodrop", "norent", "price",
+}
+
+// Stats which map straight onto a column
+var lucyStatColumns = map[string]string{
+	"AC": "ac", "HP": "hp", "MANA": "mana",
+	"STR": "astr", "STA": "asta", "AGI": "aagi", "DEX": "adex", "CHA": "acha", "INT": "aint", "WIS": "awis",
+	"SV FIRE": "fr", "SV COLD": "cr", "SV DISEASE": "dr", "SV MAGIC": "mr", "SV POISON": "pr",
+	"DMG": "damage", "ATK DELAY": "delay", "RANGE": "range", "HASTE": "haste", "CHARGES": "maxcharges",
+}
+
+var lucySlotBits = map[string]int{
+	"CHARM": 1, "EAR": 2 | 16, "HEAD": 4, "FACE": 8, "NECK": 32, "SHOULDERS": 64, "ARMS": 128,
+	"BACK": 256, "WRIST": 512 | 1024, "RANGE": 2048, "HANDS": 4096, "PRIMARY": 8192,
+	"SECONDARY": 16384, "FINGERS": 32768 | 65536, "FINGER": 32768 | 65536, "CHEST": 131072,
+	"LEGS": 262144, "FEET": 524288, "WAIST": 1048576, "AMMO": 2097152,
+}
+
+var lucyClassBits = map[string]int{
+	"WAR": 1, "CLR": 2, "PAL": 4, "RNG": 8, "SHD": 16, "DRU": 32, "MNK": 64,
+	"BRD": 128, "ROG": 256, "SHM": 512, "NEC": 1024, "WIZ": 2048, "MAG": 4096, "ENC": 8192,
+}
+
+var lucyRaceBits = map[string]int{
+	"HUM": 1, "BAR": 2, "ERU": 4, "ELF": 8, "HIE": 16, "DEF": 32, "HEF": 64,
+	"DWF": 128, "TRL": 256, "OGR": 512, "HFL": 1024, "GNM": 2048, "IKS": 4096,
+}
+
+var lucySizes = map[string]string{
+	"TINY": "0", "SMALL": "1", "MEDIUM": "2", "LARGE": "3", "GIANT": "4",
+}
+
+var lucyItemTypes = map[string]string{
+	WEAPON_SKILL_1H_SLASHING: "0", WEAPON_SKILL_2H_SLASHING: "1", WEAPON_SKILL_PIERCING: "2",
+	WEAPON_SKILL_1H_BLUNT: "3", WEAPON_SKILL_2H_BLUNT: "4", WEAPON_SKILL_ARCHERY: "5",
+	WEAPON_SKILL_THROWING: "7", WEAPON_SKILL_HAND_TO_HAND: "45",
+}
+
+// Wiki images are named after the client icon, i.e. /images/Item_1234.png
+var lucyIconRegex = regexp.MustCompile(`(?i)item_([0-9]+)\.`)
+
+func NewLucyItem(item Item) LucyItem {
+	l := LucyItem{ values: map[string]string{
+		"id": strconv.FormatInt(item.id, 10),
+		"name": item.displayName,
+		"lore": item.displayName,
+		"nodrop": "1",
+		"norent": "1",
+		"magic": "0",
+	}}
+	if l.values["name"] == "" {
+		l.values["name"] = item.name
+		l.values["lore"] = item.name
+	}
+	if matches := lucyIconRegex.FindStringSubmatch(item.imageSrc); len(matches) > 0 {
+		l.values["icon"] = matches[1]
+	}
+	// Prices are in copper
+	if item.vendorSellPrice.Valid {
+		l.values["price"] = strconv.FormatInt(int64(item.vendorSellPrice.Float64 * 1000), 10)
+	}
+
+	for _, stat := range item.statistics {
+		if column, exists := lucyStatColumns[stat.code]; exists && stat.value.Valid {
+			l.values[column] = strconv.FormatFloat(stat.value.Float64, 'f', -1, 64)
+			continue
+		}
+
+		switch stat.code {
+		case "WT":
+			// Weight is stored in tenths
+			l.values["weight"] = strconv.FormatInt(int64(stat.value.Float64 * 10 + 0.5), 10)
+		case "SIZE":
+			l.values["size"] = lucySizes[strings.TrimSpace(stat.effect)]
+		case "SLOT":
+			l.values["slots"] = strconv.Itoa(lucyBitmask(stat.effect, lucySlotBits))
+		case "CLASS":
+			l.values["classes"] = strconv.Itoa(lucyBitmask(stat.effect, lucyClassBits))
+		case "RACE":
+			l.values["races"] = strconv.Itoa(lucyBitmask(stat.effect, lucyRaceBits))
+		case "SKILL":
+			l.values["itemtype"] = lucyItemTypes[stat.effect]
+		case "AFFINITY":
+			affinity := strings.Replace(stat.effect, " ", "", -1)
+			if strings.Contains(affinity, "MAGIC") {
+				l.values["magic"] = "1"
+			}
+			if strings.Contains(affinity, "LORE") {
+				l.values["lore"] = "*" + l.values["name"]
+			}
+			if strings.Contains(affinity, "NODROP") || strings.Contains(affinity, "NOTRADE") {
+				l.values["nodrop"] = "0"
+			}
+			if strings.Contains(affinity, "NORENT") {
+				l.values["norent"] = "0"
+			}
+		}
+	}
+	return l
+}
+
+// Ors together the bits for every name in the effect, "ALL" sets every bit and
+// "ALL except X Y" sets every bit but those listed
+func lucyBitmask(effect string, bits map[string]int) int {
+	words := strings.Fields(strings.ToUpper(effect))
+	isAll := false
+	mask := 0
+	for _, word := range words {
+		if word == "ALL" {
+			isAll = true
+		}
+		mask |= bits[word]
+	}
+	if !isAll {
+		return mask
+	}
+
+	all := 0
+	for _, bit := range bits {
+		all |= bit
+	}
+	return all &^ mask
+}
+
+// Returns the item's values in lucyColumns order
+func (l LucyItem) Row() []string {
+	row := make([]string, len(lucyColumns))
+	for idx, column := range lucyColumns {
+		value := l.values[column]
+		if value == "" {
+			value = "0"
+		}
+		// The format has no escaping so the delimiter can't appear in a value
+		row[idx] = strings.NewReplacer("|", " ", "\n", " ", "\r", " ").Replace(value)
+	}
+	return row
+}
+
+// Affinities in the order the wiki lists them
+var lucyAffinities = []string{"MAGIC ITEM", "LORE ITEM", "NO DROP", "NO RENT"}
+
+// Builds an item from a row of the dump, values are keyed by lowercase column
+func NewLucyItemFromRow(columns []string, row []string) LucyItem {
+	l := LucyItem{ values: make(map[string]string) }
+	for idx, column := range columns {
+		if idx < len(row) {
+			l.values[strings.ToLower(strings.TrimSpace(column))] = strings.TrimSpace(row[idx])
+		}
+	}
+	return l
+}
+
+// Returns the item the row describes, the reverse of NewLucyItem, or why it
+// can't be read. Columns which are 0 are left out as the wiki leaves them out
+func (l LucyItem) Item() (Item, string) {
+	name := strings.TrimSpace(l.values["name"])
+	if name == "" {
+		return Item{}, "name is required"
+	}
+	item := Item{ name: name, displayName: TitleCase(name, true) }
+
+	// Prices are in copper, vendor prices in platinum
+	if price := l.number("price"); price > 0 {
+		item.vendorSellPrice = sql.NullFloat64{ Float64: price / 1000, Valid: true }
+	}
+
+	// maxcharges is -1 for items with unlimited charges
+	for _, code := range lucyStatCodes() {
+		if value := l.number(lucyStatColumns[code]); value != 0 && !(code == "CHARGES" && value < 0) {
+			item.statistics = append(item.statistics, Statistic{ code: code, value: sql.NullFloat64{ Float64: value, Valid: true } })
+		}
+	}
+	if weight := l.number("weight"); weight > 0 {
+		item.statistics = append(item.statistics, Statistic{ code: "WT", value: sql.NullFloat64{ Float64: weight / 10, Valid: true } })
+	}
+	if size := lucyKeyFor(lucySizes, l.values["size"]); size != "" {
+		item.statistics = append(item.statistics, Statistic{ code: "SIZE", effect: size })
+	}
+
+	var slots []string
+	for _, slot := range itemSlots {
+		if int(l.number("slots")) & lucySlotBits[slot] != 0 {
+			slots = append(slots, slot)
+		}
+	}
+	if len(slots) > 0 {
+		item.statistics = append(item.statistics, Statistic{ code: "SLOT", effect: strings.Join(slots, " ") })
+	}
+	if classes := lucyBitmaskNames(int(l.number("classes")), lucyClassBits); classes != "" {
+		item.statistics = append(item.statistics, Statistic{ code: "CLASS", effect: classes })
+	}
+	if races := lucyBitmaskNames(int(l.number("races")), lucyRaceBits); races != "" {
+		item.statistics = append(item.statistics, Statistic{ code: "RACE", effect: races })
+	}
+	if l.number("damage") > 0 {
+		if skill := lucyKeyFor(lucyItemTypes, l.values["itemtype"]); skill != "" {
+			item.statistics = append(item.statistics, Statistic{ code: "SKILL", effect: skill })
+		}
+	}
+
+	flags := map[string]bool{
+		"MAGIC ITEM": l.values["magic"] == "1",
+		"LORE ITEM": strings.HasPrefix(l.values["lore"], "*"),
+		"NO DROP": l.values["nodrop"] == "0",
+		"NO RENT": l.values["norent"] == "0",
+	}
+	var affinities []string
+	for _, affinity := range lucyAffinities {
+		if flags[affinity] {
+			affinities = append(affinities, affinity)
+		}
+	}
+	if len(affinities) > 0 {
+		item.statistics = append(item.statistics, Statistic{ code: "AFFINITY", effect: strings.Join(affinities, " ") })
+	}
+
+	// The same stats assignContainer adds for bags scraped from the wiki
+	if bagSlots := int(l.number("bagslots")); bagSlots > 0 {
+		item.container = &ItemContainer{ slots: bagSlots, maxSize: lucyKeyFor(lucySizes, l.values["bagsize"]), weightReduction: l.number("bagwr") }
+		item.statistics = append(item.statistics, Statistic{ code: "CAPACITY", value: sql.NullFloat64{ Float64: float64(bagSlots), Valid: true } })
+		if item.container.maxSize != "" {
+			item.statistics = append(item.statistics, Statistic{ code: "SIZE CAPACITY", effect: item.container.maxSize })
+		}
+		if item.container.weightReduction > 0 {
+			item.statistics = append(item.statistics, Statistic{ code: "WEIGHT REDUCTION", value: sql.NullFloat64{ Float64: item.container.weightReduction, Valid: true } })
+		}
+	}
+	return item, ""
+}
+
+// The value of the column as a number, 0 if it's missing or isn't one
+func (l LucyItem) number(column string) float64 {
+	value, err := strconv.ParseFloat(l.values[column], 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// The codes of lucyStatColumns in a fixed order, so items read from a dump
+// always list their stats the same way
+func lucyStatCodes() []string {
+	codes := make([]string, 0, len(lucyStatColumns))
+	for code := range lucyStatColumns {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Returns the key mapped to value, empty if there isn't one
+func lucyKeyFor(values map[string]string, value string) string {
+	for key, candidate := range values {
+		if candidate == strings.TrimSpace(value) {
+			return key
+		}
+	}
+	return ""
+}
+
+// The reverse of lucyBitmask, names in bit order or ALL if every bit is set
+func lucyBitmaskNames(mask int, bits map[string]int) string {
+	if mask <= 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(bits))
+	for name := range bits {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(a, b int) bool {
+		return bits[names[a]] < bits[names[b]]
+	})
+
+	all := 0
+	var set []string
+	for _, name := range names {
+		all |= bits[name]
+		if mask & bits[name] != 0 {
+			set = append(set, name)
+		}
+	}
+	if mask & all == all {
+		return "ALL"
+	}
+	return strings.Join(set, " ")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: MarketIndex
+ |--------------------------------------------------------------------------
+ |
+ | Represents the state of the whole market on a single day. It is built
+ | from the daily price roll-ups rather than the raw price points, each
+ | item contributes its closing price for the day to its category
+ |
+ | @member day (time.Time): The day this index covers
+ | @member volume (int64): Total number of price points seen that day
+ | @member categories (map[string]*CategoryIndex): Per category breakdown
+ |
+ */
+
+type MarketIndex struct {
+	day time.Time
+	volume int64
+	categories map[string]*CategoryIndex
+}
+
+type CategoryIndex struct {
+	items int
+	volume int64
+	median float64
+	prices []float64
+}
+
+const (
+	CATEGORY_SPELL  = "spell"
+	CATEGORY_WEAPON = "weapon"
+	CATEGORY_ARMOR  = "armor"
+	CATEGORY_MISC   = "misc"
+)
+
+// We don't store a category against items, so we derive one from the name and
+// the stats we parsed from the wiki
+const categorySQL = "CASE " +
+	"WHEN items.name LIKE 'Spell:%' OR items.name LIKE 'Song:%' THEN '" + CATEGORY_SPELL + "' " +
+	"WHEN EXISTS (SELECT 1 FROM statistics WHERE statistics.item_id = items.id AND statistics.code = 'DMG') THEN '" + CATEGORY_WEAPON + "' " +
+	"WHEN EXISTS (SELECT 1 FROM statistics WHERE statistics.item_id = items.id AND statistics.code = 'SLOT') THEN '" + CATEGORY_ARMOR + "' " +
+	"ELSE '" + CATEGORY_MISC + "' END"
+
+// Returns one index per day of the tenant's prices for the last number of days,
+// newest first. When a server is given the items it excludes from the market
+// are left out
+func FetchMarketIndex(tenant string, days int, server string) []MarketIndex {
+	var indexes []MarketIndex
+
+	parameters := []interface{}{tenant, ROLLUP_PERIOD_DAY, days}
+	query := "SELECT price_rollups.bucket, " + categorySQL + " AS category, price_rollups.close, price_rollups.volume " +
+		"FROM price_rollups " +
+		"INNER JOIN items " +
+		"ON items.id = price_rollups.item_id " +
+		"WHERE price_rollups.server = ? " +
+		"AND price_rollups.period = ? " +
+		"AND price_rollups.bucket >= DATE_SUB(CURDATE(), INTERVAL ? DAY) "
+	if server != "" {
+		query += "AND " + marketExclusionSQL + " "
+		parameters = append(parameters, server)
+	}
+	query += "ORDER BY price_rollups.bucket DESC"
+
+	rows, err := DB.Query(query, parameters...)
+	if err != nil {
+		return indexes
+	}
+	defer DB.CloseRows(rows)
+
+	byDay := make(map[time.Time]*MarketIndex)
+	var order []time.Time
+	for rows.Next() {
+		var (
+			day time.Time
+			category string
+			price float64
+			volume int64
+		)
+		err := rows.Scan(&day, &category, &price, &volume)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+
+		index, exists := byDay[day]
+		if !exists {
+			index = &MarketIndex{ day: day, categories: make(map[string]*CategoryIndex) }
+			byDay[day] = index
+			order = append(order, day)
+		}
+		index.volume += volume
+
+		categoryIndex, exists := index.categories[category]
+		if !exists {
+			categoryIndex = &CategoryIndex{}
+			index.categories[category] = categoryIndex
+		}
+		categoryIndex.items++
+		categoryIndex.volume += volume
+		categoryIndex.prices = append(categoryIndex.prices, price)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+
+	for _, day := range order {
+		index := byDay[day]
+		for _, categoryIndex := range index.categories {
+			categoryIndex.median = Median(categoryIndex.prices)
+		}
+		indexes = append(indexes, *index)
+	}
+
+	return indexes
+}
+
+func (m MarketIndex) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Day        string                    `json:"day"`
+		Volume     int64                     `json:"volume"`
+		Categories map[string]*CategoryIndex `json:"categories"`
+	}{m.day.Format("2006-01-02"), m.volume, m.categories})
+}
+
+func (c CategoryIndex) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Items  int     `json:"items"`
+		Volume int64   `json:"volume"`
+		Median float64 `json:"median"`
+	}{c.items, c.volume, c.median})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: MerchantItem
+ |--------------------------------------------------------------------------
+ |
+ | Represents an item sold by a vendor along with what the vendor charges
+ | for it, this lets consumers compare vendor prices with the player market
+ |
+ | @member merchantName (string): Name of the vendor NPC
+ | @member itemName (string): Name of the item being sold
+ | @member price (float64): The vendor's price converted to platinum
+ |
+ */
+
+type MerchantItem struct {
+	merchantName string
+	itemName string
+	price float64
+}
+
+// Coins are written as 1pp 2gp 3sp 4cp, each denomination is worth ten of the next
+var (
+	coinRegex         = regexp.MustCompile(`(?i)([0-9]+(?:\.[0-9]+)?)\s*(pp|gp|sp|cp|p|g|s|c)\b`)
+	merchantListRegex = regexp.MustCompile(`(?is)<li>\s*<a href="[^"]*" title="([^"]+)"[^>]*>.*?</a>(.*?)</li>`)
+)
+
+var coinValues = map[string]float64{
+	"pp": 1, "p": 1,
+	"gp": 0.1, "g": 0.1,
+	"sp": 0.01, "s": 0.01,
+	"cp": 0.001, "c": 0.001,
+}
+
+// Converts a price written in coins into platinum
+func ParseCoins(text string) (float64, bool) {
+	matches := coinRegex.FindAllStringSubmatch(text, -1)
+	if len(matches) == 0 {
+		return 0, false
+	}
+
+	total := 0.0
+	for _, match := range matches {
+		amount, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			continue
+		}
+		total += amount * coinValues[strings.ToLower(match[2])]
+	}
+	return total, true
+}
+
+// Vendor pages either list their wares in a table with item and price columns, or
+// as a bullet list of item links followed by the price
+func ParseMerchantInventory(merchantName string, body string) []MerchantItem {
+	var inventory []MerchantItem
+	seen := make(map[string]bool)
+
+	add := func(itemName string, priceText string) {
+		itemName = strings.TrimSpace(itemName)
+		price, ok := ParseCoins(priceText)
+		if itemName == "" || !ok || seen[strings.ToLower(itemName)] {
+			return
+		}
+		seen[strings.ToLower(itemName)] = true
+		inventory = append(inventory, MerchantItem{ merchantName: merchantName, itemName: itemName, price: price })
+	}
+
+	for _, table := range ExtractHtmlTables(body) {
+		if len(table) < 2 {
+			continue
+		}
+		itemColumn, priceColumn := -1, -1
+		for idx, header := range table[0] {
+			header = strings.ToLower(header)
+			if strings.Contains(header, "item") || header == "name" {
+				itemColumn = idx
+			} else if strings.Contains(header, "price") || strings.Contains(header, "cost") {
+				priceColumn = idx
+			}
+		}
+		if itemColumn < 0 || priceColumn < 0 {
+			continue
+		}
+		for _, row := range table[1:] {
+			if itemColumn < len(row) && priceColumn < len(row) {
+				add(row[itemColumn], row[priceColumn])
+			}
+		}
+	}
+
+	for _, match := range merchantListRegex.FindAllStringSubmatch(body, -1) {
+		add(match[1], StripTags(match[2]))
+	}
+
+	return inventory
+}
+
+// Replaces the vendor's inventory, items are linked to our items table by name
+// where we already know about them
+func SaveMerchantInventory(merchantName string, inventory []MerchantItem) {
+	if len(inventory) == 0 {
+		return
+	}
+
+	rows, err := DB.Query("DELETE FROM merchant_inventory WHERE merchant_name = ?", merchantName)
+	if err != nil {
+		Log.Error("Failed to clear inventory for merchant", "err", err)
+		return
+	}
+	DB.CloseRows(rows)
+
+	var parameters []interface{}
+	query := "INSERT INTO merchant_inventory " +
+		"(merchant_name, item_name, item_id, price) " +
+		"VALUES "
+	for _, item := range inventory {
+		query += "(?, ?, (SELECT id FROM items WHERE name = ? LIMIT 1), ?),"
+		parameters = append(parameters, merchantName, item.itemName, item.itemName, item.price)
+	}
+	query = query[0:len(query)-1]
+
+	_, err = DB.Insert(query, parameters...)
+	if err != nil {
+		Log.Error("Failed to save inventory for merchant", "err", err)
+	}
+}
+
+// Returns every vendor that sells the item, cheapest first
+func FetchMerchantPrices(itemId int64, itemName string) []MerchantItem {
+	merchants := []MerchantItem{}
+
+	query := "SELECT merchant_name, item_name, price " +
+		"FROM merchant_inventory " +
+		"WHERE item_id = ? " +
+		"OR item_name = ? " +
+		"ORDER BY price ASC"
+
+	rows, err := DB.Query(query, itemId, itemName)
+	if err != nil {
+		return merchants
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var m MerchantItem
+		if err := rows.Scan(&m.merchantName, &m.itemName, &m.price); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		merchants = append(merchants, m)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+
+	return merchants
+}
+
+func (m MerchantItem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Merchant string  `json:"merchant"`
+		Item     string  `json:"item"`
+		Price    float64 `json:"price"`
+	}{m.merchantName, m.itemName, m.price})
+}
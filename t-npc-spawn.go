@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: NpcSpawn
+ |--------------------------------------------------------------------------
+ |
+ | Represents a location an NPC spawns at. NPC pages on the wiki write
+ | these as the output of /loc, which is in Y, X, Z order
+ |
+ | @member npcName (string): Name of the NPC
+ | @member zone (string): Zone the NPC spawns in, if the page links one
+ | @member y (float64): The Y coordinate, the first value /loc prints
+ | @member x (float64): The X coordinate, the second value /loc prints
+ | @member z (sql.NullFloat64): The Z coordinate, most pages omit it
+ | @member text (string): The text the coordinates were parsed from
+ |
+ */
+
+type NpcSpawn struct {
+	npcName string
+	zone string
+	y float64
+	x float64
+	z sql.NullFloat64
+	text string
+}
+
+var (
+	locRegex  = regexp.MustCompile(`(?i)(?:/?loc(?:ation)?s?|coordinates|spawns? at)\s*(?:is|:)?\s*\(?\s*([+-]?[0-9]+(?:\.[0-9]+)?)\s*,\s*([+-]?[0-9]+(?:\.[0-9]+)?)(?:\s*,\s*([+-]?[0-9]+(?:\.[0-9]+)?))?`)
+	zoneRegex = regexp.MustCompile(`(?is)zone\s*:?\s*(?:</[^>]+>\s*)*(?:<[^a/>][^>]*>\s*)*<a href="[^"]*" title="([^"]+)"`)
+)
+
+// Parses every /loc style coordinate on an NPC page into a spawn point
+func ParseNpcSpawns(npcName string, body string) []NpcSpawn {
+	var spawns []NpcSpawn
+
+	zone := ""
+	if matches := zoneRegex.FindStringSubmatch(body); len(matches) > 1 {
+		zone = strings.TrimSpace(matches[1])
+	}
+
+	seen := make(map[string]bool)
+	text := StripTags(body)
+	for _, match := range locRegex.FindAllStringSubmatch(text, -1) {
+		y, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			continue
+		}
+		x, err := strconv.ParseFloat(match[2], 64)
+		if err != nil {
+			continue
+		}
+
+		// Pages often repeat the same camp in the description and the infobox
+		key := match[1] + "," + match[2]
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		spawn := NpcSpawn{ npcName: npcName, zone: zone, y: y, x: x, text: strings.TrimSpace(match[0]) }
+		if match[3] != "" {
+			if z, err := strconv.ParseFloat(match[3], 64); err == nil {
+				spawn.z = sql.NullFloat64{Float64: z, Valid: true}
+			}
+		}
+		spawns = append(spawns, spawn)
+	}
+
+	return spawns
+}
+
+// Replaces every spawn point stored for the NPC
+func SaveNpcSpawns(npcName string, spawns []NpcSpawn) {
+	if len(spawns) == 0 {
+		return
+	}
+
+	rows, err := DB.Query("DELETE FROM npc_spawns WHERE npc_name = ?", npcName)
+	if err != nil {
+		Log.Error("Failed to clear spawns for npc", "err", err)
+		return
+	}
+	DB.CloseRows(rows)
+
+	var parameters []interface{}
+	query := "INSERT INTO npc_spawns " +
+		"(npc_name, zone, loc_y, loc_x, loc_z, text) " +
+		"VALUES "
+	for _, spawn := range spawns {
+		query += "(?, ?, ?, ?, ?, ?),"
+		parameters = append(parameters, npcName, spawn.zone, spawn.y, spawn.x, spawn.z, spawn.text)
+	}
+	query = query[0:len(query)-1]
+
+	_, err = DB.Insert(query, parameters...)
+	if err != nil {
+		Log.Error("Failed to save spawns for npc", "err", err)
+	}
+}
+
+// Returns every spawn point we have stored for the NPC
+func FetchNpcSpawns(npcName string) []NpcSpawn {
+	spawns := []NpcSpawn{}
+
+	query := "SELECT npc_name, zone, loc_y, loc_x, loc_z, text " +
+		"FROM npc_spawns " +
+		"WHERE npc_name = ? " +
+		"ORDER BY id ASC"
+
+	rows, err := DB.Query(query, npcName)
+	if err != nil {
+		return spawns
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var (
+			spawn NpcSpawn
+			zone sql.NullString
+		)
+		err := rows.Scan(&spawn.npcName, &zone, &spawn.y, &spawn.x, &spawn.z, &spawn.text)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		spawn.zone = zone.String
+		spawns = append(spawns, spawn)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+
+	return spawns
+}
+
+func (s NpcSpawn) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Zone string   `json:"zone"`
+		Y    float64  `json:"y"`
+		X    float64  `json:"x"`
+		Z    *float64 `json:"z"`
+		Text string   `json:"text"`
+	}{s.zone, s.y, s.x, nullFloatPointer(s.z), s.text})
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Npc
+ |--------------------------------------------------------------------------
+ |
+ | Represents an NPC as described by its bestiary page on the wiki, along
+ | with the loot it is known to drop. Item pages link to their drop sources
+ | so this is what answers "who drops this"
+ |
+ | @member id (int64): Primary key of the npcs row
+ | @member name (string): Name of the NPC
+ | @member level (string): Level as the wiki writes it, often a range i.e. 12 - 14
+ | @member zone (string): Zone the NPC is found in
+ | @member hp (sql.NullInt64): Hit points, most pages don't list them
+ | @member drops ([]NpcDrop): Items the NPC is known to drop
+ | @member scrapedAt (time.Time): When the page was last scraped
+ |
+ */
+
+type Npc struct {
+	id int64
+	name string
+	level string
+	zone string
+	hp sql.NullInt64
+	drops []NpcDrop
+	scrapedAt time.Time
+}
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: NpcDrop
+ |--------------------------------------------------------------------------
+ |
+ | Represents an item listed under an NPC's known loot
+ |
+ | @member itemName (string): Name of the item as the page links it
+ | @member itemId (sql.NullInt64): Our item, if we've scraped it
+ | @member chance (sql.NullFloat64): Drop rate as a percentage, where listed
+ |
+ */
+
+type NpcDrop struct {
+	itemName string
+	itemId sql.NullInt64
+	chance sql.NullFloat64
+}
+
+var (
+	dropChanceRegex = regexp.MustCompile(`\(\s*([0-9]+(?:\.[0-9]+)?)\s*%\s*\)`)
+	npcNumberRegex  = regexp.MustCompile(`[0-9][0-9,]*`)
+)
+
+// Parses a bestiary page, returns false if the page has neither a level nor
+// any known loot as it's then unlikely to be an NPC
+func ParseNpc(name string, body string) (Npc, bool) {
+	npc := Npc{ name: name, drops: []NpcDrop{} }
+
+	for _, table := range ExtractHtmlTables(body) {
+		for _, row := range table {
+			label, value := npcInfoboxRow(row)
+			switch label {
+			case "level":
+				if npc.level == "" {
+					npc.level = value
+				}
+			case "zone":
+				if npc.zone == "" {
+					npc.zone = value
+				}
+			case "hp", "hit points":
+				if match := npcNumberRegex.FindString(value); match != "" && !npc.hp.Valid {
+					npc.hp = parseNullInt(strings.Replace(match, ",", "", -1))
+				}
+			}
+		}
+	}
+
+	// The infobox usually links the zone, prefer the link text if there is one
+	if matches := zoneRegex.FindStringSubmatch(body); len(matches) > 1 {
+		npc.zone = strings.TrimSpace(matches[1])
+	}
+
+	document, err := ParseHtml(body)
+	if err != nil {
+		Log.Error("Failed to parse html", "err", err)
+	} else {
+		npc.drops = parseNpcDrops(document)
+	}
+
+	return npc, npc.level != "" || len(npc.drops) > 0
+}
+
+// Infobox rows are either a label and value cell, or a single cell written
+// as "Label: value"
+func npcInfoboxRow(row []string) (string, string) {
+	if len(row) >= 2 {
+		return strings.ToLower(strings.TrimRight(strings.TrimSpace(row[0]), ":")), strings.TrimSpace(row[1])
+	}
+	parts := strings.SplitN(row[0], ":", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])
+}
+
+// Known loot is a bullet list of item links under its own heading, the list
+// ends at the next heading
+func parseNpcDrops(document *html.Node) []NpcDrop {
+	drops := []NpcDrop{}
+
+	seen := make(map[string]bool)
+	for _, section := range SectionNodes(document, "Known_Loot") {
+		for _, item := range FindAll(section, ByTag("li")) {
+			link := FindFirst(item, ByTag("a"))
+			if link == nil {
+				continue
+			}
+			itemName := LinkTitle(link)
+			if itemName == "" || seen[strings.ToLower(itemName)] {
+				continue
+			}
+			seen[strings.ToLower(itemName)] = true
+
+			drop := NpcDrop{ itemName: itemName }
+			if matches := dropChanceRegex.FindStringSubmatch(TextContent(item)); len(matches) > 1 {
+				if chance, err := strconv.ParseFloat(matches[1], 64); err == nil {
+					drop.chance = sql.NullFloat64{ Float64: chance, Valid: true }
+				}
+			}
+			drops = append(drops, drop)
+		}
+	}
+
+	return drops
+}
+
+// Saves the NPC and replaces its drops, drops are linked to our items table by
+// name where we already know about them
+func (n *Npc) Save(ctx context.Context) error {
+	return DB.Transaction(ctx, func(tx *Tx) error {
+		query := "INSERT INTO npcs (name, level, zone, hp, scraped_at) " +
+			"VALUES (?, ?, ?, ?, ?) " +
+			"ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), level = VALUES(level), zone = VALUES(zone), " +
+			"hp = VALUES(hp), scraped_at = VALUES(scraped_at)"
+
+		n.scrapedAt = time.Now().UTC()
+		id, err := tx.Insert(query, n.name, n.level, n.zone, n.hp, n.scrapedAt)
+		if err != nil || id <= 0 {
+			LoggerFrom(ctx).Error("Failed to save npc", "npc", n.name, "err", err)
+			return ErrDatabaseWrite
+		}
+		n.id = id
+
+		rows, err := tx.Query("DELETE FROM npc_drops WHERE npc_id = ?", n.id)
+		if err != nil {
+			return ErrDatabaseWrite
+		}
+		DB.CloseRows(rows)
+
+		if len(n.drops) == 0 {
+			return nil
+		}
+
+		var parameters []interface{}
+		query = "INSERT INTO npc_drops " +
+			"(npc_id, item_name, item_id, chance) " +
+			"VALUES "
+		for _, drop := range n.drops {
+			query += "(?, ?, (SELECT id FROM items WHERE name = ? LIMIT 1), ?),"
+			parameters = append(parameters, n.id, drop.itemName, drop.itemName, drop.chance)
+		}
+		query = query[0:len(query)-1]
+
+		if _, err := tx.Insert(query, parameters...); err != nil {
+			return ErrDatabaseWrite
+		}
+		LoggerFrom(ctx).Info("Saved npc", "npc", n.name, "id", n.id, "drops", len(n.drops))
+		return nil
+	})
+}
+
+// Returns the stored NPC and its drops, returns false if we haven't scraped it
+func FetchNpc(ctx context.Context, name string) (Npc, bool, error) {
+	npc := Npc{ name: name, drops: []NpcDrop{} }
+
+	query := "SELECT id, name, level, zone, hp, scraped_at FROM npcs WHERE name = ? LIMIT 1"
+	rows, err := DB.QueryContext(ctx, query, name)
+	if err != nil {
+		return npc, false, ErrDatabaseRead
+	}
+
+	exists := false
+	for rows.Next() {
+		if err := rows.Scan(&npc.id, &npc.name, &npc.level, &npc.zone, &npc.hp, &npc.scrapedAt); err != nil {
+			Log.Error("Scan failed", "err", err)
+			DB.CloseRows(rows)
+			return npc, false, ErrDatabaseRead
+		}
+		exists = true
+	}
+	DB.CloseRows(rows)
+	if !exists {
+		return npc, false, nil
+	}
+
+	query = "SELECT item_name, item_id, chance " +
+		"FROM npc_drops " +
+		"WHERE npc_id = ? " +
+		"ORDER BY chance IS NULL, chance DESC, item_name ASC"
+	rows, err = DB.QueryContext(ctx, query, npc.id)
+	if err != nil {
+		return npc, false, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var drop NpcDrop
+		if err := rows.Scan(&drop.itemName, &drop.itemId, &drop.chance); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		npc.drops = append(npc.drops, drop)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+		return npc, false, ErrDatabaseRead
+	}
+
+	return npc, true, nil
+}
+
+func (n Npc) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name      string    `json:"name"`
+		Level     string    `json:"level"`
+		Zone      string    `json:"zone"`
+		Hp        *int64    `json:"hp"`
+		Drops     []NpcDrop `json:"drops"`
+		ScrapedAt time.Time `json:"scraped_at"`
+	}{n.name, n.level, n.zone, nullIntPointer(n.hp), n.drops, n.scrapedAt})
+}
+
+func (d NpcDrop) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Item   string   `json:"item"`
+		ItemId *int64   `json:"item_id"`
+		Chance *float64 `json:"chance"`
+	}{d.itemName, nullIntPointer(d.itemId), nullFloatPointer(d.chance)})
+}
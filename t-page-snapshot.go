@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/alexmk92/stringutil"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: PageSnapshot
+ |--------------------------------------------------------------------------
+ |
+ | Represents the wiki page an item was parsed from, exactly as we fetched
+ | it. Pages are stored gzipped whenever a scrape succeeds, unless the page
+ | is the same as the item's latest snapshot, and only the newest
+ | page_snapshots_per_item are kept. Running with -reparse re-runs
+ | extraction over the latest snapshots, so a parser fix can be applied to
+ | every item without fetching thousands of pages from the wiki again
+ |
+ | @member id (int64): Primary key of the page_snapshots row
+ | @member itemId (int64): The item the page was parsed into
+ | @member uri (string): The page which was fetched
+ | @member body ([]byte): The gzipped page
+ | @member hash (string): Hex SHA-256 of the page before it was gzipped
+ | @member size (int): Size of the page before it was gzipped
+ | @member fetchedAt (time.Time): When the page was fetched
+ |
+ */
+
+type PageSnapshot struct {
+	id int64
+	itemId int64
+	uri string
+	body []byte
+	hash string
+	size int
+	fetchedAt time.Time
+}
+
+// Stores the page the item was just parsed from, this is best effort so a
+// failure is logged but never fails the scrape
+func SavePageSnapshot(ctx context.Context, itemId int64, uri string, body string) {
+	if itemId <= 0 {
+		return
+	}
+
+	hash := sha256Hex([]byte(body))
+	latest, exists, err := FetchLatestPageSnapshot(ctx, itemId)
+	if err != nil {
+		return
+	}
+	if exists && latest.hash == hash {
+		return
+	}
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write([]byte(body)); err != nil {
+		LoggerFrom(ctx).Error("Failed to compress page snapshot", "err", err)
+		return
+	}
+	if err := writer.Close(); err != nil {
+		LoggerFrom(ctx).Error("Failed to compress page snapshot", "err", err)
+		return
+	}
+
+	query := "INSERT INTO page_snapshots (item_id, uri, body, hash, size_bytes, fetched_at) VALUES (?, ?, ?, ?, ?, NOW())"
+	if _, err := DB.InsertContext(ctx, query, itemId, uri, compressed.Bytes(), hash, len(body)); err != nil {
+		LoggerFrom(ctx).Error("Failed to save page snapshot", "err", err)
+		return
+	}
+
+	// Everything older than the newest page_snapshots_per_item goes
+	query = "DELETE FROM page_snapshots WHERE item_id = ? AND id NOT IN (" +
+		"SELECT id FROM (SELECT id FROM page_snapshots WHERE item_id = ? ORDER BY fetched_at DESC, id DESC LIMIT ?) AS newest)"
+	if _, err := DB.InsertContext(ctx, query, itemId, itemId, Settings.PageSnapshotsPerItem); err != nil {
+		LoggerFrom(ctx).Error("Failed to prune page snapshots", "err", err)
+	}
+}
+
+// Returns the page the item was most recently parsed from, false if we don't
+// have one
+func FetchLatestPageSnapshot(ctx context.Context, itemId int64) (PageSnapshot, bool, error) {
+	snapshot := PageSnapshot{ itemId: itemId }
+
+	query := "SELECT id, uri, body, hash, size_bytes, fetched_at " +
+		"FROM page_snapshots " +
+		"WHERE item_id = ? " +
+		"ORDER BY fetched_at DESC, id DESC " +
+		"LIMIT 1"
+	rows, err := DB.QueryContext(ctx, query, itemId)
+	if err != nil {
+		return snapshot, false, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	if !rows.Next() {
+		return snapshot, false, nil
+	}
+	if err := rows.Scan(&snapshot.id, &snapshot.uri, &snapshot.body, &snapshot.hash, &snapshot.size, &snapshot.fetchedAt); err != nil {
+		Log.Error("Scan failed", "err", err)
+		return snapshot, false, ErrDatabaseRead
+	}
+	return snapshot, true, nil
+}
+
+// Returns the page as it was fetched
+func (s PageSnapshot) Page() (string, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(s.body))
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	page, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	return string(page), nil
+}
+
+// Parses the item again from its latest snapshot and saves the result, as a
+// scrape would have, so its stats and effects are replaced by what was parsed
+// and reparsing twice leaves the same rows as reparsing once. Returns false if
+// the item has no snapshot
+func (i *Item) Reparse(ctx context.Context) (bool, error) {
+	snapshot, exists, err := FetchLatestPageSnapshot(ctx, i.id)
+	if err != nil || !exists {
+		return false, err
+	}
+	page, err := snapshot.Page()
+	if err != nil {
+		LoggerFrom(ctx).Error("Failed to decompress page snapshot", "snapshot", snapshot.id, "err", err)
+		return true, ErrDatabaseRead
+	}
+
+	// The same choice of parser fetchDataFromWiki makes
+	if !stringutil.CaseInsenstiveContains(i.name, "spell:", "song:") && !stringutil.CaseInsenstiveContains(i.displayName, "spell:", "song:") {
+		return true, i.extractItemDataFromHttpResponse(ctx, page)
+	}
+	return true, i.extractSpellDataFromHttpBody(ctx, page)
+}
+
+// Reparses every item with a snapshot, or just the named items, from their
+// latest snapshots. Items corrected by hand are left alone. Returns the number
+// of items which failed to reparse
+func ReparseItems(ctx context.Context, names []string) int {
+	query := "SELECT id, name, displayName FROM items " +
+		"WHERE EXISTS (SELECT 1 FROM page_snapshots WHERE page_snapshots.item_id = items.id) " +
+		"AND COALESCE(is_manual_override, 0) = 0 "
+	var parameters []interface{}
+	if len(names) > 0 {
+		query += "AND name IN (" + Placeholders(len(names)) + ") "
+		for _, name := range names {
+			parameters = append(parameters, strings.Replace(name, "_", " ", -1))
+		}
+	}
+	query += "ORDER BY id ASC"
+
+	rows, err := DB.QueryContext(ctx, query, parameters...)
+	if err != nil {
+		return 1
+	}
+	var items []Item
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.id, &item.name, &item.displayName); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+	DB.CloseRows(rows)
+
+	failed := 0
+	for _, item := range items {
+		if ctx.Err() != nil {
+			break
+		}
+		logger := LoggerFrom(ctx).With("item", item.name)
+		if _, err := item.Reparse(WithLogger(ctx, logger)); err != nil {
+			logger.Warn("Failed to reparse item", "id", item.id, "err", err)
+			failed++
+		}
+	}
+	Log.Info("Reparsed items", "items", len(items), "failed", failed)
+	return failed
+}
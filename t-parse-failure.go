@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ParseFailure
+ |--------------------------------------------------------------------------
+ |
+ | Represents a wiki page we fetched but couldn't get an item or spell out
+ | of, either because it had no itemData block or because we couldn't read
+ | any stats from the block it had. The body is kept so an admin can see
+ | what the page looked like and retry it once the parser or the page has
+ | been fixed. A page failing again updates its row rather than adding one
+ |
+ | @member id (int64): Primary key of the parse_failures row
+ | @member name (string): The item name we were looking for
+ | @member url (string): The wiki page we fetched
+ | @member body (string): The page as we fetched it, up to PARSE_FAILURE_MAX_BODY bytes
+ | @member reason (string): The ErrorLabel of why parsing failed
+ | @member occurrences (int64): How many times the page has failed to parse
+ | @member createdAt (time.Time): When the page first failed to parse
+ | @member updatedAt (time.Time): When the page last failed to parse
+ |
+ */
+
+type ParseFailure struct {
+	id int64
+	name string
+	url string
+	body string
+	reason string
+	occurrences int64
+	createdAt time.Time
+	updatedAt time.Time
+}
+
+// Pages are rarely bigger than this and the start of the page is what shows
+// which template it was rendered with
+const PARSE_FAILURE_MAX_BODY = 65536
+
+// Whether the error means the page was fetched but didn't parse
+func IsParseFailure(err error) bool {
+	return err == ErrNotAnItemPage || err == ErrParseIncomplete
+}
+
+// Records the page as having failed to parse, uri is the page we fetched
+func RecordParseFailure(ctx context.Context, name string, uri string, body string, err error) {
+	if len(body) > PARSE_FAILURE_MAX_BODY {
+		body = strings.ToValidUTF8(body[:PARSE_FAILURE_MAX_BODY], "")
+	}
+
+	query := "INSERT INTO parse_failures (name, url, body, reason, occurrences, created_at, updated_at) " +
+		"VALUES (?, ?, ?, ?, 1, NOW(), NOW()) " +
+		"ON DUPLICATE KEY UPDATE url = VALUES(url), body = VALUES(body), reason = VALUES(reason), " +
+		"occurrences = occurrences + 1, updated_at = VALUES(updated_at)"
+	if _, err := DB.InsertContext(ctx, query, name, Settings.WikiBaseUrl + "/" + uri, body, ErrorLabel(err)); err != nil {
+		LoggerFrom(ctx).Error("Failed to record parse failure", "err", err)
+		return
+	}
+	LoggerFrom(ctx).Info("Recorded parse failure", "uri", uri, "reason", ErrorLabel(err))
+}
+
+// Removes the failure, this is called once the page has been retried successfully
+func (p *ParseFailure) Delete(ctx context.Context) {
+	if _, err := DB.InsertContext(ctx, "DELETE FROM parse_failures WHERE id = ?", p.id); err != nil {
+		LoggerFrom(ctx).Error("Failed to delete parse failure", "err", err)
+	}
+}
+
+// Loads a single failure by its id, returns false if it doesn't exist
+func FetchParseFailure(ctx context.Context, id int64) (ParseFailure, bool, error) {
+	failures, err := fetchParseFailures(ctx, "WHERE id = ? ", id)
+	if err != nil || len(failures) == 0 {
+		return ParseFailure{}, false, err
+	}
+	return failures[0], true, nil
+}
+
+// Returns the failures which happened most recently first
+func FetchParseFailures(ctx context.Context, limit int) ([]ParseFailure, error) {
+	return fetchParseFailures(ctx, "ORDER BY updated_at DESC, id DESC LIMIT ?", limit)
+}
+
+func fetchParseFailures(ctx context.Context, where string, parameters ...interface{}) ([]ParseFailure, error) {
+	failures := []ParseFailure{}
+
+	query := "SELECT id, name, url, body, reason, occurrences, created_at, updated_at " +
+		"FROM parse_failures " +
+		where
+
+	rows, err := DB.QueryContext(ctx, query, parameters...)
+	if err != nil {
+		return failures, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var p ParseFailure
+		err := rows.Scan(&p.id, &p.name, &p.url, &p.body, &p.reason, &p.occurrences, &p.createdAt, &p.updatedAt)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		failures = append(failures, p)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+		return failures, ErrDatabaseRead
+	}
+	return failures, nil
+}
+
+func (p ParseFailure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Id          int64     `json:"id"`
+		Name        string    `json:"name"`
+		Url         string    `json:"url"`
+		Body        string    `json:"body"`
+		Reason      string    `json:"reason"`
+		Occurrences int64     `json:"occurrences"`
+		CreatedAt   time.Time `json:"createdAt"`
+		UpdatedAt   time.Time `json:"updatedAt"`
+	}{p.id, p.name, p.url, p.body, p.reason, p.occurrences, p.createdAt, p.updatedAt})
+}
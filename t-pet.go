@@ -0,0 +1,184 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Pet
+ |--------------------------------------------------------------------------
+ |
+ | Represents a single row of the pet table found on summoning spell pages,
+ | magician and necromancer pets can spawn at one of several levels so a
+ | spell will usually have a handful of these
+ |
+ | @member spellId (int64): The id of the spell (item) that summons the pet
+ | @member level (int64): Level of the pet
+ | @member hp (sql.NullInt64): Hit points of the pet
+ | @member ac (sql.NullInt64): Armour class of the pet
+ | @member minDamage (sql.NullInt64): Lowest hit the pet can land
+ | @member maxDamage (sql.NullInt64): Highest hit the pet can land
+ | @member attackDelay (sql.NullInt64): Delay between the pet's attacks
+ |
+ */
+
+type Pet struct {
+	spellId int64
+	level int64
+	hp sql.NullInt64
+	ac sql.NullInt64
+	minDamage sql.NullInt64
+	maxDamage sql.NullInt64
+	attackDelay sql.NullInt64
+}
+
+var numberRegex = regexp.MustCompile(`-?[0-9]+`)
+
+// Looks for a table on the page whose header has both a level and a hp column
+// and turns each row of it into a Pet. Columns are matched by their header text
+// as the column order isn't consistent between pages
+func ParsePetTable(body string) []Pet {
+	var pets []Pet
+
+	for _, table := range ExtractHtmlTables(body) {
+		if len(table) < 2 {
+			continue
+		}
+
+		columns := make(map[string]int)
+		for idx, header := range table[0] {
+			header = strings.ToLower(header)
+			switch {
+			case header == "level" || header == "lvl":
+				columns["level"] = idx
+			case header == "hp" || strings.Contains(header, "hit points"):
+				columns["hp"] = idx
+			case header == "ac" || strings.Contains(header, "armor"):
+				columns["ac"] = idx
+			case strings.Contains(header, "damage") || strings.Contains(header, "max hit"):
+				columns["damage"] = idx
+			case strings.Contains(header, "delay"):
+				columns["delay"] = idx
+			}
+		}
+		if _, exists := columns["level"]; !exists {
+			continue
+		}
+		if _, exists := columns["hp"]; !exists {
+			continue
+		}
+
+		for _, row := range table[1:] {
+			level := petCell(row, columns, "level")
+			if !level.Valid {
+				continue
+			}
+
+			pet := Pet{
+				level: level.Int64,
+				hp: petCell(row, columns, "hp"),
+				ac: petCell(row, columns, "ac"),
+				attackDelay: petCell(row, columns, "delay"),
+			}
+
+			// Damage is usually written as a range i.e. 8-22, or just the max hit
+			if idx, exists := columns["damage"]; exists && idx < len(row) {
+				numbers := numberRegex.FindAllString(strings.Replace(row[idx], " - ", " ", -1), -1)
+				if len(numbers) == 1 {
+					pet.maxDamage = parseNullInt(numbers[0])
+				} else if len(numbers) > 1 {
+					pet.minDamage = parseNullInt(strings.TrimPrefix(numbers[0], "-"))
+					pet.maxDamage = parseNullInt(strings.TrimPrefix(numbers[1], "-"))
+				}
+			}
+
+			pets = append(pets, pet)
+		}
+	}
+
+	return pets
+}
+
+func petCell(row []string, columns map[string]int, column string) sql.NullInt64 {
+	idx, exists := columns[column]
+	if !exists || idx >= len(row) {
+		return sql.NullInt64{}
+	}
+	return parseNullInt(numberRegex.FindString(strings.Replace(row[idx], ",", "", -1)))
+}
+
+// Replaces every pet stored against the spell, pages get corrected over time so
+// we never want to merge the old rows with the new ones
+func SavePets(spellId int64, pets []Pet) {
+	if spellId <= 0 || len(pets) == 0 {
+		return
+	}
+
+	rows, err := DB.Query("DELETE FROM pets WHERE spell_id = ?", spellId)
+	if err != nil {
+		Log.Error("Failed to clear pets for spell", "err", err)
+		return
+	}
+	DB.CloseRows(rows)
+
+	var parameters []interface{}
+	query := "INSERT INTO pets " +
+		"(spell_id, level, hp, ac, min_damage, max_damage, attack_delay) " +
+		"VALUES "
+	for _, pet := range pets {
+		query += "(?, ?, ?, ?, ?, ?, ?),"
+		parameters = append(parameters, spellId, pet.level, pet.hp, pet.ac, pet.minDamage, pet.maxDamage, pet.attackDelay)
+	}
+	query = query[0:len(query)-1]
+
+	_, err = DB.Insert(query, parameters...)
+	if err != nil {
+		Log.Error("Failed to save pets for spell", "err", err)
+	}
+}
+
+// Returns every pet the spell can summon, lowest level first
+func FetchPets(spellId int64) []Pet {
+	pets := []Pet{}
+
+	query := "SELECT spell_id, level, hp, ac, min_damage, max_damage, attack_delay " +
+		"FROM pets " +
+		"WHERE spell_id = ? " +
+		"ORDER BY level ASC"
+
+	rows, err := DB.Query(query, spellId)
+	if err != nil {
+		return pets
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var p Pet
+		err := rows.Scan(&p.spellId, &p.level, &p.hp, &p.ac, &p.minDamage, &p.maxDamage, &p.attackDelay)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		pets = append(pets, p)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+
+	return pets
+}
+
+func (p Pet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Level       int64  `json:"level"`
+		Hp          *int64 `json:"hp"`
+		Ac          *int64 `json:"ac"`
+		MinDamage   *int64 `json:"minDamage"`
+		MaxDamage   *int64 `json:"maxDamage"`
+		AttackDelay *int64 `json:"attackDelay"`
+	}{p.level, nullIntPointer(p.hp), nullIntPointer(p.ac), nullIntPointer(p.minDamage), nullIntPointer(p.maxDamage), nullIntPointer(p.attackDelay)})
+}
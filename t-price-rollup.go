@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: PriceRollup
+ |--------------------------------------------------------------------------
+ |
+ | Represents the open/high/low/close prices of an item over a single hour
+ | or day. Roll-ups are precomputed by a background job so that trend
+ | charts never have to aggregate the raw price_points table. Each tenant
+ | has its own roll-ups
+ |
+ | @member server (string): The tenant the prices were reported to
+ | @member period (string): Either hour or day
+ | @member bucket (time.Time): Start of the hour or day
+ | @member open (float64): First price advertised in the bucket
+ | @member high (float64): Highest price advertised in the bucket
+ | @member low (float64): Lowest price advertised in the bucket
+ | @member close (float64): Last price advertised in the bucket
+ | @member volume (int64): Number of price points in the bucket
+ |
+ */
+
+type PriceRollup struct {
+	server string
+	itemId int64
+	period string
+	bucket time.Time
+	open float64
+	high float64
+	low float64
+	close float64
+	volume int64
+}
+
+const (
+	ROLLUP_PERIOD_HOUR = "hour"
+	ROLLUP_PERIOD_DAY  = "day"
+)
+
+// Price points matched with a lower confidence than this are left out of the
+// roll-ups, as they can't be filtered out once they've been aggregated
+const ROLLUP_MIN_CONFIDENCE = 0.9
+
+// SQL expressions which truncate a price point's timestamp to the start of its
+// bucket, along with the start of the window we recompute on each run. We always
+// recompute the previous bucket too so that late arriving points are counted
+var rollupBuckets = map[string][2]string{
+	ROLLUP_PERIOD_HOUR: {
+		"DATE_FORMAT(created_at, '%Y-%m-%d %H:00:00')",
+		"DATE_FORMAT(DATE_SUB(NOW(), INTERVAL 1 HOUR), '%Y-%m-%d %H:00:00')",
+	},
+	ROLLUP_PERIOD_DAY: {
+		"DATE(created_at)",
+		"DATE_SUB(CURDATE(), INTERVAL 1 DAY)",
+	},
+}
+
+// Recomputes the roll-ups every rollup_interval_in_secs until we shut down.
+// Periods without any roll-ups yet have every price point we have rolled up so
+// historic data is backfilled, once rather than on every boot of every replica
+func RollupPrices() {
+	for {
+		for _, period := range []string{ROLLUP_PERIOD_HOUR, ROLLUP_PERIOD_DAY} {
+			rollupPrices(period, !hasPriceRollups(period))
+		}
+		select {
+		case <-time.After(seconds(Settings.RollupIntervalInSecs)):
+		case <-AppContext.Done():
+			return
+		}
+	}
+}
+
+func rollupPrices(period string, full bool) {
+	bucket := rollupBuckets[period]
+
+	// GROUP_CONCAT ordered by time lets us pick out the first and last price in
+	// the bucket without a correlated sub query
+	query := "INSERT INTO price_rollups " +
+		"(server, item_id, period, bucket, open, high, low, close, volume) " +
+		"SELECT server, item_id, ?, " + bucket[0] + " AS rollup_bucket, " +
+		"SUBSTRING_INDEX(GROUP_CONCAT(price ORDER BY created_at ASC), ',', 1), " +
+		"MAX(price), MIN(price), " +
+		"SUBSTRING_INDEX(GROUP_CONCAT(price ORDER BY created_at DESC), ',', 1), " +
+		"COUNT(*) " +
+		"FROM price_points " +
+		"WHERE confidence >= ? "
+	if !full {
+		query += "AND created_at >= " + bucket[1] + " "
+	}
+	query += "GROUP BY server, item_id, rollup_bucket " +
+		"ON DUPLICATE KEY UPDATE open = VALUES(open), high = VALUES(high), low = VALUES(low), " +
+		"close = VALUES(close), volume = VALUES(volume)"
+
+	start := time.Now()
+	_, err := DB.Insert(query, period, ROLLUP_MIN_CONFIDENCE)
+	if err != nil {
+		Log.Error("Failed to roll up prices", "period", period, "err", err)
+	} else {
+		Log.Debug("Rolled up prices", "period", period, "duration", time.Since(start))
+	}
+}
+
+// Returns false if the period hasn't been rolled up yet. A failed lookup counts
+// as rolled up so the run sticks to the recent buckets
+func hasPriceRollups(period string) bool {
+	rows, err := DB.QueryContext(AppContext, "SELECT 1 FROM price_rollups WHERE period = ? LIMIT 1", period)
+	if err != nil {
+		Log.Error("Failed to check for price roll-ups", "period", period, "err", err)
+		return true
+	}
+	defer DB.CloseRows(rows)
+	return rows.Next()
+}
+
+// Returns the tenant's most recent roll-ups for an item, newest first
+func FetchPriceRollups(server string, itemId int64, period string, limit int) []PriceRollup {
+	var rollups []PriceRollup
+
+	query := "SELECT server, item_id, period, bucket, open, high, low, close, volume " +
+		"FROM price_rollups " +
+		"WHERE server = ? " +
+		"AND item_id = ? " +
+		"AND period = ? " +
+		"ORDER BY bucket DESC " +
+		"LIMIT ?"
+
+	rows, err := DB.Query(query, server, itemId, period, limit)
+	if err != nil {
+		return rollups
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var p PriceRollup
+		err := rows.Scan(&p.server, &p.itemId, &p.period, &p.bucket, &p.open, &p.high, &p.low, &p.close, &p.volume)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		rollups = append(rollups, p)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+
+	return rollups
+}
+
+func (p PriceRollup) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Period string    `json:"period"`
+		Bucket time.Time `json:"bucket"`
+		Open   float64   `json:"open"`
+		High   float64   `json:"high"`
+		Low    float64   `json:"low"`
+		Close  float64   `json:"close"`
+		Volume int64     `json:"volume"`
+	}{p.period, p.bucket, p.open, p.high, p.low, p.close, p.volume})
+}
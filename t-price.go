@@ -0,0 +1,183 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: PricePoint
+ |--------------------------------------------------------------------------
+ |
+ | Represents a single price that an item was advertised at in an auction
+ | line. Because the item was matched by the tokenizer we also persist how
+ | confident we were in the match, so that fuzzy matches can be excluded
+ | when aggregating prices
+ |
+ | @member server (string): The tenant the price was reported to
+ | @member itemId (int64): The item that the price belongs to
+ | @member price (float64): The advertised price in platinum
+ | @member confidence (float64): Confidence of the tokenizer match
+ | @member line (string): The raw auction line the price came from
+ |
+ */
+
+type PricePoint struct {
+	id int64
+	server string
+	itemId int64
+	price float64
+	confidence float64
+	line string
+}
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: PriceSummary
+ |--------------------------------------------------------------------------
+ |
+ | Aggregate of all price points for an item above a confidence threshold,
+ | along with the price vendors sell the item for so that consumers can
+ | spot arbitrage between the player market and NPC merchants. Prices the
+ | tenant has had corrected by hand are given too
+ |
+ */
+
+type PriceSummary struct {
+	corrected sql.NullFloat64
+	count int64
+	average sql.NullFloat64
+	min sql.NullFloat64
+	max sql.NullFloat64
+	minConfidence float64
+	vendors []MerchantItem
+}
+
+// Only confident matches update the observed price kept against the item
+const OBSERVED_PRICE_MIN_CONFIDENCE = 0.9
+
+// Matches prices such as 500, 500pp, 1.5k or 2kpp
+var priceRegex = regexp.MustCompile(`^([0-9]+(\.[0-9]+)?)(k)?(pp|p)?$`)
+
+// Attempts to parse a word from an auction line as a price in platinum
+func ParsePrice(word string) (float64, bool) {
+	matches := priceRegex.FindStringSubmatch(strings.ToLower(strings.TrimSpace(word)))
+	if len(matches) == 0 {
+		return 0, false
+	}
+
+	price, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	if matches[3] == "k" {
+		price *= 1000
+	}
+
+	return price, true
+}
+
+// Stores the price point and, if the match was confident enough, folds it into
+// the running average price stored against the item. The item is shared by
+// every tenant so only the default tenant's prices are averaged there. A price
+// corrected by hand is kept in corrected_price, so it's never averaged away
+func (p *PricePoint) Save() {
+	query := "INSERT INTO price_points " +
+		"(server, item_id, price, confidence, line) " +
+		"VALUES (?, ?, ?, ?, ?)"
+
+	id, err := DB.Insert(query, p.server, p.itemId, p.price, p.confidence, p.line)
+	if err != nil {
+		Log.Error("Failed to save price point", "err", err)
+		return
+	}
+	p.id = id
+
+	if p.confidence < OBSERVED_PRICE_MIN_CONFIDENCE || p.server != "" {
+		return
+	}
+	query = "UPDATE items " +
+		"SET observed_price = (COALESCE(observed_price, 0) * observed_count + ?) / (observed_count + 1), " +
+		"observed_count = observed_count + 1 " +
+		"WHERE id = ?"
+	if _, err := DB.Insert(query, p.price, p.itemId); err != nil {
+		Log.Error("Failed to update observed price", "err", err)
+	}
+}
+
+// Aggregates every price point the tenant has for the item, ignoring any which
+// were matched with a confidence lower than minConfidence
+func FetchPriceSummary(server string, itemId int64, minConfidence float64) PriceSummary {
+	summary := PriceSummary{ minConfidence: minConfidence }
+
+	query := "SELECT COUNT(*), AVG(price), MIN(price), MAX(price) " +
+		"FROM price_points " +
+		"WHERE server = ? " +
+		"AND item_id = ? " +
+		"AND confidence >= ?"
+
+	rows, err := DB.Query(query, server, itemId, minConfidence)
+	if err != nil {
+		return summary
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		err := rows.Scan(&summary.count, &summary.average, &summary.min, &summary.max)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+
+	summary.corrected = fetchCorrectedPrice(server, itemId)
+	return summary
+}
+
+// Returns the price corrected by hand for the tenant, the default tenant's is
+// kept against the item
+func fetchCorrectedPrice(server string, itemId int64) sql.NullFloat64 {
+	var price sql.NullFloat64
+
+	query := "SELECT price FROM price_corrections WHERE server = ? AND item_id = ?"
+	parameters := []interface{}{ server, itemId }
+	if server == "" {
+		query = "SELECT corrected_price FROM items WHERE id = ?"
+		parameters = []interface{}{ itemId }
+	}
+	rows, err := DB.Query(query, parameters...)
+	if err != nil {
+		return price
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		if err := rows.Scan(&price); err != nil {
+			Log.Error("Scan failed", "err", err)
+		}
+	}
+	return price
+}
+
+func (s PriceSummary) MarshalJSON() ([]byte, error) {
+	vendors := s.vendors
+	if vendors == nil {
+		vendors = []MerchantItem{}
+	}
+
+	return json.Marshal(struct {
+		Corrected     *float64       `json:"corrected"`
+		Count         int64          `json:"count"`
+		Average       *float64       `json:"average"`
+		Min           *float64       `json:"min"`
+		Max           *float64       `json:"max"`
+		MinConfidence float64        `json:"minConfidence"`
+		Vendors       []MerchantItem `json:"vendors"`
+	}{nullFloatPointer(s.corrected), s.count, nullFloatPointer(s.average), nullFloatPointer(s.min), nullFloatPointer(s.max), s.minConfidence, vendors})
+}
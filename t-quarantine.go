@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: QuarantinedLine
+ |--------------------------------------------------------------------------
+ |
+ | Represents a raw auction line which we refused to ingest, either because
+ | it looked like chat spam or because it didn't resolve to any item. The
+ | raw line is kept so that an admin can review it and replay it later
+ |
+ | @member id (int64): Primary key of the quarantined_lines row
+ | @member line (string): The raw line exactly as it was received
+ | @member reason (string): Why the line was quarantined
+ | @member createdAt (time.Time): When the line was quarantined
+ |
+ */
+
+type QuarantinedLine struct {
+	id int64
+	line string
+	reason string
+	createdAt time.Time
+}
+
+const (
+	QUARANTINE_REASON_EMPTY      = "empty line"
+	QUARANTINE_REASON_TOO_LONG   = "line too long"
+	QUARANTINE_REASON_URL        = "contains a url"
+	QUARANTINE_REASON_NO_LETTERS = "contains no letters"
+	QUARANTINE_REASON_REPEATED   = "repeated characters"
+	QUARANTINE_REASON_UNRESOLVED = "no resolvable items"
+)
+
+// Item names on the wiki are never anywhere near this long, anything longer
+// is almost certainly someone chatting in the auction channel
+const MAX_LINE_LENGTH = 80
+
+var (
+	urlRegex     = regexp.MustCompile(`(?i)(https?://|www\.|\.(com|net|org|gg)\b)`)
+	lettersRegex = regexp.MustCompile(`[A-Za-z]`)
+)
+
+// Given a raw line this returns the reason it looks like spam, if the line
+// looks legitimate then an empty string is returned
+func SuspiciousLineReason(line string) string {
+	line = strings.TrimSpace(line)
+
+	if line == "" {
+		return QUARANTINE_REASON_EMPTY
+	} else if len(line) > MAX_LINE_LENGTH {
+		return QUARANTINE_REASON_TOO_LONG
+	} else if urlRegex.MatchString(line) {
+		return QUARANTINE_REASON_URL
+	} else if !lettersRegex.MatchString(line) {
+		return QUARANTINE_REASON_NO_LETTERS
+	} else if hasRepeatedCharacters(line) {
+		return QUARANTINE_REASON_REPEATED
+	}
+
+	return ""
+}
+
+// Go's regexp package doesn't support back references, so we check for runs
+// of the same character by hand
+func hasRepeatedCharacters(line string) bool {
+	run := 1
+	var last rune
+	for idx, char := range line {
+		if idx > 0 && char == last && char != ' ' {
+			run++
+			if run >= 5 {
+				return true
+			}
+		} else {
+			run = 1
+		}
+		last = char
+	}
+	return false
+}
+
+// Writes the line to the quarantine table so it can be reviewed later
+func (q *QuarantinedLine) Save() {
+	query := "INSERT INTO quarantined_lines " +
+		"(line, reason) " +
+		"VALUES (?, ?)"
+
+	id, err := DB.Insert(query, q.line, q.reason)
+	if err != nil {
+		Log.Error("Failed to quarantine line", "err", err)
+	} else {
+		q.id = id
+		Log.Debug("Quarantined line", "line", q.line, "reason", q.reason)
+	}
+}
+
+// Removes the line from quarantine, this is called once a line has been
+// successfully replayed
+func (q *QuarantinedLine) Delete() {
+	query := "DELETE FROM quarantined_lines WHERE id = ?"
+	rows, err := DB.Query(query, q.id)
+	if err != nil {
+		Log.Error("Failed to delete quarantined line", "err", err)
+		return
+	}
+	DB.CloseRows(rows)
+}
+
+// Loads a single quarantined line by its id, returns false if it doesn't exist
+func (q *QuarantinedLine) FetchById(id int64) bool {
+	query := "SELECT id, line, reason, created_at " +
+		"FROM quarantined_lines " +
+		"WHERE id = ?"
+
+	rows, err := DB.Query(query, id)
+	if err != nil {
+		return false
+	}
+	defer DB.CloseRows(rows)
+
+	found := false
+	for rows.Next() {
+		err := rows.Scan(&q.id, &q.line, &q.reason, &q.createdAt)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+		} else {
+			found = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+	return found
+}
+
+// Returns every line currently in quarantine, oldest first
+func FetchQuarantinedLines() []QuarantinedLine {
+	var lines []QuarantinedLine
+
+	query := "SELECT id, line, reason, created_at " +
+		"FROM quarantined_lines " +
+		"ORDER BY id ASC"
+
+	rows, err := DB.Query(query)
+	if err != nil {
+		return lines
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var q QuarantinedLine
+		err := rows.Scan(&q.id, &q.line, &q.reason, &q.createdAt)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		lines = append(lines, q)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+	return lines
+}
+
+// Our members are unexported so we have to tell the encoder how to
+// serialise the line for the admin endpoints
+func (q QuarantinedLine) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Id        int64     `json:"id"`
+		Line      string    `json:"line"`
+		Reason    string    `json:"reason"`
+		CreatedAt time.Time `json:"createdAt"`
+	}{q.id, q.line, q.reason, q.createdAt})
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Quest
+ |--------------------------------------------------------------------------
+ |
+ | Represents a quest as described by its wiki page, the items it needs
+ | handed in, the items it rewards and the NPCs it walks you through.
+ | Items are linked to our items table by name where we know them, which
+ | is how an item's quests are found
+ |
+ | @member id (int64): Primary key of the quests row
+ | @member name (string): Name of the quest
+ | @member items ([]QuestItem): Items the quest requires or rewards
+ | @member steps ([]QuestStep): The walkthrough, in order
+ | @member scrapedAt (time.Time): When the page was last scraped
+ |
+ */
+
+type Quest struct {
+	id int64
+	name string
+	items []QuestItem
+	steps []QuestStep
+	scrapedAt time.Time
+}
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: QuestItem
+ |--------------------------------------------------------------------------
+ |
+ | Represents an item a quest requires or rewards
+ |
+ | @member questName (string): Name of the quest
+ | @member itemName (string): Name of the item as the page links it
+ | @member itemId (sql.NullInt64): Our item, if we've scraped it
+ | @member role (string): One of the QUEST_ITEM_* roles
+ |
+ */
+
+type QuestItem struct {
+	questName string
+	itemName string
+	itemId sql.NullInt64
+	role string
+}
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: QuestStep
+ |--------------------------------------------------------------------------
+ |
+ | Represents a step of a quest's walkthrough
+ |
+ | @member npcName (string): The NPC the step involves, if the step links one
+ | @member text (string): The step as the wiki writes it
+ |
+ */
+
+type QuestStep struct {
+	npcName string
+	text string
+}
+
+const (
+	QUEST_ITEM_REQUIRED = "required"
+	QUEST_ITEM_REWARD   = "reward"
+)
+
+// Parses a quest page, returns false if it has no items or steps as it's then
+// unlikely to be a quest
+func ParseQuest(name string, body string) (Quest, bool) {
+	quest := Quest{ name: name, items: []QuestItem{}, steps: []QuestStep{} }
+
+	document, err := ParseHtml(body)
+	if err != nil {
+		Log.Error("Failed to parse html", "err", err)
+		return quest, false
+	}
+
+	items := make(map[string]bool)
+	addItems := func(role string, ids ...string) {
+		for _, section := range SectionNodes(document, ids...) {
+			for _, link := range FindAll(section, ByTag("a")) {
+				itemName := LinkTitle(link)
+				key := strings.ToLower(itemName) + "|" + role
+				if itemName == "" || items[key] {
+					continue
+				}
+				items[key] = true
+				items[strings.ToLower(itemName)] = true
+				quest.items = append(quest.items, QuestItem{ questName: name, itemName: itemName, role: role })
+			}
+		}
+	}
+	addItems(QUEST_ITEM_REQUIRED, "Required_Items", "Items_Required", "Quest_Items")
+	addItems(QUEST_ITEM_REWARD, "Reward", "Rewards", "Quest_Reward", "Quest_Rewards")
+
+	// Each step is a paragraph or bullet of the walkthrough, the NPC is the
+	// first link which isn't one of the quest's items
+	for _, section := range SectionNodes(document, "Walkthrough", "Steps", "Quest_Steps") {
+		var parts []*html.Node
+		if section.Data == "ul" || section.Data == "ol" {
+			parts = FindAll(section, ByTag("li"))
+		} else {
+			parts = []*html.Node{ section }
+		}
+		for _, part := range parts {
+			text := TextContent(part)
+			if text == "" {
+				continue
+			}
+			step := QuestStep{ text: text }
+			for _, link := range FindAll(part, ByTag("a")) {
+				if linked := LinkTitle(link); !items[strings.ToLower(linked)] {
+					step.npcName = linked
+					break
+				}
+			}
+			quest.steps = append(quest.steps, step)
+		}
+	}
+
+	return quest, len(quest.items) > 0 || len(quest.steps) > 0
+}
+
+// Saves the quest and replaces its items and steps
+func (q *Quest) Save(ctx context.Context) error {
+	return DB.Transaction(ctx, func(tx *Tx) error {
+		query := "INSERT INTO quests (name, scraped_at) " +
+			"VALUES (?, ?) " +
+			"ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), scraped_at = VALUES(scraped_at)"
+
+		q.scrapedAt = time.Now().UTC()
+		id, err := tx.Insert(query, q.name, q.scrapedAt)
+		if err != nil || id <= 0 {
+			LoggerFrom(ctx).Error("Failed to save quest", "quest", q.name, "err", err)
+			return ErrDatabaseWrite
+		}
+		q.id = id
+
+		for _, table := range []string{"quest_items", "quest_steps"} {
+			rows, err := tx.Query("DELETE FROM " + table + " WHERE quest_id = ?", q.id)
+			if err != nil {
+				return ErrDatabaseWrite
+			}
+			DB.CloseRows(rows)
+		}
+
+		if len(q.items) > 0 {
+			var parameters []interface{}
+			query = "INSERT INTO quest_items (quest_id, item_name, item_id, role) VALUES "
+			for _, item := range q.items {
+				query += "(?, ?, (SELECT id FROM items WHERE name = ? LIMIT 1), ?),"
+				parameters = append(parameters, q.id, item.itemName, item.itemName, item.role)
+			}
+			if _, err := tx.Insert(query[0:len(query)-1], parameters...); err != nil {
+				return ErrDatabaseWrite
+			}
+		}
+		if len(q.steps) > 0 {
+			var parameters []interface{}
+			query = "INSERT INTO quest_steps (quest_id, position, npc_name, text) VALUES "
+			for idx, step := range q.steps {
+				query += "(?, ?, ?, ?),"
+				parameters = append(parameters, q.id, idx + 1, step.npcName, step.text)
+			}
+			if _, err := tx.Insert(query[0:len(query)-1], parameters...); err != nil {
+				return ErrDatabaseWrite
+			}
+		}
+
+		LoggerFrom(ctx).Info("Saved quest", "quest", q.name, "id", q.id, "items", len(q.items), "steps", len(q.steps))
+		return nil
+	})
+}
+
+// Returns the stored quest, returns false if we haven't scraped it
+func FetchQuest(ctx context.Context, name string) (Quest, bool, error) {
+	quest := Quest{ name: name, items: []QuestItem{}, steps: []QuestStep{} }
+
+	rows, err := DB.QueryContext(ctx, "SELECT id, name, scraped_at FROM quests WHERE name = ? LIMIT 1", name)
+	if err != nil {
+		return quest, false, ErrDatabaseRead
+	}
+	exists := false
+	for rows.Next() {
+		if err := rows.Scan(&quest.id, &quest.name, &quest.scrapedAt); err != nil {
+			Log.Error("Scan failed", "err", err)
+			DB.CloseRows(rows)
+			return quest, false, ErrDatabaseRead
+		}
+		exists = true
+	}
+	DB.CloseRows(rows)
+	if !exists {
+		return quest, false, nil
+	}
+
+	query := "SELECT item_name, item_id, role FROM quest_items WHERE quest_id = ? ORDER BY role ASC, item_name ASC"
+	rows, err = DB.QueryContext(ctx, query, quest.id)
+	if err != nil {
+		return quest, false, ErrDatabaseRead
+	}
+	for rows.Next() {
+		item := QuestItem{ questName: quest.name }
+		if err := rows.Scan(&item.itemName, &item.itemId, &item.role); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		quest.items = append(quest.items, item)
+	}
+	DB.CloseRows(rows)
+
+	query = "SELECT npc_name, text FROM quest_steps WHERE quest_id = ? ORDER BY position ASC"
+	rows, err = DB.QueryContext(ctx, query, quest.id)
+	if err != nil {
+		return quest, false, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+	for rows.Next() {
+		var step QuestStep
+		if err := rows.Scan(&step.npcName, &step.text); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		quest.steps = append(quest.steps, step)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+		return quest, false, ErrDatabaseRead
+	}
+
+	return quest, true, nil
+}
+
+// Returns every quest we know requires or rewards the item
+func FetchItemQuests(ctx context.Context, itemId int64, itemName string) ([]QuestItem, error) {
+	quests := []QuestItem{}
+
+	query := "SELECT quests.name, quest_items.item_name, quest_items.item_id, quest_items.role " +
+		"FROM quest_items " +
+		"INNER JOIN quests ON quests.id = quest_items.quest_id " +
+		"WHERE quest_items.item_id = ? " +
+		"OR quest_items.item_name = ? " +
+		"ORDER BY quests.name ASC, quest_items.role ASC"
+
+	rows, err := DB.QueryContext(ctx, query, itemId, itemName)
+	if err != nil {
+		return quests, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var q QuestItem
+		if err := rows.Scan(&q.questName, &q.itemName, &q.itemId, &q.role); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		quests = append(quests, q)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+		return quests, ErrDatabaseRead
+	}
+	return quests, nil
+}
+
+func (q Quest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name      string      `json:"name"`
+		Items     []QuestItem `json:"items"`
+		Steps     []QuestStep `json:"steps"`
+		ScrapedAt time.Time   `json:"scraped_at"`
+	}{q.name, q.items, q.steps, q.scrapedAt})
+}
+
+func (q QuestItem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Quest  string `json:"quest"`
+		Item   string `json:"item"`
+		ItemId *int64 `json:"item_id"`
+		Role   string `json:"role"`
+	}{q.questName, q.itemName, nullIntPointer(q.itemId), q.role})
+}
+
+func (s QuestStep) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Npc  string `json:"npc"`
+		Text string `json:"text"`
+	}{s.npcName, s.text})
+}
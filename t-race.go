@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Races
+ |--------------------------------------------------------------------------
+ |
+ | Items list the races that can use them in their RACE stat, either as a
+ | list of abbreviations ("HUM BAR ERU"), as ALL, or as ALL except a list.
+ | Armour is cut for one of three frames, so we group races by size to
+ | answer questions like "which chest pieces fit a small race"
+ |
+ */
+
+const (
+	WEARER_SIZE_SMALL  = "small"
+	WEARER_SIZE_MEDIUM = "medium"
+	WEARER_SIZE_LARGE  = "large"
+)
+
+var raceAbbreviations = map[string]string{
+	"human": "HUM", "barbarian": "BAR", "erudite": "ERU", "wood elf": "ELF",
+	"high elf": "HIE", "dark elf": "DEF", "half elf": "HEF", "dwarf": "DWF",
+	"troll": "TRL", "ogre": "OGR", "halfling": "HFL", "gnome": "GNM", "iksar": "IKS",
+}
+
+var raceSizes = map[string][]string{
+	WEARER_SIZE_SMALL:  {"DWF", "HFL", "GNM"},
+	WEARER_SIZE_MEDIUM: {"HUM", "ERU", "ELF", "HIE", "DEF", "HEF", "IKS"},
+	WEARER_SIZE_LARGE:  {"BAR", "TRL", "OGR"},
+}
+
+// Returns the abbreviation for a race, accepting either the full race name or
+// the abbreviation itself. Returns false if the race doesn't exist
+func NormaliseRace(race string) (string, bool) {
+	race = strings.ToLower(strings.Replace(strings.TrimSpace(race), "-", " ", -1))
+	if abbreviation, exists := raceAbbreviations[race]; exists {
+		return abbreviation, true
+	}
+	for _, abbreviation := range raceAbbreviations {
+		if strings.EqualFold(race, abbreviation) {
+			return abbreviation, true
+		}
+	}
+	return "", false
+}
+
+// Returns the races which wear armour of the given size
+func RacesOfSize(size string) ([]string, bool) {
+	races, exists := raceSizes[strings.ToLower(strings.TrimSpace(size))]
+	return races, exists
+}
+
+// Works out which sizes of wearer can use an item from its RACE stat, this is
+// returned with the item so clients don't have to repeat the logic
+func WearerSizes(raceEffect string) []string {
+	raceEffect = strings.ToUpper(raceEffect)
+	if raceEffect == "" {
+		return nil
+	}
+
+	isAll := strings.Contains(raceEffect, "ALL")
+	var sizes []string
+	for size, races := range raceSizes {
+		for _, race := range races {
+			listed := strings.Contains(raceEffect, race)
+			if (isAll && !listed) || (!isAll && listed) {
+				sizes = append(sizes, size)
+				break
+			}
+		}
+	}
+	sort.Strings(sizes)
+	return sizes
+}
+
+// Builds a SQL condition which is true when any of the races can use the item,
+// item_races already has ALL expanded into every race it covers
+func raceFitSQL(races []string) (string, []interface{}) {
+	var parameters []interface{}
+	for _, race := range races {
+		parameters = append(parameters, race)
+	}
+
+	return "EXISTS (SELECT 1 FROM item_races WHERE item_races.item_id = items.id " +
+		"AND item_races.race IN (" + Placeholders(len(races)) + "))", parameters
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Recipe
+ |--------------------------------------------------------------------------
+ |
+ | Represents a tradeskill combine as described by its wiki page. The
+ | components go into the container and the results come out of it, both
+ | are linked to our items table by name where we know them so an item's
+ | recipes can be found either way round
+ |
+ | @member id (int64): Primary key of the recipes row
+ | @member name (string): Name of the recipe
+ | @member tradeskill (string): The tradeskill used i.e. Smithing
+ | @member trivial (sql.NullInt64): Skill at which the combine stops giving skill ups
+ | @member container (string): What the combine is made in i.e. Forge
+ | @member components ([]RecipeComponent): Items which are used up, and any results
+ | @member scrapedAt (time.Time): When the page was last scraped
+ |
+ */
+
+type Recipe struct {
+	id int64
+	name string
+	tradeskill string
+	trivial sql.NullInt64
+	container string
+	components []RecipeComponent
+	scrapedAt time.Time
+}
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: RecipeComponent
+ |--------------------------------------------------------------------------
+ |
+ | Represents an item a recipe uses or produces
+ |
+ | @member recipeName (string): Name of the recipe
+ | @member itemName (string): Name of the item as the page links it
+ | @member itemId (sql.NullInt64): Our item, if we've scraped it
+ | @member count (int): How many of the item are used or produced
+ | @member role (string): One of the RECIPE_* roles
+ |
+ */
+
+type RecipeComponent struct {
+	recipeName string
+	itemName string
+	itemId sql.NullInt64
+	count int
+	role string
+}
+
+const (
+	RECIPE_COMPONENT = "component"
+	RECIPE_RESULT    = "result"
+)
+
+// Matches counts written either side of the item i.e. "2x Bone Chips" or "Bone Chips x2"
+var recipeCountRegex = regexp.MustCompile(`(?i)(?:^|\s)(?:x\s*([0-9]+)|([0-9]+)\s*x)(?:\s|$)|\(([0-9]+)\)`)
+
+// Parses a recipe page, returns false if it has no components as it's then
+// unlikely to be a recipe
+func ParseRecipe(name string, body string) (Recipe, bool) {
+	recipe := Recipe{ name: name, components: []RecipeComponent{} }
+
+	document, err := ParseHtml(body)
+	if err != nil {
+		Log.Error("Failed to parse html", "err", err)
+		return recipe, false
+	}
+
+	for _, row := range FindAll(document, ByTag("tr")) {
+		label, text, _ := zoneInfoboxRow(row)
+		switch {
+		case strings.Contains(label, "tradeskill") || label == "skill":
+			recipe.tradeskill = text
+		case strings.Contains(label, "trivial"):
+			if fields := strings.Fields(text); len(fields) > 0 {
+				recipe.trivial = parseNullInt(fields[0])
+			}
+		case strings.Contains(label, "container") || strings.Contains(label, "combine in"):
+			recipe.container = text
+		}
+	}
+
+	addComponents := func(role string, ids ...string) {
+		for _, section := range SectionNodes(document, ids...) {
+			for _, item := range FindAll(section, ByTag("li")) {
+				link := FindFirst(item, ByTag("a"))
+				if link == nil {
+					continue
+				}
+				component := RecipeComponent{ recipeName: name, itemName: LinkTitle(link), count: 1, role: role }
+				if component.itemName == "" {
+					continue
+				}
+				if matches := recipeCountRegex.FindStringSubmatch(TextContent(item)); len(matches) > 0 {
+					for _, match := range matches[1:] {
+						if count, err := strconv.Atoi(match); err == nil && count > 0 {
+							component.count = count
+						}
+					}
+				}
+				recipe.components = append(recipe.components, component)
+			}
+		}
+	}
+	addComponents(RECIPE_COMPONENT, "Components", "Ingredients", "Materials")
+	addComponents(RECIPE_RESULT, "Result", "Results", "Yield", "Produces")
+
+	for _, component := range recipe.components {
+		if component.role == RECIPE_COMPONENT {
+			return recipe, true
+		}
+	}
+	return recipe, false
+}
+
+// Saves the recipe and replaces its components
+func (r *Recipe) Save(ctx context.Context) error {
+	return DB.Transaction(ctx, func(tx *Tx) error {
+		query := "INSERT INTO recipes (name, tradeskill, trivial, container, scraped_at) " +
+			"VALUES (?, ?, ?, ?, ?) " +
+			"ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), tradeskill = VALUES(tradeskill), trivial = VALUES(trivial), " +
+			"container = VALUES(container), scraped_at = VALUES(scraped_at)"
+
+		r.scrapedAt = time.Now().UTC()
+		id, err := tx.Insert(query, r.name, r.tradeskill, r.trivial, r.container, r.scrapedAt)
+		if err != nil || id <= 0 {
+			LoggerFrom(ctx).Error("Failed to save recipe", "recipe", r.name, "err", err)
+			return ErrDatabaseWrite
+		}
+		r.id = id
+
+		rows, err := tx.Query("DELETE FROM recipe_components WHERE recipe_id = ?", r.id)
+		if err != nil {
+			return ErrDatabaseWrite
+		}
+		DB.CloseRows(rows)
+
+		if len(r.components) > 0 {
+			var parameters []interface{}
+			query = "INSERT INTO recipe_components (recipe_id, item_name, item_id, count, role) VALUES "
+			for _, component := range r.components {
+				query += "(?, ?, (SELECT id FROM items WHERE name = ? LIMIT 1), ?, ?),"
+				parameters = append(parameters, r.id, component.itemName, component.itemName, component.count, component.role)
+			}
+			if _, err := tx.Insert(query[0:len(query)-1], parameters...); err != nil {
+				return ErrDatabaseWrite
+			}
+		}
+
+		LoggerFrom(ctx).Info("Saved recipe", "recipe", r.name, "id", r.id, "components", len(r.components))
+		return nil
+	})
+}
+
+// Returns the stored recipe, returns false if we haven't scraped it
+func FetchRecipe(ctx context.Context, name string) (Recipe, bool, error) {
+	recipe := Recipe{ name: name, components: []RecipeComponent{} }
+
+	query := "SELECT id, name, tradeskill, trivial, container, scraped_at FROM recipes WHERE name = ? LIMIT 1"
+	rows, err := DB.QueryContext(ctx, query, name)
+	if err != nil {
+		return recipe, false, ErrDatabaseRead
+	}
+	exists := false
+	for rows.Next() {
+		if err := rows.Scan(&recipe.id, &recipe.name, &recipe.tradeskill, &recipe.trivial, &recipe.container, &recipe.scrapedAt); err != nil {
+			Log.Error("Scan failed", "err", err)
+			DB.CloseRows(rows)
+			return recipe, false, ErrDatabaseRead
+		}
+		exists = true
+	}
+	DB.CloseRows(rows)
+	if !exists {
+		return recipe, false, nil
+	}
+
+	query = "SELECT recipes.name, recipe_components.item_name, recipe_components.item_id, recipe_components.count, recipe_components.role " +
+		"FROM recipe_components " +
+		"INNER JOIN recipes ON recipes.id = recipe_components.recipe_id " +
+		"WHERE recipe_components.recipe_id = ? " +
+		"ORDER BY recipe_components.role ASC, recipe_components.item_name ASC"
+	if recipe.components, err = fetchRecipeComponents(ctx, query, recipe.id); err != nil {
+		return recipe, false, err
+	}
+	return recipe, true, nil
+}
+
+// Returns the recipes which use the item and the recipes which produce it
+func FetchItemRecipes(ctx context.Context, itemId int64, itemName string) ([]RecipeComponent, []RecipeComponent, error) {
+	query := "SELECT recipes.name, recipe_components.item_name, recipe_components.item_id, recipe_components.count, recipe_components.role " +
+		"FROM recipe_components " +
+		"INNER JOIN recipes ON recipes.id = recipe_components.recipe_id " +
+		"WHERE (recipe_components.item_id = ? OR recipe_components.item_name = ?) " +
+		"ORDER BY recipes.name ASC"
+
+	components, err := fetchRecipeComponents(ctx, query, itemId, itemName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	usedIn := []RecipeComponent{}
+	producedBy := []RecipeComponent{}
+	for _, component := range components {
+		if component.role == RECIPE_RESULT {
+			producedBy = append(producedBy, component)
+		} else {
+			usedIn = append(usedIn, component)
+		}
+	}
+	return usedIn, producedBy, nil
+}
+
+func fetchRecipeComponents(ctx context.Context, query string, parameters ...interface{}) ([]RecipeComponent, error) {
+	components := []RecipeComponent{}
+
+	rows, err := DB.QueryContext(ctx, query, parameters...)
+	if err != nil {
+		return components, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var c RecipeComponent
+		if err := rows.Scan(&c.recipeName, &c.itemName, &c.itemId, &c.count, &c.role); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		components = append(components, c)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+		return components, ErrDatabaseRead
+	}
+	return components, nil
+}
+
+func (r Recipe) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name       string            `json:"name"`
+		Tradeskill string            `json:"tradeskill"`
+		Trivial    *int64            `json:"trivial"`
+		Container  string            `json:"container"`
+		Components []RecipeComponent `json:"components"`
+		ScrapedAt  time.Time         `json:"scraped_at"`
+	}{r.name, r.tradeskill, nullIntPointer(r.trivial), r.container, r.components, r.scrapedAt})
+}
+
+func (c RecipeComponent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Recipe string `json:"recipe"`
+		Item   string `json:"item"`
+		ItemId *int64 `json:"item_id"`
+		Count  int    `json:"count"`
+		Role   string `json:"role"`
+	}{c.recipeName, c.itemName, nullIntPointer(c.itemId), c.count, c.role})
+}
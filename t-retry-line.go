@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: RetryLine
+ |--------------------------------------------------------------------------
+ |
+ | Represents a raw auction line which failed for a transient reason, i.e.
+ | the wiki was down or served a maintenance page. Rather than dropping the
+ | line it is queued and parsed again in the background, each failure backs
+ | off further and once RETRY_LINE_MAX_ATTEMPTS is reached the line is moved
+ | to quarantine for an admin to look at
+ |
+ | @member id (int64): Primary key of the retry_lines row
+ | @member server (string): The tenant the line was sent by
+ | @member line (string): The raw line exactly as it was received
+ | @member reason (string): The error from the last attempt
+ | @member attempts (int): Number of times the line has failed
+ | @member nextAttemptAt (time.Time): When the line will next be retried
+ | @member createdAt (time.Time): When the line was first queued
+ |
+ */
+
+type RetryLine struct {
+	id int64
+	server string
+	line string
+	reason string
+	attempts int
+	nextAttemptAt time.Time
+	createdAt time.Time
+}
+
+// Lines which are still failing after this many attempts are quarantined
+const RETRY_LINE_MAX_ATTEMPTS = 5
+
+const QUARANTINE_REASON_RETRIES_EXHAUSTED = "failed too many times"
+
+// Queues the tenant's line to be retried, if it is already queued the attempt
+// count is bumped and the next attempt pushed back, doubling the wait every time
+func QueueRetryLine(server string, line string, reason string) {
+	query := "INSERT INTO retry_lines " +
+		"(line_hash, server, line, reason, attempts, next_attempt_at) " +
+		"VALUES (?, ?, ?, ?, 1, DATE_ADD(NOW(), INTERVAL ? SECOND)) " +
+		"ON DUPLICATE KEY UPDATE reason = VALUES(reason), attempts = attempts + 1, " +
+		"next_attempt_at = DATE_ADD(NOW(), INTERVAL ? * POW(2, attempts - 1) SECOND)"
+
+	_, err := DB.Insert(query, retryLineHash(server, line), server, line, reason, Settings.RetryIntervalInSecs, Settings.RetryIntervalInSecs)
+	if err != nil {
+		Log.Error("Failed to queue line for retry", "err", err)
+	} else {
+		Log.Debug("Queued line for retry", "line", line, "reason", reason)
+	}
+}
+
+// Lines can be longer than MySQL allows in a unique key so we key on a hash,
+// the same line sent by two tenants is queued for each of them
+func retryLineHash(server string, line string) string {
+	if server != "" {
+		line = server + "\n" + line
+	}
+	hash := sha1.Sum([]byte(line))
+	return hex.EncodeToString(hash[:])
+}
+
+// Parses queued lines again every retry_interval_in_secs until we shut down. Lines
+// which fail again are re-queued by parseLine, bumping their attempts
+func RetryLines() {
+	for {
+		select {
+		case <-time.After(seconds(Settings.RetryIntervalInSecs)):
+		case <-AppContext.Done():
+			return
+		}
+
+		for _, retry := range FetchDueRetryLines() {
+			if retry.attempts >= RETRY_LINE_MAX_ATTEMPTS {
+				quarantined := QuarantinedLine{ line: retry.line, reason: QUARANTINE_REASON_RETRIES_EXHAUSTED }
+				quarantined.Save()
+				retry.Delete()
+				continue
+			}
+
+			if AppContext.Err() != nil {
+				return
+			}
+			result := IC.parseLine(WithTenant(AppContext, retry.server), retry.line)
+			if result.status != LINE_STATUS_FAILED {
+				retry.Delete()
+			}
+		}
+	}
+}
+
+func (r *RetryLine) Delete() {
+	query := "DELETE FROM retry_lines WHERE id = ?"
+	rows, err := DB.Query(query, r.id)
+	if err != nil {
+		Log.Error("Failed to delete retry line", "err", err)
+		return
+	}
+	DB.CloseRows(rows)
+}
+
+// Returns every line due a retry, oldest first
+func FetchDueRetryLines() []RetryLine {
+	return fetchRetryLines("WHERE next_attempt_at <= NOW() ")
+}
+
+// Returns every queued line, oldest first
+func FetchRetryLines() []RetryLine {
+	return fetchRetryLines("")
+}
+
+func fetchRetryLines(where string) []RetryLine {
+	lines := []RetryLine{}
+
+	query := "SELECT id, server, line, reason, attempts, next_attempt_at, created_at " +
+		"FROM retry_lines " +
+		where +
+		"ORDER BY id ASC"
+
+	rows, err := DB.Query(query)
+	if err != nil {
+		return lines
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var r RetryLine
+		err := rows.Scan(&r.id, &r.server, &r.line, &r.reason, &r.attempts, &r.nextAttemptAt, &r.createdAt)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		lines = append(lines, r)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+	return lines
+}
+
+func (r RetryLine) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Id            int64     `json:"id"`
+		Server        string    `json:"server"`
+		Line          string    `json:"line"`
+		Reason        string    `json:"reason"`
+		Attempts      int       `json:"attempts"`
+		NextAttemptAt time.Time `json:"nextAttemptAt"`
+		CreatedAt     time.Time `json:"createdAt"`
+	}{r.id, r.server, r.line, r.reason, r.attempts, r.nextAttemptAt, r.createdAt})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Snapshot
+ |--------------------------------------------------------------------------
+ |
+ | Represents a gzipped JSON dump of every item we have, taken nightly and
+ | uploaded to the snapshot bucket so that researchers can download a stable
+ | dataset rather than paging through the API. Snapshots older than
+ | snapshot_retention_days are deleted from the bucket and the table
+ |
+ | @member id (int64): Primary key of the snapshots row
+ | @member key (string): Key of the object in the snapshot bucket
+ | @member itemCount (int): Number of items in the snapshot
+ | @member size (int64): Compressed size in bytes
+ | @member createdAt (time.Time): When the snapshot was uploaded
+ |
+ */
+
+type Snapshot struct {
+	id int64
+	key string
+	itemCount int
+	size int64
+	createdAt time.Time
+}
+
+// Takes a snapshot once a day at snapshot_hour_utc until we shut down. If we
+// were down at that hour the snapshot is taken as soon as we're back up
+func SnapshotCatalog() {
+	if Settings.SnapshotBucket == "" {
+		Log.Info("Snapshots are disabled as no snapshot_bucket is configured")
+		return
+	}
+
+	for {
+		now := time.Now().UTC()
+		if now.Hour() >= Settings.SnapshotHourUtc && !snapshotTakenOn(now) {
+			bucket := NewS3Bucket(Settings.SnapshotEndpoint, Settings.SnapshotRegion, Settings.SnapshotBucket,
+				LiveSetting("snapshot_access_key", Settings.SnapshotAccessKey), LiveSetting("snapshot_secret_key", Settings.SnapshotSecretKey))
+			BackgroundWork.Add(1)
+			if err := TakeSnapshot(AppContext, bucket); err != nil {
+				Log.Error("Failed to take snapshot", "err", err)
+			}
+			PruneSnapshots(AppContext, bucket)
+			BackgroundWork.Done()
+		}
+
+		select {
+		case <-time.After(time.Until(nextSnapshotAt(time.Now().UTC()))):
+		case <-AppContext.Done():
+			return
+		}
+	}
+}
+
+func nextSnapshotAt(now time.Time) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), Settings.SnapshotHourUtc, 0, 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func snapshotTakenOn(day time.Time) bool {
+	query := "SELECT COUNT(*) FROM snapshots WHERE DATE(created_at) = ?"
+	rows, err := DB.Query(query, day.Format("2006-01-02"))
+	if err != nil {
+		Log.Error("Failed to check for snapshots", "err", err)
+		// Assume it was taken rather than uploading one every hour while SQL is down
+		return true
+	}
+	defer DB.CloseRows(rows)
+
+	var count int
+	for rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			Log.Error("Scan failed", "err", err)
+			return true
+		}
+	}
+	return count > 0
+}
+
+// Dumps every item as a JSON array, the same objects GET /items returns, gzips
+// it and uploads it to the bucket
+func TakeSnapshot(ctx context.Context, bucket S3Bucket) error {
+	start := time.Now()
+
+	var body bytes.Buffer
+	writer := gzip.NewWriter(&body)
+	encoder := json.NewEncoder(writer)
+
+	itemCount := 0
+	writer.Write([]byte("["))
+	for page := 1; ; page++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		items, _ := ListItems(page, EXPORT_BATCH_SIZE)
+		for _, item := range items {
+			if itemCount > 0 {
+				writer.Write([]byte(","))
+			}
+			if err := encoder.Encode(item); err != nil {
+				return err
+			}
+			itemCount++
+		}
+		if len(items) < EXPORT_BATCH_SIZE {
+			break
+		}
+	}
+	writer.Write([]byte("]"))
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	key := Settings.SnapshotPrefix + "items-" + start.UTC().Format("2006-01-02") + ".json.gz"
+	if err := bucket.PutObject(ctx, key, body.Bytes(), "application/gzip"); err != nil {
+		return err
+	}
+
+	query := "INSERT INTO snapshots (object_key, item_count, size_bytes) VALUES (?, ?, ?)"
+	if _, err := DB.InsertContext(ctx, query, key, itemCount, body.Len()); err != nil {
+		return err
+	}
+
+	Log.Info("Uploaded snapshot", "key", key, "items", itemCount, "bytes", body.Len(), "duration", time.Since(start))
+	return nil
+}
+
+// Deletes snapshots older than snapshot_retention_days, the row is kept if the
+// object couldn't be deleted so that we try again tomorrow
+func PruneSnapshots(ctx context.Context, bucket S3Bucket) {
+	for _, snapshot := range fetchSnapshots("WHERE created_at < DATE_SUB(NOW(), INTERVAL ? DAY) ", Settings.SnapshotRetentionDays) {
+		if err := bucket.DeleteObject(ctx, snapshot.key); err != nil {
+			Log.Error("Failed to delete snapshot", "key", snapshot.key, "err", err)
+			continue
+		}
+
+		rows, err := DB.QueryContext(ctx, "DELETE FROM snapshots WHERE id = ?", snapshot.id)
+		if err != nil {
+			Log.Error("Failed to delete snapshot row", "err", err)
+			continue
+		}
+		DB.CloseRows(rows)
+		Log.Info("Pruned snapshot", "key", snapshot.key)
+	}
+}
+
+// Returns every snapshot still in the bucket, newest first
+func FetchSnapshots() []Snapshot {
+	return fetchSnapshots("")
+}
+
+func fetchSnapshots(where string, parameters ...interface{}) []Snapshot {
+	query := "SELECT id, object_key, item_count, size_bytes, created_at " +
+		"FROM snapshots " +
+		where +
+		"ORDER BY created_at DESC"
+
+	rows, err := DB.Query(query, parameters...)
+	if err != nil {
+		Log.Error("Failed to fetch snapshots", "err", err)
+		return []Snapshot{}
+	}
+	defer DB.CloseRows(rows)
+
+	snapshots := []Snapshot{}
+	for rows.Next() {
+		var snapshot Snapshot
+		if err := rows.Scan(&snapshot.id, &snapshot.key, &snapshot.itemCount, &snapshot.size, &snapshot.createdAt); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+	return snapshots
+}
+
+// Snapshots are only linked to when snapshot_public_url is set, otherwise the
+// bucket is assumed to be private and the key is all we can give out
+func (s Snapshot) MarshalJSON() ([]byte, error) {
+	var url *string
+	if Settings.SnapshotPublicUrl != "" {
+		link := Settings.SnapshotPublicUrl + "/" + s3EscapePath(s.key)
+		url = &link
+	}
+
+	return json.Marshal(struct {
+		Key       string    `json:"key"`
+		Url       *string   `json:"url"`
+		ItemCount int       `json:"itemCount"`
+		Size      int64     `json:"size"`
+		CreatedAt time.Time `json:"createdAt"`
+	}{s.key, url, s.itemCount, s.size, s.createdAt})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: SpellLine
+ |--------------------------------------------------------------------------
+ |
+ | Represents a progression of related spells, for example the heal line
+ | Minor Healing -> Light Healing -> Healing -> Greater Healing. Each spell
+ | page links to its previous and next rank, we store those links in the
+ | spell_lines table and walk them to rebuild the full line on request
+ |
+ | @member name (string): Name of the first spell in the line
+ | @member spells ([]string): Every spell in the line, lowest rank first
+ |
+ */
+
+type SpellLine struct {
+	name string
+	spells []string
+}
+
+// Guards against cycles or broken links on the wiki sending us round forever
+const MAX_SPELL_LINE_LENGTH = 50
+
+var (
+	previousRankRegex = regexp.MustCompile(`(?is)previous(?: rank| spell)?[^<]{0,20}(?:<[^a>][^>]*>\s*)*<a href="[^"]*" title="([^"]+)"`)
+	nextRankRegex     = regexp.MustCompile(`(?is)next(?: rank| spell)?[^<]{0,20}(?:<[^a>][^>]*>\s*)*<a href="[^"]*" title="([^"]+)"`)
+)
+
+// Spells are stored as items prefixed with Spell: but the wiki links to the
+// page without the prefix, so we always key spell lines on the bare name
+func spellLineName(name string) string {
+	name = strings.TrimSpace(name)
+	for _, prefix := range []string{"spell:", "song:"} {
+		if strings.HasPrefix(strings.ToLower(name), prefix) {
+			name = strings.TrimSpace(name[len(prefix):])
+		}
+	}
+	return strings.Replace(name, "_", " ", -1)
+}
+
+// Pulls the previous and next rank links out of a spell page and stores them
+func SaveSpellRanks(spellName string, body string) {
+	var previous, next sql.NullString
+
+	if matches := previousRankRegex.FindStringSubmatch(body); len(matches) > 1 {
+		previous = sql.NullString{String: spellLineName(matches[1]), Valid: true}
+	}
+	if matches := nextRankRegex.FindStringSubmatch(body); len(matches) > 1 {
+		next = sql.NullString{String: spellLineName(matches[1]), Valid: true}
+	}
+	if !previous.Valid && !next.Valid {
+		Log.Debug("No rank links found", "spell", spellName)
+		return
+	}
+
+	query := "INSERT INTO spell_lines " +
+		"(name, previous_name, next_name) " +
+		"VALUES (?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE previous_name = VALUES(previous_name), next_name = VALUES(next_name)"
+
+	_, err := DB.Insert(query, spellLineName(spellName), previous, next)
+	if err != nil {
+		Log.Error("Failed to save spell ranks", "spell", spellName, "err", err)
+	}
+}
+
+// Fetches the previous and next rank for a single spell
+func fetchSpellRanks(name string) (sql.NullString, sql.NullString, bool) {
+	var previous, next sql.NullString
+
+	query := "SELECT previous_name, next_name " +
+		"FROM spell_lines " +
+		"WHERE name = ?"
+
+	rows, err := DB.Query(query, name)
+	if err != nil {
+		return previous, next, false
+	}
+	defer DB.CloseRows(rows)
+
+	found := false
+	for rows.Next() {
+		if err := rows.Scan(&previous, &next); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		found = true
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+	return previous, next, found
+}
+
+// Rebuilds the line that a spell belongs to by walking back to the first rank
+// and then forwards to the last. Returns false if we know nothing about the spell
+func (l *SpellLine) FetchBySpell(name string) bool {
+	name = spellLineName(name)
+
+	_, _, found := fetchSpellRanks(name)
+	if !found {
+		return false
+	}
+
+	// Walk back to the first spell in the line
+	first := name
+	seen := map[string]bool{ strings.ToLower(first): true }
+	for step := 0; step < MAX_SPELL_LINE_LENGTH; step++ {
+		previous, _, _ := fetchSpellRanks(first)
+		if !previous.Valid || seen[strings.ToLower(previous.String)] {
+			break
+		}
+		first = previous.String
+		seen[strings.ToLower(first)] = true
+	}
+
+	// Then forwards to the last, the final spell may not have a row of its own if
+	// we have never scraped it, so we always include the name we were linked to
+	l.name = first
+	l.spells = []string{first}
+	seen = map[string]bool{ strings.ToLower(first): true }
+	current := first
+	for step := 0; step < MAX_SPELL_LINE_LENGTH; step++ {
+		_, next, _ := fetchSpellRanks(current)
+		if !next.Valid || seen[strings.ToLower(next.String)] {
+			break
+		}
+		current = next.String
+		seen[strings.ToLower(current)] = true
+		l.spells = append(l.spells, current)
+	}
+
+	return true
+}
+
+func (l SpellLine) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name   string   `json:"name"`
+		Spells []string `json:"spells"`
+	}{l.name, l.spells})
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Spell
+ |--------------------------------------------------------------------------
+ |
+ | Represents a spell page on the wiki. Spell scrolls are still stored as
+ | items so they can be auctioned, this type holds the spell data itself
+ |
+ | @member name (string): Name of the spell without the Spell: prefix
+ | @member imageSrc (string): URL for the spell icon stored on the wiki
+ | @member mana (sql.NullInt64): Mana cost
+ | @member castTime (sql.NullFloat64): Casting time in seconds
+ | @member recastTime (sql.NullFloat64): Recast time in seconds
+ | @member duration (string): Duration as written on the wiki
+ | @member skill (string): Casting skill i.e. Evocation
+ | @member target (string): Target type i.e. Single, Self
+ | @member description (string): Description of the spell's effects
+ | @member classes ([]SpellClass): The classes which can cast the spell
+ |
+ */
+
+type Spell struct {
+	id int64
+	name string
+	imageSrc string
+	mana sql.NullInt64
+	castTime sql.NullFloat64
+	recastTime sql.NullFloat64
+	duration string
+	skill string
+	target string
+	description string
+	classes []SpellClass
+}
+
+type SpellClass struct {
+	class string
+	level int64
+}
+
+var classAbbreviations = map[string]string{
+	"bard": "BRD", "cleric": "CLR", "enchanter": "ENC", "shadowknight": "SHD",
+	"paladin": "PAL", "magician": "MAG", "necromancer": "NEC", "warrior": "WAR",
+	"rogue": "ROG", "ranger": "RNG", "druid": "DRU", "monk": "MNK",
+	"wizard": "WIZ", "shaman": "SHM",
+}
+
+var (
+	spellClassRegex = regexp.MustCompile("(?i)>(magician|necromancer|paladin|warrior|druid|enchanter|cleric|shadowknight|monk|shaman|wizard|bard|rogue|ranger)<")
+	spellLevelRegex = regexp.MustCompile("(?i)level[ \n]+([0-9]+)") // account for any poor formatting
+	secondsRegex    = regexp.MustCompile(`[0-9]+(\.[0-9]+)?`)
+	paragraphRegex  = regexp.MustCompile(`(?is)<p>(.*?)</p>`)
+)
+
+// Loads the spell from SQL, falling back to the wiki if we haven't stored it yet.
+// Returns false if the spell couldn't be found in either
+func (s *Spell) FetchData(ctx context.Context) bool {
+	s.name = spellLineName(s.name)
+
+	if s.fetchDataFromSQL() {
+		LoggerFrom(ctx).Debug("Spell exists in SQL", "spell", s.name)
+		return true
+	}
+
+	body, err := FetchWikiPage(ctx, TitleCase(s.name, true))
+	if err != nil {
+		return false
+	}
+	if !s.extractSpellData(body) {
+		return false
+	}
+
+	s.Save()
+	return s.id > 0
+}
+
+func (s *Spell) fetchDataFromSQL() bool {
+	query := "SELECT spells.id, spells.name, spells.imageSrc, spells.mana, spells.cast_time, spells.recast_time, " +
+		"spells.duration, spells.skill, spells.target, spells.description, spell_classes.class, spell_classes.level " +
+		"FROM spells " +
+		"LEFT JOIN spell_classes " +
+		"ON spell_classes.spell_id = spells.id " +
+		"WHERE spells.name = ? " +
+		"ORDER BY spell_classes.level ASC"
+
+	rows, err := DB.Query(query, s.name)
+	if err != nil {
+		return false
+	}
+	defer DB.CloseRows(rows)
+
+	var classes []SpellClass
+	for rows.Next() {
+		var (
+			imageSrc, duration, skill, target, description, class sql.NullString
+			level sql.NullInt64
+		)
+		err := rows.Scan(&s.id, &s.name, &imageSrc, &s.mana, &s.castTime, &s.recastTime,
+			&duration, &skill, &target, &description, &class, &level)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		s.imageSrc = imageSrc.String
+		s.duration = duration.String
+		s.skill = skill.String
+		s.target = target.String
+		s.description = description.String
+		if class.Valid && level.Valid {
+			classes = append(classes, SpellClass{ class: class.String, level: level.Int64 })
+		}
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+
+	s.classes = classes
+	return s.id > 0
+}
+
+// Parses the spell infobox, returns false if the page isn't a spell page
+func (s *Spell) extractSpellData(body string) bool {
+	classMatches := spellClassRegex.FindAllStringSubmatch(body, -1)
+	levelMatches := spellLevelRegex.FindAllStringSubmatch(body, -1)
+	if len(classMatches) == 0 || len(levelMatches) == 0 {
+		Log.Debug("Not a spell page", "spell", s.name)
+		return false
+	}
+
+	s.classes = nil
+	for idx, match := range classMatches {
+		if idx >= len(levelMatches) {
+			break
+		}
+		level, err := strconv.ParseInt(levelMatches[idx][1], 10, 64)
+		if err != nil {
+			continue
+		}
+		s.classes = append(s.classes, SpellClass{
+			class: classAbbreviations[strings.ToLower(match[1])],
+			level: level,
+		})
+	}
+
+	if srcMatches := regexp.MustCompile("(?i)(/images/.*?) ?\"").FindStringSubmatch(body); len(srcMatches) > 0 {
+		s.imageSrc = strings.TrimSpace(srcMatches[1])
+	}
+
+	s.extractSpellDetails(body)
+	return true
+}
+
+// Reads the mana, timings, skill, target and description out of the infobox,
+// this works on the pages of effects no class can cast too
+func (s *Spell) extractSpellDetails(body string) {
+	// The infobox is a two column table of labels and values
+	for _, table := range ExtractHtmlTables(body) {
+		for _, row := range table {
+			if len(row) < 2 {
+				continue
+			}
+			label := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(row[0]), ":"))
+			value := strings.TrimSpace(row[1])
+			switch {
+			case label == "mana":
+				s.mana = parseNullInt(numberRegex.FindString(value))
+			case label == "casting time":
+				s.castTime = parseSeconds(value)
+			case label == "recast time":
+				s.recastTime = parseSeconds(value)
+			case label == "duration":
+				s.duration = value
+			case label == "skill":
+				s.skill = value
+			case label == "target" || label == "target type":
+				s.target = value
+			case strings.Contains(label, "effect") && s.description == "":
+				s.description = value
+			}
+		}
+	}
+
+	// Fall back to the first paragraph of prose on the page
+	if s.description == "" {
+		for _, match := range paragraphRegex.FindAllStringSubmatch(body, -1) {
+			text := StripTags(match[1])
+			if len(text) > 20 {
+				s.description = text
+				break
+			}
+		}
+	}
+}
+
+func parseSeconds(value string) sql.NullFloat64 {
+	seconds, err := strconv.ParseFloat(secondsRegex.FindString(value), 64)
+	if err != nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: seconds, Valid: true}
+}
+
+// Upserts the spell and replaces its class rows
+func (s *Spell) Save() {
+	query := "INSERT INTO spells " +
+		"(name, imageSrc, mana, cast_time, recast_time, duration, skill, target, description) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), imageSrc = VALUES(imageSrc), mana = VALUES(mana), " +
+		"cast_time = VALUES(cast_time), recast_time = VALUES(recast_time), duration = VALUES(duration), " +
+		"skill = VALUES(skill), target = VALUES(target), description = VALUES(description)"
+
+	id, err := DB.Insert(query, s.name, s.imageSrc, s.mana, s.castTime, s.recastTime, s.duration, s.skill, s.target, s.description)
+	if err != nil || id <= 0 {
+		Log.Error("Failed to save spell", "spell", s.name, "err", err)
+		return
+	}
+	s.id = id
+
+	rows, err := DB.Query("DELETE FROM spell_classes WHERE spell_id = ?", s.id)
+	if err != nil {
+		Log.Error("Failed to clear classes for spell", "err", err)
+		return
+	}
+	DB.CloseRows(rows)
+
+	if len(s.classes) == 0 {
+		return
+	}
+
+	var parameters []interface{}
+	query = "INSERT INTO spell_classes " +
+		"(spell_id, class, level) " +
+		"VALUES "
+	for _, class := range s.classes {
+		query += "(?, ?, ?),"
+		parameters = append(parameters, s.id, class.class, class.level)
+	}
+	query = query[0:len(query)-1]
+
+	_, err = DB.Insert(query, parameters...)
+	if err != nil {
+		Log.Error("Failed to save classes for spell", "spell", s.name, "err", err)
+	} else {
+		Log.Info("Saved spell", "spell", s.name)
+	}
+}
+
+func (s Spell) MarshalJSON() ([]byte, error) {
+	classes := s.classes
+	if classes == nil {
+		classes = []SpellClass{}
+	}
+
+	return json.Marshal(struct {
+		Id          int64        `json:"id"`
+		Name        string       `json:"name"`
+		ImageSrc    string       `json:"imageSrc"`
+		Mana        *int64       `json:"mana"`
+		CastTime    *float64     `json:"castTime"`
+		RecastTime  *float64     `json:"recastTime"`
+		Duration    string       `json:"duration"`
+		Skill       string       `json:"skill"`
+		Target      string       `json:"target"`
+		Description string       `json:"description"`
+		Classes     []SpellClass `json:"classes"`
+	}{s.id, s.name, s.imageSrc, nullIntPointer(s.mana), nullFloatPointer(s.castTime), nullFloatPointer(s.recastTime),
+		s.duration, s.skill, s.target, s.description, classes})
+}
+
+func (c SpellClass) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Class string `json:"class"`
+		Level int64  `json:"level"`
+	}{c.class, c.level})
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: SpriteSheet
+ |--------------------------------------------------------------------------
+ |
+ | Represents the icons of a set of items drawn onto a single image, so a
+ | grid of items needs one image request rather than one per item. Icons
+ | are laid out left to right, top to bottom, in the order the items were
+ | requested, each in a cell the size of the largest icon. Items without
+ | an icon, or whose icon we couldn't fetch, are left out of the sheet
+ |
+ | @member image (*image.RGBA): The combined icons
+ | @member cellWidth (int): Width of each cell in pixels
+ | @member cellHeight (int): Height of each cell in pixels
+ | @member icons ([]SpriteIcon): Where each item's icon is on the sheet
+ | @member missing ([]string): Names of the items which have no icon
+ |
+ */
+
+type SpriteSheet struct {
+	image *image.RGBA
+	cellWidth int
+	cellHeight int
+	icons []SpriteIcon
+	missing []string
+}
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: SpriteIcon
+ |--------------------------------------------------------------------------
+ |
+ | Represents the position of an item's icon on a sprite sheet
+ |
+ | @member itemName (string): Name of the item
+ | @member className (string): CSS class selecting the icon i.e. item-icon-cloak-of-flames
+ | @member x (int): Offset of the icon from the left of the sheet in pixels
+ | @member y (int): Offset of the icon from the top of the sheet in pixels
+ |
+ */
+
+type SpriteIcon struct {
+	itemName string
+	className string
+	x int
+	y int
+}
+
+// Icons are fetched this many at a time, they still go through wikiLimiter
+const SPRITE_FETCH_WORKERS = 4
+
+// Decoded icons are kept as they rarely change, once this many are held the
+// cache is emptied rather than tracking which were used last
+const SPRITE_ICON_CACHE_SIZE = 5000
+
+var spriteIcons = struct {
+	sync.Mutex
+	images map[string]image.Image
+}{ images: make(map[string]image.Image) }
+
+var spriteClassRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Builds a sprite sheet of the items' icons. Items are stored items, their
+// icons are fetched from the wiki unless they're cached
+func BuildSpriteSheet(ctx context.Context, items []Item) SpriteSheet {
+	sheet := SpriteSheet{ icons: []SpriteIcon{}, missing: []string{} }
+
+	icons := make([]image.Image, len(items))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < SPRITE_FETCH_WORKERS; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				if items[idx].imageSrc == "" {
+					continue
+				}
+				icon, err := fetchSpriteIcon(ctx, items[idx].imageSrc)
+				if err == ErrReadOnly {
+					continue
+				} else if err != nil {
+					LoggerFrom(ctx).Warn("Failed to fetch item icon", "item", items[idx].name, "src", items[idx].imageSrc, "err", err)
+					continue
+				}
+				icons[idx] = icon
+			}
+		}()
+	}
+	for idx := range items {
+		indexes <- idx
+	}
+	close(indexes)
+	wg.Wait()
+
+	var found []int
+	for idx, icon := range icons {
+		if icon == nil {
+			sheet.missing = append(sheet.missing, items[idx].name)
+			continue
+		}
+		found = append(found, idx)
+		sheet.cellWidth = maxInt(sheet.cellWidth, icon.Bounds().Dx())
+		sheet.cellHeight = maxInt(sheet.cellHeight, icon.Bounds().Dy())
+	}
+
+	// As square as possible, so neither dimension gets too large for browsers
+	columns := int(math.Ceil(math.Sqrt(float64(len(found)))))
+	if columns == 0 {
+		// PNGs can't be empty, so a sheet without icons is a single clear pixel
+		sheet.image = image.NewRGBA(image.Rect(0, 0, 1, 1))
+		return sheet
+	}
+	rows := (len(found) + columns - 1) / columns
+	sheet.image = image.NewRGBA(image.Rect(0, 0, columns * sheet.cellWidth, rows * sheet.cellHeight))
+
+	for position, idx := range found {
+		x := (position % columns) * sheet.cellWidth
+		y := (position / columns) * sheet.cellHeight
+		bounds := icons[idx].Bounds()
+		draw.Draw(sheet.image, image.Rect(x, y, x + bounds.Dx(), y + bounds.Dy()), icons[idx], bounds.Min, draw.Src)
+		sheet.icons = append(sheet.icons, SpriteIcon{
+			itemName: items[idx].name,
+			className: spriteClassName(items[idx].name),
+			x: x,
+			y: y,
+		})
+	}
+
+	return sheet
+}
+
+// Returns the icon at the wiki path, i.e. /images/Item_1234.png
+func fetchSpriteIcon(ctx context.Context, src string) (image.Image, error) {
+	spriteIcons.Lock()
+	icon, exists := spriteIcons.images[src]
+	spriteIcons.Unlock()
+	if exists {
+		return icon, nil
+	}
+
+	body, _, err := FetchWikiImage(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	icon, _, err = image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	spriteIcons.Lock()
+	if len(spriteIcons.images) >= SPRITE_ICON_CACHE_SIZE {
+		spriteIcons.images = make(map[string]image.Image)
+	}
+	spriteIcons.images[src] = icon
+	spriteIcons.Unlock()
+	return icon, nil
+}
+
+func spriteClassName(itemName string) string {
+	return "item-icon-" + strings.Trim(spriteClassRegex.ReplaceAllString(strings.ToLower(itemName), "-"), "-")
+}
+
+func (s SpriteSheet) PNG() ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, s.image); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Returns a stylesheet giving every icon a class, imageUrl is where the sheet
+// itself is served from
+func (s SpriteSheet) CSS(imageUrl string) string {
+	var css strings.Builder
+	css.WriteString(".item-icon {\n")
+	css.WriteString("\tdisplay: inline-block;\n")
+	css.WriteString("\twidth: " + strconv.Itoa(s.cellWidth) + "px;\n")
+	css.WriteString("\theight: " + strconv.Itoa(s.cellHeight) + "px;\n")
+	css.WriteString("\tbackground-image: url(\"" + strings.Replace(imageUrl, "\"", "%22", -1) + "\");\n")
+	css.WriteString("\tbackground-repeat: no-repeat;\n")
+	css.WriteString("}\n")
+	for _, icon := range s.icons {
+		css.WriteString("." + icon.className + " { background-position: -" + strconv.Itoa(icon.x) + "px -" + strconv.Itoa(icon.y) + "px; }\n")
+	}
+	return css.String()
+}
+
+// Returns the offsets as JSON, imageUrl is where the sheet itself is served from
+func (s SpriteSheet) JSON(imageUrl string) ([]byte, error) {
+	return json.Marshal(struct {
+		Image      string       `json:"image"`
+		Width      int          `json:"width"`
+		Height     int          `json:"height"`
+		IconWidth  int          `json:"icon_width"`
+		IconHeight int          `json:"icon_height"`
+		Icons      []SpriteIcon `json:"icons"`
+		Missing    []string     `json:"missing"`
+	}{imageUrl, s.image.Bounds().Dx(), s.image.Bounds().Dy(), s.cellWidth, s.cellHeight, s.icons, s.missing})
+}
+
+func (i SpriteIcon) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Item  string `json:"item"`
+		Class string `json:"class"`
+		X     int    `json:"x"`
+		Y     int    `json:"y"`
+	}{i.itemName, i.className, i.x, i.y})
+}
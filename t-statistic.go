@@ -1,6 +1,12 @@
 package main
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
 
 /*
  |-------------------------------------------------------------------------
@@ -14,6 +20,8 @@ import "database/sql"
  | have negative stats, for example a fungi has -10 AGI or an AoN has -100HP
  | @member effect (string): some items may have non int based values, in which
  | case they have an effect property, this is nullable
+ | @member label (string): Long label to respond with, only set when the
+ | caller asked for labels=long
  |
  */
 
@@ -21,4 +29,213 @@ type Statistic struct {
 	code string
 	value sql.NullFloat64
 	effect string
+	label string
+}
+
+// The shape statistics take in responses, value is null for stats which are
+// only text such as SLOT or CLASS. Unit is empty for stats without one
+type StatisticResponse struct {
+	Code  string   `json:"code"`
+	Label string   `json:"label,omitempty"`
+	Value *float64 `json:"value"`
+	Unit  string   `json:"unit"`
+	Text  string   `json:"text"`
+}
+
+// Units of the stats which have one, everything else is a plain number
+var statUnits = map[string]string{
+	"HASTE": "%",
+	"MODIFIER": "%",
+	"WEIGHT REDUCTION": "%",
+	"WT": "lbs",
+}
+
+const (
+	STAT_LABELS_SHORT = "short"
+	STAT_LABELS_LONG  = "long"
+)
+
+// Long labels for each stat code, codes missing from here keep their code as
+// their label. Keep this up to date as new codes are scraped so clients don't
+// have to maintain their own copy
+var statLabels = map[string]string{
+	"AC": "Armor Class",
+	"HP": "Hit Points",
+	"MANA": "Mana",
+	"STR": "Strength",
+	"STA": "Stamina",
+	"AGI": "Agility",
+	"DEX": "Dexterity",
+	"WIS": "Wisdom",
+	"INT": "Intelligence",
+	"CHA": "Charisma",
+	"SV FIRE": "Save vs Fire",
+	"SV COLD": "Save vs Cold",
+	"SV DISEASE": "Save vs Disease",
+	"SV MAGIC": "Save vs Magic",
+	"SV POISON": "Save vs Poison",
+	"DMG": "Damage",
+	"ATK DELAY": "Attack Delay",
+	"RANGE": "Range",
+	"HASTE": "Haste",
+	"WT": "Weight",
+	"WEIGHT REDUCTION": "Weight Reduction",
+	"SIZE": "Size",
+	"CAPACITY": "Capacity",
+	"SIZE CAPACITY": "Size Capacity",
+	"CHARGES": "Charges",
+	"SLOT": "Slot",
+	"CLASS": "Class",
+	"RACE": "Race",
+	"SKILL": "Skill",
+	"AFFINITY": "Flags",
+	"EFFECT": "Effect",
+	"MODIFIER": "Skill Modifier",
+}
+
+// The long label for the code, or the code itself if it doesn't have one
+func StatLabel(code string) string {
+	if label, exists := statLabels[code]; exists {
+		return label
+	}
+	return code
+}
+
+// Sets the long label on every stat of every item, for responses to callers
+// who asked for labels=long
+func applyStatLabels(items []Item, labels string) {
+	if labels != STAT_LABELS_LONG {
+		return
+	}
+	for idx := range items {
+		for statIdx := range items[idx].statistics {
+			stat := &items[idx].statistics[statIdx]
+			stat.label = StatLabel(stat.code)
+		}
+	}
+}
+
+func (s Statistic) Unit() string {
+	return statUnits[s.code]
+}
+
+// Human readable form of the stat as it appears on the wiki, i.e. "HASTE: 36%"
+// or "SLOT: EAR", or "Haste: 36%" once a long label is set. Affinities such as
+// MAGIC ITEM are already readable
+func (s Statistic) Text() string {
+	name := s.code
+	if s.label != "" {
+		name = s.label
+	}
+
+	if !s.value.Valid {
+		if s.code == "AFFINITY" || s.code == "EFFECT" {
+			return s.effect
+		}
+		return name + ": " + s.effect
+	}
+
+	// Modifiers read as the skill they boost, i.e. "BRASS INSTRUMENTS: 21%"
+	if s.code == "MODIFIER" && s.effect != "" {
+		name = s.effect
+	}
+
+	text := name + ": " + strconv.FormatFloat(s.value.Float64, 'f', -1, 64)
+	if unit := s.Unit(); unit == "%" {
+		text += unit
+	} else if unit != "" {
+		text += " " + unit
+	}
+	return text
+}
+
+func (s Statistic) Response() StatisticResponse {
+	return StatisticResponse{ s.code, s.label, nullFloatPointer(s.value), s.Unit(), s.Text() }
+}
+
+// Rebuilds the stat a response was made from, used to import exported items.
+// The effect isn't in the response so is read back out of the text
+func statisticFromResponse(r StatisticResponse) Statistic {
+	s := Statistic{ code: r.Code, label: r.Label, value: nullFloatFromPointer(r.Value) }
+
+	name := s.code
+	if s.label != "" {
+		name = s.label
+	}
+	switch {
+	case !s.value.Valid && (s.code == "AFFINITY" || s.code == "EFFECT"):
+		s.effect = r.Text
+	case !s.value.Valid:
+		s.effect = strings.TrimPrefix(r.Text, name + ": ")
+	case s.code == "MODIFIER":
+		s.effect, _, _ = strings.Cut(r.Text, ": ")
+	}
+	s.label = ""
+	return s
+}
+
+func (s Statistic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Response())
+}
+
+// Removes duplicate statistics left behind by re-scrapes from before saveStats
+// upserted, keeping one row for each item, code and effect. This has to run
+// before the unique key on statistics can be added. Returns the number of
+// duplicate groups found and the number of rows removed
+func DedupeStatistics(ctx context.Context) (int, int64, error) {
+	query := "SELECT item_id, code, effect, MAX(value), COUNT(*) " +
+		"FROM statistics " +
+		"GROUP BY item_id, code, effect " +
+		"HAVING COUNT(*) > 1"
+
+	rows, err := DB.QueryContext(ctx, query)
+	if err != nil {
+		return 0, 0, ErrDatabaseRead
+	}
+
+	type duplicate struct {
+		itemId int64
+		code string
+		effect string
+		value sql.NullFloat64
+		count int64
+	}
+	var duplicates []duplicate
+	for rows.Next() {
+		var d duplicate
+		if err := rows.Scan(&d.itemId, &d.code, &d.effect, &d.value, &d.count); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		duplicates = append(duplicates, d)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+	DB.CloseRows(rows)
+
+	// Rows have no id to tell them apart, so each group is replaced by one row
+	var removed int64
+	for _, d := range duplicates {
+		err := DB.Transaction(ctx, func(tx *Tx) error {
+			rows, err := tx.Query("DELETE FROM statistics WHERE item_id = ? AND code = ? AND effect = ?", d.itemId, d.code, d.effect)
+			if err != nil {
+				return ErrDatabaseWrite
+			}
+			DB.CloseRows(rows)
+
+			query := "INSERT INTO statistics (item_id, code, value, effect) VALUES (?, ?, ?, ?)"
+			if _, err := tx.Insert(query, d.itemId, d.code, d.value, d.effect); err != nil {
+				return ErrDatabaseWrite
+			}
+			return nil
+		})
+		if err != nil {
+			return len(duplicates), removed, err
+		}
+		removed += d.count - 1
+	}
+
+	Log.Info("Deduplicated statistics", "groups", len(duplicates), "removed", removed)
+	return len(duplicates), removed, nil
 }
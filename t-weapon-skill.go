@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Weapon skills
+ |--------------------------------------------------------------------------
+ |
+ | Weapons list the skill used to wield them, i.e. "Skill: 1H Slashing".
+ | The wiki isn't consistent with how these are written so we normalise
+ | them to one of the WEAPON_SKILL constants before saving the SKILL stat.
+ | Weapons also have their damage to delay ratio and their delay with their
+ | own haste applied worked out when they're saved, so clients can rank
+ | them without repeating the maths
+ |
+ */
+
+const (
+	WEAPON_SKILL_1H_SLASHING  = "1H_SLASHING"
+	WEAPON_SKILL_2H_SLASHING  = "2H_SLASHING"
+	WEAPON_SKILL_1H_BLUNT     = "1H_BLUNT"
+	WEAPON_SKILL_2H_BLUNT     = "2H_BLUNT"
+	WEAPON_SKILL_PIERCING     = "PIERCING"
+	WEAPON_SKILL_HAND_TO_HAND = "HAND_TO_HAND"
+	WEAPON_SKILL_ARCHERY      = "ARCHERY"
+	WEAPON_SKILL_THROWING     = "THROWING"
+)
+
+// Every spelling we've seen for each skill, keys are lowercase with spaces
+var weaponSkillAliases = map[string]string{
+	"1h slashing": WEAPON_SKILL_1H_SLASHING, "1hs": WEAPON_SKILL_1H_SLASHING, "one hand slashing": WEAPON_SKILL_1H_SLASHING, "slashing": WEAPON_SKILL_1H_SLASHING,
+	"2h slashing": WEAPON_SKILL_2H_SLASHING, "2hs": WEAPON_SKILL_2H_SLASHING, "two hand slashing": WEAPON_SKILL_2H_SLASHING,
+	"1h blunt": WEAPON_SKILL_1H_BLUNT, "1hb": WEAPON_SKILL_1H_BLUNT, "one hand blunt": WEAPON_SKILL_1H_BLUNT, "blunt": WEAPON_SKILL_1H_BLUNT,
+	"2h blunt": WEAPON_SKILL_2H_BLUNT, "2hb": WEAPON_SKILL_2H_BLUNT, "two hand blunt": WEAPON_SKILL_2H_BLUNT,
+	"piercing": WEAPON_SKILL_PIERCING, "1h piercing": WEAPON_SKILL_PIERCING, "1hp": WEAPON_SKILL_PIERCING, "pierce": WEAPON_SKILL_PIERCING,
+	"hand to hand": WEAPON_SKILL_HAND_TO_HAND, "h2h": WEAPON_SKILL_HAND_TO_HAND,
+	"archery": WEAPON_SKILL_ARCHERY, "bow": WEAPON_SKILL_ARCHERY,
+	"throwing": WEAPON_SKILL_THROWING, "throwing weapons": WEAPON_SKILL_THROWING,
+}
+
+// Returns the canonical weapon skill, returns false if the skill isn't one we know
+func NormaliseWeaponSkill(skill string) (string, bool) {
+	key := strings.ToLower(strings.TrimSpace(skill))
+	key = strings.Replace(key, "_", " ", -1)
+	key = strings.Replace(key, "-", " ", -1)
+	key = strings.Join(strings.Fields(key), " ")
+
+	if canonical, exists := weaponSkillAliases[key]; exists {
+		return canonical, true
+	}
+	return "", false
+}
+
+// Returns the weapon's damage divided by its delay, and its delay once any haste
+// it has is applied. Both are null for items without DMG and ATK DELAY stats
+func (i *Item) weaponRatios() (sql.NullFloat64, sql.NullFloat64) {
+	var damage, delay, haste sql.NullFloat64
+	for _, stat := range i.statistics {
+		switch stat.code {
+		case "DMG":
+			damage = stat.value
+		case "ATK DELAY":
+			delay = stat.value
+		case "HASTE":
+			haste = stat.value
+		}
+	}
+	if !damage.Valid || !delay.Valid || delay.Float64 <= 0 {
+		return sql.NullFloat64{}, sql.NullFloat64{}
+	}
+
+	ratio := sql.NullFloat64{ Float64: damage.Float64 / delay.Float64, Valid: true }
+	adjusted := sql.NullFloat64{ Float64: delay.Float64 / (1 + haste.Float64 / 100), Valid: true }
+	return ratio, adjusted
+}
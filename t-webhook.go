@@ -0,0 +1,540 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Webhook
+ |--------------------------------------------------------------------------
+ |
+ | Represents a url an admin registered to be called when something happens,
+ | so alerting bots don't have to poll. item_discovered fires whenever an
+ | item is scraped for the first time, for every tenant as the catalog is
+ | shared. price_below fires when the tenant's auction lines advertise the
+ | item at or below maxPrice, fuzzy matches are ignored so a misread line
+ | doesn't set it off. Deliveries are queued in webhook_deliveries and
+ | retried with backoff, see DeliverWebhooks
+ |
+ | @member id (int64): Primary key of the webhooks row
+ | @member server (string): The tenant the webhook belongs to
+ | @member url (string): Where deliveries are POSTed
+ | @member event (string): One of the WEBHOOK_EVENT constants
+ | @member itemName (string): The item price_below watches
+ | @member maxPrice (sql.NullFloat64): The price price_below fires at or under
+ | @member format (string): One of the WEBHOOK_FORMAT constants
+ | @member secret (string): Key the payloads are signed with
+ | @member createdAt (time.Time): When the webhook was registered
+ |
+ */
+
+type Webhook struct {
+	id int64
+	server string
+	url string
+	event string
+	itemName string
+	maxPrice sql.NullFloat64
+	format string
+	secret string
+	createdAt time.Time
+}
+
+const (
+	WEBHOOK_EVENT_ITEM_DISCOVERED = "item_discovered"
+	WEBHOOK_EVENT_PRICE_BELOW     = "price_below"
+)
+
+// json payloads are signed and meant for our consumers, discord and slack
+// payloads can be sent straight to those services' incoming webhooks
+const (
+	WEBHOOK_FORMAT_JSON    = "json"
+	WEBHOOK_FORMAT_DISCORD = "discord"
+	WEBHOOK_FORMAT_SLACK   = "slack"
+)
+
+const WEBHOOK_SECRET_BYTES = 32
+
+// Every webhook, reloaded after cache_time_in_secs so webhooks registered on
+// another replica are picked up. Price points are recorded constantly and
+// shouldn't each cost a query
+var webhookCache = struct {
+	sync.Mutex
+	webhooks []Webhook
+	expiresAt time.Time
+}{}
+
+var webhookClient = &http.Client{}
+
+// Wakes the delivery loop so new deliveries go out straight away
+var webhookQueued = make(chan struct{}, 1)
+
+func IsWebhookEvent(event string) bool {
+	return event == WEBHOOK_EVENT_ITEM_DISCOVERED || event == WEBHOOK_EVENT_PRICE_BELOW
+}
+
+func IsWebhookFormat(format string) bool {
+	return format == WEBHOOK_FORMAT_JSON || format == WEBHOOK_FORMAT_DISCORD || format == WEBHOOK_FORMAT_SLACK
+}
+
+// Returns a problem with the webhook, or an empty string if it can be saved
+func (h Webhook) Validate() string {
+	parsed, err := url.Parse(h.url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "url must be an http(s) url"
+	}
+	if !IsWebhookEvent(h.event) {
+		return "event must be " + WEBHOOK_EVENT_ITEM_DISCOVERED + " or " + WEBHOOK_EVENT_PRICE_BELOW
+	}
+	if !IsWebhookFormat(h.format) {
+		return "format must be json, discord or slack"
+	}
+	if h.event == WEBHOOK_EVENT_PRICE_BELOW && (h.itemName == "" || !h.maxPrice.Valid || h.maxPrice.Float64 <= 0) {
+		return "price_below webhooks need an item and a max_price greater than 0"
+	}
+	return ""
+}
+
+// Stores the webhook with a new secret, which is returned as this is the only
+// time it's available
+func CreateWebhook(ctx context.Context, h Webhook) (Webhook, error) {
+	secret := make([]byte, WEBHOOK_SECRET_BYTES)
+	if _, err := rand.Read(secret); err != nil {
+		LoggerFrom(ctx).Error("Failed to generate webhook secret", "err", err)
+		return h, ErrDatabaseWrite
+	}
+	h.secret = hex.EncodeToString(secret)
+	h.createdAt = time.Now().UTC()
+	if h.event != WEBHOOK_EVENT_PRICE_BELOW {
+		h.itemName = ""
+		h.maxPrice = sql.NullFloat64{}
+	}
+
+	query := "INSERT INTO webhooks (server, url, event, item_name, max_price, format, secret, created_at) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	id, err := DB.InsertContext(ctx, query, h.server, h.url, h.event, h.itemName, h.maxPrice, h.format, h.secret, h.createdAt)
+	if err != nil {
+		return h, ErrDatabaseWrite
+	}
+	h.id = id
+	expireWebhookCache()
+	LoggerFrom(ctx).Info("Created webhook", "server", h.server, "id", id, "event", h.event)
+	return h, nil
+}
+
+// Deletes the webhook along with its deliveries, returns false if there's no such webhook
+func DeleteWebhook(ctx context.Context, id int64) (bool, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT id FROM webhooks WHERE id = ?", id)
+	if err != nil {
+		return false, ErrDatabaseRead
+	}
+	exists := rows.Next()
+	DB.CloseRows(rows)
+	if !exists {
+		return false, nil
+	}
+
+	err = DB.Transaction(ctx, func(tx *Tx) error {
+		if _, err := tx.Insert("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+			return err
+		}
+		_, err := tx.Insert("DELETE FROM webhooks WHERE id = ?", id)
+		return err
+	})
+	if err != nil {
+		return false, ErrDatabaseWrite
+	}
+	expireWebhookCache()
+	LoggerFrom(ctx).Info("Deleted webhook", "id", id)
+	return true, nil
+}
+
+// Returns the tenant's webhooks, or every tenant's if server is nil
+func FetchWebhooks(ctx context.Context, server *string) ([]Webhook, error) {
+	webhooks := []Webhook{}
+
+	query := "SELECT id, server, url, event, item_name, max_price, format, secret, created_at " +
+		"FROM webhooks "
+	var parameters []interface{}
+	if server != nil {
+		query += "WHERE server = ? "
+		parameters = append(parameters, *server)
+	}
+	query += "ORDER BY id ASC"
+
+	rows, err := DB.QueryContext(ctx, query, parameters...)
+	if err != nil {
+		return webhooks, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var (
+			h Webhook
+			itemName sql.NullString
+		)
+		err := rows.Scan(&h.id, &h.server, &h.url, &h.event, &itemName, &h.maxPrice, &h.format, &h.secret, &h.createdAt)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		h.itemName = itemName.String
+		webhooks = append(webhooks, h)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+		return webhooks, ErrDatabaseRead
+	}
+	return webhooks, nil
+}
+
+func cachedWebhooks(ctx context.Context) []Webhook {
+	webhookCache.Lock()
+	defer webhookCache.Unlock()
+
+	if time.Now().Before(webhookCache.expiresAt) {
+		return webhookCache.webhooks
+	}
+	webhooks, err := FetchWebhooks(ctx, nil)
+	if err != nil {
+		// Keep using what we had rather than dropping every event
+		return webhookCache.webhooks
+	}
+	webhookCache.webhooks = webhooks
+	webhookCache.expiresAt = time.Now().Add(seconds(Settings.CacheTimeInSecs))
+	return webhooks
+}
+
+func expireWebhookCache() {
+	webhookCache.Lock()
+	webhookCache.expiresAt = time.Time{}
+	webhookCache.Unlock()
+}
+
+// Queues item_discovered deliveries for an item which has just been scraped
+// for the first time
+func NotifyItemDiscovered(ctx context.Context, item Item) {
+	for _, webhook := range cachedWebhooks(ctx) {
+		if webhook.event != WEBHOOK_EVENT_ITEM_DISCOVERED {
+			continue
+		}
+		text := "New item discovered: " + strings.Replace(item.displayName, "_", " ", -1)
+		webhook.queue(ctx, item, text, item, PriceSummary{})
+	}
+}
+
+// Queues price_below deliveries for every webhook the price point is at or
+// under the max price of
+func NotifyPriceBelow(ctx context.Context, item Item, point PricePoint) {
+	if point.confidence < OBSERVED_PRICE_MIN_CONFIDENCE {
+		return
+	}
+
+	var summary *PriceSummary
+	for _, webhook := range cachedWebhooks(ctx) {
+		if webhook.event != WEBHOOK_EVENT_PRICE_BELOW || webhook.server != point.server ||
+			!strings.EqualFold(webhook.itemName, item.name) || point.price > webhook.maxPrice.Float64 {
+			continue
+		}
+		if summary == nil {
+			fetched := FetchPriceSummary(point.server, item.id, OBSERVED_PRICE_MIN_CONFIDENCE)
+			summary = &fetched
+		}
+
+		name := strings.Replace(item.displayName, "_", " ", -1)
+		text := name + " was auctioned for " + formatPlatinum(point.price) + ", at or below " +
+			formatPlatinum(webhook.maxPrice.Float64) + ": " + point.line
+		data := map[string]interface{}{
+			"item": item,
+			"price": point.price,
+			"maxPrice": webhook.maxPrice.Float64,
+			"line": point.line,
+		}
+		webhook.queue(ctx, data, text, item, *summary)
+	}
+}
+
+// Renders the payload in the webhook's format and queues it for delivery
+func (h Webhook) queue(ctx context.Context, data interface{}, text string, item Item, summary PriceSummary) {
+	var payload interface{}
+	switch h.format {
+	case WEBHOOK_FORMAT_DISCORD:
+		payload = map[string]interface{}{
+			"content": text,
+			"embeds": []DiscordEmbed{ NewDiscordEmbed(item, summary) },
+		}
+	case WEBHOOK_FORMAT_SLACK:
+		payload = map[string]string{ "text": text }
+	default:
+		payload = map[string]interface{}{
+			"event": h.event,
+			"server": h.server,
+			"data": data,
+			"at": time.Now().UTC(),
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		LoggerFrom(ctx).Error("Failed to encode webhook payload", "webhook", h.id, "err", err)
+		return
+	}
+
+	query := "INSERT INTO webhook_deliveries (webhook_id, event, payload, attempts, next_attempt_at, created_at) " +
+		"VALUES (?, ?, ?, 0, NOW(), NOW())"
+	if _, err := DB.InsertContext(ctx, query, h.id, h.event, body); err != nil {
+		LoggerFrom(ctx).Error("Failed to queue webhook delivery", "webhook", h.id, "err", err)
+		return
+	}
+
+	select {
+	case webhookQueued <- struct{}{}:
+	default:
+	}
+}
+
+// Signs the timestamp and body the same way consumers check them, i.e.
+// X-Webhook-Signature: t=1700000000,v1=<hex hmac-sha256 of "1700000000.<body>">
+func (h Webhook) sign(timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(h.secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "t=" + strconv.FormatInt(timestamp, 10) + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h Webhook) MarshalJSON() ([]byte, error) {
+	var itemName *string
+	if h.itemName != "" {
+		itemName = &h.itemName
+	}
+
+	return json.Marshal(struct {
+		Id        int64     `json:"id"`
+		Server    string    `json:"server"`
+		Url       string    `json:"url"`
+		Event     string    `json:"event"`
+		Item      *string   `json:"item"`
+		MaxPrice  *float64  `json:"maxPrice"`
+		Format    string    `json:"format"`
+		CreatedAt time.Time `json:"createdAt"`
+	}{h.id, h.server, h.url, h.event, itemName, nullFloatPointer(h.maxPrice), h.format, h.createdAt})
+}
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: WebhookDelivery
+ |--------------------------------------------------------------------------
+ |
+ | One payload queued for a webhook. Failed deliveries are retried after
+ | webhook_retry_interval_in_secs, doubling each time, until they have
+ | been attempted webhook_max_attempts times. A replica claims a delivery
+ | before sending it so each payload is sent by one replica only.
+ | Undelivered payloads are kept so an admin can see why a webhook isn't
+ | firing
+ |
+ | @member id (int64): Primary key of the webhook_deliveries row
+ | @member webhookId (int64): The webhook the payload is for
+ | @member event (string): The event which queued the payload
+ | @member payload ([]byte): The body to POST
+ | @member attempts (int): Number of times delivery has been attempted
+ | @member lastError (string): Why the last attempt failed
+ | @member nextAttemptAt (time.Time): When delivery will next be attempted
+ | @member deliveredAt (*time.Time): When the payload was delivered, nil until it is
+ | @member createdAt (time.Time): When the payload was queued
+ |
+ */
+
+type WebhookDelivery struct {
+	id int64
+	webhookId int64
+	event string
+	payload []byte
+	attempts int
+	lastError string
+	nextAttemptAt time.Time
+	deliveredAt *time.Time
+	createdAt time.Time
+}
+
+// Delivers due payloads every webhook_retry_interval_in_secs, or as soon as one
+// is queued, until we shut down
+func DeliverWebhooks() {
+	for {
+		select {
+		case <-time.After(seconds(Settings.WebhookRetryIntervalInSecs)):
+		case <-webhookQueued:
+		case <-AppContext.Done():
+			return
+		}
+
+		deliveries, err := fetchWebhookDeliveries(AppContext, "WHERE delivered_at IS NULL AND attempts < ? AND next_attempt_at <= NOW() ",
+			Settings.WebhookMaxAttempts)
+		if err != nil {
+			continue
+		}
+		if len(deliveries) == 0 {
+			continue
+		}
+
+		webhooks := make(map[int64]Webhook)
+		for _, webhook := range cachedWebhooks(AppContext) {
+			webhooks[webhook.id] = webhook
+		}
+		for _, delivery := range deliveries {
+			if AppContext.Err() != nil {
+				return
+			}
+			webhook, exists := webhooks[delivery.webhookId]
+			if !exists {
+				// Deleted since the payload was queued, or on another replica and
+				// not in our cache yet, in which case the next run picks it up
+				continue
+			}
+			if !delivery.claim() {
+				continue
+			}
+			delivery.deliver(webhook)
+		}
+	}
+}
+
+// Every replica finds the same due deliveries, so each one pushes the delivery's
+// next attempt back past the time a delivery can take before sending it. Only
+// the replica whose update changed the row sends it, if it dies part way the
+// delivery is picked up again once the claim runs out. Returns false if another
+// replica got there first
+func (d *WebhookDelivery) claim() bool {
+	claimed := false
+	err := DB.Transaction(AppContext, func(tx *Tx) error {
+		query := "UPDATE webhook_deliveries " +
+			"SET next_attempt_at = DATE_ADD(NOW(), INTERVAL ? SECOND) " +
+			"WHERE id = ? AND delivered_at IS NULL AND next_attempt_at <= NOW()"
+		changed, err := tx.Update(query, Settings.WebhookTimeoutInSecs * 2, d.id)
+		claimed = changed == 1
+		return err
+	})
+	if err != nil {
+		Log.Error("Failed to claim webhook delivery", "delivery", d.id, "err", err)
+		return false
+	}
+	return claimed
+}
+
+func (d *WebhookDelivery) deliver(webhook Webhook) {
+	ctx, cancel := context.WithTimeout(AppContext, seconds(Settings.WebhookTimeoutInSecs))
+	defer cancel()
+
+	err := func() error {
+		request, err := http.NewRequestWithContext(ctx, "POST", webhook.url, bytes.NewReader(d.payload))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("User-Agent", "service-wiki")
+		request.Header.Set("X-Webhook-Event", d.event)
+		request.Header.Set("X-Webhook-Delivery", strconv.FormatInt(d.id, 10))
+		request.Header.Set("X-Webhook-Signature", webhook.sign(time.Now().Unix(), d.payload))
+
+		response, err := webhookClient.Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			return errors.New("responded with " + strconv.Itoa(response.StatusCode) + ": " + string(message))
+		}
+		return nil
+	}()
+
+	d.attempts++
+	if err == nil {
+		query := "UPDATE webhook_deliveries SET attempts = ?, last_error = NULL, delivered_at = NOW() WHERE id = ?"
+		if _, err := DB.Insert(query, d.attempts, d.id); err != nil {
+			Log.Error("Failed to mark webhook delivered", "delivery", d.id, "err", err)
+		}
+		Log.Debug("Delivered webhook", "webhook", webhook.id, "delivery", d.id)
+		return
+	}
+
+	Log.Warn("Failed to deliver webhook", "webhook", webhook.id, "delivery", d.id, "attempts", d.attempts, "err", err)
+	query := "UPDATE webhook_deliveries SET attempts = ?, last_error = ?, " +
+		"next_attempt_at = DATE_ADD(NOW(), INTERVAL ? * POW(2, ? - 1) SECOND) " +
+		"WHERE id = ?"
+	if _, err := DB.Insert(query, d.attempts, err.Error(), Settings.WebhookRetryIntervalInSecs, d.attempts, d.id); err != nil {
+		Log.Error("Failed to reschedule webhook delivery", "delivery", d.id, "err", err)
+	}
+}
+
+// Returns the webhook's latest deliveries, newest first
+func FetchWebhookDeliveries(ctx context.Context, webhookId int64, limit int) ([]WebhookDelivery, error) {
+	return fetchWebhookDeliveries(ctx, "WHERE webhook_id = ? ORDER BY id DESC LIMIT ?", webhookId, limit)
+}
+
+func fetchWebhookDeliveries(ctx context.Context, where string, parameters ...interface{}) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+
+	query := "SELECT id, webhook_id, event, payload, attempts, last_error, next_attempt_at, delivered_at, created_at " +
+		"FROM webhook_deliveries " +
+		where
+
+	rows, err := DB.QueryContext(ctx, query, parameters...)
+	if err != nil {
+		return deliveries, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var (
+			d WebhookDelivery
+			lastError sql.NullString
+		)
+		err := rows.Scan(&d.id, &d.webhookId, &d.event, &d.payload, &d.attempts, &lastError, &d.nextAttemptAt, &d.deliveredAt, &d.createdAt)
+		if err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		d.lastError = lastError.String
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+		return deliveries, ErrDatabaseRead
+	}
+	return deliveries, nil
+}
+
+func (d WebhookDelivery) MarshalJSON() ([]byte, error) {
+	var lastError *string
+	if d.lastError != "" {
+		lastError = &d.lastError
+	}
+
+	return json.Marshal(struct {
+		Id            int64           `json:"id"`
+		Event         string          `json:"event"`
+		Payload       json.RawMessage `json:"payload"`
+		Attempts      int             `json:"attempts"`
+		LastError     *string         `json:"lastError"`
+		NextAttemptAt time.Time       `json:"nextAttemptAt"`
+		DeliveredAt   *time.Time      `json:"deliveredAt"`
+		CreatedAt     time.Time       `json:"createdAt"`
+	}{d.id, d.event, json.RawMessage(d.payload), d.attempts, lastError, d.nextAttemptAt, d.deliveredAt, d.createdAt})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ItemWikitext
+ |--------------------------------------------------------------------------
+ |
+ | Represents the raw infobox wikitext an item was scraped from. Items are
+ | parsed from the rendered page, but the wikitext is kept as well so new
+ | fields can be extracted later without scraping everything again, and so
+ | the parsed data can be compared against its source when debugging
+ |
+ | @member itemId (int64): The item the wikitext belongs to
+ | @member wikitext (string): The {{Itembox}} template, or the whole page
+ | if it doesn't use one
+ | @member fetchedAt (time.Time): When the wikitext was last fetched
+ |
+ */
+
+type ItemWikitext struct {
+	itemId int64
+	wikitext string
+	fetchedAt time.Time
+}
+
+// Fetches the unrendered source of a page, MediaWiki serves it as plain text
+// when the page is requested with action=raw
+func FetchWikitext(ctx context.Context, uri string) (string, error) {
+	return FetchWikiPage(ctx, "index.php?title=" + url.QueryEscape(uri) + "&action=raw")
+}
+
+// Returns the {{Itembox ...}} template from the page source, nested templates
+// are balanced so we don't stop at the first closing braces. If the page has
+// no infobox the whole source is returned
+func ExtractInfobox(wikitext string) string {
+	start := strings.Index(strings.ToLower(wikitext), "{{itembox")
+	if start < 0 {
+		return strings.TrimSpace(wikitext)
+	}
+
+	depth := 0
+	for idx := start; idx < len(wikitext)-1; idx++ {
+		if wikitext[idx] == '{' && wikitext[idx+1] == '{' {
+			depth++
+			idx++
+		} else if wikitext[idx] == '}' && wikitext[idx+1] == '}' {
+			depth--
+			idx++
+			if depth == 0 {
+				return wikitext[start:idx+1]
+			}
+		}
+	}
+
+	// Unbalanced template, keep everything from the start of it
+	return wikitext[start:]
+}
+
+// Fetches and stores the wikitext for an item, this is best effort so a
+// failure is logged but never fails the scrape
+func (i *Item) saveWikitext(ctx context.Context, uri string) {
+	if i.id <= 0 {
+		return
+	}
+
+	wikitext, err := FetchWikitext(ctx, uri)
+	if err != nil {
+		LoggerFrom(ctx).Warn("Failed to fetch wikitext", "err", err)
+		return
+	}
+
+	query := "INSERT INTO item_wikitext " +
+		"(item_id, wikitext, fetched_at) " +
+		"VALUES (?, ?, NOW()) " +
+		"ON DUPLICATE KEY UPDATE wikitext = VALUES(wikitext), fetched_at = VALUES(fetched_at)"
+
+	if _, err := DB.InsertContext(ctx, query, i.id, ExtractInfobox(wikitext)); err != nil {
+		LoggerFrom(ctx).Error("Failed to save wikitext", "err", err)
+	}
+}
+
+// Loads the stored wikitext for an item, returns false if we don't have any
+func FetchItemWikitext(itemId int64) (ItemWikitext, bool) {
+	w := ItemWikitext{ itemId: itemId }
+
+	query := "SELECT wikitext, fetched_at " +
+		"FROM item_wikitext " +
+		"WHERE item_id = ?"
+
+	rows, err := DB.Query(query, itemId)
+	if err != nil {
+		return w, false
+	}
+	defer DB.CloseRows(rows)
+
+	found := false
+	for rows.Next() {
+		if err := rows.Scan(&w.wikitext, &w.fetchedAt); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		found = true
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+	}
+	return w, found
+}
+
+func (w ItemWikitext) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ItemId    int64     `json:"itemId"`
+		Wikitext  string    `json:"wikitext"`
+		FetchedAt time.Time `json:"fetchedAt"`
+	}{w.itemId, w.wikitext, w.fetchedAt})
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: Zone
+ |--------------------------------------------------------------------------
+ |
+ | Represents a zone as described by its wiki page. Zones link to the zones
+ | they connect to, so together they form a graph players can route across
+ |
+ | @member id (int64): Primary key of the zones row
+ | @member name (string): Name of the zone
+ | @member levelRange (string): Level of the monsters as the wiki writes it i.e. 10 - 30
+ | @member connections ([]string): Names of the zones this zone connects to
+ | @member npcs ([]string): Names of the notable NPCs in the zone
+ | @member scrapedAt (time.Time): When the page was last scraped
+ |
+ */
+
+type Zone struct {
+	id int64
+	name string
+	levelRange string
+	connections []string
+	npcs []string
+	scrapedAt time.Time
+}
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ZoneItem
+ |--------------------------------------------------------------------------
+ |
+ | Represents an item dropped in a zone along with the NPC which drops it
+ |
+ | @member itemName (string): Name of the item
+ | @member npcName (string): Name of the NPC which drops it
+ |
+ */
+
+type ZoneItem struct {
+	itemName string
+	npcName string
+}
+
+// Parses a zone page, returns false if it has no level range or connections
+// as it's then unlikely to be a zone
+func ParseZone(name string, body string) (Zone, bool) {
+	zone := Zone{ name: name, connections: []string{}, npcs: []string{} }
+
+	document, err := ParseHtml(body)
+	if err != nil {
+		Log.Error("Failed to parse html", "err", err)
+		return zone, false
+	}
+
+	seenConnections := make(map[string]bool)
+	seenNpcs := make(map[string]bool)
+	addNpc := func(npcName string) {
+		if npcName != "" && !seenNpcs[strings.ToLower(npcName)] {
+			seenNpcs[strings.ToLower(npcName)] = true
+			zone.npcs = append(zone.npcs, npcName)
+		}
+	}
+
+	// The infobox is a table of label and value rows, or single cells written
+	// as "Label: value"
+	for _, row := range FindAll(document, ByTag("tr")) {
+		label, text, value := zoneInfoboxRow(row)
+		switch {
+		case strings.Contains(label, "level") && zone.levelRange == "":
+			zone.levelRange = text
+		case strings.Contains(label, "adjacent") || strings.Contains(label, "connect"):
+			for _, link := range FindAll(value, ByTag("a")) {
+				connection := LinkTitle(link)
+				if connection != "" && !strings.EqualFold(connection, name) && !seenConnections[strings.ToLower(connection)] {
+					seenConnections[strings.ToLower(connection)] = true
+					zone.connections = append(zone.connections, connection)
+				}
+			}
+		case strings.Contains(label, "notable"):
+			for _, link := range FindAll(value, ByTag("a")) {
+				addNpc(LinkTitle(link))
+			}
+		}
+	}
+
+	for _, section := range SectionNodes(document, "Notable_NPCs", "Notable_Mobs") {
+		for _, item := range FindAll(section, ByTag("li")) {
+			if link := FindFirst(item, ByTag("a")); link != nil {
+				addNpc(LinkTitle(link))
+			}
+		}
+	}
+
+	return zone, zone.levelRange != "" || len(zone.connections) > 0
+}
+
+// Returns the row's label in lower case, its value as text and the node
+// holding the value
+func zoneInfoboxRow(row *html.Node) (string, string, *html.Node) {
+	var cells []*html.Node
+	for cell := row.FirstChild; cell != nil; cell = cell.NextSibling {
+		if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+			cells = append(cells, cell)
+		}
+	}
+	if len(cells) >= 2 {
+		return strings.ToLower(strings.TrimRight(TextContent(cells[0]), ": ")), TextContent(cells[1]), cells[1]
+	}
+	if len(cells) == 1 {
+		text := TextContent(cells[0])
+		if idx := strings.Index(text, ":"); idx > -1 {
+			// Links in the label are rare enough that the whole cell is the value
+			return strings.ToLower(strings.TrimSpace(text[:idx])), strings.TrimSpace(text[idx+1:]), cells[0]
+		}
+	}
+	return "", "", row
+}
+
+// Saves the zone and replaces its connections and NPCs
+func (z *Zone) Save(ctx context.Context) error {
+	return DB.Transaction(ctx, func(tx *Tx) error {
+		query := "INSERT INTO zones (name, level_range, scraped_at) " +
+			"VALUES (?, ?, ?) " +
+			"ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), level_range = VALUES(level_range), scraped_at = VALUES(scraped_at)"
+
+		z.scrapedAt = time.Now().UTC()
+		id, err := tx.Insert(query, z.name, z.levelRange, z.scrapedAt)
+		if err != nil || id <= 0 {
+			LoggerFrom(ctx).Error("Failed to save zone", "zone", z.name, "err", err)
+			return ErrDatabaseWrite
+		}
+		z.id = id
+
+		for _, table := range []string{"zone_connections", "zone_npcs"} {
+			rows, err := tx.Query("DELETE FROM " + table + " WHERE zone_id = ?", z.id)
+			if err != nil {
+				return ErrDatabaseWrite
+			}
+			DB.CloseRows(rows)
+		}
+
+		if len(z.connections) > 0 {
+			var parameters []interface{}
+			query = "INSERT INTO zone_connections (zone_id, connected_zone) VALUES "
+			for _, connection := range z.connections {
+				query += "(?, ?),"
+				parameters = append(parameters, z.id, connection)
+			}
+			if _, err := tx.Insert(query[0:len(query)-1], parameters...); err != nil {
+				return ErrDatabaseWrite
+			}
+		}
+		if len(z.npcs) > 0 {
+			var parameters []interface{}
+			query = "INSERT INTO zone_npcs (zone_id, npc_name) VALUES "
+			for _, npcName := range z.npcs {
+				query += "(?, ?),"
+				parameters = append(parameters, z.id, npcName)
+			}
+			if _, err := tx.Insert(query[0:len(query)-1], parameters...); err != nil {
+				return ErrDatabaseWrite
+			}
+		}
+
+		LoggerFrom(ctx).Info("Saved zone", "zone", z.name, "id", z.id, "connections", len(z.connections), "npcs", len(z.npcs))
+		return nil
+	})
+}
+
+// Returns the stored zone, returns false if we haven't scraped it
+func FetchZone(ctx context.Context, name string) (Zone, bool, error) {
+	zone := Zone{ name: name, connections: []string{}, npcs: []string{} }
+
+	rows, err := DB.QueryContext(ctx, "SELECT id, name, level_range, scraped_at FROM zones WHERE name = ? LIMIT 1", name)
+	if err != nil {
+		return zone, false, ErrDatabaseRead
+	}
+	exists := false
+	for rows.Next() {
+		if err := rows.Scan(&zone.id, &zone.name, &zone.levelRange, &zone.scrapedAt); err != nil {
+			Log.Error("Scan failed", "err", err)
+			DB.CloseRows(rows)
+			return zone, false, ErrDatabaseRead
+		}
+		exists = true
+	}
+	DB.CloseRows(rows)
+	if !exists {
+		return zone, false, nil
+	}
+
+	if zone.connections, err = fetchNames(ctx, "SELECT connected_zone FROM zone_connections WHERE zone_id = ? ORDER BY connected_zone ASC", zone.id); err != nil {
+		return zone, false, err
+	}
+	if zone.npcs, err = fetchNames(ctx, "SELECT npc_name FROM zone_npcs WHERE zone_id = ? ORDER BY npc_name ASC", zone.id); err != nil {
+		return zone, false, err
+	}
+	return zone, true, nil
+}
+
+// Returns every item we know drops in the zone, from both the drop lists on
+// item pages and the known loot on NPC pages
+func FetchZoneItems(ctx context.Context, zoneName string) ([]ZoneItem, error) {
+	items := []ZoneItem{}
+
+	query := "SELECT items.name, item_sources.npc_name " +
+		"FROM item_sources " +
+		"INNER JOIN items ON items.id = item_sources.item_id " +
+		"WHERE item_sources.zone = ? " +
+		"UNION " +
+		"SELECT npc_drops.item_name, npcs.name " +
+		"FROM npc_drops " +
+		"INNER JOIN npcs ON npcs.id = npc_drops.npc_id " +
+		"WHERE npcs.zone = ? " +
+		"ORDER BY 1 ASC, 2 ASC"
+
+	rows, err := DB.QueryContext(ctx, query, zoneName, zoneName)
+	if err != nil {
+		return items, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var item ZoneItem
+		if err := rows.Scan(&item.itemName, &item.npcName); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+		return items, ErrDatabaseRead
+	}
+	return items, nil
+}
+
+func (z Zone) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name        string    `json:"name"`
+		LevelRange  string    `json:"level_range"`
+		Connections []string  `json:"connections"`
+		Npcs        []string  `json:"npcs"`
+		ScrapedAt   time.Time `json:"scraped_at"`
+	}{z.name, z.levelRange, z.connections, z.npcs, z.scrapedAt})
+}
+
+func (z ZoneItem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Item string `json:"item"`
+		Npc  string `json:"npc"`
+	}{z.itemName, z.npcName})
+}
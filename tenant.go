@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: ApiKey
+ |--------------------------------------------------------------------------
+ |
+ | Represents a key a server community uses to call the API. Every key is
+ | bound to a tenant, which is named like a server, and everything a tenant
+ | writes - price points, roll-ups, auctions and retried lines - is only
+ | visible to that tenant. The wiki catalog is shared by every tenant.
+ | Requests without a key belong to the default tenant, named "", which
+ | owns everything recorded before tenancy existed. Only a hash of the key
+ | is stored, the key itself is shown once when it's created
+ |
+ | @member id (int64): Primary key of the api_keys row
+ | @member tenant (string): The tenant the key belongs to
+ | @member name (string): What the key is used for, for admins
+ | @member prefix (string): The first characters of the key so admins can tell keys apart
+ | @member createdAt (time.Time): When the key was created
+ | @member revokedAt (*time.Time): When the key was revoked, nil while it's in use
+ |
+ */
+
+type ApiKey struct {
+	id int64
+	tenant string
+	name string
+	prefix string
+	createdAt time.Time
+	revokedAt *time.Time
+}
+
+const (
+	API_KEY_BYTES  = 24
+	API_KEY_PREFIX = 8
+)
+
+// Shortest admin_api_key we accept
+const ADMIN_API_KEY_MIN_LENGTH = 32
+
+type tenantContextKey struct{}
+
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// Returns the tenant the request or job belongs to, the default tenant if it
+// doesn't have one
+func TenantFrom(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantContextKey{}).(string); ok {
+		return tenant
+	}
+	return ""
+}
+
+type adminContextKey struct{}
+
+// Marks the request as sent with admin_api_key
+func WithAdmin(ctx context.Context) context.Context {
+	return context.WithValue(ctx, adminContextKey{}, true)
+}
+
+func IsAdminFrom(ctx context.Context) bool {
+	admin, _ := ctx.Value(adminContextKey{}).(bool)
+	return admin
+}
+
+// Resolved keys are cached for cache_time_in_secs so we don't hit SQL on every
+// request, revoking a key can take that long to apply
+var apiKeyCache = struct {
+	sync.Mutex
+	tenants map[string]cachedTenant
+}{ tenants: make(map[string]cachedTenant) }
+
+type cachedTenant struct {
+	tenant string
+	exists bool
+	expiresAt time.Time
+}
+
+// Binds the request to the tenant of its API key, sent as a bearer token or in
+// X-Api-Key. Unknown keys are rejected, requests without one belong to the
+// default tenant unless require_api_key is set or the route always needs one.
+// Admin routes act across every tenant so they need admin_api_key, tenants'
+// keys can't call them. The admin key acts as the default tenant elsewhere,
+// apart from TenantAllows letting it act on any server
+func Tenancy(inner http.Handler, route Route) http.Handler {
+	admin := IsAdminRoute(route)
+	keyless := keylessRoutes[route.name]
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-Api-Key")
+		if auth := r.Header.Get("Authorization"); key == "" && strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+		}
+
+		if key == "" {
+			if admin {
+				http.Error(w, "Please send the admin API key", http.StatusUnauthorized)
+				return
+			}
+			if Settings.RequireApiKey && !keyless {
+				http.Error(w, "Please send an API key", http.StatusUnauthorized)
+				return
+			}
+			inner.ServeHTTP(w, r)
+			return
+		}
+
+		if IsAdminApiKey(key) {
+			inner.ServeHTTP(w, r.WithContext(WithAdmin(r.Context())))
+			return
+		}
+
+		tenant, exists, err := resolveApiKey(r.Context(), key)
+		if err != nil {
+			http.Error(w, err.Error(), StatusForError(err))
+			return
+		}
+		if !exists {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if admin {
+			http.Error(w, "API keys can't call admin routes", http.StatusForbidden)
+			return
+		}
+
+		ctx := WithTenant(r.Context(), tenant)
+		ctx = WithLogger(ctx, LoggerFrom(ctx).With("tenant", tenant))
+		inner.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Returns true if the key is admin_api_key, never while that isn't set. The
+// comparison takes as long whatever the key so it can't be guessed a character
+// at a time
+func IsAdminApiKey(key string) bool {
+	adminKey := LiveSetting("admin_api_key", Settings.AdminApiKey)
+	if adminKey == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) == 1
+}
+
+// Returns true if the request may act on the server's data. Tenants, the
+// default tenant included, can only act on their own, only the admin key acts
+// on any server's
+func TenantAllows(r *http.Request, server string) bool {
+	return IsAdminFrom(r.Context()) || TenantFrom(r.Context()) == server
+}
+
+func hashApiKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// Returns the tenant the key belongs to, returns false if the key doesn't exist
+// or has been revoked
+func resolveApiKey(ctx context.Context, key string) (string, bool, error) {
+	hash := hashApiKey(key)
+
+	apiKeyCache.Lock()
+	cached, exists := apiKeyCache.tenants[hash]
+	apiKeyCache.Unlock()
+	if exists && time.Now().Before(cached.expiresAt) {
+		return cached.tenant, cached.exists, nil
+	}
+
+	rows, err := DB.QueryContext(ctx, "SELECT tenant FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL", hash)
+	if err != nil {
+		return "", false, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	cached = cachedTenant{ expiresAt: time.Now().Add(seconds(Settings.CacheTimeInSecs)) }
+	for rows.Next() {
+		if err := rows.Scan(&cached.tenant); err != nil {
+			Log.Error("Scan failed", "err", err)
+			return "", false, ErrDatabaseRead
+		}
+		cached.exists = true
+	}
+
+	apiKeyCache.Lock()
+	apiKeyCache.tenants[hash] = cached
+	apiKeyCache.Unlock()
+	return cached.tenant, cached.exists, nil
+}
+
+// Creates a key for the tenant, returning the key itself as this is the only
+// time it's available
+func CreateApiKey(ctx context.Context, tenant string, name string) (ApiKey, string, error) {
+	apiKey := ApiKey{ tenant: tenant, name: name, createdAt: time.Now().UTC() }
+
+	bytes := make([]byte, API_KEY_BYTES)
+	if _, err := rand.Read(bytes); err != nil {
+		LoggerFrom(ctx).Error("Failed to generate API key", "err", err)
+		return apiKey, "", ErrDatabaseWrite
+	}
+	key := hex.EncodeToString(bytes)
+	apiKey.prefix = key[:API_KEY_PREFIX]
+
+	query := "INSERT INTO api_keys (tenant, name, prefix, key_hash, created_at) VALUES (?, ?, ?, ?, ?)"
+	id, err := DB.InsertContext(ctx, query, tenant, name, apiKey.prefix, hashApiKey(key), apiKey.createdAt)
+	if err != nil {
+		return apiKey, "", ErrDatabaseWrite
+	}
+	apiKey.id = id
+	LoggerFrom(ctx).Info("Created API key", "tenant", tenant, "id", id)
+	return apiKey, key, nil
+}
+
+// Revokes the key, returns false if there's no such key in use
+func RevokeApiKey(ctx context.Context, id int64) (bool, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT id FROM api_keys WHERE id = ? AND revoked_at IS NULL", id)
+	if err != nil {
+		return false, ErrDatabaseRead
+	}
+	exists := rows.Next()
+	DB.CloseRows(rows)
+	if !exists {
+		return false, nil
+	}
+
+	rows, err = DB.QueryContext(ctx, "UPDATE api_keys SET revoked_at = ? WHERE id = ?", time.Now().UTC(), id)
+	if err != nil {
+		LoggerFrom(ctx).Error("Failed to revoke API key", "id", id, "err", err)
+		return false, ErrDatabaseWrite
+	}
+	DB.CloseRows(rows)
+
+	// Revoked keys are forgotten here straight away, other replicas catch up
+	// within cache_time_in_secs
+	apiKeyCache.Lock()
+	apiKeyCache.tenants = make(map[string]cachedTenant)
+	apiKeyCache.Unlock()
+	LoggerFrom(ctx).Info("Revoked API key", "id", id)
+	return true, nil
+}
+
+// Returns every key the tenant has, including revoked ones
+func FetchApiKeys(ctx context.Context, tenant string) ([]ApiKey, error) {
+	keys := []ApiKey{}
+
+	query := "SELECT id, tenant, name, prefix, created_at, revoked_at " +
+		"FROM api_keys " +
+		"WHERE tenant = ? " +
+		"ORDER BY id ASC"
+
+	rows, err := DB.QueryContext(ctx, query, tenant)
+	if err != nil {
+		return keys, ErrDatabaseRead
+	}
+	defer DB.CloseRows(rows)
+
+	for rows.Next() {
+		var k ApiKey
+		if err := rows.Scan(&k.id, &k.tenant, &k.name, &k.prefix, &k.createdAt, &k.revokedAt); err != nil {
+			Log.Error("Scan failed", "err", err)
+			continue
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		Log.Error("Row iteration failed", "err", err)
+		return keys, ErrDatabaseRead
+	}
+	return keys, nil
+}
+
+func (k ApiKey) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Id        int64      `json:"id"`
+		Tenant    string     `json:"tenant"`
+		Name      string     `json:"name"`
+		Prefix    string     `json:"prefix"`
+		CreatedAt time.Time  `json:"createdAt"`
+		RevokedAt *time.Time `json:"revokedAt"`
+	}{k.id, k.tenant, k.name, k.prefix, k.createdAt, k.revokedAt})
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"database/sql"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: AuctionMatch
+ |--------------------------------------------------------------------------
+ |
+ | Represents an item which was extracted from a raw auction line
+ |
+ | @member name (string): Canonical name of the item from our corpus
+ | @member text (string): The fragment of the auction line that matched
+ | @member confidence (float64): 1.0 for an exact match, falling towards 0
+ | as the edit distance between the fragment and the name grows
+ | @member start (int): Index of the first word of the fragment in the line
+ | @member end (int): Index after the last word of the fragment in the line
+ | @member price (sql.NullFloat64): The price advertised straight after the
+ | item, if there was one
+ |
+ */
+
+type AuctionMatch struct {
+	name string
+	text string
+	confidence float64
+	start int
+	end int
+	price sql.NullFloat64
+}
+
+// Matches scoring below this are discarded as noise
+const MIN_MATCH_CONFIDENCE = 0.75
+
+// Item names are rarely longer than this many words, so there's no point
+// comparing longer windows of the line against the corpus
+const MAX_MATCH_WORDS = 6
+
+// Words which regularly appear in auction lines but are never part of an item
+var auctionNoiseWords = map[string]bool{
+	"wts": true, "wtb": true, "wtt": true, "selling": true, "buying": true,
+	"trading": true, "pst": true, "obo": true, "or": true, "offer": true,
+	"each": true, "ea": true, "pp": true, "plat": true, "auctions": true,
+}
+
+var tokenCleanRegex = regexp.MustCompile(`[^a-z0-9'` + "`" + `:.\- ]+`)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: NameCorpus
+ |--------------------------------------------------------------------------
+ |
+ | Process wide cache of every item name we know about, the tokenizer
+ | compares fragments of each auction line against it. The corpus is
+ | reloaded from SQL once it is older than cache_time_in_secs. Aliases are
+ | also kept apart from the names so lookups can be resolved through them.
+ | The default tenant's aliases, which include the wiki's redirects, are
+ | shared by every tenant. Other tenants' aliases only apply to their own
+ | lines and lookups, and win over the shared ones
+ |
+ */
+
+type NameCorpus struct {
+	mutex sync.RWMutex
+	shared serverCorpus
+	servers map[string]serverCorpus
+	loadedAt time.Time
+}
+
+// The item names along with the aliases a server can use
+type serverCorpus struct {
+	names []string
+	lookup map[string]string
+	aliases map[string]string
+}
+
+type corpusAlias struct {
+	alias string
+	name string
+}
+
+// Returns a copy of the corpus with the server's own aliases added, the first
+// of them for each alias wins over the others and over the shared one
+func (c serverCorpus) with(aliases []corpusAlias) serverCorpus {
+	corpus := serverCorpus{
+		names: append([]string(nil), c.names...),
+		lookup: make(map[string]string, len(c.lookup)),
+		aliases: make(map[string]string, len(c.aliases)),
+	}
+	for key, name := range c.lookup {
+		corpus.lookup[key] = name
+	}
+	for key, name := range c.aliases {
+		corpus.aliases[key] = name
+	}
+
+	own := make(map[string]bool)
+	for _, a := range aliases {
+		_, shared := c.aliases[a.alias]
+		_, exists := c.lookup[a.alias]
+		if own[a.alias] || (exists && !shared) {
+			continue
+		}
+		if !exists {
+			corpus.names = append(corpus.names, a.alias)
+		}
+		own[a.alias] = true
+		corpus.lookup[a.alias] = a.name
+		corpus.aliases[a.alias] = a.name
+	}
+	return corpus
+}
+
+// Names have to be at least this close to what was asked for to be suggested
+const MIN_SUGGESTION_CONFIDENCE = 0.5
+
+// Most names suggested when an item isn't found
+const MAX_SUGGESTIONS = 5
+
+var Corpus = NameCorpus{}
+
+// Returns the names in the corpus with the server's aliases, along with a
+// lowercase lookup of them, reloading them from SQL if our copy has gone stale
+func (n *NameCorpus) Names(server string) ([]string, map[string]string) {
+	corpus := n.server(server)
+	return corpus.names, corpus.lookup
+}
+
+func (n *NameCorpus) server(server string) serverCorpus {
+	n.mutex.RLock()
+	stale := time.Since(n.loadedAt) > seconds(Settings.CacheTimeInSecs) || n.shared.lookup == nil
+	n.mutex.RUnlock()
+
+	if stale {
+		n.Reload()
+	}
+
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+	if corpus, exists := n.servers[server]; exists {
+		return corpus
+	}
+	return n.shared
+}
+
+// Returns the name of the item the alias stands for on the server, false if
+// it isn't an alias there. Names of items are never aliases
+func (n *NameCorpus) Alias(server string, alias string) (string, bool) {
+	name, exists := n.server(server).aliases[NormaliseAlias(alias)]
+	return name, exists
+}
+
+// Returns true if an item goes by the name, aliases aside
+func (n *NameCorpus) IsName(name string) bool {
+	corpus := n.server("")
+	key := NormaliseAlias(name)
+
+	_, isAlias := corpus.aliases[key]
+	_, exists := corpus.lookup[key]
+	return exists && !isAlias
+}
+
+// Returns up to limit item names closest to text, best first, for when
+// nothing goes by that name. Names containing text are suggested however
+// much longer they are, as that's usually someone leaving words out
+func (n *NameCorpus) Suggest(server string, text string, limit int) []string {
+	text = NormaliseAlias(text)
+	names, lookup := n.Names(server)
+
+	type suggestion struct {
+		name string
+		confidence float64
+	}
+	best := make(map[string]float64)
+	for _, candidate := range names {
+		longest := maxInt(len(text), len(candidate))
+		confidence := 0.0
+		if strings.Contains(candidate, text) {
+			confidence = 0.5 + 0.5*float64(len(text))/float64(longest)
+		} else if float64(absInt(len(text)-len(candidate))) <= float64(longest)*(1.0-MIN_SUGGESTION_CONFIDENCE) {
+			confidence = 1.0 - float64(Levenshtein(text, candidate))/float64(longest)
+		}
+		if confidence >= MIN_SUGGESTION_CONFIDENCE && confidence > best[lookup[candidate]] {
+			best[lookup[candidate]] = confidence
+		}
+	}
+
+	var suggestions []suggestion
+	for name, confidence := range best {
+		suggestions = append(suggestions, suggestion{ name, confidence })
+	}
+	sort.Slice(suggestions, func(a, b int) bool {
+		if suggestions[a].confidence != suggestions[b].confidence {
+			return suggestions[a].confidence > suggestions[b].confidence
+		}
+		return suggestions[a].name < suggestions[b].name
+	})
+
+	result := []string{}
+	for idx := 0; idx < len(suggestions) && idx < limit; idx++ {
+		result = append(result, suggestions[idx].name)
+	}
+	return result
+}
+
+// Loads every item name from SQL into the corpus, display names are added too
+// (with their underscores swapped for spaces) in case they differ from the name,
+// as are each server's aliases
+func (n *NameCorpus) Reload() {
+	var names []string
+	lookup := make(map[string]string)
+
+	rows, err := DB.Query("SELECT name, displayName FROM items")
+	if err == nil {
+		for rows.Next() {
+			var (
+				name string
+				displayName sql.NullString
+			)
+			if err := rows.Scan(&name, &displayName); err != nil {
+				Log.Error("Scan failed", "err", err)
+				continue
+			}
+			for _, variant := range []string{name, strings.Replace(displayName.String, "_", " ", -1)} {
+				key := strings.ToLower(strings.TrimSpace(variant))
+				if key == "" {
+					continue
+				}
+				if _, exists := lookup[key]; !exists {
+					lookup[key] = name
+					names = append(names, key)
+				}
+			}
+		}
+		if err := rows.Err(); err != nil {
+			Log.Error("Row iteration failed", "err", err)
+		}
+		DB.CloseRows(rows)
+	}
+
+	// Aliases match as if they were the item's name, those added by hand are
+	// loaded first so they win over approved candidates
+	shared := serverCorpus{ names: names, lookup: lookup, aliases: make(map[string]string) }
+	servers := make(map[string][]corpusAlias)
+	for _, query := range []string{
+		"SELECT server, alias, item_name FROM item_aliases",
+		"SELECT server, alias, item_name FROM alias_candidates WHERE status = '" + ALIAS_APPROVED + "'",
+	} {
+		rows, _ = DB.Query(query)
+		if rows == nil {
+			continue
+		}
+		for rows.Next() {
+			var server, alias, name string
+			if err := rows.Scan(&server, &alias, &name); err != nil {
+				Log.Error("Scan failed", "err", err)
+				continue
+			}
+			if server == "" {
+				if _, exists := shared.lookup[alias]; !exists {
+					shared.lookup[alias] = name
+					shared.aliases[alias] = name
+					shared.names = append(shared.names, alias)
+				}
+				continue
+			}
+			servers[server] = append(servers[server], corpusAlias{ alias, name })
+		}
+		DB.CloseRows(rows)
+	}
+
+	n.mutex.Lock()
+	n.shared = shared
+	n.servers = make(map[string]serverCorpus)
+	for server, aliases := range servers {
+		n.servers[server] = shared.with(aliases)
+	}
+	n.loadedAt = time.Now()
+	n.mutex.Unlock()
+
+	Log.Debug("Loaded item name corpus", "size", len(names))
+}
+
+// Splits a raw auction line sent to the server into the items it mentions.
+// We slide windows of words over the line, longest first, and score each
+// window against the corpus using the Levenshtein distance so that misspelt
+// names and the server's aliases still match
+func Tokenize(server string, line string) []AuctionMatch {
+	var matches []AuctionMatch
+
+	words := tokenWords(line)
+	if len(words) == 0 {
+		return matches
+	}
+
+	names, lookup := Corpus.Names(server)
+	consumed := make([]bool, len(words))
+
+	for size := minInt(MAX_MATCH_WORDS, len(words)); size > 0; size-- {
+		for start := 0; start+size <= len(words); start++ {
+			if anyConsumed(consumed, start, start+size) {
+				continue
+			}
+
+			text := strings.Join(words[start:start+size], " ")
+			if size == 1 && auctionNoiseWords[text] {
+				continue
+			}
+
+			name, confidence := bestMatch(text, names, lookup)
+			if confidence < MIN_MATCH_CONFIDENCE {
+				continue
+			}
+
+			for idx := start; idx < start+size; idx++ {
+				consumed[idx] = true
+			}
+			matches = append(matches, AuctionMatch{
+				name: name,
+				text: text,
+				confidence: confidence,
+				start: start,
+				end: start + size,
+			})
+		}
+	}
+
+	// Return the matches in the order they appeared in the line
+	sort.Slice(matches, func(a, b int) bool {
+		return matches[a].start < matches[b].start
+	})
+
+	// Sellers advertise the price straight after the item name, i.e. "Fungi Tunic 5k"
+	for idx := range matches {
+		if matches[idx].end < len(words) && !consumed[matches[idx].end] {
+			if price, ok := ParsePrice(words[matches[idx].end]); ok {
+				matches[idx].price = sql.NullFloat64{Float64: price, Valid: true}
+			}
+		}
+	}
+
+	return matches
+}
+
+// Lowercases the line and strips out anything which can't be part of an item name
+func tokenWords(line string) []string {
+	line = strings.ToLower(line)
+	line = strings.Replace(line, "|", " ", -1)
+	line = strings.Replace(line, "/", " ", -1)
+	line = strings.Replace(line, ",", " ", -1)
+	line = tokenCleanRegex.ReplaceAllString(line, "")
+
+	var words []string
+	for _, word := range strings.Fields(line) {
+		// Full stops are only kept so that prices like 1.5k survive
+		word = strings.Trim(word, ".")
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+func anyConsumed(consumed []bool, start int, end int) bool {
+	for idx := start; idx < end; idx++ {
+		if consumed[idx] {
+			return true
+		}
+	}
+	return false
+}
+
+// Finds the closest name in the corpus to text, returning it with its confidence
+func bestMatch(text string, names []string, lookup map[string]string) (string, float64) {
+	if name, exists := lookup[text]; exists {
+		return name, 1.0
+	}
+
+	bestName := ""
+	bestConfidence := 0.0
+	textLength := len(text)
+
+	for _, candidate := range names {
+		longest := maxInt(textLength, len(candidate))
+		// The distance can never be smaller than the difference in length, so skip
+		// anything which can't possibly score above the threshold
+		if float64(absInt(textLength-len(candidate))) > float64(longest)*(1.0-MIN_MATCH_CONFIDENCE) {
+			continue
+		}
+
+		confidence := 1.0 - float64(Levenshtein(text, candidate))/float64(longest)
+		if confidence > bestConfidence {
+			bestConfidence = confidence
+			bestName = lookup[candidate]
+		}
+	}
+
+	return bestName, bestConfidence
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: UsageWriter
+ |--------------------------------------------------------------------------
+ |
+ | Counts how often each item is viewed and mentioned in auction lines, a
+ | day at a time, in the item_usage table. Tracking happens on the hottest
+ | paths we have so it never touches SQL itself, events are handed to a
+ | single writer goroutine through a bounded buffer and summed in memory
+ | until they are flushed. If the writer falls behind the buffer fills and
+ | further events are dropped rather than making requests wait. Whatever
+ | is buffered is flushed when we shut down. Read only mirrors don't write,
+ | so they track nothing and the writer isn't started
+ |
+ */
+
+const (
+	USAGE_VIEW    = "view"
+	USAGE_MENTION = "mention"
+)
+
+type usageKey struct {
+	itemId int64
+	kind string
+	day string
+}
+
+type UsageWriter struct {
+	events chan usageKey
+	stop chan struct{}
+	stopOnce sync.Once
+	pending map[usageKey]int64
+	flushed int64
+	dropped int64
+	lost int64
+}
+
+// Rebuilt by applyConfig with the configured buffer size
+var Usage = NewUsageWriter(DefaultConfig().UsageBufferSize)
+
+func NewUsageWriter(bufferSize int) *UsageWriter {
+	return &UsageWriter{
+		events: make(chan usageKey, bufferSize),
+		stop: make(chan struct{}),
+		pending: make(map[usageKey]int64),
+	}
+}
+
+// Counts one use of the item, this never blocks. Items we haven't saved yet
+// have nothing to count against so are ignored
+func (u *UsageWriter) Track(itemId int64, kind string) {
+	if itemId <= 0 || Settings.ReadOnly {
+		return
+	}
+	select {
+	case u.events <- usageKey{ itemId, kind, time.Now().UTC().Format("2006-01-02") }:
+	default:
+		atomic.AddInt64(&u.dropped, 1)
+	}
+}
+
+// Starts the writer, it runs until Stop is called and is counted in
+// BackgroundWork so the final flush happens before the database is closed
+func (u *UsageWriter) Start() {
+	BackgroundWork.Add(1)
+	go u.run()
+}
+
+// Flushes whatever is buffered and stops the writer, call this once requests
+// have drained so their usage is included
+func (u *UsageWriter) Stop() {
+	u.stopOnce.Do(func() { close(u.stop) })
+}
+
+func (u *UsageWriter) run() {
+	defer BackgroundWork.Done()
+
+	ticker := time.NewTicker(seconds(Settings.UsageFlushIntervalInSecs))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case key := <-u.events:
+			u.pending[key]++
+			if len(u.pending) >= Settings.UsageFlushSize {
+				u.flush()
+			}
+		case <-ticker.C:
+			u.flush()
+		case <-u.stop:
+			for {
+				select {
+				case key := <-u.events:
+					u.pending[key]++
+				default:
+					u.flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Adds the pending counts to item_usage in one statement. Counts which fail to
+// save are discarded rather than held on to, so an outage can't grow them
+// without bound
+func (u *UsageWriter) flush() {
+	if len(u.pending) == 0 {
+		return
+	}
+
+	var (
+		parameters []interface{}
+		events int64
+	)
+	query := "INSERT INTO item_usage " +
+		"(item_id, kind, day, count) " +
+		"VALUES "
+	for key, count := range u.pending {
+		query += "(?, ?, ?, ?),"
+		parameters = append(parameters, key.itemId, key.kind, key.day, count)
+		events += count
+	}
+	query = query[0:len(query)-1] + " ON DUPLICATE KEY UPDATE count = count + VALUES(count)"
+
+	start := time.Now()
+	if _, err := DB.Insert(query, parameters...); err != nil {
+		Log.Error("Failed to flush usage", "rows", len(u.pending), "events", events, "err", err)
+		atomic.AddInt64(&u.lost, events)
+	} else {
+		Log.Debug("Flushed usage", "rows", len(u.pending), "events", events, "duration", time.Since(start))
+		atomic.AddInt64(&u.flushed, events)
+	}
+	u.pending = make(map[usageKey]int64)
+}
+
+func (u *UsageWriter) Stats() map[string]int64 {
+	return map[string]int64{
+		"buffered": int64(len(u.events)),
+		"capacity": int64(cap(u.events)),
+		"flushed": atomic.LoadInt64(&u.flushed),
+		"dropped": atomic.LoadInt64(&u.dropped),
+		"lost": atomic.LoadInt64(&u.lost),
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"html"
+	"io/ioutil"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Shared by every request to the wiki so that connections are reused and a hung
+// connection can never block a handler forever. The timeout is set once the
+// config has been loaded
+var wikiClient = &http.Client{
+	Timeout: seconds(Settings.WikiTimeoutInSecs),
+}
+
+// Fetches a page from the wiki and returns its body, uri is the page name in
+// its url friendly form i.e. Cloak_of_Flames. Network errors and 5xx/429
+// responses are retried up to wiki_max_attempts times with exponential backoff,
+// if every attempt fails ErrWikiUnavailable is returned. Maintenance pages,
+// CAPTCHAs and other bodies which aren't wiki content are retried the same way
+// but return ErrWikiBadResponse. Missing pages return ErrPageNotFound straight away.
+// If ctx is cancelled the request and any remaining retries are abandoned and
+// the context's error is returned
+func FetchWikiPage(ctx context.Context, uri string) (string, error) {
+	if Settings.ReadOnly {
+		return "", ErrReadOnly
+	}
+	logger := LoggerFrom(ctx)
+	var lastErr error
+
+	for attempt := 1; attempt <= Settings.WikiMaxAttempts; attempt++ {
+		if attempt > 1 {
+			delay := wikiRetryDelay(attempt)
+			logger.Warn("Retrying wiki request", "uri", uri, "delay", delay, "err", lastErr)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+
+		if err := wikiLimiter.Wait(ctx); err != nil {
+			if err == ErrWikiRateLimited {
+				logger.Warn("Too many wiki requests queued", "uri", uri)
+			}
+			return "", err
+		}
+
+		body, retry, err := fetchWikiPageOnce(ctx, uri)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if err == nil {
+			return body, nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+
+	if lastErr == ErrPageNotFound || lastErr == ErrWikiBadResponse {
+		return "", lastErr
+	}
+	logger.Error("Failed to get data from the wiki", "uri", uri, "err", lastErr)
+	return "", ErrWikiUnavailable
+}
+
+// Redirects are followed this many times at most, so a loop of them can't
+// keep us fetching forever
+const MAX_WIKI_REDIRECTS = 3
+
+var (
+	// #REDIRECT [[Other Page]] in wikitext, or the redirect notice MediaWiki
+	// renders when it doesn't follow the redirect itself
+	wikiRedirectRegex       = regexp.MustCompile(`(?i)#REDIRECT\s*\[\[([^\]|#]+)`)
+	wikiRedirectNoticeRegex = regexp.MustCompile(`(?is)class="redirectText"[^>]*>\s*<li>\s*<a href="/([^"#?]+)"`)
+	// MediaWiki followed a redirect for us, so the page isn't the one we asked for
+	wikiRedirectedFromRegex = regexp.MustCompile(`(?i)class="mw-redirectedfrom"`)
+	wikiPageNameRegex       = regexp.MustCompile(`"wgPageName"\s*:\s*"([^"]+)"`)
+	// Disambiguation pages are built from a template which tags them
+	wikiDisambiguationRegex     = regexp.MustCompile(`(?i)(?:(?:id|class)="[^"]*\bdisambig|Category:Disambiguation|\{\{disambig)`)
+	wikiDisambiguationLinkRegex = regexp.MustCompile(`(?is)<li>\s*<a href="/([^"#?:]+)"[^>]*title="([^"]+)"`)
+)
+
+// Fetches a page as FetchWikiPage does, following any redirects. Returns the
+// body along with the uri of the page it came from, which differs from uri if
+// it was redirected
+func FetchResolvedWikiPage(ctx context.Context, uri string) (string, string, error) {
+	body, err := FetchWikiPage(ctx, uri)
+	for redirects := 0; err == nil; redirects++ {
+		target, fetch := WikiRedirectTarget(body)
+		if target == "" || target == uri {
+			break
+		}
+		LoggerFrom(ctx).Info("Following wiki redirect", "from", uri, "to", target)
+		uri = target
+		if !fetch {
+			break
+		}
+		if redirects == MAX_WIKI_REDIRECTS {
+			LoggerFrom(ctx).Warn("Too many wiki redirects", "uri", uri)
+			return "", uri, ErrNotAnItemPage
+		}
+		body, err = FetchWikiPage(ctx, uri)
+	}
+	return body, uri, err
+}
+
+// Returns the page the body redirects to in its url friendly form, or an empty
+// string if it isn't a redirect. fetch is false if the wiki already followed
+// the redirect and the body is the target page
+func WikiRedirectTarget(body string) (string, bool) {
+	if match := wikiRedirectRegex.FindStringSubmatch(body); match != nil {
+		return wikiUriFromTitle(match[1]), true
+	}
+	if match := wikiRedirectNoticeRegex.FindStringSubmatch(body); match != nil {
+		return wikiUriFromTitle(match[1]), true
+	}
+	if wikiRedirectedFromRegex.MatchString(body) {
+		if match := wikiPageNameRegex.FindStringSubmatch(body); match != nil {
+			return wikiUriFromTitle(match[1]), false
+		}
+	}
+	return "", false
+}
+
+// Returns the names of the pages a disambiguation page lists, false if the
+// body isn't a disambiguation page
+func WikiDisambiguationLinks(body string) ([]string, bool) {
+	if !wikiDisambiguationRegex.MatchString(body) {
+		return nil, false
+	}
+
+	links := []string{}
+	seen := make(map[string]bool)
+	for _, match := range wikiDisambiguationLinkRegex.FindAllStringSubmatch(body, -1) {
+		name := html.UnescapeString(match[2])
+		if !seen[name] {
+			seen[name] = true
+			links = append(links, name)
+		}
+	}
+	return links, true
+}
+
+// Page titles and paths are either spaced or underscored and may be escaped,
+// we always request them underscored
+func wikiUriFromTitle(title string) string {
+	if unescaped, err := url.PathUnescape(title); err == nil {
+		title = unescaped
+	}
+	return strings.Replace(strings.TrimSpace(html.UnescapeString(title)), " ", "_", -1)
+}
+
+// Makes a single request, returning whether the failure is worth retrying
+func fetchWikiPageOnce(ctx context.Context, uri string) (string, bool, error) {
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, "GET", Settings.WikiBaseUrl + "/" + uri, nil)
+	if err != nil {
+		return "", false, err
+	}
+	resp, err := wikiClient.Do(req)
+	if err != nil {
+		return "", true, err
+	}
+	defer resp.Body.Close()
+	LoggerFrom(ctx).Debug("Wiki responded", "uri", uri, "status", resp.StatusCode, "duration", time.Since(start))
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		LoggerFrom(ctx).Warn("Failed to read wiki response body", "uri", uri, "err", err)
+		return "", true, err
+	}
+
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return "", true, errors.New("wiki responded with status " + strconv.Itoa(resp.StatusCode))
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		LoggerFrom(ctx).Debug("Page doesn't exist on the wiki", "uri", uri)
+		return "", false, ErrPageNotFound
+	}
+
+	page, err := wikiResponseBody(resp.Header, body)
+	if err != nil {
+		LoggerFrom(ctx).Warn("Unusable wiki response", "uri", uri, "err", err)
+		return "", true, ErrWikiBadResponse
+	}
+	return page, false, nil
+}
+
+// Returns true if src is a path on the wiki, i.e. /images/Item_1234.png. Paths
+// are appended to wiki_base_url, so anything which doesn't start with a single
+// slash, or which parses with a host of its own, could point the request or a
+// redirect at another host
+func IsWikiPath(src string) bool {
+	if !strings.HasPrefix(src, "/") || strings.HasPrefix(src, "//") || strings.ContainsAny(src, "\\@ \t\r\n") {
+		return false
+	}
+	parsed, err := url.Parse(src)
+	return err == nil && parsed.Scheme == "" && parsed.Host == "" && parsed.User == nil
+}
+
+// Fetches an image from the wiki, src is its path i.e. /images/Item_1234.png.
+// Returns the image along with its content type. Images aren't retried as
+// they're only ever fetched on behalf of something which can try again
+func FetchWikiImage(ctx context.Context, src string) ([]byte, string, error) {
+	if Settings.ReadOnly {
+		return nil, "", ErrReadOnly
+	}
+	if !IsWikiPath(src) {
+		LoggerFrom(ctx).Warn("Refusing to fetch an image which isn't on the wiki", "src", src)
+		return nil, "", ErrPageNotFound
+	}
+	if err := wikiLimiter.Wait(ctx); err != nil {
+		return nil, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", Settings.WikiBaseUrl + src, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := wikiClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", ErrPageNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.New("wiki responded with status " + strconv.Itoa(resp.StatusCode))
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The wiki's error pages are served with a 200 too, so trust the bytes
+	// over the header
+	contentType := http.DetectContentType(body)
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", ErrWikiBadResponse
+	}
+	return body, contentType, nil
+}
+
+// Pages are served as HTML and action=raw as wikitext, anything else is an
+// error page from something sat in front of the wiki
+var wikiContentTypes = map[string]bool{
+	"text/html": true,
+	"text/x-wiki": true,
+	"text/plain": true,
+}
+
+// Phrases which only appear on maintenance, CAPTCHA and database error pages,
+// these come back with a 200 so we have to look at the body to spot them
+var wikiErrorMarkers = []string{
+	"<title>just a moment...</title>",
+	"cf-browser-verification",
+	"g-recaptcha",
+	"hcaptcha.com",
+	"down for maintenance",
+	"undergoing maintenance",
+	"service temporarily unavailable",
+	"cannot access the database",
+}
+
+// Returns the body if it is wiki content, otherwise an error describing why it
+// isn't. Bodies which are gzipped despite the headers are decompressed first
+func wikiResponseBody(header http.Header, body []byte) (string, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return "", errors.New("empty body")
+	}
+
+	if len(body) > 2 && body[0] == 0x1f && body[1] == 0x8b {
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return "", errors.New("undecodable gzip body: " + err.Error())
+		}
+		body, err = ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return "", errors.New("undecodable gzip body: " + err.Error())
+		}
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !wikiContentTypes[mediaType] {
+		return "", errors.New("unexpected content type " + contentType)
+	}
+
+	if !utf8.Valid(body) {
+		return "", errors.New("body isn't valid UTF-8")
+	}
+
+	lower := strings.ToLower(string(body))
+	for _, marker := range wikiErrorMarkers {
+		if strings.Contains(lower, marker) {
+			return "", errors.New("body contains \"" + marker + "\"")
+		}
+	}
+
+	return string(body), nil
+}
+
+// Doubles the delay on every attempt and adds up to 50% jitter so that a batch
+// of workers which failed together don't all retry at the same moment
+func wikiRetryDelay(attempt int) time.Duration {
+	delay := time.Duration(Settings.WikiRetryBaseDelayInMs) * time.Millisecond * time.Duration(1 << uint(attempt-2))
+	jitter := time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	return delay + jitter
+}
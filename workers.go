@@ -0,0 +1,35 @@
+package main
+
+import "sync"
+
+// Calls work once for every index in [0, jobs) spread across a fixed number of
+// goroutines, blocking until every job has finished. The jobs channel is bounded
+// so we never queue more work than the workers can pick up
+func RunWorkerPool(workers int, jobs int, work func(idx int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > jobs {
+		workers = jobs
+	}
+
+	queue := make(chan int, workers * 2)
+	var wg sync.WaitGroup
+
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range queue {
+				work(idx)
+			}
+		}()
+	}
+
+	for idx := 0; idx < jobs; idx++ {
+		queue <- idx
+	}
+	close(queue)
+
+	wg.Wait()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+ |-------------------------------------------------------------------------
+ | Type: XlsxWriter
+ |--------------------------------------------------------------------------
+ |
+ | Writes a single sheet XLSX workbook a row at a time. A workbook is a zip
+ | of XML parts, the sheet is the last part so rows can be streamed straight
+ | to the client as they are read rather than building the file in memory.
+ | Strings are written inline so there's no shared string table to build
+ |
+ */
+
+type XlsxWriter struct {
+	archive *zip.Writer
+	sheet io.Writer
+	row int
+}
+
+var xlsxStaticParts = []struct {
+	name string
+	content string
+}{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+func NewXlsxWriter(w io.Writer, sheetName string) (*XlsxWriter, error) {
+	archive := zip.NewWriter(w)
+	for _, part := range xlsxStaticParts {
+		if err := writeZipPart(archive, part.name, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	workbook := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="` + xlsxEscape(sheetName) + `" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`
+	if err := writeZipPart(archive, "xl/workbook.xml", workbook); err != nil {
+		return nil, err
+	}
+
+	sheet, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	if err != nil {
+		return nil, err
+	}
+	return &XlsxWriter{ archive: archive, sheet: sheet }, nil
+}
+
+func writeZipPart(archive *zip.Writer, name string, content string) error {
+	part, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(part, content)
+	return err
+}
+
+// Writes a row, cells may be strings, float64s or nil for an empty cell
+func (x *XlsxWriter) WriteRow(cells []interface{}) error {
+	x.row++
+	var row strings.Builder
+	row.WriteString(`<row r="` + strconv.Itoa(x.row) + `">`)
+	for _, cell := range cells {
+		switch value := cell.(type) {
+		case float64:
+			row.WriteString(`<c><v>` + strconv.FormatFloat(value, 'f', -1, 64) + `</v></c>`)
+		case string:
+			row.WriteString(`<c t="inlineStr"><is><t>` + xlsxEscape(value) + `</t></is></c>`)
+		default:
+			row.WriteString(`<c/>`)
+		}
+	}
+	row.WriteString(`</row>`)
+
+	_, err := io.WriteString(x.sheet, row.String())
+	return err
+}
+
+// Finishes the sheet and the zip, nothing is readable until this is called
+func (x *XlsxWriter) Close() error {
+	if _, err := io.WriteString(x.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return x.archive.Close()
+}
+
+// Escapes the text and drops control characters, which XML can't represent
+func xlsxEscape(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if r < ' ' && r != '\t' && r != '\n' && r != '\r' {
+			return -1
+		}
+		return r
+	}, text)
+
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}